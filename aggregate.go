@@ -0,0 +1,233 @@
+package jsonstream
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// An AggregateFunc is a function computed by Aggregate.
+type AggregateFunc uint8
+
+const (
+	CountAggregate AggregateFunc = iota // Number of nodes
+	SumAggregate                        // Sum of numbers
+	MinAggregate                        // Smallest node (in the order of Sort)
+	MaxAggregate                        // Largest node (in the order of Sort)
+	AvgAggregate                        // Average of numbers
+)
+
+func (f AggregateFunc) String() string {
+	switch f {
+	case CountAggregate:
+		return "count"
+	case SumAggregate:
+		return "sum"
+	case MinAggregate:
+		return "min"
+	case MaxAggregate:
+		return "max"
+	case AvgAggregate:
+		return "avg"
+	default:
+		return "invalid aggregate"
+	}
+}
+
+// Aggregate is a StreamTransformer which computes Func over the values of a
+// stream and outputs the result when the input is exhausted, or after each
+// value if Running is true.
+//
+// If Value is not nil, the function is computed over the first node selected
+// by Value in each value (values where it selects nothing are skipped),
+// otherwise over the values themselves.  Null nodes are skipped too, except by
+// CountAggregate.  SumAggregate and AvgAggregate fail with a *TransformError
+// when a node is not a number, or when the result is a float too big for
+// float64.
+//
+// The sum is an integer as long as all the numbers are integers and it does
+// not overflow an int64.  The average is computed with floats.  When there is
+// no node, the sum is 0 and the other results (except the count) are null.
+type Aggregate struct {
+	Func    AggregateFunc
+	Value   *jsonpathtransformer.MainQueryRunner
+	Running bool
+}
+
+// Transform implements the Aggregate transform.
+func (f *Aggregate) Transform(in <-chan token.Token, out token.WriteStream) {
+	var acc aggregateAccumulator
+	stream := token.NewStreamErrorReader(in)
+	iter := iterator.New(stream)
+	for iter.Advance() {
+		value := iter.CurrentValue()
+		var node []token.Token
+		if f.Value == nil {
+			node = copyValueTokens(value)
+		} else {
+			node = f.firstNode(value)
+		}
+		if node != nil {
+			f.add(&acc, node)
+		}
+		if f.Running {
+			f.putResult(&acc, out)
+		}
+	}
+	if !f.Running {
+		f.putResult(&acc, out)
+	}
+	stream.ForwardError(out)
+}
+
+// firstNode consumes value and returns the tokens of the first node selected
+// by f.Value in it, or nil if there is none.
+func (f *Aggregate) firstNode(value iterator.Value) []token.Token {
+	acc := token.NewAccumulatorStream()
+	f.Value.EvaluateNodesResult(value).ForEachNode(func(v iterator.Value) bool {
+		v.Copy(acc)
+		return false
+	})
+	value.Discard()
+	return acc.GetTokens()
+}
+
+func (f *Aggregate) add(acc *aggregateAccumulator, node []token.Token) {
+	if f.Func == CountAggregate {
+		acc.count++
+		return
+	}
+	if scalar, ok := node[0].(*token.Scalar); ok && scalar.Type() == token.Null {
+		return
+	}
+	acc.count++
+	switch f.Func {
+	case MinAggregate:
+		if acc.extremum == nil || compareValues(node, acc.extremum) < 0 {
+			acc.extremum = node
+		}
+	case MaxAggregate:
+		if acc.extremum == nil || compareValues(node, acc.extremum) > 0 {
+			acc.extremum = node
+		}
+	default:
+		scalar, ok := node[0].(*token.Scalar)
+		if !ok || scalar.Type() != token.Number {
+			panic(token.TransformErrorf("%s: expected a number, got %s", f.Func, tokensKind(node)))
+		}
+		acc.addNumber(scalar.Bytes)
+	}
+}
+
+func (f *Aggregate) putResult(acc *aggregateAccumulator, out token.WriteStream) {
+	switch f.Func {
+	case CountAggregate:
+		out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, acc.count, 10)))
+	case SumAggregate:
+		switch {
+		case acc.isFloat:
+			out.Put(f.resultScalar(acc.floatSum))
+		case acc.bigSum != nil:
+			out.Put(token.NewScalar(token.Number, acc.bigSum.Append(nil, 10)))
+		default:
+			out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, acc.intSum, 10)))
+		}
+	case AvgAggregate:
+		if acc.count == 0 {
+			out.Put(nullInstance)
+		} else if acc.isFloat {
+			out.Put(f.resultScalar(acc.floatSum / float64(acc.count)))
+		} else if acc.bigSum != nil {
+			out.Put(f.resultScalar(bigIntToFloat(acc.bigSum) / float64(acc.count)))
+		} else {
+			out.Put(f.resultScalar(float64(acc.intSum) / float64(acc.count)))
+		}
+	default:
+		if acc.extremum == nil {
+			out.Put(nullInstance)
+		}
+		for _, tok := range acc.extremum {
+			out.Put(tok)
+		}
+	}
+}
+
+// resultScalar returns a number scalar for x, the result of a sum or average.
+// It fails if x is not finite (e.g. a sum overflowing float64), as JSON cannot
+// represent it and null would be mistaken for the result of an empty input.
+func (f *Aggregate) resultScalar(x float64) *token.Scalar {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		panic(token.TransformErrorf("%s: result out of range", f.Func))
+	}
+	return floatScalar(x)
+}
+
+// aggregateAccumulator holds the state of an Aggregate computation.
+type aggregateAccumulator struct {
+	count    int64
+	intSum   int64
+	bigSum   *big.Int // When not nil the sum is in bigSum (it overflowed intSum)
+	floatSum float64
+	isFloat  bool          // When true the sum is in floatSum
+	extremum []token.Token // For min and max
+}
+
+// addNumber adds the number literal b to the sum.  Integers are added exactly,
+// using a big.Int when they do not fit in an int64, until a number which is not
+// an integer literal is added.
+func (acc *aggregateAccumulator) addNumber(b []byte) {
+	if !acc.isFloat {
+		if acc.bigSum == nil {
+			if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+				sum := acc.intSum + n
+				// Check for overflow
+				if (sum > acc.intSum) == (n > 0) {
+					acc.intSum = sum
+					return
+				}
+			}
+			if isIntegerLiteral(b) {
+				acc.bigSum = big.NewInt(acc.intSum)
+			}
+		}
+		if acc.bigSum != nil {
+			if n, ok := new(big.Int).SetString(string(b), 10); ok {
+				acc.bigSum.Add(acc.bigSum, n)
+				return
+			}
+		}
+		acc.isFloat = true
+		if acc.bigSum != nil {
+			acc.floatSum = bigIntToFloat(acc.bigSum)
+		} else {
+			acc.floatSum = float64(acc.intSum)
+		}
+	}
+	x, _ := strconv.ParseFloat(string(b), 64)
+	acc.floatSum += x
+}
+
+func bigIntToFloat(n *big.Int) float64 {
+	x, _ := new(big.Float).SetInt(n).Float64()
+	return x
+}
+
+// copyValueTokens consumes value and returns its tokens.
+func copyValueTokens(value iterator.Value) []token.Token {
+	acc := token.NewAccumulatorStream()
+	value.Copy(acc)
+	return acc.GetTokens()
+}
+
+// floatScalar returns a number scalar for x, which is null if x is not finite
+// as JSON cannot represent it.
+func floatScalar(x float64) *token.Scalar {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return nullInstance
+	}
+	return token.NewScalar(token.Number, strconv.AppendFloat(nil, x, 'g', -1, 64))
+}
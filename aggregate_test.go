@@ -0,0 +1,164 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestAggregate(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		fn      jsonstream.AggregateFunc
+		value   string
+		running bool
+		output  string
+	}
+	input := `{"p": 1, "t": "b"} {"p": 2.5, "t": "a"} {"p": null} {"x": 1}`
+	var testCases = []testCase{
+		{
+			name:   "count values",
+			input:  input,
+			fn:     jsonstream.CountAggregate,
+			output: "4\n",
+		},
+		{
+			name:   "count nodes",
+			input:  input,
+			fn:     jsonstream.CountAggregate,
+			value:  `$.p`,
+			output: "3\n",
+		},
+		{
+			name:   "sum",
+			input:  input,
+			fn:     jsonstream.SumAggregate,
+			value:  `$.p`,
+			output: "3.5\n",
+		},
+		{
+			name:   "integer sum",
+			input:  `1 2 -10`,
+			fn:     jsonstream.SumAggregate,
+			output: "-7\n",
+		},
+		{
+			name:   "overflowing sum",
+			input:  `9223372036854775807 1`,
+			fn:     jsonstream.SumAggregate,
+			output: "9223372036854775808\n",
+		},
+		{
+			name:   "big integer sum",
+			input:  `123456789012345678901234567890 1 -2`,
+			fn:     jsonstream.SumAggregate,
+			output: "123456789012345678901234567889\n",
+		},
+		{
+			name:   "big integer sum then float",
+			input:  `9223372036854775807 1 0.5`,
+			fn:     jsonstream.SumAggregate,
+			output: "9.223372036854776e+18\n",
+		},
+		{
+			name:   "big integer avg",
+			input:  `9223372036854775807 9223372036854775807`,
+			fn:     jsonstream.AvgAggregate,
+			output: "9.223372036854776e+18\n",
+		},
+		{
+			name:   "avg",
+			input:  input,
+			fn:     jsonstream.AvgAggregate,
+			value:  `$.p`,
+			output: "1.75\n",
+		},
+		{
+			name:   "min",
+			input:  input,
+			fn:     jsonstream.MinAggregate,
+			value:  `$.t`,
+			output: "\"a\"\n",
+		},
+		{
+			name:   "max values",
+			input:  `[1] {"a": 1} 3`,
+			fn:     jsonstream.MaxAggregate,
+			output: "{\"a\": 1}\n",
+		},
+		{
+			name:   "empty stream",
+			input:  ``,
+			fn:     jsonstream.AvgAggregate,
+			output: "null\n",
+		},
+		{
+			name:   "empty sum",
+			input:  `{}`,
+			fn:     jsonstream.SumAggregate,
+			value:  `$.p`,
+			output: "0\n",
+		},
+		{
+			name:    "running",
+			input:   `3 1 2`,
+			fn:      jsonstream.MinAggregate,
+			running: true,
+			output:  "3\n1\n1\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			aggregate := &jsonstream.Aggregate{Func: c.fn, Running: c.running}
+			if c.value != "" {
+				aggregate.Value = mustCompileQuery(t, c.value)
+			}
+			got := transformJSONString(t, c.input, aggregate)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestAggregateError(t *testing.T) {
+	type testCase struct {
+		name  string
+		input string
+		fn    jsonstream.AggregateFunc
+		err   string
+	}
+	var testCases = []testCase{
+		{
+			name:  "not a number",
+			input: `1 "x"`,
+			fn:    jsonstream.AvgAggregate,
+			err:   "avg: expected a number, got a string",
+		},
+		{
+			name:  "overflowing float sum",
+			input: `1e400 1`,
+			fn:    jsonstream.SumAggregate,
+			err:   "sum: result out of range",
+		},
+		{
+			name:  "overflowing float avg",
+			input: `1.5e308 1.5e308`,
+			fn:    jsonstream.AvgAggregate,
+			err:   "avg: result out of range",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var err error
+			aggregate := &jsonstream.Aggregate{Func: c.fn}
+			stream := token.TransformStreamWithErrorHandler(streamJSONString(c.input), aggregate, func(e error) { err = e })
+			encodeJSONStream(t, stream)
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package jsonstream
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A binaryInput is the buffered input of a decoder for a binary format.  It
+// keeps track of the position in the input, so that errors can be located.
+type binaryInput struct {
+	*bufio.Reader
+	counter countingReader
+}
+
+func newBinaryInput(in io.Reader) *binaryInput {
+	input := &binaryInput{counter: countingReader{reader: in}}
+	input.Reader = bufio.NewReader(&input.counter)
+	return input
+}
+
+// offset returns the number of bytes read so far.
+func (in *binaryInput) offset() int64 {
+	return in.counter.count - int64(in.Buffered())
+}
+
+// parseError returns a *token.ParseError at the current offset for err if it
+// is due to invalid input in the given format (errors reading the input are
+// returned unchanged).
+func (in *binaryInput) parseError(format string, err error) error {
+	if err == nil || in.counter.err != nil && errors.Is(err, in.counter.err) {
+		return err
+	}
+	return &token.ParseError{
+		Format: format,
+		Offset: in.offset(),
+		Msg:    strings.TrimPrefix(err.Error(), format+": "),
+		Err:    err,
+	}
+}
+
+// A countingReader counts the bytes read from reader, and records the error it
+// returns if it is not io.EOF.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+	err    error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
@@ -1,15 +1,30 @@
 package jsonstream
 
 import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
 	"log"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
 	"github.com/arnodel/jsonstream/token"
 )
 
 // MaxDepthFilter is a Transformer that truncates the stream to a given depth.
 // Collections which are more deeply nested than MaxDepth are elided
-// (their contents is replaced with "..." in the examples below).
+// (their contents is replaced with "..." in the examples below).  The depth is
+// counted from each top-level value in the stream, so e.g. after a split each
+// element is truncated independently.
 //
 // E.g.
 //
@@ -26,31 +41,69 @@ import (
 // At MaxDepth=2
 //
 //	[1, 2, {"x": [...], "y": 2}]
+//
+// The elisions record the number of items removed (e.g. 2 for the object at
+// MaxDepth=1), so that the output can show it.
 type MaxDepthFilter struct {
 	MaxDepth int
 }
 
 // Transform implements the MaxDepthFilter tansform.
 func (f *MaxDepthFilter) Transform(in <-chan token.Token, out token.WriteStream) {
-	depth := 0
+	var (
+		depth    int
+		elision  *token.Elision // Counts the items of the value being elided
+		inObject bool           // True if the value being elided is an object
+	)
 	for item := range in {
+		if _, ok := item.(*token.StreamError); ok {
+			out.Put(item)
+			continue
+		}
 		postIncr := 0
 		switch item.(type) {
 		case *token.StartArray, *token.StartObject:
 			postIncr++
 		case *token.EndArray, *token.EndObject:
 			depth--
+			if depth == f.MaxDepth {
+				out.Put(elision)
+			}
 		}
 		if depth <= f.MaxDepth {
 			out.Put(item)
+		} else if depth == f.MaxDepth+1 {
+			elision.Count += itemCount(item, inObject)
 		}
 		if depth == f.MaxDepth && postIncr > 0 {
-			out.Put(&token.Elision{})
+			elision = &token.Elision{}
+			_, inObject = item.(*token.StartObject)
 		}
 		depth += postIncr
 	}
 }
 
+// itemCount returns the number of items of an array (or object if inObject is
+// true) accounted for by tok, which must be at the top level of the array (or
+// object).
+func itemCount(tok token.Token, inObject bool) int {
+	switch t := tok.(type) {
+	case *token.EndArray, *token.EndObject:
+		return 0
+	case *token.Elision:
+		return t.Count
+	case *token.Scalar:
+		if inObject && !t.IsKey() {
+			return 0
+		}
+	default:
+		if inObject {
+			return 0
+		}
+	}
+	return 1
+}
+
 // KeyExtractor is a Transformer that transforms an object into the value
 // associated with a particular key.
 //
@@ -109,13 +162,89 @@ func (f *DeepKeyExtractor) transformObject(obj *iterator.Object, out token.Write
 	}
 }
 
+// KeyGlobFilter is a Transformer that keeps only the keys of an object which
+// match a pattern, in their original order.  It copies other types unchanged.
+//
+// E.g. if the pattern is compiled from the glob "user_*"
+//
+//	{"user_id": 5, "admin": true, "user_name": "Kim"} -> {"user_id": 5, "user_name": "Kim"}
+//	[1, 2]                                            -> [1, 2]
+type KeyGlobFilter struct {
+	Pattern *regexp.Regexp
+}
+
+// TransformValue implements the KeyGlobFilter transform
+func (f *KeyGlobFilter) TransformValue(value iterator.Value, out token.WriteStream) {
+	obj, ok := value.(*iterator.Object)
+	if !ok {
+		value.Copy(out)
+		return
+	}
+	out.Put(&token.StartObject{})
+	for obj.Advance() {
+		key, val := obj.CurrentKeyVal()
+		if f.Pattern.MatchString(key.ToString()) {
+			out.Put(key)
+			val.Copy(out)
+		}
+	}
+	if obj.Elided() {
+		out.Put(&token.Elision{Count: obj.ElidedCount()})
+	}
+	out.Put(&token.EndObject{})
+}
+
+// GlobToRegexp compiles a shell-style glob pattern into a regular expression
+// matching whole strings.  In the glob, "*" matches any sequence of characters,
+// "?" matches any single character and "[...]" matches a character class (which
+// is negated if it starts with "!" or "^").  Other characters match themselves,
+// and "\" escapes the following character.
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^(?s:`)
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if i+1 == len(glob) {
+				return nil, errors.New("glob: trailing backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, errors.New("glob: unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString(`)$`)
+	return regexp.Compile(b.String())
+}
+
 // ExplodeArray is a transformer that turns an array into a stream of values.
-// It copies other types unchanged.
+// It copies other types unchanged, unless Strict is true in which case it
+// fails with a *token.TransformError.  Elided array contents (e.g. from a
+// MaxDepthFilter upstream) cannot be represented in the output stream so they
+// are skipped.
 //
 //	E.g.
 //	 [1, 2, 3]        -> 1 2 3
 //	 {"x": 2, "y": 5} -> {"x": 2, "y": 5}
-type ExplodeArray struct{}
+type ExplodeArray struct {
+	Strict bool
+}
 
 // TransformValue implements the ExplodeArray transform
 func (f ExplodeArray) TransformValue(value iterator.Value, out token.WriteStream) {
@@ -125,10 +254,882 @@ func (f ExplodeArray) TransformValue(value iterator.Value, out token.WriteStream
 			v.CurrentValue().Copy(out)
 		}
 	default:
+		if f.Strict {
+			value.Discard()
+			panic(token.TransformErrorf("split: expected an array, got %s", valueKind(value)))
+		}
 		value.Copy(out)
 	}
 }
 
+// ObjectValues is a transformer that turns an object into the stream of its
+// values, in the order of their keys in the input.  Keys are discarded.  It
+// copies other types unchanged.  As with ExplodeArray, elided object contents
+// are skipped.
+//
+//	E.g.
+//	 {"x": 2, "y": [5]} -> 2 [5]
+//	 [1, 2]             -> [1, 2]
+type ObjectValues struct{}
+
+// TransformValue implements the ObjectValues transform
+func (f ObjectValues) TransformValue(value iterator.Value, out token.WriteStream) {
+	switch v := value.(type) {
+	case *iterator.Object:
+		for v.Advance() {
+			_, val := v.CurrentKeyVal()
+			val.Copy(out)
+		}
+	default:
+		value.Copy(out)
+	}
+}
+
+// Keys is a transformer that turns an object into the array of its keys, in
+// the order of the input.  It copies other types unchanged.
+//
+//	E.g.
+//	 {"x": 2, "y": [5]} -> ["x", "y"]
+//	 [1, 2]             -> [1, 2]
+type Keys struct{}
+
+// TransformValue implements the Keys transform
+func (f Keys) TransformValue(value iterator.Value, out token.WriteStream) {
+	transformObjectItems(value, out, func(key *token.Scalar, val iterator.Value) {
+		out.Put(stringScalar(key.ToString()))
+	})
+}
+
+// Values is a transformer that turns an object into the array of its values,
+// in the order of their keys in the input.  It copies other types unchanged.
+// See also ObjectValues, which streams the values instead.
+//
+//	E.g.
+//	 {"x": 2, "y": [5]} -> [2, [5]]
+//	 [1, 2]             -> [1, 2]
+type Values struct{}
+
+// TransformValue implements the Values transform
+func (f Values) TransformValue(value iterator.Value, out token.WriteStream) {
+	transformObjectItems(value, out, func(key *token.Scalar, val iterator.Value) {
+		val.Copy(out)
+	})
+}
+
+// Entries is a transformer that turns an object into the array of its
+// [key, value] pairs, in the order of the input.  It copies other types
+// unchanged.
+//
+//	E.g.
+//	 {"x": 2, "y": [5]} -> [["x", 2], ["y", [5]]]
+//	 [1, 2]             -> [1, 2]
+type Entries struct{}
+
+// TransformValue implements the Entries transform
+func (f Entries) TransformValue(value iterator.Value, out token.WriteStream) {
+	transformObjectItems(value, out, func(key *token.Scalar, val iterator.Value) {
+		out.Put(&token.StartArray{})
+		out.Put(stringScalar(key.ToString()))
+		val.Copy(out)
+		out.Put(&token.EndArray{})
+	})
+}
+
+// KeyFilter is a transformer that keeps only the members of an object whose
+// key is in Keys, or drops them if Omit is true.  Members keep their order in
+// the input.  It copies other types unchanged.
+//
+//	E.g. with Keys = {"a", "c"}
+//	 {"a": 1, "b": 2, "c": 3} -> {"a": 1, "c": 3}  (or {"b": 2} if Omit is true)
+//	 [1, 2]                   -> [1, 2]
+type KeyFilter struct {
+	Keys map[string]bool
+	Omit bool
+}
+
+// TransformValue implements the KeyFilter transform
+func (f KeyFilter) TransformValue(value iterator.Value, out token.WriteStream) {
+	obj, ok := value.(*iterator.Object)
+	if !ok {
+		value.Copy(out)
+		return
+	}
+	out.Put(&token.StartObject{})
+	for obj.Advance() {
+		key, val := obj.CurrentKeyVal()
+		if f.Keys[key.ToString()] == f.Omit {
+			continue
+		}
+		out.Put(key)
+		val.Copy(out)
+	}
+	if obj.Elided() {
+		out.Put(&token.Elision{Count: obj.ElidedCount()})
+	}
+	out.Put(&token.EndObject{})
+}
+
+// transformObjectItems turns an object into an array whose items are output by
+// putItem for each member of the object (which must consume the value).  If
+// the object has elided contents, so does the array.  Other values are copied
+// unchanged.
+func transformObjectItems(value iterator.Value, out token.WriteStream, putItem func(*token.Scalar, iterator.Value)) {
+	obj, ok := value.(*iterator.Object)
+	if !ok {
+		value.Copy(out)
+		return
+	}
+	out.Put(&token.StartArray{})
+	for obj.Advance() {
+		putItem(obj.CurrentKeyVal())
+	}
+	if obj.Elided() {
+		out.Put(&token.Elision{Count: obj.ElidedCount()})
+	}
+	out.Put(&token.EndArray{})
+}
+
+// RecurseLeaves is a transformer that turns a value into the stream of all the
+// scalars it contains at any depth, in document order.  Empty arrays and
+// objects (and keys) are dropped.
+//
+//	E.g.
+//	 {"a": [1, {"b": "x"}], "c": [], "d": null} -> 1 "x" null
+//	 2                                          -> 2
+type RecurseLeaves struct{}
+
+// TransformValue implements the RecurseLeaves transform
+func (f RecurseLeaves) TransformValue(value iterator.Value, out token.WriteStream) {
+	switch v := value.(type) {
+	case *iterator.Object:
+		for v.Advance() {
+			_, val := v.CurrentKeyVal()
+			f.TransformValue(val, out)
+		}
+	case *iterator.Array:
+		for v.Advance() {
+			f.TransformValue(v.CurrentValue(), out)
+		}
+	default:
+		value.Copy(out)
+	}
+}
+
+// EmptyKind is a set of kinds of values that OmitEmpty considers empty.
+type EmptyKind uint8
+
+const (
+	EmptyNull   EmptyKind = 1 << iota // null
+	EmptyString                       // ""
+	EmptyArray                        // []
+	EmptyObject                       // {}
+
+	AllEmptyKinds = EmptyNull | EmptyString | EmptyArray | EmptyObject
+)
+
+// OmitEmpty is a Transformer that drops object fields whose value is empty,
+// at any depth.  The kinds of values which are considered empty are given by
+// Kinds.  Whether a value is empty is decided on the input, so an object whose
+// fields are all omitted is output as {} and not omitted itself.
+//
+// E.g. with AllEmptyKinds
+//
+//	{"a": 1, "b": "", "c": [], "d": {"e": null, "f": [{}]}} -> {"a": 1, "d": {"f": [{}]}}
+type OmitEmpty struct {
+	Kinds EmptyKind
+}
+
+// TransformValue implements the OmitEmpty transform
+func (f OmitEmpty) TransformValue(value iterator.Value, out token.WriteStream) {
+	switch v := value.(type) {
+	case *iterator.Object:
+		out.Put(&token.StartObject{})
+		for v.Advance() {
+			key, val := v.CurrentKeyVal()
+			if !f.isEmpty(val) {
+				out.Put(key)
+				f.TransformValue(val, out)
+			}
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndObject{})
+	case *iterator.Array:
+		out.Put(&token.StartArray{})
+		for v.Advance() {
+			f.TransformValue(v.CurrentValue(), out)
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndArray{})
+	default:
+		value.Copy(out)
+	}
+}
+
+// isEmpty returns true if value is of a kind that is considered empty.  It does
+// not consume value, but looks ahead one item in arrays and objects.
+func (f OmitEmpty) isEmpty(value iterator.Value) bool {
+	switch v := value.(type) {
+	case *iterator.Scalar:
+		scalar := v.Scalar()
+		switch scalar.Type() {
+		case token.Null:
+			return f.Kinds&EmptyNull != 0
+		case token.String:
+			return f.Kinds&EmptyString != 0 && len(scalar.Bytes) == 2
+		}
+	case *iterator.Object:
+		if f.Kinds&EmptyObject != 0 {
+			clone, detach := v.Clone()
+			defer detach()
+			return !clone.(*iterator.Object).Advance() && !clone.(*iterator.Object).Elided()
+		}
+	case *iterator.Array:
+		if f.Kinds&EmptyArray != 0 {
+			clone, detach := v.CloneArray()
+			defer detach()
+			return !clone.Advance() && !clone.Elided()
+		}
+	}
+	return false
+}
+
+// TryTransformer applies Transformer to each value in the stream separately.
+// If it fails on a value with a *token.TransformError, the value is replaced
+// with an error object instead of aborting the whole stream.
+//
+// E.g. with the strict split transformer
+//
+//	[1, 2] 3 [4] -> 1 2 {"_error": "split: expected an array, got a number", "_input": 3} 4
+//
+// Note that the output of Transformer for a value is only emitted once it has
+// successfully processed it, and that transformers which keep state across
+// values (e.g. join) only see one value at a time.
+type TryTransformer struct {
+	Transformer token.StreamTransformer
+}
+
+// TransformValue implements the TryTransformer transform
+func (t TryTransformer) TransformValue(value iterator.Value, out token.WriteStream) {
+	input := token.NewAccumulatorStream()
+	value.Copy(input)
+	inputTokens := input.GetTokens()
+
+	// The channel is buffered so that nothing blocks if the transformer fails
+	// before consuming all its input.
+	in := make(chan token.Token, len(inputTokens))
+	for _, tok := range inputTokens {
+		in <- tok
+	}
+	close(in)
+
+	output := token.NewAccumulatorStream()
+	var err error
+	func() {
+		defer token.CatchTransformError(&err)
+		t.Transformer.Transform(in, output)
+	}()
+	if err != nil {
+		out.Put(&token.StartObject{})
+		out.Put(errorKey)
+		out.Put(stringScalar(err.Error()))
+		out.Put(inputKey)
+		for _, tok := range inputTokens {
+			out.Put(tok)
+		}
+		out.Put(&token.EndObject{})
+		return
+	}
+	for _, tok := range output.GetTokens() {
+		out.Put(tok)
+	}
+}
+
+var (
+	errorKey = keyScalar("_error")
+	inputKey = keyScalar("_input")
+)
+
+// Reindex is a Transformer that turns an array of objects into an object whose
+// keys are given by evaluating Key on each item.  It is like a split followed
+// by indexing each item by its key.  Key should select a string, a number or a
+// boolean in each item, or the transform fails with a *token.TransformError.
+// Values which are not arrays are copied unchanged.
+//
+// If Collect is false, the output is streamed and items with the same key
+// give duplicate keys in the output.  If Collect is true, the items are
+// buffered in memory and each key is associated with the array of items which
+// have that key, in the order of first appearance of the keys.
+//
+// E.g. if Key is $.id
+//
+//	[{"id": "a", "x": 1}, {"id": "b", "x": 2}] -> {"a": {"id": "a", "x": 1}, "b": {"id": "b", "x": 2}}
+//
+// And if Collect is true
+//
+//	[{"id": 1}, {"id": 2}, {"id": 1}] -> {"1": [{"id": 1}, {"id": 1}], "2": [{"id": 2}]}
+type Reindex struct {
+	Key     *jsonpathtransformer.MainQueryRunner
+	Collect bool
+}
+
+// TransformValue implements the Reindex transform
+func (f *Reindex) TransformValue(value iterator.Value, out token.WriteStream) {
+	arr, ok := value.(*iterator.Array)
+	if !ok {
+		value.Copy(out)
+		return
+	}
+	var keys []string
+	groups := map[string]*token.AccumulatorStream{}
+	if !f.Collect {
+		out.Put(&token.StartObject{})
+	}
+	for arr.Advance() {
+		item := arr.CurrentValue()
+		key, err := f.itemKey(item)
+		if err != nil {
+			// Keep the output well-formed before failing
+			if !f.Collect {
+				out.Put(&token.EndObject{})
+			}
+			panic(err)
+		}
+		if !f.Collect {
+			out.Put(keyScalar(key))
+			item.Copy(out)
+			continue
+		}
+		group, ok := groups[key]
+		if !ok {
+			group = token.NewAccumulatorStream()
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		item.Copy(group)
+	}
+	if f.Collect {
+		out.Put(&token.StartObject{})
+		for _, key := range keys {
+			out.Put(keyScalar(key))
+			out.Put(&token.StartArray{})
+			for _, tok := range groups[key].GetTokens() {
+				out.Put(tok)
+			}
+			out.Put(&token.EndArray{})
+		}
+	}
+	if arr.Elided() {
+		out.Put(&token.Elision{Count: arr.ElidedCount()})
+	}
+	out.Put(&token.EndObject{})
+}
+
+// itemKey returns the key of item, without consuming it.
+func (f *Reindex) itemKey(item iterator.Value) (string, *token.TransformError) {
+	clone, detach := item.Clone()
+	if detach != nil {
+		defer detach()
+	}
+	var keyValue iterator.Value
+	f.Key.EvaluateNodesResult(clone).ForEachNode(func(v iterator.Value) bool {
+		keyValue = v
+		return false
+	})
+	if keyValue == nil {
+		return "", token.TransformErrorf("reindex: item has no key")
+	}
+	key, ok := keyValue.AsScalar()
+	switch {
+	case !ok || key.Type() == token.Null:
+		return "", token.TransformErrorf("reindex: expected a scalar key, got %s", valueKind(keyValue))
+	case key.Type() == token.String:
+		return key.ToString(), nil
+	default:
+		return string(key.Bytes), nil
+	}
+}
+
+// valueKind returns a description of the type of value, for use in error
+// messages.
+func valueKind(value iterator.Value) string {
+	switch v := value.(type) {
+	case *iterator.Object:
+		return "an object"
+	case *iterator.Array:
+		return "an array"
+	case *iterator.Scalar:
+		return scalarKind(v.Scalar())
+	default:
+		return "an invalid value"
+	}
+}
+
+// tokensKind is like valueKind for a value given as tokens.
+func tokensKind(toks []token.Token) string {
+	switch t := toks[0].(type) {
+	case *token.StartObject:
+		return "an object"
+	case *token.StartArray:
+		return "an array"
+	case *token.Scalar:
+		return scalarKind(t)
+	default:
+		return "an invalid value"
+	}
+}
+
+func scalarKind(scalar *token.Scalar) string {
+	switch scalar.Type() {
+	case token.String:
+		return "a string"
+	case token.Number:
+		return "a number"
+	case token.Boolean:
+		return "a boolean"
+	default:
+		return "null"
+	}
+}
+
+// GroupBy is a StreamTransformer which groups the values of a stream by the
+// first node selected by Key in them (null if there is none).  Each group is
+// output as an object with the key and the values in the group, in the order
+// of first appearance of the keys.
+//
+// E.g. if Key is $.level
+//
+//	{"level": "info", "id": 1} {"level": "warn", "id": 2} {"level": "info", "id": 3}
+//
+// is transformed to
+//
+//	{"key": "info", "items": [{"level": "info", "id": 1}, {"level": "info", "id": 3}]}
+//	{"key": "warn", "items": [{"level": "warn", "id": 2}]}
+//
+// If Map is true, a single object is output instead, which maps each key to
+// the array of values in its group.  Then the keys must be scalars, converted
+// to strings as with Reindex.
+//
+// Keys are equal when they compare equal with Sort, e.g. 1 and 1.0 are equal.
+// Unless Sorted is true, all the values are held in memory until the input is
+// exhausted.  If Sorted is true, the input is assumed to be sorted by key so
+// groups are streamed: a new group starts whenever the key changes (so if the
+// input is not sorted, a key may have several groups).
+type GroupBy struct {
+	Key    *jsonpathtransformer.MainQueryRunner
+	Map    bool
+	Sorted bool
+}
+
+// Transform implements the GroupBy transform.
+func (f *GroupBy) Transform(in <-chan token.Token, out token.WriteStream) {
+	stream := token.NewStreamErrorReader(in)
+	iter := iterator.New(stream)
+	if f.Sorted {
+		f.transformSorted(iter, out)
+		stream.ForwardError(out)
+		return
+	}
+	type group struct {
+		key   []token.Token
+		items *token.AccumulatorStream
+	}
+	var groups []*group
+	index := map[string]*group{}
+	for iter.Advance() {
+		value := iter.CurrentValue()
+		key := evaluateKey(f.Key, value)
+		id := groupID(key)
+		g, ok := index[id]
+		if !ok {
+			if f.Map {
+				if _, err := groupMapKey(key); err != nil {
+					panic(err)
+				}
+			}
+			g = &group{key: key, items: token.NewAccumulatorStream()}
+			index[id] = g
+			groups = append(groups, g)
+		}
+		value.Copy(g.items)
+	}
+	if f.Map {
+		out.Put(&token.StartObject{})
+	}
+	for _, g := range groups {
+		f.startGroup(g.key, out)
+		for _, tok := range g.items.GetTokens() {
+			out.Put(tok)
+		}
+		f.endGroup(out)
+	}
+	if f.Map {
+		out.Put(&token.EndObject{})
+	}
+	stream.ForwardError(out)
+}
+
+// transformSorted streams the groups of consecutive values with equal keys.
+func (f *GroupBy) transformSorted(iter *iterator.Iterator, out token.WriteStream) {
+	var current []token.Token
+	if f.Map {
+		out.Put(&token.StartObject{})
+	}
+	for iter.Advance() {
+		value := iter.CurrentValue()
+		key := evaluateKey(f.Key, value)
+		if current == nil || compareValues(key, current) != 0 {
+			if f.Map {
+				if _, err := groupMapKey(key); err != nil {
+					// Keep the output well-formed before failing
+					if current != nil {
+						f.endGroup(out)
+					}
+					out.Put(&token.EndObject{})
+					panic(err)
+				}
+			}
+			if current != nil {
+				f.endGroup(out)
+			}
+			f.startGroup(key, out)
+			current = key
+		}
+		value.Copy(out)
+	}
+	if current != nil {
+		f.endGroup(out)
+	}
+	if f.Map {
+		out.Put(&token.EndObject{})
+	}
+}
+
+// startGroup outputs the start of a group, up to the start of the array of
+// its values.
+func (f *GroupBy) startGroup(key []token.Token, out token.WriteStream) {
+	if f.Map {
+		// The key has already been checked.
+		name, _ := groupMapKey(key)
+		out.Put(keyScalar(name))
+	} else {
+		out.Put(&token.StartObject{})
+		out.Put(keyScalar("key"))
+		for _, tok := range key {
+			out.Put(tok)
+		}
+		out.Put(keyScalar("items"))
+	}
+	out.Put(&token.StartArray{})
+}
+
+// endGroup outputs the end of a group, after its values.
+func (f *GroupBy) endGroup(out token.WriteStream) {
+	out.Put(&token.EndArray{})
+	if !f.Map {
+		out.Put(&token.EndObject{})
+	}
+}
+
+// groupMapKey returns the key in the output of GroupBy with Map set for a group
+// with the given key.
+func groupMapKey(key []token.Token) (string, *token.TransformError) {
+	scalar, ok := key[0].(*token.Scalar)
+	switch {
+	case !ok || scalar.Type() == token.Null:
+		return "", token.TransformErrorf("group_by: expected a scalar key, got %s", tokensKind(key))
+	case scalar.Type() == token.String:
+		return scalar.ToString(), nil
+	default:
+		return string(scalar.Bytes), nil
+	}
+}
+
+// groupID returns a string which identifies key, so that keys which compare
+// equal with Sort have the same id.
+func groupID(key []token.Token) string {
+	var b strings.Builder
+	for _, tok := range key {
+		switch t := tok.(type) {
+		case *token.Scalar:
+			var s string
+			switch t.Type() {
+			case token.Number:
+				x, _ := strconv.ParseFloat(string(t.Bytes), 64)
+				s = strconv.FormatFloat(x, 'g', -1, 64)
+			case token.String:
+				s = t.ToString()
+			default:
+				s = string(t.Bytes)
+			}
+			// Prefix with the type and length to avoid ambiguities
+			fmt.Fprintf(&b, "%d:%d:%s", t.Type(), len(s), s)
+		case *token.Elision:
+			// Ignored when comparing keys
+		default:
+			b.WriteString(tok.String())
+		}
+	}
+	return b.String()
+}
+
+// ObjectConstructor is a ValueTransformer that outputs for each value a new
+// object made of Fields, whose values are computed by JSONPath queries run on
+// the value.
+//
+// E.g. with the fields name: $.user.name and total: $.cart.total
+//
+//	{"user": {"id": 1, "name": "Bob"}, "cart": {"total": 12}} -> {"name": "Bob", "total": 12}
+//
+// Each query needs to read the value, so it is buffered in memory.
+type ObjectConstructor struct {
+	Fields []ConstructedField
+}
+
+// A ConstructedField is a field of the objects output by ObjectConstructor.
+// If Array is false, the value of the field is the first node selected by
+// Query, or null if there is none.  If Array is true, it is the array of all
+// the nodes selected by Query.
+type ConstructedField struct {
+	Key   string
+	Query *jsonpathtransformer.MainQueryRunner
+	Array bool
+}
+
+// TransformValue implements the ObjectConstructor transform.
+func (f *ObjectConstructor) TransformValue(value iterator.Value, out token.WriteStream) {
+	out.Put(&token.StartObject{})
+	for _, field := range f.Fields {
+		out.Put(keyScalar(field.Key))
+		field.writeValue(value, out)
+	}
+	out.Put(&token.EndObject{})
+	value.Discard()
+}
+
+// writeValue outputs the value of the field, without consuming value.
+func (f *ConstructedField) writeValue(value iterator.Value, out token.WriteStream) {
+	clone, detach := value.Clone()
+	if detach != nil {
+		defer detach()
+	}
+	found := false
+	if f.Array {
+		out.Put(&token.StartArray{})
+	}
+	f.Query.EvaluateNodesResult(clone).ForEachNode(func(v iterator.Value) bool {
+		found = true
+		v.Copy(out)
+		return f.Array
+	})
+	switch {
+	case f.Array:
+		out.Put(&token.EndArray{})
+	case !found:
+		out.Put(nullInstance)
+	}
+}
+
+// Setter is a ValueTransformer that replaces the nodes selected by the JSONPath
+// query Path with Value, which must be the tokens of a single JSON value.
+//
+// E.g. if Path is $.users[*].active and Value is true
+//
+//	{"users": [{"active": false}, {"id": 2}]} -> {"users": [{"active": true}, {"id": 2}]}
+//
+// As JSONPath queries only select existing nodes, the "active" key is not added
+// to the second user above.  To add missing keys, set Parent to a query
+// selecting the objects which should have the key, and Key to the key (in the
+// example, $.users[*] and "active").  Then Key is added with Value to the
+// objects selected by Parent which do not have it.
+//
+// When nodes selected by Path are nested, only the outermost one is replaced.
+// Each value needs to be read twice (once to find the selected nodes and once
+// to output it), so it is buffered in memory.
+type Setter struct {
+	Path   *jsonpathtransformer.MainQueryRunner
+	Value  []token.Token
+	Parent *jsonpathtransformer.MainQueryRunner
+	Key    string
+}
+
+// TransformValue implements the Setter transform.
+func (f *Setter) TransformValue(value iterator.Value, out token.WriteStream) {
+	tree := &pathTree{}
+	tree.addPaths(f.Path, value, func(node *pathTree) { node.selected = true })
+	if f.Parent != nil {
+		tree.addPaths(f.Parent, value, func(node *pathTree) { node.isParent = true })
+	}
+	tree.rewrite(value, f.Value, f.Key, out)
+}
+
+// Deleter is a ValueTransformer that removes the nodes selected by the JSONPath
+// query Path, streaming out the rest of the value.
+//
+// E.g. if Path is $.users[*].password
+//
+//	{"users": [{"id": 1, "password": "x"}, {"id": 2}]} -> {"users": [{"id": 1}, {"id": 2}]}
+//
+// If Path selects the top-level value itself (i.e. Path is $), it is removed
+// from the stream.  Each value needs to be read twice (once to find the
+// selected nodes and once to output it), so it is buffered in memory.
+type Deleter struct {
+	Path *jsonpathtransformer.MainQueryRunner
+}
+
+// TransformValue implements the Deleter transform.
+func (f *Deleter) TransformValue(value iterator.Value, out token.WriteStream) {
+	tree := &pathTree{}
+	tree.addPaths(f.Path, value, func(node *pathTree) { node.selected = true })
+	tree.rewrite(value, nil, "", out)
+}
+
+// A pathTree records the nodes of a value selected by JSONPath queries.
+type pathTree struct {
+	selected bool                                        // Selected by the query of the transform
+	isParent bool                                        // Selected by Setter.Parent
+	convert  func(iterator.Value) (*token.Scalar, error) // For convertSelected
+	keys     map[string]*pathTree
+	indices  map[int64]*pathTree
+}
+
+// addPaths calls mark on the nodes of the tree located by the paths of the
+// nodes selected by query in value, without consuming value.
+func (t *pathTree) addPaths(query *jsonpathtransformer.MainQueryRunner, value iterator.Value, mark func(*pathTree)) {
+	clone, detach := value.Clone()
+	if detach != nil {
+		defer detach()
+	}
+	for _, path := range query.EvaluatePaths(clone) {
+		node := t
+		for _, elt := range path {
+			node = node.child(elt)
+		}
+		mark(node)
+	}
+}
+
+// rewrite copies value to out, replacing the selected nodes with the
+// replacement tokens.  If there are no replacement tokens, selected nodes are
+// removed (with their keys in objects).  Objects marked with isParent which do
+// not have parentKey get it added with the replacement value.
+func (t *pathTree) rewrite(value iterator.Value, replacement []token.Token, parentKey string, out token.WriteStream) {
+	if t == nil {
+		value.Copy(out)
+		return
+	}
+	if t.selected {
+		value.Discard()
+		for _, tok := range replacement {
+			out.Put(tok)
+		}
+		return
+	}
+	switch v := value.(type) {
+	case *iterator.Object:
+		out.Put(&token.StartObject{})
+		hasKey := false
+		for v.Advance() {
+			key, item := v.CurrentKeyVal()
+			name := key.ToString()
+			hasKey = hasKey || name == parentKey
+			child := t.keys[name]
+			if child != nil && child.selected && len(replacement) == 0 {
+				continue
+			}
+			out.Put(key)
+			child.rewrite(item, replacement, parentKey, out)
+		}
+		if t.isParent && !hasKey {
+			out.Put(keyScalar(parentKey))
+			for _, tok := range replacement {
+				out.Put(tok)
+			}
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndObject{})
+	case *iterator.Array:
+		out.Put(&token.StartArray{})
+		for index := int64(0); v.Advance(); index++ {
+			t.indices[index].rewrite(v.CurrentValue(), replacement, parentKey, out)
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndArray{})
+	default:
+		value.Copy(out)
+	}
+}
+
+// convertSelected copies value to out, replacing the selected nodes with the
+// result of their convert function (which consumes them).  The first error
+// returned by a convert function is stored in *err.
+func (t *pathTree) convertSelected(value iterator.Value, out token.WriteStream, err *error) {
+	if t == nil {
+		value.Copy(out)
+		return
+	}
+	if t.selected {
+		result, convertErr := t.convert(value)
+		if convertErr != nil && *err == nil {
+			*err = convertErr
+		}
+		out.Put(result)
+		return
+	}
+	switch v := value.(type) {
+	case *iterator.Object:
+		out.Put(&token.StartObject{})
+		for v.Advance() {
+			key, item := v.CurrentKeyVal()
+			out.Put(key)
+			t.keys[key.ToString()].convertSelected(item, out, err)
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndObject{})
+	case *iterator.Array:
+		out.Put(&token.StartArray{})
+		for index := int64(0); v.Advance(); index++ {
+			t.indices[index].convertSelected(v.CurrentValue(), out, err)
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndArray{})
+	default:
+		value.Copy(out)
+	}
+}
+
+func (t *pathTree) child(elt jsonpathtransformer.PathElement) *pathTree {
+	var child *pathTree
+	if elt.Key != nil {
+		name := elt.Key.ToString()
+		if child = t.keys[name]; child == nil {
+			if t.keys == nil {
+				t.keys = map[string]*pathTree{}
+			}
+			child = &pathTree{}
+			t.keys[name] = child
+		}
+	} else if child = t.indices[elt.Index]; child == nil {
+		if t.indices == nil {
+			t.indices = map[int64]*pathTree{}
+		}
+		child = &pathTree{}
+		t.indices[elt.Index] = child
+	}
+	return child
+}
+
 // JoinStream is the reverse of ExplodeArray.  It turns a stream of values
 // into a JSON array
 //
@@ -141,20 +1142,302 @@ type JoinStream struct{}
 
 // Transform implements the JoinStream transform
 func (f JoinStream) Transform(in <-chan token.Token, out token.WriteStream) {
+	stream := token.NewStreamErrorReader(in)
 	out.Put(&token.StartArray{})
-	for item := range in {
+	for item := stream.Next(); item != nil; item = stream.Next() {
 		out.Put(item)
 	}
 	out.Put(&token.EndArray{})
+	stream.ForwardError(out)
 }
 
-// TraceStream logs all the stream items and doesn't send any items on.
-// It's useful for debugging streams
-type TraceStream struct{}
+// TraceStream logs the stream items and sends them on unchanged, so it can be
+// inserted anywhere in a pipeline to debug it.  Items are logged to Logger, or
+// to the standard logger if it is nil.
+//
+// If Values is true, the items of each top-level value are bracketed with
+// "--- value N ---" and "--- end value N ---" lines, where N counts values
+// from 1.  If Every is greater than 1, only one item in Every is logged (the
+// first one, then the one after Every-1 items, etc.).  If Limit is positive,
+// logging stops after Limit items have been logged, with a "--- trace limit
+// reached ---" line.
+type TraceStream struct {
+	Values bool
+	Logger *log.Logger
+	Every  int
+	Limit  int
+}
 
 // Transform implements the TraceStream transform
 func (t TraceStream) Transform(in <-chan token.Token, out token.WriteStream) {
+	logf := log.Printf
+	if t.Logger != nil {
+		logf = t.Logger.Printf
+	}
+	depth := 0
+	count := 0
+	seen := 0
+	logged := 0
 	for item := range in {
-		log.Printf("%s", item)
+		out.Put(item)
+		if t.Limit > 0 && logged >= t.Limit {
+			continue
+		}
+		if t.Values && depth == 0 {
+			count++
+			logf("--- value %d ---", count)
+		}
+		if t.Every <= 1 || seen%t.Every == 0 {
+			logf("%s", item)
+			logged++
+		}
+		seen++
+		switch item.(type) {
+		case *token.StartArray, *token.StartObject:
+			depth++
+		case *token.EndArray, *token.EndObject:
+			depth--
+		}
+		if t.Values && depth == 0 {
+			logf("--- end value %d ---", count)
+		}
+		if t.Limit > 0 && logged == t.Limit {
+			logf("--- trace limit reached ---")
+		}
+	}
+}
+
+// Tee is a StreamTransformer which passes its input on unchanged and also sends
+// it to Sink (e.g. an encoder writing to a file), so that the stream at some
+// position of a pipeline can be inspected or saved.  If Sink fails, Tee fails
+// with a *token.TransformError wrapping its error after the current value.
+type Tee struct {
+	Sink token.StreamSink
+}
+
+// Transform implements the Tee transform
+func (t Tee) Transform(in <-chan token.Token, out token.WriteStream) {
+	sinkIn := make(chan token.Token)
+	sinkErr := make(chan error, 1)
+	var failed atomic.Bool
+	go func() {
+		err := t.Sink.Consume(sinkIn)
+		if err != nil {
+			failed.Store(true)
+		}
+		sinkErr <- err
+
+		// Drain the channel so that the stream is not blocked.
+		for range sinkIn {
+		}
+	}()
+	depth := 0
+	for tok := range in {
+		// Stop between values so that the output is well-formed.
+		if depth == 0 && failed.Load() {
+			break
+		}
+		if _, ok := tok.(*token.StreamError); ok {
+			// The error is for the end of the pipeline, not for Sink.
+			out.Put(tok)
+			continue
+		}
+		switch tok.(type) {
+		case *token.StartArray, *token.StartObject:
+			depth++
+		case *token.EndArray, *token.EndObject:
+			depth--
+		}
+		out.Put(tok)
+		sinkIn <- tok
+	}
+	close(sinkIn)
+	if err := <-sinkErr; err != nil {
+		panic(&token.TransformError{Err: err})
+	}
+}
+
+// RegexpFilter is a Transformer that only keeps the values in the stream that
+// match a regular expression.  If Target is nil, the pattern is matched against
+// the compact JSON text of the value (truncated to MaxTextSize bytes if it is
+// positive), otherwise it is matched against the string values selected by
+// Target.
+//
+// E.g. if the pattern is /error/i
+//
+//	{"msg": "Error: no such file"} -> {"msg": "Error: no such file"}
+//	{"msg": "all good"}            -> <empty stream>
+type RegexpFilter struct {
+	Pattern     *regexp.Regexp
+	Target      *jsonpathtransformer.MainQueryRunner
+	MaxTextSize int
+}
+
+// TransformValue implements the RegexpFilter transform
+func (f *RegexpFilter) TransformValue(value iterator.Value, out token.WriteStream) {
+	clone, detach := value.Clone()
+	matched := f.matches(clone)
+	if detach != nil {
+		detach()
+	}
+	if matched {
+		value.Copy(out)
+	} else {
+		value.Discard()
+	}
+}
+
+func (f *RegexpFilter) matches(value iterator.Value) bool {
+	if f.Target == nil {
+		return f.Pattern.Match(valueText(value, f.MaxTextSize))
+	}
+	matched := false
+	f.Target.EvaluateNodesResult(value).ForEachNode(func(v iterator.Value) bool {
+		scalar, ok := v.AsScalar()
+		matched = ok && scalar.Type() == token.String && f.Pattern.MatchString(scalar.ToString())
+		return !matched
+	})
+	return matched
+}
+
+// valueText returns the compact JSON encoding of value, truncated to maxSize
+// bytes if maxSize is positive.
+func valueText(value iterator.Value, maxSize int) []byte {
+	w := &limitedBuffer{maxSize: maxSize}
+	// An error means the limit has been reached, which is expected.
+	_ = WriteJSON(w, value)
+	return w.Bytes()
+}
+
+// limitedBuffer is a bytes.Buffer which refuses to grow beyond maxSize bytes
+// (if maxSize is positive).
+type limitedBuffer struct {
+	bytes.Buffer
+	maxSize int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.maxSize > 0 && b.Len()+len(p) > b.maxSize {
+		n, _ := b.Buffer.Write(p[:b.maxSize-b.Len()])
+		return n, errLimitReached
+	}
+	return b.Buffer.Write(p)
+}
+
+var errLimitReached = errors.New("limit reached")
+
+// WindowDedupFilter is a Transformer that drops values which are equal to a
+// value seen recently.  It keeps the hashes of the last WindowSize distinct
+// values it has seen (a duplicate value counts as seen again), so its memory
+// usage is bounded.  Equality is approximated by comparing hashes.
+//
+// E.g. if WindowSize is 2
+//
+//	1 2 1 3 4 1 -> 1 2 3 4 1
+type WindowDedupFilter struct {
+	WindowSize int
+
+	recent  *list.List               // most recently seen hashes at the front
+	entries map[uint64]*list.Element // elements of recent, indexed by hash
+}
+
+// TransformValue implements the WindowDedupFilter transform
+func (f *WindowDedupFilter) TransformValue(value iterator.Value, out token.WriteStream) {
+	if f.recent == nil {
+		f.recent = list.New()
+		f.entries = map[uint64]*list.Element{}
+	}
+	clone, detach := value.Clone()
+	h := HashValue(clone)
+	if detach != nil {
+		detach()
+	}
+	if elt, ok := f.entries[h]; ok {
+		f.recent.MoveToFront(elt)
+		value.Discard()
+		return
+	}
+	f.entries[h] = f.recent.PushFront(h)
+	if f.recent.Len() > f.WindowSize {
+		delete(f.entries, f.recent.Remove(f.recent.Back()).(uint64))
+	}
+	value.Copy(out)
+}
+
+// ReservoirSample is a Transformer that outputs K values chosen uniformly at
+// random among the values in its input, which can have any length.  It uses
+// reservoir sampling so it only keeps at most K values in memory, but it only
+// outputs them (in the order of the input) when the input is exhausted.  If
+// there are at most K values in the input, they are all output.
+//
+// Rand is the source of randomness, which can be seeded for reproducible
+// samples.
+type ReservoirSample struct {
+	K    int
+	Rand *rand.Rand
+}
+
+// Transform implements the ReservoirSample transform
+func (f *ReservoirSample) Transform(in <-chan token.Token, out token.WriteStream) {
+	type sample struct {
+		index int
+		toks  []token.Token
+	}
+	reservoir := make([]sample, 0, f.K)
+	stream := token.NewStreamErrorReader(in)
+	iter := iterator.New(stream)
+	for i := 0; iter.Advance(); i++ {
+		slot := i
+		if i >= f.K {
+			slot = f.Rand.Intn(i + 1)
+			if slot >= f.K {
+				continue
+			}
+		}
+		acc := token.NewAccumulatorStream()
+		iter.CurrentValue().Copy(acc)
+		if i < f.K {
+			reservoir = append(reservoir, sample{i, acc.GetTokens()})
+		} else {
+			reservoir[slot] = sample{i, acc.GetTokens()}
+		}
+	}
+	sort.Slice(reservoir, func(i, j int) bool { return reservoir[i].index < reservoir[j].index })
+	for _, s := range reservoir {
+		for _, tok := range s.toks {
+			out.Put(tok)
+		}
+	}
+	stream.ForwardError(out)
+}
+
+// HashValue consumes value and returns a 64 bit hash of its tokens.
+func HashValue(value iterator.Value) uint64 {
+	hasher := hashingStream{fnv.New64a()}
+	value.Copy(hasher)
+	return hasher.Sum64()
+}
+
+// hashingStream is a WriteStream which updates a hash with the tokens it is
+// given.
+type hashingStream struct {
+	hash.Hash64
+}
+
+func (s hashingStream) Put(tok token.Token) {
+	switch x := tok.(type) {
+	case *token.Scalar:
+		s.Write([]byte{'s', x.TypeAndFlags & (token.TypeMask | token.KeyMask)})
+		s.Write(x.Bytes)
+	case *token.StartObject:
+		s.Write([]byte{'{'})
+	case *token.EndObject:
+		s.Write([]byte{'}'})
+	case *token.StartArray:
+		s.Write([]byte{'['})
+	case *token.EndArray:
+		s.Write([]byte{']'})
+	case *token.Elision:
+		s.Write([]byte{'.'})
 	}
 }
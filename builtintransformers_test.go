@@ -0,0 +1,1113 @@
+package jsonstream_test
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/internal/jsonpath"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// transformJSONString decodes the input, applies the transformers in sequence
+// and returns the output as compact JSON with one value per line.
+func transformJSONString(t *testing.T, input string, transformers ...token.StreamTransformer) string {
+	stream := token.StartStream(jsonstream.NewJSONDecoder(strings.NewReader(input)), func(err error) {
+		t.Errorf("Unexpected decoding error: %s", err)
+	})
+	for _, transformer := range transformers {
+		stream = token.TransformStream(stream, transformer)
+	}
+	return encodeJSONStream(t, stream)
+}
+
+func encodeJSONStream(t *testing.T, stream <-chan token.Token) string {
+	var b strings.Builder
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	if err := token.ConsumeStream(stream, encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return b.String()
+}
+
+func mustCompileQuery(t *testing.T, s string) *jsonpathtransformer.MainQueryRunner {
+	query, err := jsonpath.ParseQueryString(s)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	runner, err := jsonpathtransformer.CompileQuery(query)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	return &runner
+}
+
+func TestRegexpFilter(t *testing.T) {
+	input := `{"msg": "An ERROR occurred", "id": 1}
+{"msg": "all good", "id": 2}
+{"msg": "fine", "detail": {"text": "error in nested value"}, "id": 3}
+"error"
+42`
+	t.Run("whole value", func(t *testing.T) {
+		filter := &jsonstream.RegexpFilter{Pattern: regexp.MustCompile(`(?i)error`)}
+		got := transformJSONString(t, input, iterator.AsStreamTransformer(filter))
+		expected := `{"msg": "An ERROR occurred","id": 1}
+{"msg": "fine","detail": {"text": "error in nested value"},"id": 3}
+"error"
+`
+		if got != expected {
+			t.Fatalf("Expected %q, got %q", expected, got)
+		}
+	})
+	t.Run("targeted field", func(t *testing.T) {
+		filter := &jsonstream.RegexpFilter{
+			Pattern: regexp.MustCompile(`(?i)error`),
+			Target:  mustCompileQuery(t, "$.msg"),
+		}
+		got := transformJSONString(t, input, iterator.AsStreamTransformer(filter))
+		expected := `{"msg": "An ERROR occurred","id": 1}
+`
+		if got != expected {
+			t.Fatalf("Expected %q, got %q", expected, got)
+		}
+	})
+	t.Run("bounded text", func(t *testing.T) {
+		filter := &jsonstream.RegexpFilter{Pattern: regexp.MustCompile(`nested`), MaxTextSize: 20}
+		got := transformJSONString(t, input, iterator.AsStreamTransformer(filter))
+		if got != "" {
+			t.Fatalf("Expected no output, got %q", got)
+		}
+	})
+}
+
+func TestWindowDedupFilter(t *testing.T) {
+	type testCase struct {
+		name       string
+		windowSize int
+		input      string
+		output     string
+	}
+	var testCases = []testCase{
+		{
+			name:       "duplicate within window",
+			windowSize: 2,
+			input:      `1 {"a": [2]} 1 {"a": [2]}`,
+			output:     "1\n{\"a\": [2]}\n",
+		},
+		{
+			name:       "duplicate outside window",
+			windowSize: 2,
+			input:      `1 2 3 1`,
+			output:     "1\n2\n3\n1\n",
+		},
+		{
+			name:       "duplicates refresh the window",
+			windowSize: 2,
+			input:      `1 2 1 3 1 2`,
+			output:     "1\n2\n3\n2\n",
+		},
+		{
+			name:       "keys and strings differ",
+			windowSize: 10,
+			input:      `{"a": "b"} {"b": "a"} ["a", "b"]`,
+			output:     "{\"a\": \"b\"}\n{\"b\": \"a\"}\n[\"a\",\"b\"]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			filter := &jsonstream.WindowDedupFilter{WindowSize: c.windowSize}
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(filter))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestExplodeArrayStrict(t *testing.T) {
+	type testCase struct {
+		name   string
+		strict bool
+		input  string
+		output string
+		err    string
+	}
+	var testCases = []testCase{
+		{
+			name:   "arrays",
+			strict: true,
+			input:  `[1, 2] [] [[3]]`,
+			output: "1\n2\n[3]\n",
+		},
+		{
+			name:   "pass-through by default",
+			input:  `[1] {"a": 2} "x"`,
+			output: "1\n{\"a\": 2}\n\"x\"\n",
+		},
+		{
+			name:   "scalar in strict mode",
+			strict: true,
+			input:  `[1] 2 [3]`,
+			output: "1\n",
+			err:    "split: expected an array, got a number",
+		},
+		{
+			name:   "object in strict mode",
+			strict: true,
+			input:  `{"a": [1]} [2]`,
+			output: "",
+			err:    "split: expected an array, got an object",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var transformErr error
+			stream := token.TransformStreamWithErrorHandler(
+				streamJSONString(c.input),
+				iterator.AsStreamTransformer(jsonstream.ExplodeArray{Strict: c.strict}),
+				func(err error) { transformErr = err },
+			)
+			got := encodeJSONStream(t, stream)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+			switch {
+			case c.err == "" && transformErr != nil:
+				t.Fatalf("Unexpected error: %s", transformErr)
+			case c.err != "" && transformErr == nil:
+				t.Fatalf("Expected error %q", c.err)
+			case c.err != "" && transformErr.Error() != c.err:
+				t.Fatalf("Expected error %q, got %q", c.err, transformErr)
+			}
+		})
+	}
+}
+
+func TestObjectValues(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "key order",
+			input:  `{"z": 1, "a": 2, "m": 3}`,
+			output: "1\n2\n3\n",
+		},
+		{
+			name:   "nested values",
+			input:  `{"id1": {"name": "x", "tags": [1, {"y": null}]}, "id2": [[]]}`,
+			output: "{\"name\": \"x\",\"tags\": [1,{\"y\": null}]}\n[[]]\n",
+		},
+		{
+			name:   "other values",
+			input:  `[1, 2] "x" {} {"a": true}`,
+			output: "[1,2]\n\"x\"\ntrue\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(jsonstream.ObjectValues{}))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		keys   []string
+		omit   bool
+		output string
+	}
+	input := `{"name": "x", "password": "p", "email": "e"} [1] {"a": {"name": 1}}`
+	var testCases = []testCase{
+		{
+			name:   "pick",
+			input:  input,
+			keys:   []string{"email", "name"},
+			output: "{\"name\": \"x\",\"email\": \"e\"}\n[1]\n{}\n",
+		},
+		{
+			name:   "omit",
+			input:  input,
+			keys:   []string{"password"},
+			omit:   true,
+			output: "{\"name\": \"x\",\"email\": \"e\"}\n[1]\n{\"a\": {\"name\": 1}}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			filter := jsonstream.KeyFilter{Keys: map[string]bool{}, Omit: c.omit}
+			for _, key := range c.keys {
+				filter.Keys[key] = true
+			}
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(filter))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestKeysValuesEntries(t *testing.T) {
+	type testCase struct {
+		name        string
+		input       string
+		transformer iterator.ValueTransformer
+		output      string
+	}
+	input := `{"z": 1, "a": [2]} {} [1, 2] "x"`
+	var testCases = []testCase{
+		{
+			name:        "keys",
+			input:       input,
+			transformer: jsonstream.Keys{},
+			output:      "[\"z\",\"a\"]\n[]\n[1,2]\n\"x\"\n",
+		},
+		{
+			name:        "values",
+			input:       input,
+			transformer: jsonstream.Values{},
+			output:      "[1,[2]]\n[]\n[1,2]\n\"x\"\n",
+		},
+		{
+			name:        "entries",
+			input:       input,
+			transformer: jsonstream.Entries{},
+			output:      "[[\"z\",1],[\"a\",[2]]]\n[]\n[1,2]\n\"x\"\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(c.transformer))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestMaxDepthFilterAfterSplit(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		depth  int
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "nested objects",
+			input:  `[{"a": {"b": {"c": 1}}, "d": 2}, {"e": [1, [2]]}, {"f": {}}]`,
+			depth:  1,
+			output: "{\"a\": {...},\"d\": 2}\n{\"e\": [...]}\n{\"f\": {...}}\n",
+		},
+		{
+			name:   "mixed elements",
+			input:  `[1, [[2]], {"x": [3]}, "y"]`,
+			depth:  1,
+			output: "1\n[[...]]\n{\"x\": [...]}\n\"y\"\n",
+		},
+		{
+			name:   "depth 0",
+			input:  `[[1], {"a": 1}, 2] [3]`,
+			depth:  0,
+			output: "[...]\n{...}\n2\n3\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input,
+				iterator.AsStreamTransformer(jsonstream.ExplodeArray{}),
+				&jsonstream.MaxDepthFilter{MaxDepth: c.depth},
+			)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestTryTransformer(t *testing.T) {
+	type testCase struct {
+		name        string
+		input       string
+		transformer token.StreamTransformer
+		output      string
+	}
+	var testCases = []testCase{
+		{
+			name:        "mixed success and errors",
+			input:       `[1, 2] 3 [4] {"a": [5]}`,
+			transformer: iterator.AsStreamTransformer(jsonstream.ExplodeArray{Strict: true}),
+			output: `1
+2
+{"_error": "split: expected an array, got a number","_input": 3}
+4
+{"_error": "split: expected an array, got an object","_input": {"a": [5]}}
+`,
+		},
+		{
+			name:        "no errors",
+			input:       `{"x": 1} {"x": [2]}`,
+			transformer: iterator.AsStreamTransformer(&jsonstream.KeyExtractor{Key: "x"}),
+			output:      "1\n[2]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var transformErr error
+			stream := token.TransformStreamWithErrorHandler(
+				streamJSONString(c.input),
+				iterator.AsStreamTransformer(jsonstream.TryTransformer{Transformer: c.transformer}),
+				func(err error) { transformErr = err },
+			)
+			got := encodeJSONStream(t, stream)
+			if transformErr != nil {
+				t.Fatalf("Unexpected error: %s", transformErr)
+			}
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestKeyGlobFilter(t *testing.T) {
+	type testCase struct {
+		name   string
+		glob   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "prefix",
+			glob:   "user_*",
+			input:  `{"user_id": 5, "admin": true, "user_name": "Kim", "superuser_id": 1}`,
+			output: "{\"user_id\": 5,\"user_name\": \"Kim\"}\n",
+		},
+		{
+			name:   "no match",
+			glob:   "user_*",
+			input:  `{"admin": {"user_id": 1}}`,
+			output: "{}\n",
+		},
+		{
+			name:   "single character and class",
+			glob:   "x?[0-9]",
+			input:  `{"xa1": 1, "xb": 2, "xab": 3, "x-9": 4}`,
+			output: "{\"xa1\": 1,\"x-9\": 4}\n",
+		},
+		{
+			name:   "negated class",
+			glob:   "[!a]*",
+			input:  `{"abc": 1, "bcd": 2}`,
+			output: "{\"bcd\": 2}\n",
+		},
+		{
+			name:   "escaped and special characters",
+			glob:   `a.\*`,
+			input:  `{"a.*": 1, "ab*": 2, "a.b": 3}`,
+			output: "{\"a.*\": 1}\n",
+		},
+		{
+			name:   "other values",
+			glob:   "*",
+			input:  `[{"a": 1}] "x"`,
+			output: "[{\"a\": 1}]\n\"x\"\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			ptn, err := jsonstream.GlobToRegexp(c.glob)
+			if err != nil {
+				t.Fatalf("Invalid glob: %s", err)
+			}
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(&jsonstream.KeyGlobFilter{Pattern: ptn}))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestReindex(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		key     string
+		collect bool
+		output  string
+		err     string
+	}
+	var testCases = []testCase{
+		{
+			name:   "unique keys",
+			input:  `[{"id": "a", "x": 1}, {"id": "b", "x": [2]}]`,
+			key:    `$.id`,
+			output: "{\"a\": {\"id\": \"a\",\"x\": 1},\"b\": {\"id\": \"b\",\"x\": [2]}}\n",
+		},
+		{
+			name:   "duplicate keys",
+			input:  `[{"id": 1}, {"id": 2}, {"id": 1, "y": true}]`,
+			key:    `$.id`,
+			output: "{\"1\": {\"id\": 1},\"2\": {\"id\": 2},\"1\": {\"id\": 1,\"y\": true}}\n",
+		},
+		{
+			name:    "duplicate keys collected",
+			input:   `[{"id": 1}, {"id": 2}, {"id": 1, "y": true}]`,
+			key:     `$.id`,
+			collect: true,
+			output:  "{\"1\": [{\"id\": 1},{\"id\": 1,\"y\": true}],\"2\": [{\"id\": 2}]}\n",
+		},
+		{
+			name:   "nested key",
+			input:  `[{"user": {"name": "Kim"}, "n": 1}]`,
+			key:    `$.user.name`,
+			output: "{\"Kim\": {\"user\": {\"name\": \"Kim\"},\"n\": 1}}\n",
+		},
+		{
+			name:   "other values",
+			input:  `{"a": 1} "x"`,
+			key:    `$.id`,
+			output: "{\"a\": 1}\n\"x\"\n",
+		},
+		{
+			name:  "missing key",
+			input: `[{"id": 1}, {"x": 2}]`,
+			key:   `$.id`,
+			err:   "reindex: item has no key",
+		},
+		{
+			name:  "non scalar key",
+			input: `[{"id": [1]}]`,
+			key:   `$.id`,
+			err:   "reindex: expected a scalar key, got an array",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var transformErr error
+			stream := token.TransformStreamWithErrorHandler(
+				streamJSONString(c.input),
+				iterator.AsStreamTransformer(&jsonstream.Reindex{Key: mustCompileQuery(t, c.key), Collect: c.collect}),
+				func(err error) { transformErr = err },
+			)
+			got := encodeJSONStream(t, stream)
+			switch {
+			case c.err == "" && transformErr != nil:
+				t.Fatalf("Unexpected error: %s", transformErr)
+			case c.err == "" && got != c.output:
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			case c.err != "" && transformErr == nil:
+				t.Fatalf("Expected error %q", c.err)
+			case c.err != "" && transformErr.Error() != c.err:
+				t.Fatalf("Expected error %q, got %q", c.err, transformErr)
+			}
+		})
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		key    string
+		asMap  bool
+		sorted bool
+		output string
+	}
+	input := `{"l": "i", "n": 1} {"l": "w", "n": 2.0} {"l": "i", "n": 3} {"n": 2}`
+	var testCases = []testCase{
+		{
+			name:   "groups",
+			input:  input,
+			key:    `$.l`,
+			output: "{\"key\": \"i\",\"items\": [{\"l\": \"i\",\"n\": 1},{\"l\": \"i\",\"n\": 3}]}\n{\"key\": \"w\",\"items\": [{\"l\": \"w\",\"n\": 2.0}]}\n{\"key\": null,\"items\": [{\"n\": 2}]}\n",
+		},
+		{
+			name:   "equal numbers",
+			input:  input,
+			key:    `$.n`,
+			asMap:  true,
+			output: "{\"1\": [{\"l\": \"i\",\"n\": 1}],\"2.0\": [{\"l\": \"w\",\"n\": 2.0},{\"n\": 2}],\"3\": [{\"l\": \"i\",\"n\": 3}]}\n",
+		},
+		{
+			name:   "sorted",
+			input:  input,
+			key:    `$.l`,
+			sorted: true,
+			output: "{\"key\": \"i\",\"items\": [{\"l\": \"i\",\"n\": 1}]}\n{\"key\": \"w\",\"items\": [{\"l\": \"w\",\"n\": 2.0}]}\n{\"key\": \"i\",\"items\": [{\"l\": \"i\",\"n\": 3}]}\n{\"key\": null,\"items\": [{\"n\": 2}]}\n",
+		},
+		{
+			name:   "sorted map",
+			input:  `{"a": [1]} {"a": [1]} {"a": [2]}`,
+			key:    `$.a[0]`,
+			asMap:  true,
+			sorted: true,
+			output: "{\"1\": [{\"a\": [1]},{\"a\": [1]}],\"2\": [{\"a\": [2]}]}\n",
+		},
+		{
+			name:   "empty map",
+			input:  ``,
+			key:    `$.a`,
+			asMap:  true,
+			output: "{}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			groupBy := &jsonstream.GroupBy{Key: mustCompileQuery(t, c.key), Map: c.asMap, Sorted: c.sorted}
+			got := transformJSONString(t, c.input, groupBy)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestGroupByMapError(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		var err error
+		groupBy := &jsonstream.GroupBy{Key: mustCompileQuery(t, `$.a`), Map: true, Sorted: sorted}
+		stream := token.TransformStreamWithErrorHandler(streamJSONString(`{"a": 1} {"a": [1]}`), groupBy, func(e error) { err = e })
+		encodeJSONStream(t, stream)
+		if err == nil || err.Error() != "group_by: expected a scalar key, got an array" {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestObjectConstructor(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		fields []jsonstream.ConstructedField
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:  "singular fields",
+			input: `{"user": {"id": 1, "name": "Bob"}, "cart": {"total": 12}} 2`,
+			fields: []jsonstream.ConstructedField{
+				{Key: "name", Query: mustCompileQuery(t, `$.user.name`)},
+				{Key: "total", Query: mustCompileQuery(t, `$.cart.total`)},
+			},
+			output: "{\"name\": \"Bob\",\"total\": 12}\n{\"name\": null,\"total\": null}\n",
+		},
+		{
+			name:  "first node",
+			input: `[{"x": 1}, {"x": 2}]`,
+			fields: []jsonstream.ConstructedField{
+				{Key: "x", Query: mustCompileQuery(t, `$[*].x`)},
+			},
+			output: "{\"x\": 1}\n",
+		},
+		{
+			name:  "array fields",
+			input: `[{"x": 1}, {"x": 2}] 3`,
+			fields: []jsonstream.ConstructedField{
+				{Key: "xs", Query: mustCompileQuery(t, `$[*].x`), Array: true},
+				{Key: "all", Query: mustCompileQuery(t, `$`), Array: true},
+			},
+			output: "{\"xs\": [1,2],\"all\": [[{\"x\": 1},{\"x\": 2}]]}\n{\"xs\": [],\"all\": [3]}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			constructor := &jsonstream.ObjectConstructor{Fields: c.fields}
+			got := encodeJSONStream(t, token.TransformStream(streamJSONString(c.input), iterator.AsStreamTransformer(constructor)))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestSetter(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		path   string
+		value  string
+		parent string
+		key    string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "replace root",
+			input:  `1 [2]`,
+			path:   `$`,
+			value:  `{"a": null}`,
+			output: "{\"a\": null}\n{\"a\": null}\n",
+		},
+		{
+			name:   "replace existing nodes",
+			input:  `{"users": [{"active": false}, {"id": 2}, {"active": [1, 2]}]}`,
+			path:   `$.users[*].active`,
+			value:  `true`,
+			output: "{\"users\": [{\"active\": true},{\"id\": 2},{\"active\": true}]}\n",
+		},
+		{
+			name:   "add missing keys",
+			input:  `{"users": [{"active": false}, {"id": 2}, 3]}`,
+			path:   `$.users[*].active`,
+			value:  `true`,
+			parent: `$.users[*]`,
+			key:    "active",
+			output: "{\"users\": [{\"active\": true},{\"id\": 2,\"active\": true},3]}\n",
+		},
+		{
+			name:   "filter and indices",
+			input:  `[1, 5, 2, 7]`,
+			path:   `$[?@ > 4, 0]`,
+			value:  `[]`,
+			output: "[[],[],2,[]]\n",
+		},
+		{
+			name:   "nested selections",
+			input:  `{"a": {"a": 1}, "b": [{"a": 2}]}`,
+			path:   `$..a`,
+			value:  `0`,
+			output: "{\"a\": 0,\"b\": [{\"a\": 0}]}\n",
+		},
+		{
+			name:   "nothing selected",
+			input:  `{"a": [1, {"b": 2}]}`,
+			path:   `$.x`,
+			value:  `0`,
+			output: "{\"a\": [1,{\"b\": 2}]}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var value []token.Token
+			for tok := range streamJSONString(c.value) {
+				value = append(value, tok)
+			}
+			setter := &jsonstream.Setter{Path: mustCompileQuery(t, c.path), Value: value, Key: c.key}
+			if c.parent != "" {
+				setter.Parent = mustCompileQuery(t, c.parent)
+			}
+			got := encodeJSONStream(t, token.TransformStream(streamJSONString(c.input), iterator.AsStreamTransformer(setter)))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestDeleter(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		path   string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "delete keys",
+			input:  `{"users": [{"id": 1, "password": "x"}, {"id": 2}, 3]}`,
+			path:   `$.users[*].password`,
+			output: "{\"users\": [{\"id\": 1},{\"id\": 2},3]}\n",
+		},
+		{
+			name:   "delete array items",
+			input:  `[1, 5, 2, 7]`,
+			path:   `$[?@ > 4, 0]`,
+			output: "[2]\n",
+		},
+		{
+			name:   "delete root",
+			input:  `1 [2]`,
+			path:   `$`,
+			output: "",
+		},
+		{
+			name:   "nested selections",
+			input:  `{"a": {"a": 1}, "b": [{"a": 2, "c": 3}]}`,
+			path:   `$..a`,
+			output: "{\"b\": [{\"c\": 3}]}\n",
+		},
+		{
+			name:   "nothing selected",
+			input:  `{"a": [1, {"b": 2}]}`,
+			path:   `$.x`,
+			output: "{\"a\": [1,{\"b\": 2}]}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			deleter := &jsonstream.Deleter{Path: mustCompileQuery(t, c.path)}
+			got := encodeJSONStream(t, token.TransformStream(streamJSONString(c.input), iterator.AsStreamTransformer(deleter)))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestOmitEmpty(t *testing.T) {
+	type testCase struct {
+		name   string
+		kinds  jsonstream.EmptyKind
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "all kinds",
+			kinds:  jsonstream.AllEmptyKinds,
+			input:  `{"a": 1, "b": "", "c": [], "d": {}, "e": null, "f": false, "g": 0, "h": " "}`,
+			output: "{\"a\": 1,\"f\": false,\"g\": 0,\"h\": \" \"}\n",
+		},
+		{
+			name:   "recursive",
+			kinds:  jsonstream.AllEmptyKinds,
+			input:  `{"a": {"b": null, "c": [{"d": "", "e": [1]}, {}]}, "f": {"g": []}}`,
+			output: "{\"a\": {\"c\": [{\"e\": [1]},{}]},\"f\": {}}\n",
+		},
+		{
+			name:   "some kinds",
+			kinds:  jsonstream.EmptyNull | jsonstream.EmptyArray,
+			input:  `{"a": null, "b": "", "c": [], "d": {}}`,
+			output: "{\"b\": \"\",\"d\": {}}\n",
+		},
+		{
+			name:   "top-level values are kept",
+			kinds:  jsonstream.AllEmptyKinds,
+			input:  `null "" [] {} [null, ""]`,
+			output: "null\n\"\"\n[]\n{}\n[null,\"\"]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(jsonstream.OmitEmpty{Kinds: c.kinds}))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+// Transforms which iterate over collections must cope with contents elided by
+// a MaxDepthFilter upstream, and not emit malformed streams.
+func TestTransformsOnElidedInput(t *testing.T) {
+	type testCase struct {
+		name        string
+		input       string
+		depth       int
+		transformer token.StreamTransformer
+		output      string
+	}
+	glob, err := jsonstream.GlobToRegexp("*")
+	if err != nil {
+		t.Fatalf("Invalid glob: %s", err)
+	}
+	var testCases = []testCase{
+		{
+			name:        "split nested arrays",
+			input:       `[[1, [2]], {"a": [3]}, 4]`,
+			depth:       1,
+			transformer: iterator.AsStreamTransformer(jsonstream.ExplodeArray{}),
+			output:      "[...]\n{...}\n4\n",
+		},
+		{
+			name:        "split elided array",
+			input:       `[[1, 2], 3] 4`,
+			depth:       0,
+			transformer: iterator.AsStreamTransformer(jsonstream.ExplodeArray{}),
+			output:      "4\n",
+		},
+		{
+			name:        "object values",
+			input:       `{"a": {"b": 1}, "c": 2} {"d": 3}`,
+			depth:       1,
+			transformer: iterator.AsStreamTransformer(jsonstream.ObjectValues{}),
+			output:      "{...}\n2\n3\n",
+		},
+		{
+			name:        "glob",
+			input:       `{"a": {"b": 1}} {"c": [2]}`,
+			depth:       0,
+			transformer: iterator.AsStreamTransformer(&jsonstream.KeyGlobFilter{Pattern: glob}),
+			output:      "{...}\n{...}\n",
+		},
+		{
+			name:        "omit empty",
+			input:       `{"a": {"b": null}, "c": [], "d": null}`,
+			depth:       1,
+			transformer: iterator.AsStreamTransformer(jsonstream.OmitEmpty{Kinds: jsonstream.AllEmptyKinds}),
+			output:      "{\"a\": {...},\"c\": [...]}\n",
+		},
+		{
+			name:        "reindex",
+			input:       `[{"id": 1}, {"id": 2}] [[{"id": 3}]]`,
+			depth:       0,
+			transformer: iterator.AsStreamTransformer(&jsonstream.Reindex{Key: mustCompileQuery(t, "$.id")}),
+			output:      "{...}\n{...}\n",
+		},
+		{
+			name:        "jsonpath wildcard",
+			input:       `[{"a": [1]}, [2]]`,
+			depth:       1,
+			transformer: *mustCompileQuery(t, "$[*]"),
+			output:      "{...}\n[...]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input, &jsonstream.MaxDepthFilter{MaxDepth: c.depth}, c.transformer)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestTraceStreamValues(t *testing.T) {
+	var trace strings.Builder
+	log.SetOutput(&trace)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	}()
+
+	got := transformJSONString(t, `1 {"a": [2]} "x"`, jsonstream.TraceStream{Values: true})
+	if expected := "1\n{\"a\": [2]}\n\"x\"\n"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	expected := `--- value 1 ---
+Scalar(1)
+--- end value 1 ---
+--- value 2 ---
+StartObject
+Scalar("a")
+StartArray
+Scalar(2)
+EndArray
+EndObject
+--- end value 2 ---
+--- value 3 ---
+Scalar("x")
+--- end value 3 ---
+`
+	if trace.String() != expected {
+		t.Fatalf("Expected trace %q, got %q", expected, trace.String())
+	}
+}
+
+func TestTraceStreamSampling(t *testing.T) {
+	type testCase struct {
+		name  string
+		trace jsonstream.TraceStream
+		log   string
+	}
+	var testCases = []testCase{
+		{
+			name:  "every",
+			trace: jsonstream.TraceStream{Every: 3},
+			log:   "StartArray\nScalar(3)\nStartArray\n",
+		},
+		{
+			name:  "limit",
+			trace: jsonstream.TraceStream{Limit: 2},
+			log:   "StartArray\nScalar(1)\n--- trace limit reached ---\n",
+		},
+		{
+			name:  "every and limit with values",
+			trace: jsonstream.TraceStream{Values: true, Every: 2, Limit: 2},
+			log:   "--- value 1 ---\nStartArray\nScalar(2)\n--- trace limit reached ---\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var trace strings.Builder
+			c.trace.Logger = log.New(&trace, "", 0)
+			got := transformJSONString(t, `[1, 2, 3, 4] [5]`, c.trace)
+			if expected := "[1,2,3,4]\n[5]\n"; got != expected {
+				t.Fatalf("Expected %q, got %q", expected, got)
+			}
+			if trace.String() != c.log {
+				t.Fatalf("Expected trace %q, got %q", c.log, trace.String())
+			}
+		})
+	}
+}
+
+func TestTee(t *testing.T) {
+	var b strings.Builder
+	tee := jsonstream.Tee{Sink: &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}}
+	got := transformJSONString(t, `[1, 2] {"a": "x"}`, tee, iterator.AsStreamTransformer(jsonstream.ExplodeArray{}))
+	if expected := "1\n2\n{\"a\": \"x\"}\n"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	if expected := "[1,2]\n{\"a\": \"x\"}\n"; b.String() != expected {
+		t.Fatalf("Expected tee output %q, got %q", expected, b.String())
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Consume(in <-chan token.Token) error {
+	<-in
+	return errors.New("disk full")
+}
+
+func TestTeeError(t *testing.T) {
+	var err error
+	input := strings.Repeat("[1, 2, 3] ", 100)
+	stream := token.TransformStreamWithErrorHandler(streamJSONString(input), jsonstream.Tee{Sink: failingSink{}}, func(e error) { err = e })
+	encodeJSONStream(t, stream)
+	if err == nil || err.Error() != "disk full" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// split and join are inverse of each other: "split join" turns an array back
+// into itself and "join split" turns a stream back into itself.
+func TestSplitJoinRoundTrip(t *testing.T) {
+	splitJoin := []token.StreamTransformer{iterator.AsStreamTransformer(jsonstream.ExplodeArray{}), jsonstream.JoinStream{}}
+	joinSplit := []token.StreamTransformer{jsonstream.JoinStream{}, iterator.AsStreamTransformer(jsonstream.ExplodeArray{})}
+	type testCase struct {
+		name         string
+		input        string
+		transformers []token.StreamTransformer
+	}
+	var testCases = []testCase{
+		{
+			name:         "split join array",
+			input:        `[1, "a", null, {"b": [2]}]`,
+			transformers: splitJoin,
+		},
+		{
+			name:         "split join empty array",
+			input:        `[]`,
+			transformers: splitJoin,
+		},
+		{
+			name:         "split join nested arrays",
+			input:        `[[], [[1]], [2, [3, []]]]`,
+			transformers: splitJoin,
+		},
+		{
+			name:         "join split stream",
+			input:        `1 "a" {"b": [2]} null`,
+			transformers: joinSplit,
+		},
+		{
+			name:         "join split empty stream",
+			input:        ``,
+			transformers: joinSplit,
+		},
+		{
+			name:         "join split stream of arrays",
+			input:        `[] [1, [2]] [[]]`,
+			transformers: joinSplit,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			expected := transformJSONString(t, c.input)
+			got := transformJSONString(t, c.input, c.transformers...)
+			if got != expected {
+				t.Fatalf("Expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestRecurseLeaves(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "nested structure",
+			input:  `{"a": [1, {"b": "x", "c": [true, [null]]}], "d": 2.5}`,
+			output: "1\n\"x\"\ntrue\nnull\n2.5\n",
+		},
+		{
+			name:   "empty containers",
+			input:  `[] {} [[], {"a": {}}, [[]]]`,
+			output: "",
+		},
+		{
+			name:   "scalars",
+			input:  `1 "a" [2]`,
+			output: "1\n\"a\"\n2\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(jsonstream.RecurseLeaves{}))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestReservoirSample(t *testing.T) {
+	input := `1 2 3 4 5 6 7 8 9 10 [11] {"a": 12}`
+	sample := func(k int, seed int64) string {
+		return transformJSONString(t, input, &jsonstream.ReservoirSample{K: k, Rand: rand.New(rand.NewSource(seed))})
+	}
+
+	t.Run("deterministic with a seed", func(t *testing.T) {
+		first := sample(3, 42)
+		if n := strings.Count(first, "\n"); n != 3 {
+			t.Fatalf("Expected 3 values, got %q", first)
+		}
+		for i := 0; i < 5; i++ {
+			if got := sample(3, 42); got != first {
+				t.Fatalf("Expected %q, got %q", first, got)
+			}
+		}
+	})
+
+	t.Run("K larger than input", func(t *testing.T) {
+		expected := transformJSONString(t, input)
+		for _, k := range []int{12, 20} {
+			if got := sample(k, 1); got != expected {
+				t.Fatalf("K=%d: expected %q, got %q", k, expected, got)
+			}
+		}
+	})
+
+	t.Run("uniform", func(t *testing.T) {
+		// Each value should be selected about 1/4 of the time
+		counts := map[string]int{}
+		const runs = 2000
+		for seed := int64(0); seed < runs; seed++ {
+			for _, v := range strings.Split(strings.TrimSpace(sample(3, seed)), "\n") {
+				counts[v]++
+			}
+		}
+		if len(counts) != 12 {
+			t.Fatalf("Expected all 12 values to be sampled, got %v", counts)
+		}
+		for v, n := range counts {
+			if n < runs/4-150 || n > runs/4+150 {
+				t.Fatalf("Value %s sampled %d times out of %d", v, n, runs)
+			}
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package jsonstream
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A CastType is a type that Cast converts scalars to.
+type CastType uint8
+
+const (
+	CastInt    CastType = iota // Integer number
+	CastFloat                  // Any number
+	CastBool                   // Boolean
+	CastString                 // String
+)
+
+func (t CastType) String() string {
+	switch t {
+	case CastInt:
+		return "int"
+	case CastFloat:
+		return "float"
+	case CastBool:
+		return "bool"
+	case CastString:
+		return "string"
+	default:
+		return "invalid cast type"
+	}
+}
+
+// Cast is a ValueTransformer which converts the scalars selected by the
+// JSONPath queries of Fields to the type of the field.  E.g. with the fields
+// $.age:int and $.active:bool
+//
+//	{"age": "42", "active": "true"} -> {"age": 42, "active": true}
+//
+// The conversions are:
+//   - to int: strings are parsed as numbers, booleans give 1 or 0 and numbers
+//     are kept if their value is an integer;
+//   - to float: strings are parsed as numbers, booleans give 1 or 0;
+//   - to bool: strings are parsed with strconv.ParseBool (so "1", "t", "TRUE"
+//     etc. are true), numbers are true unless they are 0;
+//   - to string: numbers and booleans give their JSON representation.
+//
+// Null is left unchanged.  A value which cannot be converted (e.g. an object,
+// or "abc" to int) is replaced with null, unless Strict is true in which case
+// the transform fails with a *TransformError once the value has been output.
+//
+// When several fields select the same node, the last one applies.  Each value
+// needs to be read twice (once to find the selected nodes and once to output
+// it), so it is buffered in memory.
+type Cast struct {
+	Fields []CastField
+	Strict bool
+}
+
+// A CastField is a JSONPath query selecting nodes to convert to Type.
+type CastField struct {
+	Path *jsonpathtransformer.MainQueryRunner
+	Type CastType
+}
+
+// TransformValue implements the Cast transform.
+func (f *Cast) TransformValue(value iterator.Value, out token.WriteStream) {
+	tree := &pathTree{}
+	for _, field := range f.Fields {
+		castType := field.Type
+		tree.addPaths(field.Path, value, func(node *pathTree) {
+			node.selected = true
+			node.convert = func(v iterator.Value) (*token.Scalar, error) {
+				return castValue(v, castType)
+			}
+		})
+	}
+	var err error
+	tree.convertSelected(value, out, &err)
+	if err != nil && f.Strict {
+		panic(token.TransformErrorf("cast: %s", err))
+	}
+}
+
+// castValue consumes value and returns it converted to castType, or null and
+// an error if it cannot be converted.
+func castValue(value iterator.Value, castType CastType) (*token.Scalar, error) {
+	scalar, ok := value.AsScalar()
+	if !ok {
+		kind := valueKind(value)
+		value.Discard()
+		return nullInstance, fmt.Errorf("cannot convert %s to %s", kind, castType)
+	}
+	var result *token.Scalar
+	switch scalar.Type() {
+	case token.Null:
+		return scalar, nil
+	case token.Boolean:
+		result = castBool(scalar.Bytes[0] == 't', castType)
+	case token.Number:
+		result = castNumber(scalar.Bytes, castType)
+	case token.String:
+		result = castString(scalar.ToString(), castType)
+	}
+	if result == nil {
+		return nullInstance, fmt.Errorf("cannot convert %s to %s", scalar.Bytes, castType)
+	}
+	return result, nil
+}
+
+func castBool(b bool, castType CastType) *token.Scalar {
+	switch castType {
+	case CastInt, CastFloat:
+		if b {
+			return token.NewScalar(token.Number, []byte("1"))
+		}
+		return token.NewScalar(token.Number, []byte("0"))
+	case CastBool:
+		if b {
+			return trueInstance
+		}
+		return falseInstance
+	default:
+		return stringScalar(strconv.FormatBool(b))
+	}
+}
+
+func castNumber(b []byte, castType CastType) *token.Scalar {
+	switch castType {
+	case CastInt:
+		return parseIntScalar(string(b))
+	case CastFloat:
+		return token.NewScalar(token.Number, b)
+	case CastBool:
+		x, _ := strconv.ParseFloat(string(b), 64)
+		return castBool(x != 0, CastBool)
+	default:
+		return stringScalar(string(b))
+	}
+}
+
+// castString returns nil if s cannot be converted.
+func castString(s string, castType CastType) *token.Scalar {
+	switch castType {
+	case CastInt:
+		return parseIntScalar(strings.TrimSpace(s))
+	case CastFloat:
+		x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+			return nil
+		}
+		return floatScalar(x)
+	case CastBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil
+		}
+		return castBool(b, CastBool)
+	default:
+		return stringScalar(s)
+	}
+}
+
+// parseIntScalar returns an integer number scalar for s if it is a number with
+// an integer value, nil otherwise.
+func parseIntScalar(s string) *token.Scalar {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return token.NewScalar(token.Number, strconv.AppendInt(nil, n, 10))
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil || x != math.Trunc(x) || math.IsInf(x, 0) {
+		return nil
+	}
+	return token.NewScalar(token.Number, strconv.AppendFloat(nil, x, 'f', -1, 64))
+}
@@ -0,0 +1,88 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestCast(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		path   string
+		to     jsonstream.CastType
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "strings to int",
+			input:  `["42", " -7 ", "1e3", "1.5", "abc"]`,
+			path:   `$[*]`,
+			to:     jsonstream.CastInt,
+			output: "[42,-7,1000,null,null]\n",
+		},
+		{
+			name:   "numbers and booleans to int",
+			input:  `[3, 2.0, 2.5, true, false, null]`,
+			path:   `$[*]`,
+			to:     jsonstream.CastInt,
+			output: "[3,2,null,1,0,null]\n",
+		},
+		{
+			name:   "to float",
+			input:  `["2.50", 1e2, true, "NaN", "x"]`,
+			path:   `$[*]`,
+			to:     jsonstream.CastFloat,
+			output: "[2.5,1e2,1,null,null]\n",
+		},
+		{
+			name:   "to bool",
+			input:  `["true", "0", "T", 0, 0.5, "yes"]`,
+			path:   `$[*]`,
+			to:     jsonstream.CastBool,
+			output: "[true,false,true,false,true,null]\n",
+		},
+		{
+			name:   "to string",
+			input:  `{"ts": 1700000000, "ok": false, "s": "x", "n": null}`,
+			path:   `$.*`,
+			to:     jsonstream.CastString,
+			output: "{\"ts\": \"1700000000\",\"ok\": \"false\",\"s\": \"x\",\"n\": null}\n",
+		},
+		{
+			name:   "composite values",
+			input:  `{"a": [1], "b": {"c": "1"}}`,
+			path:   `$.a`,
+			to:     jsonstream.CastInt,
+			output: "{\"a\": null,\"b\": {\"c\": \"1\"}}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			cast := &jsonstream.Cast{Fields: []jsonstream.CastField{{Path: mustCompileQuery(t, c.path), Type: c.to}}}
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(cast))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestCastStrict(t *testing.T) {
+	var err error
+	cast := &jsonstream.Cast{
+		Fields: []jsonstream.CastField{{Path: mustCompileQuery(t, `$.a`), Type: jsonstream.CastInt}},
+		Strict: true,
+	}
+	stream := token.TransformStreamWithErrorHandler(streamJSONString(`{"a": "x", "b": 1}`), iterator.AsStreamTransformer(cast), func(e error) { err = e })
+	got := encodeJSONStream(t, stream)
+	if got != "{\"a\": null,\"b\": 1}\n" {
+		t.Fatalf("Unexpected output: %q", got)
+	}
+	if err == nil || err.Error() != `cast: cannot convert "x" to int` {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
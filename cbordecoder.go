@@ -0,0 +1,411 @@
+package jsonstream
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A CBORDecoder reads input in the CBOR binary format (RFC 8949) and streams it
+// into a JSON stream.
+//
+// The input is a sequence of CBOR data items, each of which is streamed as a
+// JSON value.  Indefinite length arrays and maps are streamed as they are read,
+// without needing to be buffered.  Data items without a JSON equivalent are
+// converted as follows.
+//   - Byte strings are streamed as base64 encoded strings.
+//   - The undefined value is streamed as null.
+//   - Epoch-based date/time values (tag 1) are streamed as RFC 3339 strings.
+//   - Bignums (tags 2 and 3) are streamed as numbers.
+//   - Other tags are ignored, i.e. only their content is streamed.
+//   - Map keys which are not text strings must be scalars and are streamed as
+//     keys made of their JSON representation (e.g. the key 1 becomes "1").
+//
+// Other simple values and non-finite floats cause an error.
+type CBORDecoder struct {
+	reader *binaryInput
+}
+
+var _ token.StreamSource = &CBORDecoder{}
+
+// NewCBORDecoder sets up a new CBORDecoder instance to read from the given
+// input.
+func NewCBORDecoder(in io.Reader) *CBORDecoder {
+	return &CBORDecoder{reader: newBinaryInput(in)}
+}
+
+// Produce reads a sequence of CBOR data items and streams them, until it runs
+// out of input or encounters invalid CBOR, in which case it will return an
+// error.
+func (d *CBORDecoder) Produce(out chan<- token.Token) error {
+	return produceValues(d, out)
+}
+
+func (d *CBORDecoder) decodeValue(out token.WriteStream) (bool, error) {
+	b, err := d.reader.ReadByte()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, d.reader.parseError("cbor", d.parseValue(b, out))
+}
+
+// parseValue reads a single CBOR data item whose first byte b has already been
+// read and streams it.
+func (d *CBORDecoder) parseValue(b byte, out token.WriteStream) error {
+	major, info := b>>5, b&0x1F
+	switch major {
+	case cborUnsigned:
+		n, err := d.readArgument(info)
+		if err != nil {
+			return err
+		}
+		out.Put(token.NewScalar(token.Number, strconv.AppendUint(nil, n, 10)))
+	case cborNegative:
+		n, err := d.readArgument(info)
+		if err != nil {
+			return err
+		}
+		// The value is -1 - n, which may not fit in an int64.
+		if n < math.MaxInt64 {
+			out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, -1-int64(n), 10)))
+		} else {
+			x := new(big.Int).SetUint64(n)
+			out.Put(token.NewScalar(token.Number, x.Neg(x).Sub(x, bigOne).Append(nil, 10)))
+		}
+	case cborByteString:
+		raw, err := d.readString(major, info)
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(base64.StdEncoding.EncodeToString(raw)))
+	case cborTextString:
+		raw, err := d.readString(major, info)
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(string(raw)))
+	case cborArray:
+		return d.parseArray(info, out)
+	case cborMap:
+		return d.parseMap(info, out)
+	case cborTag:
+		tag, err := d.readArgument(info)
+		if err != nil {
+			return err
+		}
+		return d.parseTagged(tag, out)
+	default:
+		return d.parseSimple(info, out)
+	}
+	return nil
+}
+
+func (d *CBORDecoder) parseArray(info byte, out token.WriteStream) error {
+	out.Put(&token.StartArray{})
+	err := d.forEachItem(info, func(b byte) error {
+		return d.parseValue(b, out)
+	})
+	if err != nil {
+		return err
+	}
+	out.Put(&token.EndArray{})
+	return nil
+}
+
+func (d *CBORDecoder) parseMap(info byte, out token.WriteStream) error {
+	out.Put(&token.StartObject{})
+	err := d.forEachItem(info, func(b byte) error {
+		key, err := d.parseKey(b)
+		if err != nil {
+			return err
+		}
+		out.Put(key)
+		b, err = d.reader.ReadByte()
+		if err != nil {
+			return cborUnexpectedEOF(err)
+		}
+		return d.parseValue(b, out)
+	})
+	if err != nil {
+		return err
+	}
+	out.Put(&token.EndObject{})
+	return nil
+}
+
+// forEachItem calls f with the first byte of each item of an array or map (a
+// map item being a key-value pair), whether its length is definite or not.
+func (d *CBORDecoder) forEachItem(info byte, f func(byte) error) error {
+	if info == cborIndefinite {
+		for {
+			b, err := d.reader.ReadByte()
+			if err != nil {
+				return cborUnexpectedEOF(err)
+			}
+			if b == cborBreak {
+				return nil
+			}
+			if err := f(b); err != nil {
+				return err
+			}
+		}
+	}
+	n, err := d.readArgument(info)
+	if err != nil {
+		return err
+	}
+	for ; n > 0; n-- {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return cborUnexpectedEOF(err)
+		}
+		if err := f(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseKey reads a map key whose first byte b has already been read.  Keys
+// which are not text strings are converted to strings using their JSON
+// representation.
+func (d *CBORDecoder) parseKey(b byte) (*token.Scalar, error) {
+	switch b >> 5 {
+	case cborTextString:
+		raw, err := d.readString(cborTextString, b&0x1F)
+		if err != nil {
+			return nil, err
+		}
+		return keyScalar(string(raw)), nil
+	case cborArray, cborMap, cborTag:
+		return nil, fmt.Errorf("cbor: unsupported map key of major type %d", b>>5)
+	}
+	// Decode the key as a value, which is a single scalar.
+	keyOut := token.NewAccumulatorStream()
+	if err := d.parseValue(b, keyOut); err != nil {
+		return nil, err
+	}
+	scalar := keyOut.GetTokens()[0].(*token.Scalar)
+	if scalar.Type() == token.String {
+		return keyScalar(scalar.ToString()), nil
+	}
+	return keyScalar(string(scalar.Bytes)), nil
+}
+
+// parseTagged reads the content of a tagged data item.
+func (d *CBORDecoder) parseTagged(tag uint64, out token.WriteStream) error {
+	b, err := d.reader.ReadByte()
+	if err != nil {
+		return cborUnexpectedEOF(err)
+	}
+	major := b >> 5
+	switch {
+	case tag == cborEpochTag && (major == cborUnsigned || major == cborNegative || b >= 0xF9 && b <= 0xFB):
+		secs := token.NewAccumulatorStream()
+		if err := d.parseValue(b, secs); err != nil {
+			return err
+		}
+		x, err := strconv.ParseFloat(string(secs.GetTokens()[0].(*token.Scalar).Bytes), 64)
+		if err != nil {
+			return fmt.Errorf("cbor: invalid epoch time: %w", err)
+		}
+		whole, frac := math.Modf(x)
+		t := time.Unix(int64(whole), int64(frac*1e9))
+		out.Put(stringScalar(t.UTC().Format(time.RFC3339Nano)))
+		return nil
+	case (tag == cborPositiveBignumTag || tag == cborNegativeBignumTag) && major == cborByteString:
+		raw, err := d.readString(major, b&0x1F)
+		if err != nil {
+			return err
+		}
+		n := new(big.Int).SetBytes(raw)
+		if tag == cborNegativeBignumTag {
+			n.Neg(n).Sub(n, bigOne)
+		}
+		out.Put(token.NewScalar(token.Number, n.Append(nil, 10)))
+		return nil
+	default:
+		return d.parseValue(b, out)
+	}
+}
+
+// parseSimple reads a data item of major type 7 (simple values and floats).
+func (d *CBORDecoder) parseSimple(info byte, out token.WriteStream) error {
+	switch info {
+	case 20:
+		out.Put(falseInstance)
+	case 21:
+		out.Put(trueInstance)
+	case 22, 23:
+		// Both null and undefined
+		out.Put(nullInstance)
+	case 25:
+		bits, err := d.readUint(2)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(halfToFloat64(uint16(bits)), 32, out)
+	case 26:
+		bits, err := d.readUint(4)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(float64(math.Float32frombits(uint32(bits))), 32, out)
+	case 27:
+		bits, err := d.readUint(8)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(math.Float64frombits(bits), 64, out)
+	case cborIndefinite:
+		return errors.New("cbor: unexpected break")
+	default:
+		return fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+	return nil
+}
+
+// readString reads a byte string or a text string (according to major) whose
+// additional information is info, concatenating the chunks of indefinite
+// length strings.
+func (d *CBORDecoder) readString(major, info byte) ([]byte, error) {
+	if info != cborIndefinite {
+		n, err := d.readArgument(info)
+		if err != nil {
+			return nil, err
+		}
+		return d.readBytes(n)
+	}
+	var result []byte
+	for {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return nil, cborUnexpectedEOF(err)
+		}
+		if b == cborBreak {
+			return result, nil
+		}
+		if b>>5 != major || b&0x1F == cborIndefinite {
+			return nil, errors.New("cbor: invalid chunk in indefinite length string")
+		}
+		n, err := d.readArgument(b & 0x1F)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(len(result))+n > cborMaxLength {
+			return nil, errors.New("cbor: value too long")
+		}
+		chunk, err := d.readBytes(n)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chunk...)
+	}
+}
+
+// readArgument reads the argument of a data item given its additional
+// information.
+func (d *CBORDecoder) readArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return d.readUint(1 << (info - 24))
+	default:
+		return 0, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+}
+
+func (d *CBORDecoder) putFloat(x float64, bitSize int, out token.WriteStream) error {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return fmt.Errorf("cbor: non-finite number %g cannot be represented in JSON", x)
+	}
+	out.Put(token.NewScalar(token.Number, strconv.AppendFloat(nil, x, 'g', -1, bitSize)))
+	return nil
+}
+
+// readUint reads a big-endian unsigned integer of the given size in bytes.
+func (d *CBORDecoder) readUint(size int) (uint64, error) {
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return 0, cborUnexpectedEOF(err)
+		}
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func (d *CBORDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > cborMaxLength {
+		return nil, errors.New("cbor: value too long")
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(d.reader, raw); err != nil {
+		return nil, cborUnexpectedEOF(err)
+	}
+	return raw, nil
+}
+
+// halfToFloat64 converts an IEEE 754 half-precision float to a float64.
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1F
+	mant := float64(h & 0x3FF)
+	var x float64
+	switch exp {
+	case 0:
+		x = math.Ldexp(mant, -24)
+	case 0x1F:
+		if mant == 0 {
+			x = math.Inf(1)
+		} else {
+			x = math.NaN()
+		}
+	default:
+		x = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		x = -x
+	}
+	return x
+}
+
+func cborUnexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("cbor: unexpected end of input")
+	}
+	return err
+}
+
+// CBOR major types
+const (
+	cborUnsigned   = 0
+	cborNegative   = 1
+	cborByteString = 2
+	cborTextString = 3
+	cborArray      = 4
+	cborMap        = 5
+	cborTag        = 6
+)
+
+const (
+	cborIndefinite        = 31
+	cborBreak             = 0xFF
+	cborEpochTag          = 1
+	cborPositiveBignumTag = 2
+	cborNegativeBignumTag = 3
+	cborMaxLength         = 1 << 30
+)
+
+var bigOne = big.NewInt(1)
@@ -0,0 +1,129 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+// Most test cases are taken from the examples in RFC 8949, Appendix A.
+func TestCBORDecoder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  []byte
+		output string
+		err    bool
+	}
+	var testCases = []testCase{
+		{
+			name: "integers",
+			input: []byte{
+				0x88,
+				0x17,       // 23
+				0x18, 0x64, // 100
+				0x1A, 0x00, 0x0F, 0x42, 0x40, // 1000000
+				0x1B, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // 18446744073709551615
+				0x20,       // -1
+				0x38, 0x63, // -100
+				0x3B, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // -18446744073709551616
+				0xC2, 0x49, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // 18446744073709551616
+			},
+			output: `[23,100,1000000,18446744073709551615,-1,-100,-18446744073709551616,18446744073709551616]`,
+		},
+		{
+			name: "floats",
+			input: []byte{
+				0x84,
+				0xF9, 0x3E, 0x00, // 1.5
+				0xF9, 0x00, 0x01, // 5.960464477539063e-8
+				0xFA, 0x47, 0xC3, 0x50, 0x00, // 100000.0
+				0xFB, 0xC0, 0x10, 0x66, 0x66, 0x66, 0x66, 0x66, 0x66, // -4.1
+			},
+			output: `[1.5,5.9604645e-08,100000,-4.1]`,
+		},
+		{
+			name:   "simple values",
+			input:  []byte{0x84, 0xF4, 0xF5, 0xF6, 0xF7},
+			output: `[false,true,null,null]`,
+		},
+		{
+			name:   "strings",
+			input:  []byte{0x83, 0x62, 0xC3, 0xBC, 0x44, 0x01, 0x02, 0x03, 0x04, 0x62, '"', '\\'},
+			output: `["ü","AQIDBA==","\"\\"]`,
+		},
+		{
+			name:   "indefinite length strings",
+			input:  []byte{0x82, 0x7F, 0x65, 's', 't', 'r', 'e', 'a', 0x64, 'm', 'i', 'n', 'g', 0xFF, 0x5F, 0x42, 0x01, 0x02, 0x43, 0x03, 0x04, 0x05, 0xFF},
+			output: `["streaming","AQIDBAU="]`,
+		},
+		{
+			name:   "map",
+			input:  []byte{0xA2, 0x61, 'a', 0x01, 0x61, 'b', 0x82, 0x02, 0x03},
+			output: `{"a": 1,"b": [2,3]}`,
+		},
+		{
+			name:   "non-string keys",
+			input:  []byte{0xA2, 0x01, 0x02, 0xF5, 0x04},
+			output: `{"1": 2,"true": 4}`,
+		},
+		{
+			name:   "indefinite length array and map",
+			input:  []byte{0xBF, 0x63, 'F', 'u', 'n', 0xF5, 0x63, 'A', 'm', 't', 0x9F, 0x01, 0x9F, 0xFF, 0xFF, 0xFF},
+			output: `{"Fun": true,"Amt": [1,[]]}`,
+		},
+		{
+			name: "tags",
+			input: []byte{
+				0x84,
+				0xC0, 0x74, '2', '0', '1', '3', '-', '0', '3', '-', '2', '1', 'T', '2', '0', ':', '0', '4', ':', '0', '0', 'Z',
+				0xC1, 0x1A, 0x51, 0x4B, 0x67, 0xB0, // epoch 1363896240
+				0xC1, 0xFB, 0x41, 0xD4, 0x52, 0xD9, 0xEC, 0x20, 0x00, 0x00, // epoch 1363896240.5
+				0xD8, 0x20, 0x63, 'x', ':', 'y', // URI
+			},
+			output: `["2013-03-21T20:04:00Z","2013-03-21T20:04:00Z","2013-03-21T20:04:00.5Z","x:y"]`,
+		},
+		{
+			name:   "multiple data items",
+			input:  []byte{0x01, 0x80, 0xA0},
+			output: "1\n[]\n{}",
+		},
+		{
+			name:  "unexpected break",
+			input: []byte{0x82, 0x01, 0xFF},
+			err:   true,
+		},
+		{
+			name:  "array key",
+			input: []byte{0xA1, 0x80, 0x01},
+			err:   true,
+		},
+		{
+			name:  "invalid chunk",
+			input: []byte{0x7F, 0x41, 0x00, 0xFF},
+			err:   true,
+		},
+		{
+			name:  "truncated input",
+			input: []byte{0x9F, 0x01},
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeToJSONString(t, jsonstream.NewCBORDecoder(bytes.NewReader(c.input)))
+			if c.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
@@ -3,23 +3,42 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"log"
+	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/arnodel/jsonstream"
 	"github.com/arnodel/jsonstream/internal/jsonpath"
+	"github.com/arnodel/jsonstream/internal/jsonpath/ast"
+	"github.com/arnodel/jsonstream/internal/xxhash"
 	"github.com/arnodel/jsonstream/iterator"
 	"github.com/arnodel/jsonstream/jsonpathtransformer"
 	"github.com/arnodel/jsonstream/token"
+	"github.com/klauspost/compress/zstd"
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
 )
@@ -43,27 +62,238 @@ func main() {
 	var colorizer *jsonstream.Colorizer
 	var quoteKeys bool
 	var compactMaxWidth int
+	var arrayCompactWidth int
+	var objectCompactWidth int
+	var indentFirstLevelOnly bool
+	var colorNumbersBySign bool
+	var floatFormat string
+	var stableFloatRepr bool
+	var internKeys bool
+	var quoteIntegersOver uint64
+	var rawStrings bool
+	var templateText string
+	var alignValues bool
+	var omitEmpty bool
+	var sortKeys bool
+	var maxObjectSize int
+	newHash := sha256.New
+	var compactKeys bool
+	var csvTrim bool
+	var csvDelim rune
+	var maxKeyLength int
+	var allowNaN bool
+	var nonFinite jsonstream.NonFiniteNumbers
+	var compactCommas bool
+	var elisionMarker string
+	var maxString int
+	var explain bool
+	var pipelineFile string
+	var showMetrics bool
+	var crlf bool
+	var diffFilename string
+	var wrapFiles bool
+	var follow bool
+	var skipErrors bool
+	var inputURL string
+	var httpRetries int
+	httpHeader := http.Header{}
 
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		colorizer = &defaultColorizer
-	}
-
-	flag.BoolFunc("colors", "force using colors", func(s string) error {
-		colorizer = &defaultColorizer
+	colorMode := "auto"
+	flag.Func("color", "when to use colors: auto (the default), always or never", func(s string) error {
+		switch s {
+		case "auto", "always", "never":
+			colorMode = s
+			return nil
+		default:
+			return errors.New("must be auto, always or never")
+		}
+	})
+	flag.BoolFunc("colors", "force using colors (same as -color always)", func(s string) error {
+		colorMode = "always"
 		return nil
 	})
-	flag.BoolFunc("nocolors", "disable colors", func(s string) error {
-		colorizer = nil
+	flag.BoolFunc("nocolors", "disable colors (same as -color never)", func(s string) error {
+		colorMode = "never"
 		return nil
 	})
 
 	flag.StringVar(&filename, "file", "", "json input filename (stdin if omitted)")
-	flag.IntVar(&indent, "indent", 2, "indent step for json output (negative means no new lines)")
+	flag.BoolVar(&readFiles, "files", false, "read the input from the files given after -- one after the other, instead of stdin")
+	flag.StringVar(&inputURL, "url", "", "fetch the input from this http(s) URL instead of stdin")
+	flag.Func("header", "add a header to the -url request (e.g. \"Authorization: Bearer xyz\"), can be repeated", func(s string) error {
+		name, value, ok := strings.Cut(s, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return errors.New("expected NAME: VALUE")
+		}
+		httpHeader.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		return nil
+	})
+	flag.IntVar(&httpRetries, "retries", 2, "number of times to retry the -url request when it fails or the server is unavailable")
+	flag.BoolVar(&skipErrors, "skip-errors", false, "report invalid lines in json input to stderr and carry on with the next line")
+	flag.BoolVar(&follow, "follow", false, "keep reading the input file (or stdin if it is a file) as it grows, like tail -f")
+	flag.BoolVar(&wrapFiles, "wrap-files", false, "with -files, apply the transforms to each file separately and output each value as {\"file\": name, \"value\": value}")
+	flag.StringVar(&diffFilename, "diff", "", "output a JSON Patch transforming the input into the contents of this file")
+	flag.IntVar(&indent, "indent", 2, "indent step for json output (0 means new lines without indentation, negative means no new lines)")
+	flag.IntVar(&indent, "json-indent", 2, "same as -indent")
 	flag.StringVar(&outputFormat, "out", "json", "output format")
 	flag.StringVar(&inputFormat, "in", "auto", "input format")
 	flag.BoolVar(&quoteKeys, "quotekeys", false, "always use quoted keys in JSON Path output")
 	flag.IntVar(&compactMaxWidth, "compactwidth", 60, "max width for compact arrays or objects")
-	flag.Parse()
+	flag.IntVar(&arrayCompactWidth, "array-compact-width", 0, "max width for compact arrays (defaults to -compactwidth)")
+	flag.IntVar(&objectCompactWidth, "object-compact-width", 0, "max width for compact objects (defaults to -compactwidth)")
+	flag.StringVar(&floatFormat, "float-format", "", "reformat non-integer numbers in json output using this format (g, f or e)")
+	flag.Uint64Var(&quoteIntegersOver, "quote-all-numbers-over", 0, "output integers whose magnitude exceeds this as strings in json output (e.g. 9007199254740991 for JavaScript safety)")
+	flag.BoolVar(&stableFloatRepr, "stable-float-repr", false, "reformat non-integer numbers in json output as JavaScript does")
+	flag.BoolVar(&colorNumbersBySign, "color-numbers-by-sign", false, "color negative numbers red and positive numbers green")
+	flag.BoolVar(&traceValues, "trace-values", false, "make trace show the boundaries of top-level values")
+	flag.IntVar(&traceEvery, "trace-every", 1, "make trace only log one token in this number")
+	flag.IntVar(&traceLimit, "trace-limit", 0, "make trace stop logging after this number of tokens (0 means no limit)")
+	flag.Int64Var(&sampleSeed, "sample-seed", 0, "seed for the random choices of sample-k (0 means a different seed each time)")
+	flag.IntVar(&sortRunSize, "sort-run-size", jsonstream.DefaultSortRunSize, "bytes of values held in memory by sort before spilling to temporary files")
+	flag.BoolVar(&aggregateRunning, "running", false, "make count, sum, min, max and avg output their result after each value")
+	flag.BoolVar(&castStrict, "cast-strict", false, "make cast fail on values which cannot be converted instead of replacing them with null")
+	flag.BoolVar(&datesStrict, "dates-strict", false, "make dates fail on values which cannot be parsed instead of replacing them with null")
+	flag.IntVar(&parallelWorkers, "parallel", 1, "apply transforms which process each value independently (e.g. jsonpath queries) using this number of goroutines")
+	flag.BoolVar(&parallelUnordered, "parallel-unordered", false, "with -parallel, output values as soon as they are transformed rather than in input order")
+	flag.BoolVar(&splitStrict, "split-strict", false, "make split fail on values which are not arrays")
+	flag.IntVar(&jsonpathMaxDepth, "jsonpath-max-depth", 0, "fail when jsonpath descendant segments look deeper than this into values (0 means no limit)")
+	flag.IntVar(&jsonpathMaxWindow, "jsonpath-max-window", 0, "fail when jsonpath queries need to buffer more than this number of tokens (0 means no limit)")
+	flag.Func("jsonpath-output", "what jsonpath transforms output: values (the default), paths, path-values, exists or count", func(s string) error {
+		switch s {
+		case "values":
+			jsonpathOutputMode = jsonpathtransformer.OutputValues
+		case "paths":
+			jsonpathOutputMode = jsonpathtransformer.OutputPaths
+		case "path-values":
+			jsonpathOutputMode = jsonpathtransformer.OutputPathValues
+		case "exists":
+			jsonpathOutputMode = jsonpathtransformer.OutputExists
+		case "count":
+			jsonpathOutputMode = jsonpathtransformer.OutputCount
+		default:
+			return errors.New("must be values, paths, path-values, exists or count")
+		}
+		return nil
+	})
+	flag.BoolVar(&jsonpathLax, "jsonpath-lax", false, "make jsonpath queries output nodes in document order, each once, rather than in RFC 9535 order (override with a strict: or lax: prefix)")
+	flag.BoolVar(&showMetrics, "metrics", false, "print the number of tokens and bytes output by each stage of the pipeline and the time it took to stderr on exit")
+	flag.StringVar(&pipelineFile, "pipeline", "", "read transforms from this file (one per line, or a JSON array of strings) and apply them before the transforms in the arguments")
+	flag.BoolVar(&explain, "explain", false, "describe how the jsonpath queries in the transforms run (e.g. what they buffer) instead of reading the input")
+	flag.BoolVar(&jsonpathRelaxedNames, "jsonpath-relaxed-names", false, "allow dashes in jsonpath member name shorthands (e.g. $.user-id)")
+	flag.BoolVar(&csvTrim, "csv-trim", false, "trim whitespace around unquoted fields in csv input")
+	flag.Func("csv-delim", "field delimiter in csv input (a single character, or \\t or tab for a tab)", func(s string) error {
+		switch s {
+		case `\t`, "tab":
+			csvDelim = '\t'
+			return nil
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		if size == 0 || size != len(s) || r == utf8.RuneError {
+			return errors.New("must be a single character")
+		}
+		csvDelim = r
+		return nil
+	})
+	flag.IntVar(&maxKeyLength, "max-key-length", 0, "fail on object keys longer than this many bytes in json input (0 means no limit)")
+	flag.Func("dup-keys", "what to do with duplicate keys in objects in json input: keep-all (the default, jsonpath name selectors then select all of them), first-wins, last-wins or error", func(s string) error {
+		switch s {
+		case "keep-all":
+			duplicateKeys = jsonstream.DuplicateKeysKeepAll
+		case "first-wins":
+			duplicateKeys = jsonstream.DuplicateKeysFirstWins
+		case "last-wins":
+			duplicateKeys = jsonstream.DuplicateKeysLastWins
+		case "error":
+			duplicateKeys = jsonstream.DuplicateKeysError
+		default:
+			return errors.New("must be keep-all, first-wins, last-wins or error")
+		}
+		return nil
+	})
+	flag.BoolVar(&allowNaN, "allow-nan", false, "accept NaN, Infinity and -Infinity as numbers in json input, as Python outputs them")
+	flag.Func("non-finite", "what to output for NaN and infinite numbers in json output, which are not valid JSON: verbatim (the default), null, string or error", func(s string) error {
+		switch s {
+		case "verbatim":
+			nonFinite = jsonstream.NonFiniteVerbatim
+		case "null":
+			nonFinite = jsonstream.NonFiniteEmitNull
+		case "string":
+			nonFinite = jsonstream.NonFiniteEmitString
+		case "error":
+			nonFinite = jsonstream.NonFiniteError
+		default:
+			return errors.New("must be verbatim, null, string or error")
+		}
+		return nil
+	})
+	flag.BoolVar(&internKeys, "intern-keys", false, "share repeated object keys in json input to save memory")
+	flag.BoolVar(&rawStrings, "raw", false, "output top-level strings without quotes in json output")
+	flag.StringVar(&templateText, "template", "", "Go text/template rendering each value for -out template (e.g. '{{.name}}: {{.price}}')")
+	flag.BoolVar(&alignValues, "pretty-scalars-align", false, "pad object keys in json output so that values line up")
+	flag.BoolVar(&compactKeys, "compact-keys", false, "do not output a space after colons in json output")
+	flag.BoolVar(&compactCommas, "compact-commas", false, "do not output a space after commas between items on the same line in json output")
+	flag.IntVar(&maxString, "max-string", 0, "cut strings longer than this many bytes in json output, showing how many bytes were removed (0 means no limit)")
+	flag.StringVar(&elisionMarker, "elision-marker", "", "marker for elided items in json output when their number is known, with %d replaced by the number (e.g. '…%d more')")
+	flag.BoolVar(&omitEmpty, "omit-empty", false, "drop object fields whose value is null, \"\", [] or {} from the output")
+	flag.BoolVar(&sortKeys, "sort-keys", false, "sort the members of objects by key in the output")
+	flag.IntVar(&maxObjectSize, "max-object-size", jsonstream.DefaultMaxObjectSize, "bytes of an object held in memory by -sort-keys and -out jcs or hash before failing")
+	flag.Func("hash-alg", "hash algorithm for -out hash: sha256 (the default), sha512, sha1, md5 or xxhash", func(s string) error {
+		switch s {
+		case "sha256":
+			newHash = sha256.New
+		case "sha512":
+			newHash = sha512.New
+		case "sha1":
+			newHash = sha1.New
+		case "md5":
+			newHash = md5.New
+		case "xxhash":
+			newHash = func() hash.Hash { return xxhash.New() }
+		default:
+			return errors.New("must be sha256, sha512, sha1, md5 or xxhash")
+		}
+		return nil
+	})
+	flag.BoolVar(&crlf, "crlf", false, "use CRLF line endings in the output")
+	flag.BoolVar(&indentFirstLevelOnly, "indent-first-level-only", false, "only break the top level of json output across lines")
+	transformArgs, inputFiles := parseArgs()
+	if pipelineFile != "" {
+		pipeline, err := readPipelineFile(pipelineFile)
+		if err != nil {
+			fatalError("error reading pipeline %q: %s", pipelineFile, err)
+		}
+		transformArgs = append(pipeline, transformArgs...)
+	}
+	if readFiles && len(inputFiles) == 0 {
+		fatalError("-files requires input files after --")
+	}
+	if readFiles && filename != "" {
+		fatalError("-file and -files cannot be used together")
+	}
+	if wrapFiles && !readFiles {
+		fatalError("-wrap-files requires -files")
+	}
+	if diffFilename != "" && hasTeeTransform(transformArgs) {
+		// Both inputs would be written to the same file at the same time.
+		fatalError("tee= cannot be used with -diff")
+	}
+	if follow && readFiles {
+		fatalError("-follow cannot be used with -files")
+	}
+	if inputURL != "" && (filename != "" || readFiles || follow) {
+		fatalError("-url cannot be used with -file, -files or -follow")
+	}
+
+	if useColors(colorMode) {
+		colorizer = &defaultColorizer
+	}
+	if colorizer != nil && colorNumbersBySign {
+		signColorizer := *colorizer
+		signColorizer.ColorNumbersBySign = true
+		signColorizer.NegativeNumberColorCode = Red
+		signColorizer.PositiveNumberColorCode = Green
+		colorizer = &signColorizer
+	}
 
 	// Set up stdout for handling colors
 
@@ -72,68 +302,244 @@ func main() {
 		stdout = colorable.NewColorableStdout()
 	}
 
-	// Open input file
-	var input io.Reader
-	if filename != "" {
-		var err error
-		input, err = os.Open(filename)
-		if err != nil {
-			fatalError("error opening %q: %s", filename, err)
+	// With -skip-errors, invalid lines in json input are reported and skipped.
+	var onSkippedError func(error)
+	if skipErrors {
+		onSkippedError = func(err error) {
+			// Same format as reportParseError
+			var parseErr *token.ParseError
+			if errors.As(err, &parseErr) {
+				fmt.Fprintf(os.Stderr, "skipping invalid input at %s: %s\n", parseErr.Location(), parseErr.Msg)
+			} else {
+				fmt.Fprintf(os.Stderr, "skipping invalid input: %s\n", err)
+			}
 		}
-	} else {
-		input = os.Stdin
 	}
 
-	// Choose the input decoder
-	if inputFormat == "auto" {
-		var start = make([]byte, 40)
-		_, err := input.Read(start)
-		if err == io.EOF {
-			fatalError("unable to guess format of empty file")
+	// newDecoder returns a decoder for input in the input format, guessing the
+	// format (and the compression) from the start of the input if needed.
+	newDecoder := func(input io.Reader) token.StreamSource {
+		format, compression := splitCompression(inputFormat)
+
+		// readStart returns the start of the input, leaving it to be read
+		// again.
+		readStart := func() []byte {
+			var start = make([]byte, 40)
+			n, err := io.ReadAtLeast(input, start, 1)
+			if err == io.EOF {
+				fatalError("unable to guess format of empty file")
+			}
+			if err != nil {
+				fatalError("unable to read input: %s", err)
+			}
+			start = start[:n]
+			input = io.MultiReader(bytes.NewReader(start), input)
+			return start
 		}
-		if err != nil {
-			fatalError("unable to read input: %s", err)
+		if format == "auto" && compression == "" {
+			compression = guessCompression(readStart())
 		}
-		inputFormat = guessFormat(start)
-		if inputFormat == "" {
-			fatalError("unable to guess input format, please specify -in FORMAT")
+		if compression != "" {
+			var err error
+			input, err = decompress(input, compression)
+			if err != nil {
+				fatalError("unable to decompress input: %s", err)
+			}
+		}
+		if format == "auto" {
+			format = guessFormat(readStart())
+			if format == "" {
+				fatalError("unable to guess input format, please specify -in FORMAT")
+			}
+		}
+
+		if skipErrors && format != "json" && format != "json5" {
+			fatalError("-skip-errors only works with json input, not %s", format)
 		}
-		input = io.MultiReader(bytes.NewReader(start), input)
+		if duplicateKeys != jsonstream.DuplicateKeysKeepAll && format != "json" && format != "json5" {
+			fatalError("-dup-keys only works with json input, not %s", format)
+		}
+		switch format {
+		case "json":
+			jsonDecoder := jsonstream.NewJSONDecoder(input)
+			jsonDecoder.InternKeys = internKeys
+			jsonDecoder.MaxKeyLength = maxKeyLength
+			jsonDecoder.OnSkippedError = onSkippedError
+			jsonDecoder.AllowNonFinite = allowNaN
+			jsonDecoder.DuplicateKeys = duplicateKeys
+			return jsonDecoder
+		case "json5":
+			jsonDecoder := jsonstream.NewJSONDecoder(input)
+			jsonDecoder.InternKeys = internKeys
+			jsonDecoder.MaxKeyLength = maxKeyLength
+			jsonDecoder.OnSkippedError = onSkippedError
+			jsonDecoder.AllowComments = true
+			jsonDecoder.AllowTrailingCommas = true
+			jsonDecoder.AllowSingleQuotes = true
+			jsonDecoder.AllowUnquotedKeys = true
+			jsonDecoder.AllowNonFinite = true
+			jsonDecoder.DuplicateKeys = duplicateKeys
+			return jsonDecoder
+		case "jpv", "path":
+			return jsonstream.NewJPVDecoder(input)
+		case "csv", "tsv":
+			csvDecoder := jsonstream.NewCSVDecoder(input)
+			csvDecoder.TrimSpace = csvTrim
+			csvDecoder.Delimiter = csvDelimiter(format, csvDelim)
+			return csvDecoder
+		case "smile":
+			return jsonstream.NewSmileDecoder(input)
+		case "hjson":
+			return jsonstream.NewHJSONDecoder(input)
+		case "msgpack":
+			return jsonstream.NewMsgPackDecoder(input)
+		case "cbor":
+			return jsonstream.NewCBORDecoder(input)
+		case "xml":
+			return jsonstream.NewXMLDecoder(input)
+		case "csv-header", "csvh", "tsv-header", "tsvh":
+			csvDecoder := jsonstream.NewCSVDecoder(input)
+			csvDecoder.HasHeader = true
+			csvDecoder.RecordsProduceObjects = true
+			csvDecoder.TrimSpace = csvTrim
+			csvDecoder.Delimiter = csvDelimiter(format, csvDelim)
+			return csvDecoder
+		case "yaml":
+			fatalError("YAML input is not supported, please specify -in FORMAT if the input is not YAML")
+		default:
+			fatalError("invalid input format: %q", format)
+		}
+		return nil
 	}
 
-	var decoder token.StreamSource
+	handleTransformError := func(err error) {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		transformFailed.Store(true)
+	}
 
-	switch inputFormat {
-	case "json":
-		decoder = jsonstream.NewJSONDecoder(input)
-	case "jpv", "path":
-		decoder = jsonstream.NewJPVDecoder(input)
-	case "csv":
-		decoder = jsonstream.NewCSVDecoder(input)
-	case "csv-header", "csvh":
-		csvDecoder := jsonstream.NewCSVDecoder(input)
-		csvDecoder.HasHeader = true
-		csvDecoder.RecordsProduceObjects = true
-		decoder = csvDecoder
-	default:
-		fatalError("invalid input format: %q", outputFormat)
+	// Parse transforms and apply them sequentially
+	transformers, err := parseTransformers(transformArgs)
+	if err != nil {
+		fatalError("error: %s", err)
+	}
+	if explain {
+		explainTransforms(os.Stdout, transformArgs)
+		return
 	}
 
-	// Start parsing the input file
-	stream := token.StartStream(
-		decoder,
-		func(err error) {
-			fmt.Fprintf(os.Stderr, "error while parsing: %s", err)
-		},
-	)
+	// With -metrics, each stage of the pipeline is wrapped to collect
+	// statistics about it.
+	var metrics *token.Metrics
+	if showMetrics {
+		metrics = &token.Metrics{}
+	}
+	measureTransformer := func(name string, transformer token.StreamTransformer) token.StreamTransformer {
+		if metrics == nil {
+			return transformer
+		}
+		return metrics.Transformer(name, transformer)
+	}
+	measureSource := func(name string, source token.StreamSource) token.StreamSource {
+		if metrics == nil {
+			return source
+		}
+		return metrics.Source(name, source)
+	}
 
-	// Parse transforms and apply them sequentially
-	for _, arg := range flag.Args() {
-		transformer, err := parseTransformer(arg)
+	transformStream := func(stream <-chan token.Token, transformers []token.StreamTransformer) <-chan token.Token {
+		names := transformNames(transformArgs)
+		for len(transformers) > 0 {
+			// With -parallel, runs of transforms which apply to each value
+			// independently are spread over several goroutines.
+			if n := countParallelizable(transformers); parallelWorkers > 1 && n > 0 {
+				chain := measureTransformer(strings.Join(names[:n], " | "), valueChain(transformers[:n]))
+				stream = token.ParallelTransformStream(stream, chain, parallelWorkers, !parallelUnordered, handleTransformError)
+				transformers, names = transformers[n:], names[n:]
+				continue
+			}
+			stream = token.TransformStreamWithErrorHandler(stream, measureTransformer(names[0], transformers[0]), handleTransformError)
+			transformers, names = transformers[1:], names[1:]
+		}
+		if omitEmpty {
+			omitEmptyTransformer := iterator.AsStreamTransformer(jsonstream.OmitEmpty{Kinds: jsonstream.AllEmptyKinds})
+			stream = token.TransformStreamWithErrorHandler(stream, measureTransformer("-omit-empty", omitEmptyTransformer), handleTransformError)
+		}
+		if sortKeys {
+			sortKeysTransformer := iterator.AsStreamTransformer(jsonstream.SortKeys{MaxObjectSize: maxObjectSize})
+			stream = token.TransformStreamWithErrorHandler(stream, measureTransformer("-sort-keys", sortKeysTransformer), handleTransformError)
+		}
+		return stream
+	}
+
+	var stream <-chan token.Token
+	if readFiles {
+		// Files which cannot be opened are skipped, as cat does, but jp exits
+		// with an error at the end.
+		stream = concatFiles(inputFiles, func(name string, err error) {
+			fmt.Fprintf(os.Stderr, "error opening %q: %s\n", name, err)
+			transformFailed.Store(true)
+		}, func(name string, input io.Reader) <-chan token.Token {
+			fileStream := token.StartStream(
+				measureSource("input", newDecoder(input)),
+				func(err error) { reportParseError(name, err) },
+			)
+			if !wrapFiles {
+				return fileStream
+			}
+			// The arguments have already been checked above
+			fileTransformers, _ := parseTransformers(transformArgs)
+			return wrapFileValues(name, transformStream(fileStream, fileTransformers))
+		})
+		if !wrapFiles {
+			stream = transformStream(stream, transformers)
+		}
+	} else {
+		// Open input file (or URL)
+		var input io.Reader
+		switch {
+		case inputURL != "":
+			source := &jsonstream.HTTPSource{URL: inputURL, Header: httpHeader, Retries: httpRetries}
+			input, err = source.Open()
+			if err != nil {
+				fatalError("error fetching %q: %s", inputURL, err)
+			}
+		case filename != "":
+			file, err := os.Open(filename)
+			if err != nil {
+				fatalError("error opening %q: %s", filename, err)
+			}
+			input = file
+			if follow {
+				input = followFile(file)
+			}
+		case follow:
+			input = followFile(os.Stdin)
+		default:
+			input = os.Stdin
+		}
+
+		// Start parsing the input file
+		stream = token.StartStream(
+			measureSource("input", newDecoder(input)),
+			func(err error) { reportParseError("", err) },
+		)
+		stream = transformStream(stream, transformers)
+	}
+
+	// With -diff, the transforms are applied to both inputs (with separate
+	// transformers as they may have state) and the output is the difference.
+	if diffFilename != "" {
+		diffInput, err := os.Open(diffFilename)
 		if err != nil {
-			fatalError("error: %s", err)
+			fatalError("error opening %q: %s", diffFilename, err)
 		}
-		stream = token.TransformStream(stream, transformer)
+		diffStream := token.StartStream(
+			measureSource("-diff input", newDecoder(diffInput)),
+			func(err error) { reportParseError(diffFilename, err) },
+		)
+		// The arguments have already been checked above
+		diffTransformers, _ := parseTransformers(transformArgs)
+		stream = jsonstream.DiffStream(stream, transformStream(diffStream, diffTransformers))
 	}
 
 	// Write the output stream to stdout
@@ -144,20 +550,59 @@ func main() {
 		Writer:     out,
 		IndentSize: indent,
 	}
+	if indentFirstLevelOnly {
+		printer.MaxIndentLevel = 1
+	}
+	if crlf {
+		printer.Newline = "\r\n"
+	}
 
-	// If we are writing to a terminal, flush after each line so user gets feedback early.
-	if isatty.IsTerminal(os.Stdout.Fd()) {
+	// If we are writing to a terminal or following the input, flush after each
+	// line so user gets feedback early.
+	if follow || isatty.IsTerminal(os.Stdout.Fd()) {
 		printer.Flusher = out
 	}
 
 	var encoder token.StreamSink
 	switch outputFormat {
-	case "json":
-		encoder = &jsonstream.JSONEncoder{
+	case "json", "ndjson", "raw":
+		jsonEncoder := &jsonstream.JSONEncoder{
 			Printer:               printer,
 			Colorizer:             colorizer,
 			CompactWidthLimit:     compactMaxWidth,
+			ArrayCompactWidth:     arrayCompactWidth,
+			ObjectCompactWidth:    objectCompactWidth,
 			CompactObjectMaxItems: 2,
+			StableFloatRepr:       stableFloatRepr,
+			QuoteIntegersOver:     quoteIntegersOver,
+			RawStrings:            rawStrings,
+			AlignValues:           alignValues,
+			NoSpaceAfterColon:     compactKeys,
+			NoSpaceAfterComma:     compactCommas,
+			ElisionMarker:         elisionMarker,
+			MaxStringLength:       maxString,
+			NonFinite:             nonFinite,
+		}
+		switch floatFormat {
+		case "":
+		case "g", "f", "e":
+			jsonEncoder.FloatFormat = floatFormat[0]
+		default:
+			fatalError("invalid float format: %q", floatFormat)
+		}
+		if floatFormat != "" && stableFloatRepr {
+			fatalError("-float-format and -stable-float-repr cannot be used together")
+		}
+		switch outputFormat {
+		case "ndjson":
+			if rawStrings {
+				fatalError("-raw cannot be used with -out ndjson")
+			}
+			encoder = &jsonstream.NDJSONEncoder{JSONEncoder: *jsonEncoder}
+		case "raw":
+			encoder = &jsonstream.RawEncoder{JSONEncoder: *jsonEncoder}
+		default:
+			encoder = jsonEncoder
 		}
 	case "jpv", "path":
 		{
@@ -165,11 +610,36 @@ func main() {
 			jpvEncoder.AlwaysQuoteKeys = quoteKeys
 			encoder = jpvEncoder
 		}
+	case "jcs":
+		encoder = &jsonstream.JCSEncoder{Printer: printer, MaxObjectSize: maxObjectSize}
+	case "template":
+		if templateText == "" {
+			fatalError("-out template requires a -template")
+		}
+		tmpl, err := template.New("template").Parse(templateText)
+		if err != nil {
+			fatalError("invalid template: %s", err)
+		}
+		encoder = &jsonstream.TemplateEncoder{Printer: printer, Template: tmpl}
+	case "hash":
+		encoder = &jsonstream.HashEncoder{Printer: printer, Hash: newHash(), MaxObjectSize: maxObjectSize}
+	case "csv":
+		encoder = &jsonstream.CSVEncoder{Printer: printer, UseCRLF: crlf}
+	case "msgpack":
+		encoder = &jsonstream.MsgPackEncoder{Printer: printer}
+	case "yaml":
+		encoder = &jsonstream.YAMLEncoder{Printer: printer, Colorizer: colorizer, IndentSize: indent}
 	default:
 		fatalError("invalid output format: %q", outputFormat)
 	}
 
-	err := token.ConsumeStream(stream, encoder)
+	if metrics != nil {
+		encoder = metrics.Sink("output", encoder)
+	}
+	err = token.ConsumeStream(stream, encoder)
+	if metrics != nil {
+		metrics.WriteSummary(os.Stderr)
+	}
 	if err != nil {
 		if errors.Is(err, syscall.EPIPE) {
 			// stdout is a pipe and something closed it (e.g. 'head' or 'less').
@@ -178,17 +648,526 @@ func main() {
 		}
 		fatalError("error: %s", err)
 	}
+	if transformFailed.Load() {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses the command line flags and returns the transform arguments
+// and the input files.  Unlike flag.Parse(), it allows flags to come after
+// transforms (e.g. "jp '$.tags[*]' -raw"), which is possible because transforms
+// never start with "-".  All arguments after "--" are input files with -files,
+// and transforms otherwise.
+func parseArgs() ([]string, []string) {
+	var transforms []string
+	args, terminated := parseFlags(os.Args[1:])
+	for !terminated && len(args) > 0 {
+		arg := args[0]
+		switch {
+		case arg == "--":
+			terminated = true
+			args = args[1:]
+		case len(arg) > 1 && arg[0] == '-':
+			args, terminated = parseFlags(args)
+		default:
+			transforms = append(transforms, arg)
+			args = args[1:]
+		}
+	}
+	if readFiles {
+		return transforms, args
+	}
+	return append(transforms, args...), nil
+}
+
+// readPipelineFile returns the transforms in a pipeline file.  The file either
+// contains a JSON array of strings, e.g.
+//
+//	["$.items[*]", "truncate(max-depth=2)", "count"]
+//
+// or one transform per line, in which case spaces around transforms are
+// removed, and empty lines and lines starting with "#" are ignored.  Unlike on
+// the command line, transforms do not need to be quoted, e.g.
+//
+//	# Count the items
+//	$.items[*]
+//	truncate(max-depth=2)
+//	count
+func readPipelineFile(filename string) ([]string, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var transforms []string
+	if bytes.HasPrefix(bytes.TrimSpace(contents), []byte("[")) {
+		value, err := readJSONFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := iterator.Decode(value, &transforms); err != nil {
+			return nil, err
+		}
+		return transforms, nil
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		transforms = append(transforms, line)
+	}
+	return transforms, nil
+}
+
+// parseFlags parses the flags at the start of args and returns the remaining
+// arguments, and whether the flags were terminated by "--" (which the flag
+// package drops).  It exits on error, as flag.Parse() does.
+func parseFlags(args []string) ([]string, bool) {
+	flag.CommandLine.Parse(args)
+	rest := flag.Args()
+	n := len(args) - len(rest)
+	return rest, n > 0 && args[n-1] == "--"
+}
+
+// Errors in the input and in transforms are reported straight away, but jp
+// only exits (with status 1) once the output produced so far has been written.
+var transformFailed atomic.Bool
+
+// reportParseError reports an error decoding the input (from the named file if
+// name is not empty) to stderr, and makes jp exit with an error at the end.
+// When the input is invalid, the location of the error is given with the input
+// around it, e.g.
+//
+//	error at line 2, col 9: unexpected: ','
+//	  {"a": [1,, 2]}
+//	          ^
+func reportParseError(name string, err error) {
+	transformFailed.Store(true)
+	var in string
+	if name != "" {
+		in = fmt.Sprintf(" in %q", name)
+	}
+	var parseErr *token.ParseError
+	if !errors.As(err, &parseErr) {
+		fmt.Fprintf(os.Stderr, "error while parsing%s: %s\n", in, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error%s at %s: %s\n", in, parseErr.Location(), parseErr.Msg)
+	if parseErr.Context != "" {
+		// Keep tabs so that the caret lines up with the error.
+		indent := []rune(parseErr.Context[:parseErr.ContextPos])
+		for i, r := range indent {
+			if r != '\t' {
+				indent[i] = ' '
+			}
+		}
+		fmt.Fprintf(os.Stderr, "  %s\n  %s^\n", parseErr.Context, string(indent))
+	}
+}
+
+// When true, the input is read from the files given after "--".
+var readFiles bool
+
+// concatFiles returns the concatenation of the streams returned by fileStream
+// for each of the named files, which are opened one at a time.  Files which
+// cannot be opened are reported to handleError and skipped.
+func concatFiles(names []string, handleError func(string, error), fileStream func(string, io.Reader) <-chan token.Token) <-chan token.Token {
+	out := make(chan token.Token)
+	go func() {
+		defer close(out)
+		for _, name := range names {
+			file, err := os.Open(name)
+			if err != nil {
+				handleError(name, err)
+				continue
+			}
+			for tok := range fileStream(name, file) {
+				out <- tok
+			}
+			file.Close()
+		}
+	}()
+	return out
+}
+
+// wrapFileValues returns a stream where each value in the stream is replaced
+// with {"file": name, "value": value}.
+func wrapFileValues(name string, stream <-chan token.Token) <-chan token.Token {
+	out := make(chan token.Token)
+	go func() {
+		defer close(out)
+		depth := 0
+		for tok := range stream {
+			if depth == 0 {
+				out <- &token.StartObject{}
+				out <- token.NewKey(token.String, []byte(`"file"`))
+				out <- token.StringScalar(name)
+				out <- token.NewKey(token.String, []byte(`"value"`))
+			}
+			switch tok.(type) {
+			case *token.StartArray, *token.StartObject:
+				depth++
+			case *token.EndArray, *token.EndObject:
+				depth--
+			}
+			out <- tok
+			if depth == 0 {
+				out <- &token.EndObject{}
+			}
+		}
+	}()
+	return out
+}
+
+// How long to wait before reading a followed file again when it has no more
+// data.
+const followPollInterval = 200 * time.Millisecond
+
+// followFile returns a reader which keeps reading file as it grows, like tail
+// -f, i.e. it waits for more data instead of returning io.EOF.  Only regular
+// files are followed, other files (e.g. pipes) are returned unchanged as they
+// cannot grow after the end is reached.
+func followFile(file *os.File) io.Reader {
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return file
+	}
+	return &followReader{file: file}
+}
+
+type followReader struct {
+	file *os.File
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		time.Sleep(followPollInterval)
+	}
+}
+
+// When true, the split transform fails on values which are not arrays.
+var splitStrict bool
+
+// When true, the cast transform fails on values which cannot be converted.
+var castStrict bool
+
+// When true, the dates transform fails on values which cannot be parsed.
+var datesStrict bool
+
+// The number of goroutines used to apply transforms which process each value
+// independently, and whether their output may be reordered.
+var (
+	parallelWorkers   int
+	parallelUnordered bool
+)
+
+// When true, the trace transform shows the boundaries of top-level values.
+var traceValues bool
+
+// When greater than 1, the trace transform only logs one token in traceEvery,
+// and when positive, it stops logging after traceLimit tokens.
+var (
+	traceEvery int
+	traceLimit int
+)
+
+// fileSink is a StreamSink which writes the streams it consumes to a file, as
+// JPV if the filename ends with .jpv and as JSON with one value per line
+// otherwise.  It is used by tee=FILE.  The streams are appended one after the
+// other, so that with -wrap-files the file gets the values of all the input
+// files.
+type fileSink struct {
+	filename string
+
+	mu  sync.Mutex
+	out *bufio.Writer
+}
+
+func (s *fileSink) Consume(in <-chan token.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var encoder token.StreamSink
+	if strings.HasSuffix(s.filename, ".jpv") {
+		encoder = &jsonstream.JPVEncoder{Printer: &jsonstream.DefaultPrinter{Writer: s.out}}
+	} else {
+		encoder = &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: s.out, IndentSize: -1}}
+	}
+	if err := encoder.Consume(in); err != nil {
+		return err
+	}
+	return s.out.Flush()
+}
+
+// The sinks used by tee=FILE transforms, by filename.  Transforms may be parsed
+// several times (e.g. with -wrap-files), so each file is only created once and
+// its sink is shared.  Like the files of trace loggers, the files stay open
+// until jp exits.
+var (
+	fileSinks   = map[string]*fileSink{}
+	fileSinksMu sync.Mutex
+)
+
+// teeSink returns the sink writing to the given file, creating the file if it
+// is the first time it is used.
+func teeSink(filename string) (*fileSink, error) {
+	if filename == "" {
+		return nil, errors.New("missing tee filename")
+	}
+	fileSinksMu.Lock()
+	defer fileSinksMu.Unlock()
+	if sink, ok := fileSinks[filename]; ok {
+		return sink, nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	sink := &fileSink{filename: filename, out: bufio.NewWriter(file)}
+	fileSinks[filename] = sink
+	return sink, nil
+}
+
+// The loggers used by trace=FILE transforms, by filename.  Transforms may be
+// parsed several times (e.g. with -diff), so each file is only created once
+// and its logger is shared.
+var (
+	traceLoggers   = map[string]*log.Logger{}
+	traceLoggersMu sync.Mutex
+)
+
+// traceLogger returns the logger writing to the given file, creating the file
+// if it is the first time it is used.
+func traceLogger(filename string) (*log.Logger, error) {
+	if filename == "" {
+		return nil, errors.New("missing trace filename")
+	}
+	traceLoggersMu.Lock()
+	defer traceLoggersMu.Unlock()
+	if logger, ok := traceLoggers[filename]; ok {
+		return logger, nil
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New(file, "", log.LstdFlags)
+	traceLoggers[filename] = logger
+	return logger, nil
+}
+
+// When not 0, the seed used by sample-k, so that samples are reproducible.
+var sampleSeed int64
+
+// The number of bytes of values the sort transforms hold in memory before
+// spilling them to temporary files.
+var sortRunSize int
+
+// When true, aggregate transforms output their result after each value.
+var aggregateRunning bool
+
+// When true, jsonpath queries accept dashes in member name shorthands.
+var jsonpathRelaxedNames bool
+
+// What jsonpath transforms output for the nodes they select.
+var jsonpathOutputMode jsonpathtransformer.OutputMode
+
+// When positive, the maximum depth jsonpath descendant segments can look into.
+var jsonpathMaxDepth int
+
+// When positive, the maximum number of tokens jsonpath queries can buffer.
+var jsonpathMaxWindow int
+
+// When true, jsonpath queries output nodes in document order by default.
+var jsonpathLax bool
+
+// What the json decoder does with duplicate keys.  When they are all kept,
+// jsonpath name selectors select all the members with their name.
+var duplicateKeys jsonstream.DuplicateKeyPolicy
+
+// parseQuery parses a jsonpath query, honouring the -jsonpath-relaxed-names,
+// -jsonpath-max-depth, -jsonpath-max-window, -jsonpath-lax and -dup-keys
+// flags.  The options override the flags.
+func parseQuery(s string, options ...jsonpathtransformer.CompileOption) (jsonpathtransformer.MainQueryRunner, error) {
+	query, err := parseQueryAST(s)
+	if err != nil {
+		return jsonpathtransformer.MainQueryRunner{}, err
+	}
+	return compileQuery(query, options...)
+}
+
+func parseQueryAST(s string) (ast.Query, error) {
+	if jsonpathRelaxedNames {
+		return jsonpath.ParseQueryStringRelaxedNames(s)
+	}
+	return jsonpath.ParseQueryString(s)
+}
+
+func compileQuery(query ast.Query, options ...jsonpathtransformer.CompileOption) (jsonpathtransformer.MainQueryRunner, error) {
+	options = append([]jsonpathtransformer.CompileOption{
+		jsonpathtransformer.WithStrictOrder(!jsonpathLax),
+		jsonpathtransformer.WithAllDuplicateKeys(duplicateKeys == jsonstream.DuplicateKeysKeepAll),
+	}, options...)
+	runner, err := jsonpathtransformer.CompileQuery(query, options...)
+	if err != nil {
+		return jsonpathtransformer.MainQueryRunner{}, err
+	}
+	return runner.WithMaxDepth(jsonpathMaxDepth).WithMaxWindowSize(jsonpathMaxWindow), nil
+}
+
+// parseAssignment parses a transform of the form
+//
+//	<jsonpath query> = <json value>
+//
+// If s is not of that form, it returns a nil transformer and a nil error.
+// When the last segment of the query selects a single name, the name is added
+// to the objects which do not have it (see jsonstream.Setter).
+func parseAssignment(s string) (token.StreamTransformer, error) {
+	for i := strings.IndexByte(s, '='); i >= 0; i = nextIndexByte(s, '=', i) {
+		if i > 0 && strings.IndexByte("=!<>", s[i-1]) >= 0 || i+1 < len(s) && s[i+1] == '=' {
+			// Part of a comparison operator
+			continue
+		}
+		query, err := parseQueryAST(strings.TrimSpace(s[:i]))
+		if err != nil {
+			continue
+		}
+		value, err := parseJSONValue(s[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid assigned value: %w", err)
+		}
+		path, err := compileQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		setter := &jsonstream.Setter{Path: &path, Value: value}
+		if parentQuery, name, ok := splitLastName(query); ok {
+			parent, err := compileQuery(parentQuery)
+			if err != nil {
+				return nil, err
+			}
+			setter.Parent = &parent
+			setter.Key = name
+		}
+		return iterator.AsStreamTransformer(setter), nil
+	}
+	return nil, nil
+}
+
+// splitLastName returns the query without its last segment and the name
+// selected by the last segment, if it is a child segment with a single name
+// selector (e.g. $.a.b gives $.a and "b").
+func splitLastName(query ast.Query) (ast.Query, string, bool) {
+	n := len(query.Segments)
+	if n == 0 {
+		return query, "", false
+	}
+	last := query.Segments[n-1]
+	if last.Type != ast.ChildSegmentType || len(last.Selectors) != 1 {
+		return query, "", false
+	}
+	name, ok := last.Selectors[0].(ast.NameSelector)
+	if !ok {
+		return query, "", false
+	}
+	query.Segments = query.Segments[:n-1]
+	return query, name.Name, true
+}
+
+// nextIndexByte returns the index of the first instance of c in s after index
+// i, or -1 if there is none.
+func nextIndexByte(s string, c byte, i int) int {
+	j := strings.IndexByte(s[i+1:], c)
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+// parseJSONValue returns the tokens of the single JSON value in s.
+func parseJSONValue(s string) ([]token.Token, error) {
+	var decodeErr error
+	var toks []token.Token
+	values, depth := 0, 0
+	for tok := range token.StartStream(jsonstream.NewJSONDecoder(strings.NewReader(s)), func(err error) { decodeErr = err }) {
+		toks = append(toks, tok)
+		switch tok.(type) {
+		case *token.StartArray, *token.StartObject:
+			depth++
+		case *token.EndArray, *token.EndObject:
+			depth--
+		}
+		if depth == 0 {
+			values++
+		}
+	}
+	switch {
+	case decodeErr != nil:
+		return nil, decodeErr
+	case values != 1:
+		return nil, fmt.Errorf("expected a single JSON value, got %d", values)
+	}
+	return toks, nil
 }
 
 func parseTransformer(arg string) (token.StreamTransformer, error) {
 	if arg == "split" {
-		return iterator.AsStreamTransformer(jsonstream.ExplodeArray{}), nil
+		return iterator.AsStreamTransformer(jsonstream.ExplodeArray{Strict: splitStrict}), nil
+	}
+	if arg == "object_values" {
+		return iterator.AsStreamTransformer(jsonstream.ObjectValues{}), nil
+	}
+	if strings.HasPrefix(arg, "pick=") {
+		return newKeyFilter(strings.TrimPrefix(arg, "pick="), false)
+	}
+	if strings.HasPrefix(arg, "omit=") {
+		return newKeyFilter(strings.TrimPrefix(arg, "omit="), true)
+	}
+	if arg == "keys" {
+		return iterator.AsStreamTransformer(jsonstream.Keys{}), nil
+	}
+	if arg == "values" {
+		return iterator.AsStreamTransformer(jsonstream.Values{}), nil
+	}
+	if arg == "entries" {
+		return iterator.AsStreamTransformer(jsonstream.Entries{}), nil
+	}
+	if arg == "recurse-leaves" {
+		return iterator.AsStreamTransformer(jsonstream.RecurseLeaves{}), nil
+	}
+	if arg == "sort" {
+		return &jsonstream.Sort{MaxRunSize: sortRunSize}, nil
+	}
+	if strings.HasPrefix(arg, "sort_by=") {
+		key, err := parseQuery(strings.TrimPrefix(arg, "sort_by="))
+		if err != nil {
+			return nil, err
+		}
+		return &jsonstream.Sort{Key: &key, MaxRunSize: sortRunSize}, nil
 	}
 	if arg == "join" {
 		return jsonstream.JoinStream{}, nil
 	}
 	if arg == "trace" {
-		return jsonstream.TraceStream{}, nil
+		return jsonstream.TraceStream{Values: traceValues, Every: traceEvery, Limit: traceLimit}, nil
+	}
+	if strings.HasPrefix(arg, "tee=") {
+		sink, err := teeSink(strings.TrimPrefix(arg, "tee="))
+		if err != nil {
+			return nil, err
+		}
+		return jsonstream.Tee{Sink: sink}, nil
+	}
+	if strings.HasPrefix(arg, "trace=") {
+		logger, err := traceLogger(strings.TrimPrefix(arg, "trace="))
+		if err != nil {
+			return nil, err
+		}
+		return jsonstream.TraceStream{Values: traceValues, Every: traceEvery, Limit: traceLimit, Logger: logger}, nil
 	}
 	if strings.HasPrefix(arg, "...") {
 		return iterator.AsStreamTransformer(&jsonstream.DeepKeyExtractor{Key: strings.TrimPrefix(arg, "...")}), nil
@@ -203,16 +1182,711 @@ func parseTransformer(arg string) (token.StreamTransformer, error) {
 		}
 		return &jsonstream.MaxDepthFilter{MaxDepth: int(depth)}, nil
 	}
+	if strings.HasPrefix(arg, "dedup-window=") {
+		size, err := strconv.ParseInt(strings.TrimPrefix(arg, "dedup-window="), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size <= 0 {
+			return nil, errors.New("dedup window size must be positive")
+		}
+		return iterator.AsStreamTransformer(&jsonstream.WindowDedupFilter{WindowSize: int(size)}), nil
+	}
+	if strings.HasPrefix(arg, "sample-k=") {
+		k, err := strconv.ParseInt(strings.TrimPrefix(arg, "sample-k="), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if k <= 0 {
+			return nil, errors.New("sample size must be positive")
+		}
+		seed := sampleSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		return &jsonstream.ReservoirSample{K: int(k), Rand: rand.New(rand.NewSource(seed))}, nil
+	}
+	if arg == "schema" {
+		return &jsonstream.InferSchema{}, nil
+	}
+	if strings.HasPrefix(arg, "schema-sample=") {
+		n, err := strconv.ParseInt(strings.TrimPrefix(arg, "schema-sample="), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, errors.New("schema sample rate must be positive")
+		}
+		return &jsonstream.InferSchema{Sample: int(n)}, nil
+	}
+	if arg == "stats" {
+		return &jsonstream.Stats{}, nil
+	}
+	if strings.HasPrefix(arg, "stats-keys=") {
+		n, err := strconv.ParseInt(strings.TrimPrefix(arg, "stats-keys="), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, errors.New("number of stats keys must be positive")
+		}
+		return &jsonstream.Stats{TopKeys: int(n)}, nil
+	}
+	if mode, query, ok := strings.Cut(arg, ":"); ok && (mode == "strict" || mode == "lax") && strings.HasPrefix(query, "$") {
+		runner, err := parseQuery(query, jsonpathtransformer.WithStrictOrder(mode == "strict"))
+		return runner.WithOutputMode(jsonpathOutputMode), err
+	}
 	if strings.HasPrefix(arg, "$") {
-		query, err := jsonpath.ParseQueryString(arg)
+		runner, err := parseQuery(arg)
+		if err != nil {
+			if setter, setErr := parseAssignment(arg); setter != nil || setErr != nil {
+				return setter, setErr
+			}
+		}
+		return runner.WithOutputMode(jsonpathOutputMode), err
+	}
+	if transformer, err := parseAggregate(arg); transformer != nil || err != nil {
+		return transformer, err
+	}
+	if strings.HasPrefix(arg, "patch=") {
+		return parsePatchFile(strings.TrimPrefix(arg, "patch="))
+	}
+	if strings.HasPrefix(arg, "merge=") {
+		patch, err := readJSONFile(strings.TrimPrefix(arg, "merge="))
 		if err != nil {
 			return nil, err
 		}
-		return jsonpathtransformer.CompileQuery(query)
+		return iterator.AsStreamTransformer(jsonstream.NewMergePatch(patch)), nil
+	}
+	if strings.HasPrefix(arg, "cast=") {
+		return parseCast(strings.TrimPrefix(arg, "cast="))
+	}
+	if strings.HasPrefix(arg, "dates=") {
+		return parseDates(strings.TrimPrefix(arg, "dates="))
+	}
+	if strings.HasPrefix(arg, "group_by=") {
+		return parseGroupBy(strings.TrimPrefix(arg, "group_by="))
+	}
+	if strings.HasPrefix(arg, "{") && strings.HasSuffix(arg, "}") {
+		return parseObjectConstructor(arg[1 : len(arg)-1])
+	}
+	if strings.HasPrefix(arg, "delete ") {
+		path, err := parseQuery(strings.TrimSpace(strings.TrimPrefix(arg, "delete ")))
+		if err != nil {
+			return nil, err
+		}
+		return iterator.AsStreamTransformer(&jsonstream.Deleter{Path: &path}), nil
+	}
+	if strings.HasPrefix(arg, "grep(") && strings.HasSuffix(arg, ")") {
+		return parseGrep(arg[5 : len(arg)-1])
+	}
+	if strings.HasPrefix(arg, "glob(") && strings.HasSuffix(arg, ")") {
+		ptn, err := jsonstream.GlobToRegexp(arg[5 : len(arg)-1])
+		if err != nil {
+			return nil, err
+		}
+		return iterator.AsStreamTransformer(&jsonstream.KeyGlobFilter{Pattern: ptn}), nil
+	}
+	if strings.HasPrefix(arg, "reindex(") && strings.HasSuffix(arg, ")") {
+		return parseReindex(arg[8 : len(arg)-1])
+	}
+	if strings.HasPrefix(arg, "truncate(") && strings.HasSuffix(arg, ")") {
+		return parseTruncate(arg[9 : len(arg)-1])
+	}
+	if strings.HasPrefix(arg, "try(") && strings.HasSuffix(arg, ")") {
+		transformer, err := parseTransformer(strings.TrimSpace(arg[4 : len(arg)-1]))
+		if err != nil {
+			return nil, fmt.Errorf("try: %w", err)
+		}
+		return iterator.AsStreamTransformer(jsonstream.TryTransformer{Transformer: transformer}), nil
 	}
 	return nil, errors.New("invalid filter")
 }
 
+// simplifyTransforms removes sequences of transforms which cancel each other
+// out, so they do not need to be run.  Currently this is "join split", which
+// always outputs its input stream unchanged.
+//
+// Note that "split join" is not removed as it is not a no-op in general (e.g.
+// it turns "[1] [2]" into "[1, 2]"), except when it follows a "join" - but
+// then the "join split" pair is removed first.
+func simplifyTransforms(args []string) []string {
+	indices := simplifiedTransformIndices(args)
+	simplified := make([]string, 0, len(indices))
+	for _, i := range indices {
+		simplified = append(simplified, args[i])
+	}
+	return simplified
+}
+
+// simplifiedTransformIndices returns the indices in args of the transforms
+// that remain after simplification (see simplifyTransforms).
+func simplifiedTransformIndices(args []string) []int {
+	var indices []int
+	for i, arg := range args {
+		n := len(indices)
+		if arg == "split" && n > 0 && args[indices[n-1]] == "join" {
+			indices = indices[:n-1]
+			continue
+		}
+		indices = append(indices, i)
+	}
+	return indices
+}
+
+// parseTransformers parses all the transform arguments and returns the
+// transformers to apply after simplification.  If an argument is invalid, the
+// error names its position (starting from 1) and the argument itself, so it is
+// easy to find in a long pipeline.
+func parseTransformers(args []string) ([]token.StreamTransformer, error) {
+	parsed := make([]token.StreamTransformer, len(args))
+	for i, arg := range args {
+		transformer, err := parseTransformer(arg)
+		if err != nil {
+			return nil, fmt.Errorf("transform #%d ('%s'): %w", i+1, arg, err)
+		}
+		parsed[i] = transformer
+	}
+	var transformers []token.StreamTransformer
+	for _, i := range simplifiedTransformIndices(args) {
+		transformers = append(transformers, parsed[i])
+	}
+	return transformers, nil
+}
+
+// explainTransforms writes to w how each jsonpath query in args runs (see
+// jsonpathtransformer.MainQueryRunner.Explain).  The args must be valid
+// transforms.
+func explainTransforms(w io.Writer, args []string) {
+	for i, arg := range args {
+		fmt.Fprintf(w, "transform #%d ('%s'):", i+1, arg)
+		transformer, _ := parseTransformer(arg)
+		if runner, ok := transformer.(jsonpathtransformer.MainQueryRunner); ok {
+			fmt.Fprintf(w, "\n%s", runner.Explain())
+		} else {
+			fmt.Fprintln(w, " not a jsonpath query")
+		}
+	}
+}
+
+// hasTeeTransform returns true if one of the transform arguments is a tee=FILE
+// transform.
+func hasTeeTransform(args []string) bool {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "tee=") {
+			return true
+		}
+	}
+	return false
+}
+
+// transformNames returns the names of the transformers returned by
+// parseTransformers for args, which are their position and argument.
+func transformNames(args []string) []string {
+	var names []string
+	for _, i := range simplifiedTransformIndices(args) {
+		names = append(names, fmt.Sprintf("#%d '%s'", i+1, args[i]))
+	}
+	return names
+}
+
+// countParallelizable returns the number of transformers at the start of
+// transformers which can be applied to each value independently and
+// concurrently, as required by token.ParallelTransformStream.
+func countParallelizable(transformers []token.StreamTransformer) int {
+	for i, transformer := range transformers {
+		if !isParallelizable(transformer) {
+			return i
+		}
+	}
+	return len(transformers)
+}
+
+// isParallelizable returns true if the transformer is known to transform each
+// value independently of the others.  Transformers must be listed explicitly,
+// so that a transformer which keeps state across values (e.g. dedup-window) is
+// never run on several goroutines by mistake.
+func isParallelizable(transformer token.StreamTransformer) bool {
+	if _, ok := transformer.(jsonpathtransformer.MainQueryRunner); ok {
+		return true
+	}
+	valueTransformer, ok := iterator.ValueTransformerOf(transformer)
+	if !ok {
+		return false
+	}
+	switch t := valueTransformer.(type) {
+	case jsonstream.TryTransformer:
+		return isParallelizable(t.Transformer)
+	case *jsonstream.KeyExtractor,
+		*jsonstream.DeepKeyExtractor,
+		*jsonstream.KeyGlobFilter,
+		jsonstream.KeyFilter,
+		jsonstream.ExplodeArray,
+		jsonstream.ObjectValues,
+		jsonstream.Keys,
+		jsonstream.Values,
+		jsonstream.Entries,
+		jsonstream.RecurseLeaves,
+		jsonstream.OmitEmpty,
+		jsonstream.SortKeys,
+		*jsonstream.Reindex,
+		*jsonstream.ObjectConstructor,
+		*jsonstream.Setter,
+		*jsonstream.Deleter,
+		*jsonstream.RegexpFilter,
+		*jsonstream.Cast,
+		*jsonstream.DateConverter,
+		*jsonstream.MergePatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// A valueChain applies a sequence of transformers to a single value, without
+// starting goroutines.  This is how a run of transformers is given to
+// token.ParallelTransformStream.
+type valueChain []token.StreamTransformer
+
+func (c valueChain) Transform(in <-chan token.Token, out token.WriteStream) {
+	for _, transformer := range c[:len(c)-1] {
+		acc := token.NewAccumulatorStream()
+		transformer.Transform(in, acc)
+		toks := acc.GetTokens()
+		next := make(chan token.Token, len(toks))
+		for _, tok := range toks {
+			next <- tok
+		}
+		close(next)
+		in = next
+	}
+	c[len(c)-1].Transform(in, out)
+}
+
+// parseGrep parses the arguments of a grep transform, which are of the form
+//
+//	/<regexp>/<flags>
+//	/<regexp>/<flags>, <jsonpath query>
+//
+// where <flags> is made of the letters i, m, s (as in Go regexp flags).
+func parseGrep(args string) (token.StreamTransformer, error) {
+	ptn, rest, err := parseRegexpLiteral(args)
+	if err != nil {
+		return nil, err
+	}
+	filter := &jsonstream.RegexpFilter{Pattern: ptn, MaxTextSize: 1 << 20}
+	rest = strings.TrimSpace(rest)
+	if rest != "" {
+		if rest[0] != ',' {
+			return nil, fmt.Errorf("grep: unexpected %q after regexp", rest)
+		}
+		runner, err := parseQuery(strings.TrimSpace(rest[1:]))
+		if err != nil {
+			return nil, fmt.Errorf("grep: %w", err)
+		}
+		filter.Target = &runner
+	}
+	return iterator.AsStreamTransformer(filter), nil
+}
+
+// parseReindex parses the arguments of a reindex transform, which are of the
+// form
+//
+//	<jsonpath query>
+//	<jsonpath query>, collect
+func parseReindex(args string) (token.StreamTransformer, error) {
+	reindex := &jsonstream.Reindex{}
+	if i := strings.LastIndexByte(args, ','); i >= 0 && strings.TrimSpace(args[i+1:]) == "collect" {
+		reindex.Collect = true
+		args = args[:i]
+	}
+	runner, err := parseQuery(strings.TrimSpace(args))
+	if err != nil {
+		return nil, fmt.Errorf("reindex: %w", err)
+	}
+	reindex.Key = &runner
+	return iterator.AsStreamTransformer(reindex), nil
+}
+
+// parseTruncate parses the limits of a truncate transform, which is of the form
+//
+//	<limit>=<n>,...
+//
+// where <limit> is max-depth, max-array-items or max-string-length.
+func parseTruncate(args string) (token.StreamTransformer, error) {
+	truncate := &jsonstream.Truncate{MaxDepth: -1}
+	for _, arg := range strings.Split(args, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(arg), "=")
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid truncate limit %q: expected <limit>=<n>", arg)
+		}
+		switch name {
+		case "max-depth":
+			if n < 0 {
+				return nil, errors.New("max-depth must not be negative")
+			}
+			truncate.MaxDepth = int(n)
+			continue
+		case "max-array-items":
+			truncate.MaxArrayItems = int(n)
+		case "max-string-length":
+			truncate.MaxStringLength = int(n)
+		default:
+			return nil, fmt.Errorf("invalid truncate limit %q", name)
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("%s must be positive", name)
+		}
+	}
+	return truncate, nil
+}
+
+// aggregateFuncs maps the names of aggregate transforms to their functions.
+var aggregateFuncs = map[string]jsonstream.AggregateFunc{
+	"count": jsonstream.CountAggregate,
+	"sum":   jsonstream.SumAggregate,
+	"min":   jsonstream.MinAggregate,
+	"max":   jsonstream.MaxAggregate,
+	"avg":   jsonstream.AvgAggregate,
+}
+
+// parseAggregate parses an aggregate transform, which is of the form
+//
+//	<func>
+//	<func>=<jsonpath query>
+//
+// where <func> is one of count, sum, min, max, avg.  If arg is not of that
+// form, it returns a nil transformer and a nil error.
+func parseAggregate(arg string) (token.StreamTransformer, error) {
+	name, query, hasQuery := strings.Cut(arg, "=")
+	fn, ok := aggregateFuncs[name]
+	if !ok {
+		return nil, nil
+	}
+	aggregate := &jsonstream.Aggregate{Func: fn, Running: aggregateRunning}
+	if hasQuery {
+		value, err := parseQuery(query)
+		if err != nil {
+			return nil, err
+		}
+		aggregate.Value = &value
+	}
+	return aggregate, nil
+}
+
+// parsePatchFile returns a transformer applying the JSON Patch in the given
+// file.
+func parsePatchFile(filename string) (token.StreamTransformer, error) {
+	patch, err := readJSONFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return jsonstream.NewApplyPatch(patch)
+}
+
+// readJSONFile returns the single JSON value in the given file.
+func readJSONFile(filename string) (iterator.Value, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	toks, err := parseJSONValue(string(contents))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	iter := iterator.New(token.NewSliceReadStream(toks))
+	iter.Advance()
+	return iter.CurrentValue(), nil
+}
+
+// parseGroupBy parses the arguments of a group_by transform, which are of the
+// form
+//
+//	<jsonpath query>[,map][,sorted]
+func parseGroupBy(args string) (token.StreamTransformer, error) {
+	groupBy := &jsonstream.GroupBy{}
+	for {
+		i := strings.LastIndexByte(args, ',')
+		if i < 0 {
+			break
+		}
+		switch strings.TrimSpace(args[i+1:]) {
+		case "map":
+			groupBy.Map = true
+		case "sorted":
+			groupBy.Sorted = true
+		default:
+			i = -1
+		}
+		if i < 0 {
+			break
+		}
+		args = args[:i]
+	}
+	key, err := parseQuery(strings.TrimSpace(args))
+	if err != nil {
+		return nil, err
+	}
+	groupBy.Key = &key
+	return groupBy, nil
+}
+
+// parseCast parses the fields of a cast transform, which is of the form
+//
+//	<query>:<type>,<query>:<type>,...
+//
+// where <type> is int, float (or number), bool or string.
+func parseCast(args string) (token.StreamTransformer, error) {
+	cast := &jsonstream.Cast{Strict: castStrict}
+	for _, arg := range splitTopLevel(args, ',') {
+		i := strings.LastIndexByte(arg, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid cast field %q: expected <query>:<type>", arg)
+		}
+		var castType jsonstream.CastType
+		switch typeName := strings.TrimSpace(arg[i+1:]); typeName {
+		case "int":
+			castType = jsonstream.CastInt
+		case "float", "number":
+			castType = jsonstream.CastFloat
+		case "bool":
+			castType = jsonstream.CastBool
+		case "string":
+			castType = jsonstream.CastString
+		default:
+			return nil, fmt.Errorf("invalid cast type %q", typeName)
+		}
+		path, err := parseQuery(strings.TrimSpace(arg[:i]))
+		if err != nil {
+			return nil, err
+		}
+		cast.Fields = append(cast.Fields, jsonstream.CastField{Path: &path, Type: castType})
+	}
+	return iterator.AsStreamTransformer(cast), nil
+}
+
+// parseDates parses the fields of a dates transform, which is of the form
+//
+//	<query>:[<from>->]<to>,...
+//
+// where <from> and <to> are unix, unixms, rfc3339 or time layouts (JSON strings
+// if they contain commas or colons).  The arrow can also be written "→".  When
+// <from> is omitted, the input format is guessed.
+func parseDates(args string) (token.StreamTransformer, error) {
+	converter := &jsonstream.DateConverter{Strict: datesStrict}
+	for _, arg := range splitTopLevel(args, ',') {
+		i := lastIndexTopLevel(arg, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid dates field %q: expected <query>:<format>", arg)
+		}
+		field := jsonstream.DateField{}
+		formats := strings.Replace(strings.TrimSpace(arg[i+1:]), "→", "->", 1)
+		if j := strings.Index(formats, "->"); j >= 0 {
+			from, err := parseDateFormat(formats[:j])
+			if err != nil {
+				return nil, err
+			}
+			field.From = from
+			formats = formats[j+2:]
+		}
+		to, err := parseDateFormat(formats)
+		if err != nil {
+			return nil, err
+		}
+		field.To = to
+		path, err := parseQuery(strings.TrimSpace(arg[:i]))
+		if err != nil {
+			return nil, err
+		}
+		field.Path = &path
+		converter.Fields = append(converter.Fields, field)
+	}
+	return iterator.AsStreamTransformer(converter), nil
+}
+
+// parseDateFormat parses a date format in a dates transform, which is a JSON
+// string or any non-empty text.
+func parseDateFormat(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `"`) {
+		toks, err := parseJSONValue(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid date format %s: %w", s, err)
+		}
+		if scalar, ok := toks[0].(*token.Scalar); ok && scalar.Type() == token.String {
+			s = scalar.ToString()
+		}
+	}
+	if s == "" {
+		return "", errors.New("missing date format")
+	}
+	return s, nil
+}
+
+// newKeyFilter parses the comma separated keys of a pick or omit transform.
+// Keys are names or JSON strings, as in object construction.
+func newKeyFilter(args string, omit bool) (token.StreamTransformer, error) {
+	filter := jsonstream.KeyFilter{Keys: map[string]bool{}, Omit: omit}
+	for _, arg := range splitTopLevel(args, ',') {
+		key, err := parseFieldKey(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, err
+		}
+		filter.Keys[key] = true
+	}
+	return iterator.AsStreamTransformer(filter), nil
+}
+
+// parseObjectConstructor parses the fields of an object construction transform,
+// which is of the form
+//
+//	{<key>: <jsonpath query>, ...}
+//
+// where <key> is a name (as in $.name) or a JSON string.  The values of fields
+// whose query is not singular (e.g. $.items[*]) are arrays of the selected
+// nodes.
+func parseObjectConstructor(args string) (token.StreamTransformer, error) {
+	constructor := &jsonstream.ObjectConstructor{}
+	if strings.TrimSpace(args) == "" {
+		return iterator.AsStreamTransformer(constructor), nil
+	}
+	for _, field := range splitTopLevel(args, ',') {
+		i := indexTopLevel(field, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("expected <key>: <jsonpath query>, got %q", strings.TrimSpace(field))
+		}
+		key, err := parseFieldKey(strings.TrimSpace(field[:i]))
+		if err != nil {
+			return nil, err
+		}
+		query, err := parseQueryAST(strings.TrimSpace(field[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+		runner, err := compileQuery(query)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", key, err)
+		}
+		_, singular := query.AsSingularQuery()
+		constructor.Fields = append(constructor.Fields, jsonstream.ConstructedField{
+			Key:   key,
+			Query: &runner,
+			Array: !singular,
+		})
+	}
+	return iterator.AsStreamTransformer(constructor), nil
+}
+
+// parseFieldKey parses the key of a field in an object construction, which is
+// either a JSON string or a name made of letters, digits, '_' and '-'.
+func parseFieldKey(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		toks, err := parseJSONValue(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid key %s: %w", s, err)
+		}
+		if scalar, ok := toks[0].(*token.Scalar); ok && scalar.Type() == token.String {
+			return scalar.ToString(), nil
+		}
+	}
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-')
+	}) >= 0 {
+		return "", fmt.Errorf("invalid key %q", s)
+	}
+	return s, nil
+}
+
+// splitTopLevel splits s around the occurrences of sep which are not inside
+// brackets, parentheses, braces or string literals.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	for {
+		i := indexTopLevel(s, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// lastIndexTopLevel returns the index of the last occurrence of sep in s which
+// is not inside brackets, parentheses, braces or string literals, or -1.
+func lastIndexTopLevel(s string, sep byte) int {
+	last := -1
+	for {
+		i := indexTopLevel(s[last+1:], sep)
+		if i < 0 {
+			return last
+		}
+		last += i + 1
+	}
+}
+
+// indexTopLevel returns the index of the first occurrence of sep in s which is
+// not inside brackets, parentheses, braces or string literals, or -1.
+func indexTopLevel(s string, sep byte) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '(' || c == '{':
+			depth++
+		case c == ']' || c == ')' || c == '}':
+			depth--
+		case c == sep && depth == 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseRegexpLiteral parses a regexp of the form /<regexp>/<flags> at the start
+// of s and returns the compiled regexp and the rest of s.
+func parseRegexpLiteral(s string) (*regexp.Regexp, string, error) {
+	if !strings.HasPrefix(s, "/") {
+		return nil, "", errors.New("expected regexp of the form /.../")
+	}
+	end := -1
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == '/' {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, "", errors.New("unterminated regexp")
+	}
+	ptn := strings.ReplaceAll(s[1:end], `\/`, "/")
+	rest := s[end+1:]
+	flags := ""
+	for rest != "" && strings.ContainsRune("ims", rune(rest[0])) {
+		flags += rest[:1]
+		rest = rest[1:]
+	}
+	if flags != "" {
+		ptn = "(?" + flags + ")" + ptn
+	}
+	re, err := regexp.Compile(ptn)
+	if err != nil {
+		return nil, "", err
+	}
+	return re, rest, nil
+}
+
 type FormatGuesser struct {
 	pattern *regexp.Regexp
 	format  string
@@ -225,11 +1899,88 @@ func formatGuesser(format string, pattern string) FormatGuesser {
 	}
 }
 
+// csvDelimiter returns the field delimiter to use to decode the given csv
+// input format (tsv formats always use tabs).
+func csvDelimiter(format string, delim rune) rune {
+	if strings.HasPrefix(format, "tsv") {
+		return '\t'
+	}
+	return delim
+}
+
+// The format guessers are tried in order on the start of the input.
+//
+// YAML is detected in two ways.  A document start marker ("---") or a
+// directive ("%YAML") is a sure sign, so it is checked before CSV.  Otherwise a
+// first line of the form "key: value" (or just "key:") is taken to be YAML.
+// This is only tried after JSON and CSV, because YAML is nearly a superset of
+// JSON and a CSV header could contain a colon.  The heuristic does not detect
+// YAML documents which start with a comment, a sequence ("- item") or a flow
+// collection (which looks like JSON anyway).
+//
+// TSV is tried before CSV as a CSV file is unlikely to contain tabs in its
+// first line, but the TSV guessers do not accept commas in the first line so
+// that they do not take over CSV files whose fields contain tabs.
 var formatGuessers = []FormatGuesser{
-	formatGuesser("jpv", `^$`),
+	formatGuesser("jpv", `^\$`),
 	formatGuesser("json", `^[{[]`),
+	formatGuesser("xml", `^\s*<`),
+	formatGuesser("yaml", `^(---|%YAML)(\s|$)`),
+	formatGuesser("tsv-header", `^[a-zA-Z][a-zA-Z_0-9-]*(\t[a-zA-Z][a-zA-Z_0-9-]*)+(\n|\t?$)`),
+	formatGuesser("tsv", `^[^\t,"\n]*(\t[^\t,"\n]*)+(\n|$)`),
 	formatGuesser("csv-header", `^[a-zA-Z][a-zA-Z_0-9-]*(,[a-zA-Z][a-zA-Z_0-9-]*)+(\n|,?$)`),
 	formatGuesser("csv", `^([^,"\n]*|("[^"]*"))(,[^,"\n]*|,("[^"]*"))+(\n|,?$)`),
+	formatGuesser("yaml", `^[a-zA-Z_][a-zA-Z_0-9 -]*:( [^\n]*)?(\n|$)`),
+}
+
+// splitCompression splits an input format such as "json.gz" into the format
+// and the compression.  A compression on its own (e.g. "gz") means the format
+// is guessed.
+func splitCompression(inputFormat string) (string, string) {
+	for _, compression := range []string{"gz", "bz2", "zst"} {
+		if inputFormat == compression {
+			return "auto", compression
+		}
+		if format, ok := strings.CutSuffix(inputFormat, "."+compression); ok {
+			return format, compression
+		}
+	}
+	return inputFormat, ""
+}
+
+// guessCompression returns the compression of the input given its start (or ""
+// if it is not compressed), detected with the magic bytes of each format.
+func guessCompression(start []byte) string {
+	switch {
+	case bytes.HasPrefix(start, []byte{0x1f, 0x8b}):
+		return "gz"
+	case len(start) >= 4 && bytes.HasPrefix(start, []byte("BZh")) && '1' <= start[3] && start[3] <= '9':
+		return "bz2"
+	case bytes.HasPrefix(start, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zst"
+	}
+	return ""
+}
+
+// decompress returns a reader for the decompressed input.
+func decompress(input io.Reader, compression string) (io.Reader, error) {
+	switch compression {
+	case "gz":
+		// Concatenated gzip members (e.g. from gzip -c a b) are read as one
+		// stream, as gunzip does.
+		return gzip.NewReader(input)
+	case "bz2":
+		return bzip2.NewReader(input), nil
+	default: // "zst"
+		// Concatenated frames are read as one stream, as zstd -d does.  The
+		// decoder is not closed as it decodes synchronously, so it holds no
+		// goroutines.
+		decoder, err := zstd.NewReader(input, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder, nil
+	}
 }
 
 func guessFormat(start []byte) string {
@@ -241,6 +1992,30 @@ func guessFormat(start []byte) string {
 	return ""
 }
 
+// useColors decides whether to output colors given the -color mode.  In auto
+// mode, colors are used when stdout is a terminal, following the NO_COLOR
+// (https://no-color.org) and CLICOLOR / CLICOLOR_FORCE conventions: NO_COLOR
+// disables colors, CLICOLOR_FORCE enables them even if stdout is not a
+// terminal and CLICOLOR=0 disables them.
+func useColors(mode string) bool {
+	switch mode {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("CLICOLOR_FORCE"); force != "" && force != "0" {
+		return true
+	}
+	if os.Getenv("CLICOLOR") == "0" {
+		return false
+	}
+	return isatty.IsTerminal(os.Stdout.Fd())
+}
+
 func fatalError(msg string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, msg, args...)
 	os.Exit(1)
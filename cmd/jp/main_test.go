@@ -0,0 +1,1344 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+	"github.com/klauspost/compress/zstd"
+)
+
+// When this environment variable is set, the test binary runs the jp command
+// instead of the tests.  This is used by runJP.
+const runMainEnvVar = "JP_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnvVar) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runJP runs the jp command with the given arguments, feeding it the input.  It
+// returns the output of the command and an error if it failed.
+func runJP(t *testing.T, input string, args ...string) (string, error) {
+	return runJPWithEnv(t, nil, input, args...)
+}
+
+// runJPWithEnv is like runJP but the command is run with the given environment
+// variables (of the form "NAME=value") set in addition to the current
+// environment.
+func runJPWithEnv(t *testing.T, env []string, input string, args ...string) (string, error) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnvVar+"=1")
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		t.Logf("stderr: %s", stderr.String())
+	}
+	return stdout.String(), err
+}
+
+// runTransforms applies the transforms described by args to the JSON input and
+// returns the output as compact JSON with one value per line.
+func runTransforms(t *testing.T, input string, args []string) string {
+	stream := token.StartStream(jsonstream.NewJSONDecoder(strings.NewReader(input)), nil)
+	for _, arg := range args {
+		transformer, err := parseTransformer(arg)
+		if err != nil {
+			t.Fatalf("Invalid transform %q: %s", arg, err)
+		}
+		stream = token.TransformStream(stream, transformer)
+	}
+	var b strings.Builder
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	if err := token.ConsumeStream(stream, encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return b.String()
+}
+
+func TestSimplifyTransforms(t *testing.T) {
+	type testCase struct {
+		name       string
+		input      string
+		args       []string
+		simplified []string
+	}
+	var testCases = []testCase{
+		{
+			name:       "join split",
+			input:      `1 [2, 3] {"x": 4}`,
+			args:       []string{"join", "split"},
+			simplified: []string{},
+		},
+		{
+			name:       "split join is kept",
+			input:      `[1] [2]`,
+			args:       []string{"split", "join"},
+			simplified: []string{"split", "join"},
+		},
+		{
+			name:       "join split join",
+			input:      `[1] [2]`,
+			args:       []string{"join", "split", "join"},
+			simplified: []string{"join"},
+		},
+		{
+			name:       "nested pairs",
+			input:      `[[1, 2], [3]] 4`,
+			args:       []string{"$[*]", "join", "join", "split", "split", "depth=0"},
+			simplified: []string{"$[*]", "depth=0"},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			simplified := simplifyTransforms(c.args)
+			if !reflect.DeepEqual(simplified, c.simplified) {
+				t.Fatalf("Expected %q, got %q", c.simplified, simplified)
+			}
+			expected := runTransforms(t, c.input, c.args)
+			got := runTransforms(t, c.input, simplified)
+			if got != expected {
+				t.Fatalf("Expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		arg    string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "set and add key",
+			input:  `{"users": [{"active": false}, {"id": 2}]}`,
+			arg:    `$.users[*].active = true`,
+			output: `{"users": [{"active": true},{"id": 2,"active": true}]}`,
+		},
+		{
+			name:   "no spaces",
+			input:  `{"a": 1}`,
+			arg:    `$.b={"c":[1]}`,
+			output: `{"a": 1,"b": {"c": [1]}}`,
+		},
+		{
+			name:   "comparisons in query",
+			input:  `[1, 2, 3]`,
+			arg:    `$[?@ >= 2 && @ != 3] = "x"`,
+			output: `[1,"x",3]`,
+		},
+		{
+			name:   "string containing =",
+			input:  `{"a=b": 1}`,
+			arg:    `$['a=b'] = "="`,
+			output: `{"a=b": "="}`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runTransforms(t, c.input, []string{c.arg})
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	got := runTransforms(t, `{"users": [{"id": 1, "password": "x"}, {"id": 2}]} [1, 2]`, []string{"delete $.users[*].password"})
+	expected := "{\"users\": [{\"id\": 1},{\"id\": 2}]}\n[1,2]\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestSortBy(t *testing.T) {
+	got := runTransforms(t, `{"d": 3} {"d": 1} {"e": 2}`, []string{"sort_by=$.d"})
+	expected := "{\"e\": 2}\n{\"d\": 1}\n{\"d\": 3}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	got := runTransforms(t, `{"k": 1} {"k": 2} {"k": 1}`, []string{"group_by=$['k', 'x'] , map"})
+	expected := "{\"1\": [{\"k\": 1},{\"k\": 1}],\"2\": [{\"k\": 2}]}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestAggregates(t *testing.T) {
+	input := `{"price": 2} {"price": 3.5} {}`
+	for arg, expected := range map[string]string{
+		"count":          "3\n",
+		"sum=$.price":    "5.5\n",
+		"max=$.price":    "3.5\n",
+		"avg=$['price']": "2.75\n",
+	} {
+		got := runTransforms(t, input, []string{arg})
+		if got != expected {
+			t.Fatalf("%s: expected %q, got %q", arg, expected, got)
+		}
+	}
+}
+
+func TestDiffFlag(t *testing.T) {
+	diffFile := filepath.Join(t.TempDir(), "to.json")
+	if err := os.WriteFile(diffFile, []byte(`{"a": 2, "b": "x", "c": 3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := runJP(t, `{"a": 1, "b": "y"}`, "-in", "json", "-indent", "-1", "-diff", diffFile, "delete $.b")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `[{"op": "replace","path": "/a","value": 2},{"op": "add","path": "/c","value": 3}]` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestFilesFlag(t *testing.T) {
+	dir := t.TempDir()
+	fileA := filepath.Join(dir, "a.json")
+	fileB := filepath.Join(dir, "b.json")
+	if err := os.WriteFile(fileA, []byte(`{"x": 1} [2, 3]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileB, []byte(`[4] {} []`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := runJP(t, "", "-indent", "-1", "-files", "count", "--", fileA, fileB)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "5\n"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	got, err = runJP(t, "", "-indent", "-1", "-compact-commas", "-files", "-wrap-files", "count", "--", fileA, fileB)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := fmt.Sprintf(`{"file": %q,"value": 2}`+"\n"+`{"file": %q,"value": 3}`+"\n", fileA, fileB)
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	got, err = runJP(t, "", "-indent", "-1", "-compact-commas", "-files", "-wrap-files", "--", filepath.Join(dir, "missing.json"), fileA)
+	if err == nil {
+		t.Fatalf("Expected an error for the missing file")
+	}
+	expected = fmt.Sprintf(`{"file": %q,"value": {"x": 1}}`+"\n"+`{"file": %q,"value": [2,3]}`+"\n", fileA, fileA)
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "log.jsonl")
+	if err := os.WriteFile(logFile, []byte(`{"a": 1}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-in", "json", "-indent", "-1", "-follow", "-file", logFile, "$.a")
+	cmd.Env = append(os.Environ(), runMainEnvVar+"=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	lines := bufio.NewScanner(stdout)
+	expectLine := func(expected string) {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("Expected %q, got end of output", expected)
+		}
+		if got := lines.Text(); got != expected {
+			t.Fatalf("Expected %q, got %q", expected, got)
+		}
+	}
+	expectLine("1")
+	// Records appended to the file are output as soon as they are complete.
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, record := range []string{`{"a": 2}` + "\n", `{"a": `, `3}` + "\n"} {
+		if _, err := file.WriteString(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectLine("2")
+	expectLine("3")
+}
+
+func TestURLFlag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xyz" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintln(w, `{"event": "a"}`)
+		fmt.Fprintln(w, `{"nested": {"event": "b"}}`)
+	}))
+	defer server.Close()
+	got, err := runJP(t, "", "-in", "json", "-indent", "-1", "-url", server.URL, "-header", "Authorization: Bearer xyz", "$..event")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "\"a\"\n\"b\"\n"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	if _, err := runJP(t, "", "-url", server.URL, "-retries", "0"); err == nil {
+		t.Fatalf("Expected an error without the header")
+	}
+}
+
+func TestCompressedInput(t *testing.T) {
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write([]byte(`{"a": 1}` + "\n" + `[2]` + "\n"))
+	zw.Close()
+	var zstdCompressed bytes.Buffer
+	zstdWriter, _ := zstd.NewWriter(&zstdCompressed)
+	zstdWriter.Write([]byte(`{"a": 1}` + "\n" + `[2]` + "\n"))
+	zstdWriter.Close()
+	// Output of bzip2 -c on the same input.
+	bzipped, _ := hex.DecodeString("425a683931415926535949eccea6000005db80001050003010000a2000000a200022980d3d4201a69a059a5890ee70bb9229c284824f667530")
+	type testCase struct {
+		name   string
+		input  []byte
+		args   []string
+		output string
+		fails  bool
+	}
+	var testCases = []testCase{
+		{
+			name:   "gzip detected",
+			input:  gzipped.Bytes(),
+			output: `{"a": 1}` + "\n" + `[2]` + "\n",
+		},
+		{
+			name:   "bzip2 detected",
+			input:  bzipped,
+			output: `{"a": 1}` + "\n" + `[2]` + "\n",
+		},
+		{
+			name:   "format and compression",
+			input:  gzipped.Bytes(),
+			args:   []string{"-in", "json.gz"},
+			output: `{"a": 1}` + "\n" + `[2]` + "\n",
+		},
+		{
+			name:   "compression only",
+			input:  bzipped,
+			args:   []string{"-in", "bz2"},
+			output: `{"a": 1}` + "\n" + `[2]` + "\n",
+		},
+		{
+			name:  "not compressed",
+			input: []byte(`[1]`),
+			args:  []string{"-in", "json.gz"},
+			fails: true,
+		},
+		{
+			name:   "zstd detected",
+			input:  zstdCompressed.Bytes(),
+			output: `{"a": 1}` + "\n" + `[2]` + "\n",
+		},
+		{
+			name:   "zstd format and compression",
+			input:  zstdCompressed.Bytes(),
+			args:   []string{"-in", "json.zst"},
+			output: `{"a": 1}` + "\n" + `[2]` + "\n",
+		},
+		{
+			name:  "invalid zstd",
+			input: []byte{0x28, 0xb5, 0x2f, 0xfd, 0, 0, 0, 0},
+			fails: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := runJP(t, string(tc.input), append([]string{"-indent", "-1"}, tc.args...)...)
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("Expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != tc.output {
+				t.Fatalf("Expected %q, got %q", tc.output, got)
+			}
+		})
+	}
+}
+
+func TestSkipErrors(t *testing.T) {
+	input := `{"a": 1}` + "\n" + `{"a": 2,}` + "\n" + `{"a": 3}` + "\n"
+	got, err := runJP(t, input, "-in", "json", "-indent", "-1", "-skip-errors", "$.a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "1\n3\n"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	if _, err := runJP(t, "a,b\n1,2\n", "-in", "csv", "-skip-errors"); err == nil {
+		t.Fatalf("Expected an error with csv input")
+	}
+}
+
+func TestPatchFile(t *testing.T) {
+	patchFile := filepath.Join(t.TempDir(), "patch.json")
+	patch := `[{"op": "replace", "path": "/a", "value": 2}, {"op": "add", "path": "/c", "value": 3}]`
+	if err := os.WriteFile(patchFile, []byte(patch), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := runTransforms(t, `{"a": 1, "b": "y"}`, []string{"patch=" + patchFile})
+	expected := `{"a": 2,"b": "y","c": 3}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestMergeFile(t *testing.T) {
+	patchFile := filepath.Join(t.TempDir(), "local.json")
+	if err := os.WriteFile(patchFile, []byte(`{"debug": true, "db": {"password": null}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got := runTransforms(t, `{"db": {"host": "h", "password": "p"}}`, []string{"merge=" + patchFile})
+	expected := `{"db": {"host": "h"},"debug": true}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestObjectConstruction(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		arg    string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "singular queries",
+			input:  `{"user": {"name": "Bob"}, "cart": {"total": 12}}`,
+			arg:    `{name: $.user.name, total: $.cart.total}`,
+			output: `{"name": "Bob","total": 12}`,
+		},
+		{
+			name:   "missing value",
+			input:  `{"a": 1}`,
+			arg:    `{a: $.a, b: $.b}`,
+			output: `{"a": 1,"b": null}`,
+		},
+		{
+			name:   "non singular query",
+			input:  `{"items": [{"id": 1}, {"id": 2}]}`,
+			arg:    `{ids: $.items[*].id, first: $.items[0]}`,
+			output: `{"ids": [1,2],"first": {"id": 1}}`,
+		},
+		{
+			name:   "quoted keys and commas in queries",
+			input:  `{"a,b": 1, "c": [1, 2, 3]}`,
+			arg:    `{"x y": $['a,b'], "z": $.c[0,2]}`,
+			output: `{"x y": 1,"z": [1,3]}`,
+		},
+		{
+			name:   "empty object",
+			input:  `[1]`,
+			arg:    `{}`,
+			output: `{}`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runTransforms(t, c.input, []string{c.arg})
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
+
+func TestParseTransformersError(t *testing.T) {
+	type testCase struct {
+		name string
+		args []string
+		err  string
+	}
+	var testCases = []testCase{
+		{
+			name: "bad query",
+			args: []string{"split", "$.bad[", "join"},
+			err:  "transform #2 ('$.bad['): invalid query string",
+		},
+		{
+			name: "unknown transform",
+			args: []string{"$.x", "depth=1", "frobnicate"},
+			err:  "transform #3 ('frobnicate'): invalid filter",
+		},
+		{
+			name: "position before simplification",
+			args: []string{"join", "split", "sample-k=0"},
+			err:  "transform #3 ('sample-k=0'): sample size must be positive",
+		},
+		{
+			name: "missing tee file",
+			args: []string{"tee="},
+			err:  "transform #1 ('tee='): missing tee filename",
+		},
+		{
+			name: "missing trace file",
+			args: []string{"trace="},
+			err:  "transform #1 ('trace='): missing trace filename",
+		},
+		{
+			name: "bad schema sample rate",
+			args: []string{"schema-sample=0"},
+			err:  "transform #1 ('schema-sample=0'): schema sample rate must be positive",
+		},
+		{
+			name: "bad number of stats keys",
+			args: []string{"stats-keys=0"},
+			err:  "transform #1 ('stats-keys=0'): number of stats keys must be positive",
+		},
+		{
+			name: "bad truncate limit",
+			args: []string{"truncate(max-array-items=0)"},
+			err:  "transform #1 ('truncate(max-array-items=0)'): max-array-items must be positive",
+		},
+		{
+			name: "unknown truncate limit",
+			args: []string{"truncate(max-keys=3)"},
+			err:  `transform #1 ('truncate(max-keys=3)'): invalid truncate limit "max-keys"`,
+		},
+		{
+			name: "bad assigned value",
+			args: []string{"$.a = [1"},
+			err:  "transform #1 ('$.a = [1'): invalid assigned value: syntax error at L1,C4: expected ']' or ',', got: <EOF>",
+		},
+		{
+			name: "bad cast type",
+			args: []string{"cast=$.a:date"},
+			err:  `transform #1 ('cast=$.a:date'): invalid cast type "date"`,
+		},
+		{
+			name: "missing date format",
+			args: []string{"dates=$.a:unix->"},
+			err:  `transform #1 ('dates=$.a:unix->'): missing date format`,
+		},
+		{
+			name: "bad pick key",
+			args: []string{"pick=a,"},
+			err:  `transform #1 ('pick=a,'): invalid key ""`,
+		},
+		{
+			name: "bad constructed key",
+			args: []string{"{a b: $}"},
+			err:  `transform #1 ('{a b: $}'): invalid key "a b"`,
+		},
+		{
+			name: "bad constructed field",
+			args: []string{"{a: $.x, b}"},
+			err:  `transform #1 ('{a: $.x, b}'): expected <key>: <jsonpath query>, got "b"`,
+		},
+		{
+			name: "nested error",
+			args: []string{"try($[?)"},
+			err:  "transform #1 ('try($[?)'): try: invalid query string",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseTransformers(c.args)
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("Expected error %q, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestSplitToCSV(t *testing.T) {
+	input := `[
+  {"name": "Alice", "age": 30, "tags": ["a", "b"]},
+  {"age": 25, "name": "Bob, Jr."},
+  {"name": "Carol", "email": "carol@example.com", "age": null}
+]`
+	got, err := runJP(t, input, "-in", "json", "-out", "csv", "split")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `name,age,tags
+Alice,30,"[""a"",""b""]"
+"Bob, Jr.",25,
+Carol,,
+`
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestGuessFormat(t *testing.T) {
+	type testCase struct {
+		name   string
+		start  string
+		format string
+	}
+	var testCases = []testCase{
+		{
+			name:   "json object",
+			start:  `{"a": 1}`,
+			format: "json",
+		},
+		{
+			name:   "json array",
+			start:  "[\n  1,\n  2\n]",
+			format: "json",
+		},
+		{
+			name:   "xml",
+			start:  "<?xml version=\"1.0\"?>\n<a>",
+			format: "xml",
+		},
+		{
+			name:   "jpv",
+			start:  "$.a[0] = 1\n$.b = 2\n",
+			format: "jpv",
+		},
+		{
+			name:   "yaml document start",
+			start:  "---\nname: Alice\nage: 30\n",
+			format: "yaml",
+		},
+		{
+			name:   "yaml document start with csv",
+			start:  "---\na,b,c\n",
+			format: "yaml",
+		},
+		{
+			name:   "yaml key value",
+			start:  "name: Alice\nage: 30\n",
+			format: "yaml",
+		},
+		{
+			name:   "yaml nested block",
+			start:  "server:\n  host: localhost\n",
+			format: "yaml",
+		},
+		{
+			name:   "csv with header",
+			start:  "name,age\nAlice,30\n",
+			format: "csv-header",
+		},
+		{
+			name:   "csv with colons",
+			start:  "time: 10:00,place\n",
+			format: "csv",
+		},
+		{
+			name:   "short input",
+			start:  "a,b",
+			format: "csv-header",
+		},
+		{
+			name:   "tsv with header",
+			start:  "name\tage\nAlice\t30\n",
+			format: "tsv-header",
+		},
+		{
+			name:   "tsv",
+			start:  "Alice\t30\t\tx y\n",
+			format: "tsv",
+		},
+		{
+			name:   "csv with tabs",
+			start:  "a\tb,c\n",
+			format: "csv",
+		},
+		{
+			name:   "unknown",
+			start:  "hello world\n",
+			format: "",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			format := guessFormat([]byte(c.start))
+			if format != c.format {
+				t.Fatalf("Expected %q, got %q", c.format, format)
+			}
+		})
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	got, err := runJP(t, `{"tags":["a","b c","d"]}`, "-in", "json", "$.tags[*]", "-raw")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "a\nb c\nd\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRawStringsNonStrings(t *testing.T) {
+	got, err := runJP(t, `["x\ty", 1, {"a": "b"}, null]`, "-in", "json", "-indent", "-1", "-raw", "split")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "x\ty\n1\n{\"a\": \"b\"}\nnull\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRawOutput(t *testing.T) {
+	input := `["http://a/?q=1", "x\u00e9\ny", 1.50, true, null, {"a": [1, 2]}, ["b"]]`
+	got, err := runJP(t, input, "-in", "json", "-out", "raw", "-stable-float-repr", "split")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "http://a/?q=1\nxé\ny\n1.50\ntrue\nnull\n{\"a\":[1,2]}\n[\"b\"]\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestTemplateOutput(t *testing.T) {
+	input := `{"items": [{"name": "pen", "price": 1.50}, {"name": "ink", "price": 12}]}`
+	got, err := runJP(t, input, "-in", "json", "-out", "template", "-template", "{{.name}}: {{.price}}", "$.items[*]")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "pen: 1.50\nink: 12\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	if _, err := runJP(t, input, "-in", "json", "-out", "template"); err == nil {
+		t.Fatalf("Expected an error without -template")
+	}
+	if _, err := runJP(t, input, "-in", "json", "-out", "template", "-template", "{{.name"); err == nil {
+		t.Fatalf("Expected an error for an invalid template")
+	}
+}
+
+func TestElisionMarker(t *testing.T) {
+	got, err := runJP(t, `{"a": {"b": 1, "c": 2}, "d": [1, 2, 3]}`, "-in", "json", "-indent", "-1", "-elision-marker", "…%d more", "depth=1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\"a\": {…2 more},\"d\": […3 more]}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	got, err := runJP(t, `{"a": [1, 2, 3], "b": {"c": [1]}, "d": "abcdef"}`, "-in", "json", "-indent", "-1", "truncate(max-depth=2, max-array-items=2, max-string-length=4)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\"a\": [1, 2...],\"b\": {\"c\": [...]},\"d\": \"abcd…\"}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestMaxString(t *testing.T) {
+	got, err := runJP(t, `{"name": "a long name", "n": 12345678}`, "-in", "json", "-indent", "-1", "-max-string", "6")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\"name\": \"a long…(+5 bytes)\", \"n\": 12345678}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestJSONIndentZero(t *testing.T) {
+	got, err := runJP(t, `{"a": [1, 2]}`, "-in", "json", "-compactwidth", "0", "-json-indent", "0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\n\"a\": [\n1,\n2\n]\n}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestJSON5Input(t *testing.T) {
+	input := `// Configuration
+{
+	name: 'jp', /* the name */
+	"tags": ['a', "b",],
+}`
+	got, err := runJP(t, input, "-in", "json5", "-indent", "-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `{"name": "jp","tags": ["a", "b"]}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestNonFinite(t *testing.T) {
+	input := `{"a": NaN, "b": [Infinity, -Infinity, 1.5]}`
+	got, err := runJP(t, input, "-in", "json", "-indent", "-1", "-allow-nan", "-non-finite", "null")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `{"a": null,"b": [null, null, 1.5]}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	got, err = runJP(t, input, "-in", "json", "-indent", "-1", "-allow-nan", "-non-finite", "string")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected = `{"a": "NaN","b": ["Infinity", "-Infinity", 1.5]}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	if _, err := runJP(t, input, "-in", "json", "-allow-nan", "-non-finite", "error"); err == nil {
+		t.Fatalf("Expected an error")
+	}
+}
+
+func TestDuplicateKeys(t *testing.T) {
+	input := `{"a": 1, "b": 2, "a": 3}`
+	type testCase struct {
+		policy string
+		output string
+	}
+	var testCases = []testCase{
+		{policy: "keep-all", output: "1\n3\n"},
+		{policy: "first-wins", output: "1\n"},
+		{policy: "last-wins", output: "3\n"},
+	}
+	for _, c := range testCases {
+		t.Run(c.policy, func(t *testing.T) {
+			got, err := runJP(t, input, "-in", "json", "-dup-keys", c.policy, "$.a")
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+	// The input is cut short at the duplicate key, before "b".
+	got, _ := runJP(t, `{"a": 1, "a": 3, "b": 2}`, "-in", "json", "-dup-keys", "error", "$.b")
+	if got != "" {
+		t.Fatalf("Expected no output, got %q", got)
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	input := `{"b": {"y": 1, "x": 2.50}, "a": [{"d": 1, "c": "\u00e9"}]}`
+	type testCase struct {
+		name   string
+		args   []string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "sort keys",
+			args:   []string{"-sort-keys", "-out", "ndjson"},
+			output: "{\"a\":[{\"c\":\"\\u00e9\",\"d\":1}],\"b\":{\"x\":2.50,\"y\":1}}\n",
+		},
+		{
+			name:   "jcs",
+			args:   []string{"-out", "jcs"},
+			output: "{\"a\":[{\"c\":\"é\",\"d\":1}],\"b\":{\"x\":2.5,\"y\":1}}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := runJP(t, input, append([]string{"-in", "json"}, c.args...)...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+	if _, err := runJP(t, input, "-in", "json", "-sort-keys", "-max-object-size", "20"); err == nil {
+		t.Fatalf("Expected an error for an object larger than -max-object-size")
+	}
+}
+
+func TestHashOutput(t *testing.T) {
+	input := `{"b": [1.0, 2], "a": "x"} {"a": "x", "b": [1, 2e0]} {"a": "y"}`
+	for _, alg := range []string{"sha256", "sha512", "sha1", "md5", "xxhash"} {
+		t.Run(alg, func(t *testing.T) {
+			got, err := runJP(t, input, "-in", "json", "-out", "hash", "-hash-alg", alg)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("Expected 3 hashes, got %q", got)
+			}
+			if lines[0] != lines[1] || lines[0] == lines[2] {
+				t.Fatalf("Expected only the first two hashes to be equal, got %q", got)
+			}
+		})
+	}
+}
+
+func TestColorEnvironment(t *testing.T) {
+	type testCase struct {
+		name    string
+		env     []string
+		args    []string
+		colored bool
+	}
+	var testCases = []testCase{
+		{
+			name: "not a terminal",
+		},
+		{
+			name:    "CLICOLOR_FORCE",
+			env:     []string{"CLICOLOR_FORCE=1"},
+			colored: true,
+		},
+		{
+			name: "CLICOLOR_FORCE=0",
+			env:  []string{"CLICOLOR_FORCE=0"},
+		},
+		{
+			name: "NO_COLOR wins over CLICOLOR_FORCE",
+			env:  []string{"CLICOLOR_FORCE=1", "NO_COLOR=1"},
+		},
+		{
+			name:    "CLICOLOR_FORCE wins over CLICOLOR=0",
+			env:     []string{"CLICOLOR_FORCE=1", "CLICOLOR=0"},
+			colored: true,
+		},
+		{
+			name:    "-color always overrides NO_COLOR",
+			env:     []string{"NO_COLOR=1"},
+			args:    []string{"-color", "always"},
+			colored: true,
+		},
+		{
+			name: "-color never overrides CLICOLOR_FORCE",
+			env:  []string{"CLICOLOR_FORCE=1"},
+			args: []string{"-color", "never"},
+		},
+		{
+			name:    "-colors",
+			env:     []string{"NO_COLOR=1"},
+			args:    []string{"-colors"},
+			colored: true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			// Start from a neutral environment
+			env := append([]string{"NO_COLOR=", "CLICOLOR_FORCE=", "CLICOLOR="}, c.env...)
+			got, err := runJPWithEnv(t, env, `{"a": 1}`, c.args...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if colored := strings.Contains(got, "\x1b["); colored != c.colored {
+				t.Fatalf("Expected colored=%t, got %q", c.colored, got)
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	got, err := runJP(t, `{"config": {"b": 1, "a": 2}}`, "-in", "json", "-indent", "-1", "$.config", "keys")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "[\"b\", \"a\"]\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	got, err := runJP(t, `[{"id": 1}, {"id": 2, "x": null}]`, "-in", "json", "-indent", "-1", "split", "schema-sample=1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `{"$schema": "https://json-schema.org/draft/2020-12/schema","type": "object","properties": {"id": {"type": "integer","minimum": 1,"maximum": 2},"x": {"type": "null"}},"required": ["id"]}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestStats(t *testing.T) {
+	got, err := runJP(t, `{"a": [1, "x"], "b": {"a": true}} null`, "-in", "json", "-indent", "-1", "stats-keys=1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `{"values": 2,"objects": 2,"arrays": 1,"strings": 1,"numbers": 1,"booleans": 1,"nulls": 1,"max_depth": 2,"bytes": 34,"keys": {"a": 2}}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestPickOmit(t *testing.T) {
+	input := `{"name": "x", "password": "p", "a b": 1}`
+	got, err := runJP(t, input, "-in", "json", "-indent", "-1", `pick=name,"a b"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\"name\": \"x\", \"a b\": 1}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+	got, err = runJP(t, input, "-in", "json", "-indent", "-1", "omit=password")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected = "{\"name\": \"x\", \"a b\": 1}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestCast(t *testing.T) {
+	input := "age,active,ts\n\"42\",TRUE,1700000000\n"
+	got, err := runJP(t, input, "-in", "csvh", "-indent", "-1", "cast=$.age:int,$.active:bool,$.ts:string")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\"age\": 42,\"active\": true,\"ts\": \"1700000000\"}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestDates(t *testing.T) {
+	input := `{"created_at": 1700000000, "day": "2023-11-14 10:00"}`
+	got, err := runJP(t, input, "-in", "json", "-indent", "-1", `dates=$.created_at:unix→rfc3339,$.day:"2006-01-02 15:04"->unix`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "{\"created_at\": \"2023-11-14T22:13:20Z\", \"day\": 1699956000}\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestJSONPathOutputPaths(t *testing.T) {
+	got, err := runJP(t, `{"users": [{"name": "a"}, {"id": 1}, {"name": "b"}]}`, "-in", "json", "-jsonpath-output", "paths", "$..name")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "\"$['users'][0]['name']\"\n\"$['users'][2]['name']\"\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestJSONPathOrder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		args   []string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "strict by default",
+			args:   []string{"$[2, 0]"},
+			output: "3\n1\n",
+		},
+		{
+			name:   "lax query",
+			args:   []string{"lax:$[2, 0]"},
+			output: "1\n3\n",
+		},
+		{
+			name:   "lax flag",
+			args:   []string{"-jsonpath-lax", "$[2, 0]"},
+			output: "1\n3\n",
+		},
+		{
+			name:   "strict query with lax flag",
+			args:   []string{"-jsonpath-lax", "strict:$[2, 0]"},
+			output: "3\n1\n",
+		},
+		{
+			name:   "different stages",
+			input:  `[["a", "b"], ["c", "d"]]`,
+			args:   []string{"lax:$[1, 0]", "strict:$[1, 0]"},
+			output: "\"b\"\n\"a\"\n\"d\"\n\"c\"\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			input := c.input
+			if input == "" {
+				input = `[1, 2, 3]`
+			}
+			got, err := runJP(t, input, append([]string{"-in", "json"}, c.args...)...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	got, err := runJP(t, "", "-explain", "$[-2, 0]", "keys")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `transform #1 ('$[-2, 0]'):
+query $[-2, 0] (RFC 9535 order)
+  segment 1: child [-2, 0]: looks 2 items ahead in arrays to know the negative index of items; holds back items selected by later selectors until the earlier ones are done (RFC 9535 order)
+transform #2 ('keys'): not a jsonpath query
+`
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestPipelineFile(t *testing.T) {
+	type testCase struct {
+		name     string
+		pipeline string
+		args     []string
+		output   string
+	}
+	var testCases = []testCase{
+		{
+			name:     "lines",
+			pipeline: "# Select items\n\n  $.items  \r\n$[?@ > 1]\n",
+			output:   "2\n3\n",
+		},
+		{
+			name:     "json array",
+			pipeline: `["$.items", "$[?@ > 1]"]`,
+			output:   "2\n3\n",
+		},
+		{
+			name:     "with arguments",
+			pipeline: "$.items[*]",
+			args:     []string{"count"},
+			output:   "3\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			pipelineFile := filepath.Join(t.TempDir(), "pipeline")
+			if err := os.WriteFile(pipelineFile, []byte(c.pipeline), 0o644); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			args := append([]string{"-in", "json", "-pipeline", pipelineFile}, c.args...)
+			got, err := runJP(t, `{"items": [1, 2, 3]}`, args...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestTee(t *testing.T) {
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "items.json")
+	jpvFile := filepath.Join(dir, "input.jpv")
+	got, err := runJP(t, `{"items": [1, {"a": 2}]}`, "-in", "json", "-indent", "-1", "tee="+jpvFile, "$.items[*]", "tee="+jsonFile, "$.a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got != "2\n" {
+		t.Fatalf("Expected %q, got %q", "2\n", got)
+	}
+	for file, expected := range map[string]string{
+		jsonFile: "1\n{\"a\": 2}\n",
+		jpvFile:  "$.items[0] = 1\n$.items[1].a = 2\n\n",
+	} {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if string(contents) != expected {
+			t.Errorf("Expected %q in %s, got %q", expected, filepath.Base(file), contents)
+		}
+	}
+}
+
+func TestTeeWrapFiles(t *testing.T) {
+	dir := t.TempDir()
+	fileA := filepath.Join(dir, "a.json")
+	fileB := filepath.Join(dir, "b.json")
+	teeFile := filepath.Join(dir, "out.json")
+	for name, contents := range map[string]string{fileA: `[1] {"a": 2}`, fileB: `[3]`} {
+		if err := os.WriteFile(name, []byte(contents), 0o644); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if _, err := runJP(t, "", "-indent", "-1", "-files", "-wrap-files", "tee="+teeFile, "--", fileA, fileB); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	contents, err := os.ReadFile(teeFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "[1]\n{\"a\": 2}\n[3]\n"; string(contents) != expected {
+		t.Errorf("Expected %q, got %q", expected, contents)
+	}
+}
+
+func TestTeeDiff(t *testing.T) {
+	dir := t.TempDir()
+	diffFile := filepath.Join(dir, "b.json")
+	if err := os.WriteFile(diffFile, []byte(`{"a": 1}`), 0o644); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if _, err := runJP(t, `{"a": 1}`, "-in", "json", "-diff", diffFile, "tee="+filepath.Join(dir, "out.json")); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	traceFile := filepath.Join(t.TempDir(), "trace.log")
+	got, err := runJP(t, `[1, 2] 3`, "-in", "json", "-indent", "-1", "-trace-every", "2", "trace="+traceFile, "$[*]")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got != "1\n2\n" {
+		t.Fatalf("Expected the trace to pass the stream through, got %q", got)
+	}
+	contents, err := os.ReadFile(traceFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	expected := []string{"StartArray", "Scalar(2)", "Scalar(3)"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines in trace, got:\n%s", len(expected), contents)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, " "+expected[i]) {
+			t.Errorf("Expected line %d to end with %q, got %q", i, expected[i], line)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-in", "json", "-metrics", "$[*]", "count")
+	cmd.Env = append(os.Environ(), runMainEnvVar+"=1")
+	cmd.Stdin = strings.NewReader(`[1, "ab"] [2]`)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Unexpected error: %s, stderr: %s", err, stderr.String())
+	}
+	if stdout.String() != "3\n" {
+		t.Fatalf("Expected %q, got %q", "3\n", stdout.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")
+	expected := []string{"stage", "input", "#1 '$[*]'", "#2 'count'", "output"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got:\n%s", len(expected), stderr.String())
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, "  "+expected[i]) {
+			t.Errorf("Expected line %d to end with %q, got %q", i, expected[i], line)
+		}
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "3" || fields[1] != "6" {
+		t.Errorf("Expected 3 tokens and 6 bytes output by '$[*]', got %q", lines[2])
+	}
+}
+
+func TestParallel(t *testing.T) {
+	var input, expected strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "{\"id\": %d, \"tags\": [\"x\"]}\n", i)
+		fmt.Fprintf(&expected, "%d\n", i)
+	}
+	got, err := runJP(t, input.String(), "-in", "json", "-parallel", "4", "pick=id", "$.id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got != expected.String() {
+		t.Fatalf("Expected %q, got %q", expected.String(), got)
+	}
+	got, err = runJP(t, input.String(), "-in", "json", "-parallel", "4", "$.tags[0]", "count")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got != "100\n" {
+		t.Fatalf("Expected %q, got %q", "100\n", got)
+	}
+}
+
+func TestIsParallelizable(t *testing.T) {
+	for arg, expected := range map[string]bool{
+		"$.a[*]":              true,
+		".a":                  true,
+		"keys":                true,
+		"try(.a)":             true,
+		"dedup-window=3":      false,
+		"try(dedup-window=3)": false,
+		"sort":                false,
+		"count":               false,
+	} {
+		transformer, err := parseTransformer(arg)
+		if err != nil {
+			t.Fatalf("Invalid transform %q: %s", arg, err)
+		}
+		if got := isParallelizable(transformer); got != expected {
+			t.Errorf("%s: expected %t, got %t", arg, expected, got)
+		}
+	}
+}
+
+func TestInvalidInputExitStatus(t *testing.T) {
+	got, err := runJP(t, `[1] [1,,2]`, "-in", "json", "-indent", "-1")
+	if err == nil {
+		t.Fatal("Expected jp to fail")
+	}
+	if expected := "[1]\n[1...]\n"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
@@ -2,19 +2,58 @@ package jsonstream
 
 import "github.com/arnodel/jsonstream/token"
 
+// A Colorizer decides which ANSI color codes to print around scalars.
+//
+// If ColorNumbersBySign is true, then negative numbers are printed with
+// NegativeNumberColorCode and positive numbers with PositiveNumberColorCode.
+// Zero is printed with the same color as other numbers.
 type Colorizer struct {
 	KeyColorCode     []byte
 	ScalarColorCodes [4][]byte
 	ResetCode        []byte
+
+	ColorNumbersBySign      bool
+	NegativeNumberColorCode []byte
+	PositiveNumberColorCode []byte
 }
 
 func (c *Colorizer) ScalarColorCode(scalar *token.Scalar) []byte {
 	if scalar.IsKey() {
 		return c.KeyColorCode
 	}
+	if c.ColorNumbersBySign && scalar.Type() == token.Number {
+		switch numberSign(scalar.Bytes) {
+		case -1:
+			return c.NegativeNumberColorCode
+		case 1:
+			return c.PositiveNumberColorCode
+		}
+	}
 	return c.ScalarColorCodes[scalar.Type()]
 }
 
+// numberSign returns -1, 0 or 1 according to the sign of the JSON number
+// literal b.
+func numberSign(b []byte) int {
+	sign := 1
+	if len(b) > 0 && b[0] == '-' {
+		sign = -1
+		b = b[1:]
+	}
+	// The number is 0 iff all the digits before the exponent are 0.
+	for _, d := range b {
+		switch d {
+		case '0', '.':
+			continue
+		case 'e', 'E':
+			return 0
+		default:
+			return sign
+		}
+	}
+	return 0
+}
+
 func (c *Colorizer) PrintScalar(p Printer, scalar *token.Scalar) {
 	if c != nil {
 		p.PrintBytes(c.ScalarColorCode(scalar))
@@ -0,0 +1,65 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+var (
+	testRed   = "\033[31m"
+	testGreen = "\033[32m"
+	testWhite = "\033[37m"
+	testReset = "\033[0m"
+)
+
+var testColorizer = jsonstream.Colorizer{
+	ScalarColorCodes:        [4][]byte{nil, nil, []byte(testWhite), nil},
+	ResetCode:               []byte(testReset),
+	ColorNumbersBySign:      true,
+	NegativeNumberColorCode: []byte(testRed),
+	PositiveNumberColorCode: []byte(testGreen),
+}
+
+func TestColorNumbersBySign(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     string
+		colorizer *jsonstream.Colorizer
+		output    string
+	}
+	var testCases = []testCase{
+		{
+			name:      "negative",
+			input:     `-12.5`,
+			colorizer: &testColorizer,
+			output:    testRed + "-12.5" + testReset + "\n",
+		},
+		{
+			name:      "positive",
+			input:     `3e-2`,
+			colorizer: &testColorizer,
+			output:    testGreen + "3e-2" + testReset + "\n",
+		},
+		{
+			name:      "zero",
+			input:     `-0.0e5`,
+			colorizer: &testColorizer,
+			output:    testWhite + "-0.0e5" + testReset + "\n",
+		},
+		{
+			name:   "no colors",
+			input:  `-1`,
+			output: "-1\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: 2}
+			got := encodeJSONString(t, c.input, printer, &jsonstream.JSONEncoder{Colorizer: c.colorizer})
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
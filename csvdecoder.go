@@ -1,7 +1,9 @@
 package jsonstream
 
 import (
+	"bytes"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -10,40 +12,99 @@ import (
 	"github.com/arnodel/jsonstream/token"
 )
 
-// A CSVDecoder reads CSV input and streams it into a JSON stream.
+// A CSVDecoder reads CSV input and streams it into a JSON stream.  When records
+// produce objects, their keys are streamed as the same *token.Scalar instances
+// for all records, so there is no need for an option to intern keys.
+//
+// If TrimSpace is true, whitespace around fields which are not quoted is
+// removed before their type is inferred (so " 42 " is the number 42).  The
+// contents of quoted fields is left untouched, but there can be whitespace
+// before the opening quote.
+//
+// If Delimiter is not 0, it is used to separate fields instead of a comma
+// (e.g. '\t' for TSV input).  Note that with a whitespace delimiter, TrimSpace
+// should not be used as consecutive delimiters would be merged.
 type CSVDecoder struct {
-	reader                *csv.Reader
+	input                 io.Reader
 	HasHeader             bool // When true, treat the first record as a header
 	RecordsProduceObjects bool // When false, produce an array for each record, else an object
+	TrimSpace             bool
+	Delimiter             rune
 	fieldNames            []*token.Scalar
+
+	reader      *csv.Reader
+	recorder    *csvInputRecorder
+	recordCount int
+	queue       valueQueue
 }
 
-var _ token.StreamSource = &CSVDecoder{}
+var (
+	_ token.StreamSource = &CSVDecoder{}
+	_ token.TokenSource  = &CSVDecoder{}
+)
 
 // NewCSVDecoder sets up a new CSVDecoder isntance to read from the given input.
 func NewCSVDecoder(in io.Reader) *CSVDecoder {
-	return &CSVDecoder{reader: csv.NewReader(in)}
+	return &CSVDecoder{input: in}
 }
 
 // Produce reads a stream of CSV records, until it runs out of input or
 // encounters invalid CSV, in which case it will return an error
 func (d *CSVDecoder) Produce(out chan<- token.Token) error {
-	recordCount := 0
-	for {
-		record, err := d.reader.Read()
-		if err != nil {
-			if err == io.EOF {
-				return nil
-			}
-			return err
+	return produceValues(d, out)
+}
+
+// NextToken returns the next token in the stream, or io.EOF when there is no
+// more input.  The tokens of each record are buffered.
+func (d *CSVDecoder) NextToken() (token.Token, error) {
+	return d.queue.nextToken(d)
+}
+
+func (d *CSVDecoder) decodeValue(out token.WriteStream) (bool, error) {
+	if d.reader == nil {
+		d.initReader()
+	}
+	record, err := d.reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return false, nil
 		}
-		if recordCount > 0 || !d.HasHeader {
-			d.produceRecord(record, out)
-		} else {
-			// Try and get field names from the first record
-			d.SetFieldNames(record)
+		var csvErr *csv.ParseError
+		if errors.As(err, &csvErr) {
+			err = &token.ParseError{
+				Format: "csv",
+				Line:   csvErr.Line,
+				Col:    csvErr.Column,
+				Offset: d.reader.InputOffset(),
+				Msg:    csvErr.Err.Error(),
+				Err:    err,
+			}
 		}
-		recordCount++
+		return false, err
+	}
+	if d.recorder != nil {
+		trimUnquotedFields(d.reader, d.recorder, record)
+	}
+	if d.recordCount > 0 || !d.HasHeader {
+		d.produceRecord(record, out)
+	} else {
+		// Try and get field names from the first record
+		d.SetFieldNames(record)
+	}
+	d.recordCount++
+	return true, nil
+}
+
+func (d *CSVDecoder) initReader() {
+	if d.TrimSpace {
+		d.recorder = &csvInputRecorder{input: d.input, line: 1}
+		d.reader = csv.NewReader(d.recorder)
+		d.reader.TrimLeadingSpace = true
+	} else {
+		d.reader = csv.NewReader(d.input)
+	}
+	if d.Delimiter != 0 {
+		d.reader.Comma = d.Delimiter
 	}
 }
 
@@ -54,23 +115,85 @@ func (d *CSVDecoder) SetFieldNames(record []string) {
 	}
 }
 
-func (d *CSVDecoder) produceRecord(record []string, out chan<- token.Token) {
+func (d *CSVDecoder) produceRecord(record []string, out token.WriteStream) {
 	if d.RecordsProduceObjects {
-		out <- &token.StartObject{}
+		out.Put(&token.StartObject{})
 		for i, field := range record {
-			out <- d.getFieldName(i)
-			out <- fieldToScalar(field, false)
+			out.Put(d.getFieldName(i))
+			out.Put(fieldToScalar(field, false))
 		}
-		out <- &token.EndObject{}
+		out.Put(&token.EndObject{})
 	} else {
-		out <- &token.StartArray{}
+		out.Put(&token.StartArray{})
 		for _, field := range record {
-			out <- fieldToScalar(field, false)
+			out.Put(fieldToScalar(field, false))
 		}
-		out <- &token.EndArray{}
+		out.Put(&token.EndArray{})
 	}
 }
 
+// trimUnquotedFields removes trailing whitespace from the fields of record
+// which were not quoted in the input (leading whitespace was already removed
+// by reader).
+func trimUnquotedFields(reader *csv.Reader, recorder *csvInputRecorder, record []string) {
+	line, _ := reader.FieldPos(0)
+	recorder.discardBefore(line)
+	for i, field := range record {
+		if recorder.byteAt(reader.FieldPos(i)) != '"' {
+			record[i] = strings.TrimRight(field, " \t")
+		}
+	}
+}
+
+// csvInputRecorder records the input read by a csv.Reader, so that the raw
+// input at a position returned by csv.Reader.FieldPos can be inspected.
+type csvInputRecorder struct {
+	input io.Reader
+	data  []byte
+	line  int // Line number of data[0]
+}
+
+func (r *csvInputRecorder) Read(p []byte) (int, error) {
+	n, err := r.input.Read(p)
+	r.data = append(r.data, p[:n]...)
+	return n, err
+}
+
+// byteAt returns the byte of the input at the given line and column (both
+// starting from 1), or 0 if it is not available.
+func (r *csvInputRecorder) byteAt(line, col int) byte {
+	offset := r.lineOffset(line)
+	if offset < 0 || offset+col-1 >= len(r.data) {
+		return 0
+	}
+	return r.data[offset+col-1]
+}
+
+// discardBefore forgets the input before the given line.
+func (r *csvInputRecorder) discardBefore(line int) {
+	if offset := r.lineOffset(line); offset > 0 {
+		r.data = r.data[offset:]
+		r.line = line
+	}
+}
+
+// lineOffset returns the offset in r.data of the start of the given line, or -1
+// if it is not available.
+func (r *csvInputRecorder) lineOffset(line int) int {
+	if line < r.line {
+		return -1
+	}
+	offset := 0
+	for l := r.line; l < line; l++ {
+		i := bytes.IndexByte(r.data[offset:], '\n')
+		if i < 0 {
+			return -1
+		}
+		offset += i + 1
+	}
+	return offset
+}
+
 func (d *CSVDecoder) getFieldName(i int) *token.Scalar {
 	if i >= len(d.fieldNames) {
 		for j := len(d.fieldNames); j <= i; j++ {
@@ -95,7 +218,7 @@ func fieldToScalar(field string, isHeader bool) *token.Scalar {
 	var fieldCouldBeNumber = !isHeader
 	var escapeCount = 0
 	for i, b := range []byte(field) {
-		if b == '"' || b == '\n' || b == '\\' {
+		if b == '"' || b == '\n' || b == '\\' || b == '\t' || b == '\r' {
 			escapeCount++
 		} else if i == 0 {
 			fieldIsAlnum = isalpha(b)
@@ -148,6 +271,14 @@ func csvFieldToStringScalar(field string, escapeCount int) *token.Scalar {
 			tokenBytes[i] = '\\'
 			i++
 			b = 'n'
+		case '\t':
+			tokenBytes[i] = '\\'
+			i++
+			b = 't'
+		case '\r':
+			tokenBytes[i] = '\\'
+			i++
+			b = 'r'
 		}
 		tokenBytes[i] = b
 		i++
@@ -0,0 +1,116 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestCSVDecoderTrimSpace(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     string
+		trim      bool
+		hasHeader bool
+		output    string
+	}
+	var testCases = []testCase{
+		{
+			name:   "no trimming by default",
+			input:  "a, 42 ,b \n",
+			output: `["a"," 42 ","b "]`,
+		},
+		{
+			name:   "unquoted fields",
+			input:  " a, 42 ,  hi  , true ,\t\n",
+			trim:   true,
+			output: `["a",42,"hi",true,null]`,
+		},
+		{
+			name:   "quoted fields",
+			input:  `"  hi  ",  " 42 ", "a ""b"" "` + "\n",
+			trim:   true,
+			output: `["  hi  "," 42 ","a \"b\" "]`,
+		},
+		{
+			name:   "multiline quoted field",
+			input:  "x ,\"a\n b \"\n\"c \", d \n",
+			trim:   true,
+			output: "[\"x\",\"a\\n b \"]\n[\"c \",\"d\"]",
+		},
+		{
+			name:      "header",
+			input:     " name , age \n Kim , 33 \n",
+			trim:      true,
+			hasHeader: true,
+			output:    `{"name": "Kim","age": 33}`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewCSVDecoder(strings.NewReader(c.input))
+			decoder.TrimSpace = c.trim
+			decoder.HasHeader = c.hasHeader
+			decoder.RecordsProduceObjects = c.hasHeader
+			got, err := decodeToJSONString(t, decoder)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
+
+func TestCSVDecoderDelimiter(t *testing.T) {
+	type testCase struct {
+		name      string
+		input     string
+		delimiter rune
+		hasHeader bool
+		output    string
+	}
+	var testCases = []testCase{
+		{
+			name:   "default is comma",
+			input:  "a,b\tc\n",
+			output: `["a","b\tc"]`,
+		},
+		{
+			name:      "tab",
+			input:     "a\t\"b\tc\"\t, d\t3\n",
+			delimiter: '\t',
+			output:    `["a","b\tc",", d",3]`,
+		},
+		{
+			name:      "tab with header",
+			input:     "name\tage\nKim\t33\n\t\n",
+			delimiter: '\t',
+			hasHeader: true,
+			output:    "{\"name\": \"Kim\",\"age\": 33}\n{\"name\": null,\"age\": null}",
+		},
+		{
+			name:      "semicolon",
+			input:     "1;2,5;\"x;y\"\n",
+			delimiter: ';',
+			output:    `[1,"2,5","x;y"]`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewCSVDecoder(strings.NewReader(c.input))
+			decoder.Delimiter = c.delimiter
+			decoder.HasHeader = c.hasHeader
+			decoder.RecordsProduceObjects = c.hasHeader
+			got, err := decodeToJSONString(t, decoder)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
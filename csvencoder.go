@@ -0,0 +1,131 @@
+package jsonstream
+
+import (
+	"encoding/csv"
+	"fmt"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A CSVEncoder outputs a stream of JSON values as CSV records, using the given
+// Printer to send output (note that the Printer's indentation is not used).
+//
+// Each top-level value is output as one record:
+//   - an array is output as a record made of its items;
+//   - an object is output as a record made of its values.  The keys of the
+//     first object in the stream are used to output a header record and
+//     subsequent objects are output with their values in the order of the
+//     header (values for other keys are dropped, missing values are empty);
+//   - a scalar is output as a record with a single field.
+//
+// Strings are output without quotes, null as an empty field and nested arrays
+// and objects as compact JSON.  Records are terminated with "\n", or "\r\n" if
+// UseCRLF is true.
+type CSVEncoder struct {
+	Printer
+	UseCRLF bool
+
+	writer     *csv.Writer
+	header     []*token.Scalar
+	headerDone bool
+}
+
+var _ token.StreamSink = &CSVEncoder{}
+
+// Consume outputs the JSON stream encoded in the given channel as CSV.  It
+// assumes that the stream is well-formed, i.e. is a valid encoding for a stream
+// of JSON values and may panic if that is not the case.
+//
+// And error can be returned if the Printer could not perform some writing
+// operation.  A typical example is if it attempt to write to a closed pipe.
+func (e *CSVEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	e.writer = csv.NewWriter(printerWriter{e.Printer})
+	e.writer.UseCRLF = e.UseCRLF
+	reader := token.NewStreamErrorReader(stream)
+	iterator := iterator.New(reader)
+	for iterator.Advance() {
+		e.writeRecord(iterator.CurrentValue())
+	}
+	return reader.Err()
+}
+
+func (e *CSVEncoder) writeRecord(value iterator.Value) {
+	var record []string
+	switch v := value.(type) {
+	case *iterator.Object:
+		record = e.objectRecord(v)
+	case *iterator.Array:
+		for v.Advance() {
+			record = append(record, csvField(v.CurrentValue()))
+		}
+	default:
+		record = []string{csvField(value)}
+	}
+	e.write(record)
+}
+
+func (e *CSVEncoder) objectRecord(obj *iterator.Object) []string {
+	if !e.headerDone {
+		var record []string
+		for obj.Advance() {
+			key, value := obj.CurrentKeyVal()
+			e.header = append(e.header, key)
+			record = append(record, csvField(value))
+		}
+		headerRecord := make([]string, len(e.header))
+		for i, key := range e.header {
+			headerRecord[i] = key.ToString()
+		}
+		e.write(headerRecord)
+		e.headerDone = true
+		return record
+	}
+	record := make([]string, len(e.header))
+	for obj.Advance() {
+		key, value := obj.CurrentKeyVal()
+		for i, headerKey := range e.header {
+			if key.Equal(headerKey) {
+				record[i] = csvField(value)
+				break
+			}
+		}
+	}
+	return record
+}
+
+func (e *CSVEncoder) write(record []string) {
+	// Errors from the Printer are raised as panics so the only possible error
+	// here is invalid configuration of the csv writer.
+	if err := e.writer.Write(record); err != nil {
+		panic(fmt.Sprintf("csv encoder: %s", err))
+	}
+	e.writer.Flush()
+}
+
+// csvField returns the text of a CSV field representing the value.
+func csvField(value iterator.Value) string {
+	scalar, ok := value.AsScalar()
+	if !ok {
+		return string(valueText(value, 0))
+	}
+	switch scalar.Type() {
+	case token.Null:
+		return ""
+	case token.String:
+		return scalar.ToString()
+	default:
+		return string(scalar.Bytes)
+	}
+}
+
+// printerWriter adapts a Printer to the io.Writer interface.
+type printerWriter struct {
+	Printer
+}
+
+func (w printerWriter) Write(p []byte) (int, error) {
+	w.PrintBytes(p)
+	return len(p), nil
+}
@@ -0,0 +1,175 @@
+package jsonstream
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// Date formats understood by DateConverter.  Any other format is a layout for
+// time.Parse and time.Format.
+const (
+	DateUnix       = "unix"    // Number of seconds since the epoch
+	DateUnixMillis = "unixms"  // Number of milliseconds since the epoch
+	DateRFC3339    = "rfc3339" // String such as "2006-01-02T15:04:05Z07:00"
+)
+
+// DateConverter is a ValueTransformer which rewrites the timestamps selected
+// by the JSONPath queries of Fields from the From format of the field to its
+// To format.  E.g. with the field $.created_at from unix to rfc3339
+//
+//	{"created_at": 1700000000} -> {"created_at": "2023-11-14T22:13:20Z"}
+//
+// Epoch timestamps may be numbers or strings containing numbers, and may have
+// a fractional part.  When From is empty, the format is guessed: numbers are
+// epoch seconds and strings are RFC 3339 timestamps or epoch seconds.
+// Timestamps are output in UTC, epoch seconds with a fractional part if they
+// are not whole.
+//
+// Null is left unchanged.  A value which cannot be parsed is replaced with
+// null, unless Strict is true in which case the transform fails with a
+// *TransformError once the value has been output.
+//
+// Each value needs to be read twice (once to find the selected nodes and once
+// to output it), so it is buffered in memory.
+type DateConverter struct {
+	Fields []DateField
+	Strict bool
+}
+
+// A DateField is a JSONPath query selecting timestamps to convert.
+type DateField struct {
+	Path     *jsonpathtransformer.MainQueryRunner
+	From, To string
+}
+
+// TransformValue implements the DateConverter transform.
+func (f *DateConverter) TransformValue(value iterator.Value, out token.WriteStream) {
+	tree := &pathTree{}
+	for _, field := range f.Fields {
+		field := field
+		tree.addPaths(field.Path, value, func(node *pathTree) {
+			node.selected = true
+			node.convert = field.convert
+		})
+	}
+	var err error
+	tree.convertSelected(value, out, &err)
+	if err != nil && f.Strict {
+		panic(token.TransformErrorf("dates: %s", err))
+	}
+}
+
+// convert consumes value and returns it converted to the To format, or null
+// and an error if it cannot be parsed.
+func (f DateField) convert(value iterator.Value) (*token.Scalar, error) {
+	scalar, ok := value.AsScalar()
+	if !ok {
+		kind := valueKind(value)
+		value.Discard()
+		return nullInstance, fmt.Errorf("expected a timestamp, got %s", kind)
+	}
+	if scalar.Type() == token.Null {
+		return scalar, nil
+	}
+	t, ok := parseDate(scalar, f.From)
+	if !ok {
+		from := f.From
+		if from == "" {
+			from = "a timestamp"
+		}
+		return nullInstance, fmt.Errorf("cannot parse %s as %s", scalar.Bytes, from)
+	}
+	return formatDate(t.UTC(), f.To), nil
+}
+
+func parseDate(scalar *token.Scalar, from string) (time.Time, bool) {
+	var s string
+	switch scalar.Type() {
+	case token.Number:
+		s = string(scalar.Bytes)
+		if from == "" {
+			from = DateUnix
+		}
+	case token.String:
+		s = strings.TrimSpace(scalar.ToString())
+		if from == "" {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t, true
+			}
+			from = DateUnix
+		}
+	default:
+		return time.Time{}, false
+	}
+	switch from {
+	case DateUnix:
+		return parseEpoch(s, 1)
+	case DateUnixMillis:
+		return parseEpoch(s, 1000)
+	case DateRFC3339:
+		from = time.RFC3339
+	}
+	if scalar.Type() != token.String {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(from, s, time.UTC)
+	return t, err == nil
+}
+
+// parseEpoch parses s as a number of units since the epoch, where there are
+// unitsPerSecond units in a second.
+func parseEpoch(s string, unitsPerSecond int64) (time.Time, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n/unitsPerSecond, n%unitsPerSecond*(1e9/unitsPerSecond)), true
+	}
+	x, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	sec := math.Floor(x / float64(unitsPerSecond))
+	nsec := math.Round((x/float64(unitsPerSecond) - sec) * 1e9)
+	if math.IsNaN(sec) || sec < math.MinInt64 || sec >= math.MaxInt64 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(sec), int64(nsec)), true
+}
+
+func formatDate(t time.Time, to string) *token.Scalar {
+	switch to {
+	case DateUnix:
+		return token.NewScalar(token.Number, appendEpochSeconds(nil, t))
+	case DateUnixMillis:
+		return token.NewScalar(token.Number, strconv.AppendInt(nil, t.UnixMilli(), 10))
+	case DateRFC3339:
+		return stringScalar(t.Format(time.RFC3339Nano))
+	default:
+		return stringScalar(t.Format(to))
+	}
+}
+
+// appendEpochSeconds appends to b the number of seconds since the epoch at t,
+// with as many decimals as needed.
+func appendEpochSeconds(b []byte, t time.Time) []byte {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	if sec < 0 {
+		b = append(b, '-')
+		sec = -sec
+		if nsec > 0 {
+			sec--
+			nsec = 1e9 - nsec
+		}
+	}
+	b = strconv.AppendInt(b, sec, 10)
+	if nsec == 0 {
+		return b
+	}
+	frac := strconv.AppendInt(nil, 1e9+nsec, 10)[1:]
+	return append(append(b, '.'), strings.TrimRight(string(frac), "0")...)
+}
@@ -0,0 +1,85 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestDateConverter(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		from, to string
+		output   string
+	}
+	var testCases = []testCase{
+		{
+			name:   "unix to rfc3339",
+			input:  `[1700000000, "1700000000", 1700000000.25, -1.5, null]`,
+			from:   jsonstream.DateUnix,
+			to:     jsonstream.DateRFC3339,
+			output: "[\"2023-11-14T22:13:20Z\",\"2023-11-14T22:13:20Z\",\"2023-11-14T22:13:20.25Z\",\"1969-12-31T23:59:58.5Z\",null]\n",
+		},
+		{
+			name:   "rfc3339 to unix",
+			input:  `["2023-11-14T23:13:20.5+01:00", "1970-01-01T00:00:00Z", "1969-12-31T23:59:58.5Z"]`,
+			from:   jsonstream.DateRFC3339,
+			to:     jsonstream.DateUnix,
+			output: "[1700000000.5,0,-1.5]\n",
+		},
+		{
+			name:   "unixms to layout",
+			input:  `[1700000000123]`,
+			from:   jsonstream.DateUnixMillis,
+			to:     "2006-01-02 15:04:05.000",
+			output: "[\"2023-11-14 22:13:20.123\"]\n",
+		},
+		{
+			name:   "layout to unixms",
+			input:  `["14/11/2023"]`,
+			from:   "02/01/2006",
+			to:     jsonstream.DateUnixMillis,
+			output: "[1699920000000]\n",
+		},
+		{
+			name:   "guessed format",
+			input:  `[1700000000, "2023-11-14T22:13:20Z", "1700000000"]`,
+			to:     jsonstream.DateUnixMillis,
+			output: "[1700000000000,1700000000000,1700000000000]\n",
+		},
+		{
+			name:   "invalid timestamps",
+			input:  `["yesterday", true, [1]]`,
+			to:     jsonstream.DateUnix,
+			output: "[null,null,null]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			converter := &jsonstream.DateConverter{Fields: []jsonstream.DateField{{Path: mustCompileQuery(t, `$[*]`), From: c.from, To: c.to}}}
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(converter))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestDateConverterStrict(t *testing.T) {
+	var err error
+	converter := &jsonstream.DateConverter{
+		Fields: []jsonstream.DateField{{Path: mustCompileQuery(t, `$.ts`), From: jsonstream.DateRFC3339, To: jsonstream.DateUnix}},
+		Strict: true,
+	}
+	stream := token.TransformStreamWithErrorHandler(streamJSONString(`{"ts": "now"}`), iterator.AsStreamTransformer(converter), func(e error) { err = e })
+	got := encodeJSONStream(t, stream)
+	if got != "{\"ts\": null}\n" {
+		t.Fatalf("Unexpected output: %q", got)
+	}
+	if err == nil || err.Error() != `dates: cannot parse "now" as rfc3339` {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
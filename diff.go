@@ -0,0 +1,204 @@
+package jsonstream
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// DiffStream compares two streams of JSON values and returns a stream of JSON
+// Patch documents (RFC 6902), one for each pair of values at the same position
+// in the streams.  Each document is an array of operations which transform the
+// value in from into the value in to (see Diff).
+//
+// If from has more values than to, the extra values give the document
+//
+//	[{"op": "remove", "path": ""}]
+//
+// and if to has more values than from, the extra values v give the document
+//
+//	[{"op": "add", "path": "", "value": v}]
+func DiffStream(from, to <-chan token.Token) <-chan token.Token {
+	out := make(chan token.Token)
+	go func() {
+		defer close(out)
+		w := token.ChannelWriteStream(out)
+		fromIter := iterator.New(token.ChannelReadStream(from))
+		toIter := iterator.New(token.ChannelReadStream(to))
+		for {
+			hasFrom, hasTo := fromIter.Advance(), toIter.Advance()
+			switch {
+			case hasFrom && hasTo:
+				Diff(fromIter.CurrentValue(), toIter.CurrentValue(), w)
+			case hasFrom:
+				w.Put(&token.StartArray{})
+				putPatchOp(w, "remove", "", nil)
+				w.Put(&token.EndArray{})
+			case hasTo:
+				w.Put(&token.StartArray{})
+				putPatchOp(w, "add", "", toIter.CurrentValue())
+				w.Put(&token.EndArray{})
+			default:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Diff consumes the values from and to and outputs a JSON Patch document (RFC
+// 6902) which transforms from into to.
+//
+// The comparison is structural and streamed as far as possible.  Arrays are
+// compared position by position, so inserting an item at the start of an
+// array gives a "replace" operation for each item after it.  Object members
+// are compared while both objects have the same keys in the same order.  When
+// the keys differ, the rest of both objects is buffered in memory in order to
+// match members by key.
+func Diff(from, to iterator.Value, out token.WriteStream) {
+	out.Put(&token.StartArray{})
+	diffValues(out, "", from, to)
+	out.Put(&token.EndArray{})
+}
+
+func diffValues(out token.WriteStream, path string, from, to iterator.Value) {
+	switch f := from.(type) {
+	case *iterator.Object:
+		if t, ok := to.(*iterator.Object); ok {
+			diffObjects(out, path, f, t)
+			return
+		}
+	case *iterator.Array:
+		if t, ok := to.(*iterator.Array); ok {
+			diffArrays(out, path, f, t)
+			return
+		}
+	case *iterator.Scalar:
+		if t, ok := to.(*iterator.Scalar); ok && f.Scalar().Equal(t.Scalar()) {
+			return
+		}
+	}
+	from.Discard()
+	putPatchOp(out, "replace", path, to)
+}
+
+func diffArrays(out token.WriteStream, path string, from, to *iterator.Array) {
+	// Extra items in from are all removed at the same index, as each removal
+	// shifts the items which follow.
+	removeIndex := -1
+	for i := 0; ; i++ {
+		hasFrom, hasTo := from.Advance(), to.Advance()
+		switch {
+		case hasFrom && hasTo:
+			diffValues(out, path+"/"+strconv.Itoa(i), from.CurrentValue(), to.CurrentValue())
+		case hasFrom:
+			if removeIndex < 0 {
+				removeIndex = i
+			}
+			putPatchOp(out, "remove", path+"/"+strconv.Itoa(removeIndex), nil)
+		case hasTo:
+			putPatchOp(out, "add", path+"/"+strconv.Itoa(i), to.CurrentValue())
+		default:
+			return
+		}
+	}
+}
+
+func diffObjects(out token.WriteStream, path string, from, to *iterator.Object) {
+	for {
+		hasFrom, hasTo := from.Advance(), to.Advance()
+		switch {
+		case hasFrom && hasTo:
+			fromKey, fromValue := from.CurrentKeyVal()
+			toKey, toValue := to.CurrentKeyVal()
+			if fromKey.Equal(toKey) {
+				diffValues(out, path+"/"+escapePointerToken(fromKey.ToString()), fromValue, toValue)
+				continue
+			}
+			diffObjectMembers(out, path, bufferObjectMembers(from), bufferObjectMembers(to))
+			return
+		case hasFrom:
+			fromKey, _ := from.CurrentKeyVal()
+			putPatchOp(out, "remove", path+"/"+escapePointerToken(fromKey.ToString()), nil)
+		case hasTo:
+			toKey, toValue := to.CurrentKeyVal()
+			putPatchOp(out, "add", path+"/"+escapePointerToken(toKey.ToString()), toValue)
+		default:
+			return
+		}
+	}
+}
+
+// diffObjectMembers outputs the operations which transform the from members
+// into the to members, matching them by key.
+func diffObjectMembers(out token.WriteStream, path string, from, to []bufferedMember) {
+	fromIndex := make(map[string]int, len(from))
+	for i, m := range from {
+		fromIndex[m.key] = i
+	}
+	toKeys := make(map[string]bool, len(to))
+	for _, m := range to {
+		toKeys[m.key] = true
+	}
+	for _, m := range from {
+		if !toKeys[m.key] {
+			putPatchOp(out, "remove", path+"/"+escapePointerToken(m.key), nil)
+		}
+	}
+	for _, m := range to {
+		memberPath := path + "/" + escapePointerToken(m.key)
+		if i, ok := fromIndex[m.key]; ok {
+			diffValues(out, memberPath, tokensValue(from[i].toks), tokensValue(m.toks))
+		} else {
+			putPatchOp(out, "add", memberPath, tokensValue(m.toks))
+		}
+	}
+}
+
+type bufferedMember struct {
+	key  string
+	toks []token.Token
+}
+
+// bufferObjectMembers returns the current member of obj and the ones after it,
+// consuming obj.
+func bufferObjectMembers(obj *iterator.Object) []bufferedMember {
+	var members []bufferedMember
+	for {
+		key, value := obj.CurrentKeyVal()
+		members = append(members, bufferedMember{key: key.ToString(), toks: copyValueTokens(value)})
+		if !obj.Advance() {
+			return members
+		}
+	}
+}
+
+// tokensValue returns the value made of the given tokens.
+func tokensValue(toks []token.Token) iterator.Value {
+	iter := iterator.New(token.NewSliceReadStream(toks))
+	iter.Advance()
+	return iter.CurrentValue()
+}
+
+// putPatchOp outputs a JSON Patch operation, consuming value if it is not nil.
+func putPatchOp(out token.WriteStream, op string, path string, value iterator.Value) {
+	out.Put(&token.StartObject{})
+	out.Put(keyScalar("op"))
+	out.Put(stringScalar(op))
+	out.Put(keyScalar("path"))
+	out.Put(stringScalar(path))
+	if value != nil {
+		out.Put(keyScalar("value"))
+		value.Copy(out)
+	}
+	out.Put(&token.EndObject{})
+}
+
+var pointerTokenEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// escapePointerToken escapes a reference token of a JSON Pointer (RFC 6901).
+func escapePointerToken(s string) string {
+	return pointerTokenEscaper.Replace(s)
+}
@@ -0,0 +1,74 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestDiffStream(t *testing.T) {
+	type testCase struct {
+		name   string
+		from   string
+		to     string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "equal values",
+			from:   `{"a": [1, "x", null, true]} 1.0`,
+			to:     `{"a": [1, "x", null, true]} 1`,
+			output: "[]\n[]\n",
+		},
+		{
+			name:   "replace scalars and types",
+			from:   `{"a": 1, "b": [1], "c": "x"}`,
+			to:     `{"a": 2, "b": {}, "c": "x"}`,
+			output: "[{\"op\": \"replace\",\"path\": \"/a\",\"value\": 2},{\"op\": \"replace\",\"path\": \"/b\",\"value\": {}}]\n",
+		},
+		{
+			name:   "array items",
+			from:   `[1, 2, 3, 4] [1]`,
+			to:     `[1, 5] [1, 2, 3]`,
+			output: "[{\"op\": \"replace\",\"path\": \"/1\",\"value\": 5},{\"op\": \"remove\",\"path\": \"/2\"},{\"op\": \"remove\",\"path\": \"/2\"}]\n[{\"op\": \"add\",\"path\": \"/1\",\"value\": 2},{\"op\": \"add\",\"path\": \"/2\",\"value\": 3}]\n",
+		},
+		{
+			name:   "same key order",
+			from:   `{"a": {"x": 1}, "b": 2}`,
+			to:     `{"a": {"x": 2}, "b": 2, "c": 3}`,
+			output: "[{\"op\": \"replace\",\"path\": \"/a/x\",\"value\": 2},{\"op\": \"add\",\"path\": \"/c\",\"value\": 3}]\n",
+		},
+		{
+			name:   "different key order",
+			from:   `{"a": 1, "b": 2, "c": [1], "d": 4}`,
+			to:     `{"a": 1, "c": [2], "e": 5, "b": 2}`,
+			output: "[{\"op\": \"remove\",\"path\": \"/d\"},{\"op\": \"replace\",\"path\": \"/c/0\",\"value\": 2},{\"op\": \"add\",\"path\": \"/e\",\"value\": 5}]\n",
+		},
+		{
+			name:   "escaped keys",
+			from:   `{"a/b": 1, "c~d": 2}`,
+			to:     `{"a/b": 3}`,
+			output: "[{\"op\": \"replace\",\"path\": \"/a~1b\",\"value\": 3},{\"op\": \"remove\",\"path\": \"/c~0d\"}]\n",
+		},
+		{
+			name:   "extra values",
+			from:   `1 2`,
+			to:     `1 2 [3]`,
+			output: "[]\n[]\n[{\"op\": \"add\",\"path\": \"\",\"value\": [3]}]\n",
+		},
+		{
+			name:   "missing values",
+			from:   `1 2`,
+			to:     `3`,
+			output: "[{\"op\": \"replace\",\"path\": \"\",\"value\": 3}]\n[{\"op\": \"remove\",\"path\": \"\"}]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := encodeJSONStream(t, jsonstream.DiffStream(streamJSONString(c.from), streamJSONString(c.to)))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
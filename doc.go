@@ -20,8 +20,9 @@ package jsonstream
 //   the next program can be fed input very early, before the whole file is processed.
 //
 // This package has been made with the goal writing a CLI utility that processes JSON input,
-// so there is no facility for marshaling or unmarshaling as in the standard library
-// [encoding/json] package.
+// so there is no facility for marshaling as in the standard library [encoding/json] package.
+// Values can be unmarshaled into Go values with [iterator.Decode], which makes it possible
+// to stream through a huge array and only decode the items which are needed.
 //
 // The CLI utility is in the directory cmd/pj.  You can install it with
 //
@@ -3,11 +3,10 @@ module github.com/arnodel/jsonstream
 go 1.20
 
 require (
+	github.com/arnodel/grammar v0.0.0-20211030100909-fff725e3d446
+	github.com/klauspost/compress v1.17.9
 	github.com/mattn/go-colorable v0.1.13
 	github.com/mattn/go-isatty v0.0.20
 )
 
-require (
-	github.com/arnodel/grammar v0.0.0-20211030100909-fff725e3d446 // indirect
-	golang.org/x/sys v0.14.0 // indirect
-)
+require golang.org/x/sys v0.14.0 // indirect
@@ -0,0 +1,61 @@
+package jsonstream
+
+import (
+	"encoding/hex"
+	"hash"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A HashEncoder outputs the hash of each value of a stream as a hexadecimal
+// string, followed by a new line.  The hash is computed over the canonical
+// form of the value output by a JCSEncoder (without the trailing new line), so
+// two values have the same hash if and only if they are equal (barring
+// collisions), whatever the order of their keys or the formatting of their
+// numbers and strings.
+//
+// The canonical form is fed to the hash as it is produced, so top-level arrays
+// are hashed with constant memory.  However objects are held in memory until
+// they end (see JCSEncoder).
+type HashEncoder struct {
+	Printer
+	Hash          hash.Hash // Reset before each value
+	MaxObjectSize int       // DefaultMaxObjectSize if 0 or less
+}
+
+var _ token.StreamSink = &HashEncoder{}
+
+// Consume outputs the hashes of the values in the given channel.  It returns
+// an error if the Printer could not write or a value could not be
+// canonicalized.
+func (e *HashEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	jcs := &JCSEncoder{Printer: hashPrinter{e.Hash}, MaxObjectSize: e.MaxObjectSize}
+	reader := token.NewStreamErrorReader(stream)
+	iter := iterator.New(reader)
+	var sum []byte
+	for iter.Advance() {
+		e.Hash.Reset()
+		jcs.writeValue(iter.CurrentValue())
+		sum = e.Hash.Sum(sum[:0])
+		e.PrintBytes([]byte(hex.EncodeToString(sum)))
+		e.Printer.Reset()
+	}
+	return reader.Err()
+}
+
+// hashPrinter is a Printer that writes its output to a hash.
+type hashPrinter struct {
+	hash.Hash
+}
+
+func (p hashPrinter) Indent()  {}
+func (p hashPrinter) Dedent()  {}
+func (p hashPrinter) NewLine() {}
+func (p hashPrinter) Reset()   {}
+
+func (p hashPrinter) PrintBytes(b []byte) {
+	// Hashes never return an error
+	p.Write(b)
+}
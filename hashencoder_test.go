@@ -0,0 +1,61 @@
+package jsonstream_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestHashEncoder(t *testing.T) {
+	sha := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:]) + "\n"
+	}
+	type testCase struct {
+		name   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "canonical form is hashed",
+			input:  `{"b": [1.0, "é"], "a": null}`,
+			output: sha(`{"a":null,"b":[1,"é"]}`),
+		},
+		{
+			name:   "one hash per value",
+			input:  `[1, {"y": 2, "x": 1}] "s" 1e2`,
+			output: sha(`[1,{"x":1,"y":2}]`) + sha(`"s"`) + sha(`100`),
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			encoder := &jsonstream.HashEncoder{
+				Printer: &jsonstream.DefaultPrinter{Writer: &b},
+				Hash:    sha256.New(),
+			}
+			if err := token.ConsumeStream(streamJSONString(c.input), encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := b.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestHashEncoderError(t *testing.T) {
+	encoder := &jsonstream.HashEncoder{
+		Printer: &jsonstream.DefaultPrinter{Writer: &strings.Builder{}},
+		Hash:    sha256.New(),
+	}
+	err := token.ConsumeStream(streamJSONString(`[1, 1e400]`), encoder)
+	if err == nil || !strings.Contains(err.Error(), "out of float64 range") {
+		t.Fatalf("Expected error, got %v", err)
+	}
+}
@@ -0,0 +1,449 @@
+package jsonstream
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/arnodel/jsonstream/internal/scanner"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A HJSONDecoder reads input in the HJSON format and streams it into a JSON
+// stream.
+//
+// HJSON (https://hjson.github.io/) is a syntax extension to JSON designed for
+// configuration files.  On top of JSON, it supports:
+//   - comments starting with "#" or "//" until the end of the line, and
+//     "/* ... */" comments;
+//   - optional commas between items (a new line is enough);
+//   - keys without quotes;
+//   - strings without quotes, which extend to the end of the line (so they
+//     cannot be followed by a comment or a comma);
+//   - single quoted strings, and multiline strings enclosed in triple single
+//     quotes;
+//   - omitting the braces of the root object.
+//
+// An HJSON document contains a single value, and it is read in memory before
+// being decoded.
+type HJSONDecoder struct {
+	reader io.Reader
+	data   []byte
+	pos    int
+	done   bool
+}
+
+var _ token.StreamSource = &HJSONDecoder{}
+
+// NewHJSONDecoder sets up a new HJSONDecoder instance to read from the given
+// input.
+func NewHJSONDecoder(in io.Reader) *HJSONDecoder {
+	return &HJSONDecoder{reader: in}
+}
+
+// Produce reads an HJSON document and streams its value.  It returns an error
+// if the input is not valid HJSON.
+func (d *HJSONDecoder) Produce(out chan<- token.Token) error {
+	return produceValues(d, out)
+}
+
+func (d *HJSONDecoder) decodeValue(out token.WriteStream) (bool, error) {
+	if d.done {
+		return false, nil
+	}
+	d.done = true
+	return true, d.decodeDocument(out)
+}
+
+func (d *HJSONDecoder) decodeDocument(out token.WriteStream) error {
+	data, err := io.ReadAll(d.reader)
+	if err != nil {
+		return err
+	}
+	d.data = data
+	d.pos = 0
+	if err := d.skipSpace(); err != nil {
+		return err
+	}
+	if d.pos == len(d.data) {
+		return nil
+	}
+	if d.isRootObjectWithoutBraces() {
+		out.Put(&token.StartObject{})
+		if err := d.parseMembers(out, false); err != nil {
+			return err
+		}
+		out.Put(&token.EndObject{})
+	} else if err := d.parseValue(out); err != nil {
+		return err
+	}
+	if err := d.skipSpace(); err != nil {
+		return err
+	}
+	if d.pos < len(d.data) {
+		return d.syntaxError("unexpected %q after root value", d.data[d.pos])
+	}
+	return nil
+}
+
+// isRootObjectWithoutBraces returns true if the document starts with a key
+// followed by a colon.
+func (d *HJSONDecoder) isRootObjectWithoutBraces() bool {
+	if c := d.data[d.pos]; c == '{' || c == '[' {
+		return false
+	}
+	start := d.pos
+	defer func() { d.pos = start }()
+	if _, err := d.parseKey(); err != nil {
+		return false
+	}
+	if err := d.skipSpace(); err != nil {
+		return false
+	}
+	return d.pos < len(d.data) && d.data[d.pos] == ':'
+}
+
+// skipSpace skips whitespace and comments.
+func (d *HJSONDecoder) skipSpace() error {
+	for d.pos < len(d.data) {
+		switch c := d.data[d.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			d.pos++
+		case c == '#' || c == '/' && d.peekByte(1) == '/':
+			d.skipLine()
+		case c == '/' && d.peekByte(1) == '*':
+			end := bytes.Index(d.data[d.pos+2:], []byte("*/"))
+			if end < 0 {
+				return d.syntaxError("unterminated comment")
+			}
+			d.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (d *HJSONDecoder) peekByte(offset int) byte {
+	if d.pos+offset < len(d.data) {
+		return d.data[d.pos+offset]
+	}
+	return 0
+}
+
+// skipLine moves to the end of the current line (before the new line).
+func (d *HJSONDecoder) skipLine() {
+	if end := bytes.IndexByte(d.data[d.pos:], '\n'); end >= 0 {
+		d.pos += end
+	} else {
+		d.pos = len(d.data)
+	}
+}
+
+// parseMembers parses the members of an object.  If braced is true, it
+// consumes the closing brace.
+func (d *HJSONDecoder) parseMembers(out token.WriteStream, braced bool) error {
+	for {
+		if err := d.skipSpace(); err != nil {
+			return err
+		}
+		if d.pos == len(d.data) {
+			if braced {
+				return d.syntaxError("expected '}', got EOF")
+			}
+			return nil
+		}
+		if braced && d.data[d.pos] == '}' {
+			d.pos++
+			return nil
+		}
+		key, err := d.parseKey()
+		if err != nil {
+			return err
+		}
+		out.Put(keyScalar(key))
+		if err := d.skipSpace(); err != nil {
+			return err
+		}
+		if d.pos == len(d.data) || d.data[d.pos] != ':' {
+			return d.syntaxError("expected ':' after key %q", key)
+		}
+		d.pos++
+		if err := d.parseValue(out); err != nil {
+			return err
+		}
+		if err := d.skipSpace(); err != nil {
+			return err
+		}
+		if d.pos < len(d.data) && d.data[d.pos] == ',' {
+			d.pos++
+		}
+	}
+}
+
+func (d *HJSONDecoder) parseKey() (string, error) {
+	if d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case '"', '\'':
+			return d.parseQuotedString()
+		}
+	}
+	start := d.pos
+	for d.pos < len(d.data) && !isHJSONPunctuator(d.data[d.pos]) && !isHJSONSpace(d.data[d.pos]) {
+		d.pos++
+	}
+	if d.pos == start {
+		return "", d.syntaxError("expected key")
+	}
+	return string(d.data[start:d.pos]), nil
+}
+
+func (d *HJSONDecoder) parseValue(out token.WriteStream) error {
+	if err := d.skipSpace(); err != nil {
+		return err
+	}
+	if d.pos == len(d.data) {
+		return d.syntaxError("expected value, got EOF")
+	}
+	switch c := d.data[d.pos]; c {
+	case '{':
+		d.pos++
+		out.Put(&token.StartObject{})
+		if err := d.parseMembers(out, true); err != nil {
+			return err
+		}
+		out.Put(&token.EndObject{})
+	case '[':
+		d.pos++
+		out.Put(&token.StartArray{})
+		if err := d.parseItems(out); err != nil {
+			return err
+		}
+		out.Put(&token.EndArray{})
+	case '"', '\'':
+		var s string
+		var err error
+		if bytes.HasPrefix(d.data[d.pos:], multilineQuote) {
+			s, err = d.parseMultilineString()
+		} else {
+			s, err = d.parseQuotedString()
+		}
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(s))
+	default:
+		if isHJSONPunctuator(c) {
+			return d.syntaxError("unexpected %q", c)
+		}
+		out.Put(d.parseQuotelessValue())
+	}
+	return nil
+}
+
+func (d *HJSONDecoder) parseItems(out token.WriteStream) error {
+	for {
+		if err := d.skipSpace(); err != nil {
+			return err
+		}
+		if d.pos == len(d.data) {
+			return d.syntaxError("expected ']', got EOF")
+		}
+		if d.data[d.pos] == ']' {
+			d.pos++
+			return nil
+		}
+		if err := d.parseValue(out); err != nil {
+			return err
+		}
+		if err := d.skipSpace(); err != nil {
+			return err
+		}
+		if d.pos < len(d.data) && d.data[d.pos] == ',' {
+			d.pos++
+		}
+	}
+}
+
+// parseQuotelessValue parses a literal (true, false, null or a number) if it is
+// followed by the end of the line, a separator or a comment.  Otherwise it
+// parses a string which extends to the end of the line.
+func (d *HJSONDecoder) parseQuotelessValue() *token.Scalar {
+	start := d.pos
+	d.skipLine()
+	line := d.data[start:d.pos]
+	if n := literalPrefixLength(line); n > 0 {
+		rest := bytes.TrimLeft(line[n:], " \t\r")
+		if len(rest) == 0 || rest[0] == ',' || rest[0] == ']' || rest[0] == '}' || rest[0] == '#' ||
+			bytes.HasPrefix(rest, []byte("//")) || bytes.HasPrefix(rest, []byte("/*")) {
+			d.pos = start + n
+			switch line[0] {
+			case 't':
+				return trueInstance
+			case 'f':
+				return falseInstance
+			case 'n':
+				return nullInstance
+			default:
+				return token.NewScalar(token.Number, bytes.Clone(line[:n]))
+			}
+		}
+	}
+	return stringScalar(string(bytes.TrimRight(line, " \t\r")))
+}
+
+func literalPrefixLength(b []byte) int {
+	for _, lit := range [][]byte{trueBytes, falseBytes, nullBytes} {
+		if bytes.HasPrefix(b, lit) {
+			return len(lit)
+		}
+	}
+	return len(hjsonNumberPattern.Find(b))
+}
+
+var hjsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?`)
+
+// parseQuotedString parses a string enclosed in double or single quotes, with
+// JSON escape sequences (plus \' in single quoted strings).
+func (d *HJSONDecoder) parseQuotedString() (string, error) {
+	quote := d.data[d.pos]
+	d.pos++
+	var b strings.Builder
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		d.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\':
+			if err := d.parseEscape(&b); err != nil {
+				return "", err
+			}
+		case c == '\n' || c == '\r':
+			return "", d.syntaxError("new line in quoted string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", d.syntaxError("unterminated string")
+}
+
+func (d *HJSONDecoder) parseEscape(b *strings.Builder) error {
+	if d.pos == len(d.data) {
+		return d.syntaxError("unterminated string")
+	}
+	c := d.data[d.pos]
+	d.pos++
+	switch c {
+	case '"', '\'', '\\', '/':
+		b.WriteByte(c)
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'u':
+		r, err := d.parseHexRune()
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) && bytes.HasPrefix(d.data[d.pos:], []byte(`\u`)) {
+			d.pos += 2
+			r2, err := d.parseHexRune()
+			if err != nil {
+				return err
+			}
+			r = utf16.DecodeRune(r, r2)
+		}
+		b.WriteRune(r)
+	default:
+		d.pos--
+		return d.syntaxError("invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+func (d *HJSONDecoder) parseHexRune() (rune, error) {
+	if d.pos+4 > len(d.data) {
+		return 0, d.syntaxError("invalid unicode escape")
+	}
+	n, err := strconv.ParseUint(string(d.data[d.pos:d.pos+4]), 16, 16)
+	if err != nil {
+		return 0, d.syntaxError("invalid unicode escape")
+	}
+	d.pos += 4
+	return rune(n), nil
+}
+
+var multilineQuote = []byte("'''")
+
+// parseMultilineString parses a string enclosed in triple single quotes.
+// Whitespace up to the column of the opening quotes is removed from each line,
+// as well as the first line if it is empty and the last line feed.
+func (d *HJSONDecoder) parseMultilineString() (string, error) {
+	indent := d.pos - (bytes.LastIndexByte(d.data[:d.pos], '\n') + 1)
+	d.pos += len(multilineQuote)
+	end := bytes.Index(d.data[d.pos:], multilineQuote)
+	if end < 0 {
+		return "", d.syntaxError("unterminated multiline string")
+	}
+	content := string(d.data[d.pos : d.pos+end])
+	d.pos += end + len(multilineQuote)
+	content = strings.ReplaceAll(content, "\r", "")
+	if i := strings.IndexByte(content, '\n'); i >= 0 && strings.TrimLeft(content[:i], " \t") == "" {
+		content = content[i+1:]
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		j := 0
+		for j < indent && j < len(line) && (line[j] == ' ' || line[j] == '\t') {
+			j++
+		}
+		lines[i] = line[j:]
+	}
+	content = strings.Join(lines, "\n")
+	if strings.HasSuffix(content, "\n") {
+		content = content[:len(content)-1]
+	} else if i := strings.LastIndexByte(content, '\n'); i >= 0 && strings.TrimLeft(content[i+1:], " \t") == "" {
+		// The closing quotes are on their own line
+		content = content[:i]
+	}
+	return content, nil
+}
+
+func (d *HJSONDecoder) syntaxError(format string, args ...any) error {
+	line := bytes.Count(d.data[:d.pos], []byte{'\n'})
+	col := utf8.RuneCount(d.data[bytes.LastIndexByte(d.data[:d.pos], '\n')+1 : d.pos])
+	context, contextPos := scanner.ContextAt(d.data, d.pos)
+	return &token.ParseError{
+		Format:     "hjson",
+		Line:       line + 1,
+		Col:        col + 1,
+		Offset:     int64(d.pos),
+		Context:    context,
+		ContextPos: contextPos,
+		Msg:        fmt.Sprintf(format, args...),
+	}
+}
+
+func isHJSONPunctuator(c byte) bool {
+	switch c {
+	case ',', ':', '[', ']', '{', '}':
+		return true
+	}
+	return false
+}
+
+func isHJSONSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,136 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestHJSONDecoder(t *testing.T) {
+	type testCase struct {
+		name  string
+		input string
+		json  string
+		err   bool
+	}
+	var testCases = []testCase{
+		{
+			name:  "plain json",
+			input: `{"a": [1, 2.5, -3e2, true, false, null, "x\tyé"]}`,
+			json:  `{"a": [1, 2.5, -3e2, true, false, null, "x\tyé"]}`,
+		},
+		{
+			name: "comments",
+			input: `{
+				# hash comment
+				"a": 1, // line comment
+				/* block
+				   comment */ "b": 2
+			}`,
+			json: `{"a": 1, "b": 2}`,
+		},
+		{
+			name: "optional commas",
+			input: `{
+				"a": 1
+				"b": [
+					1
+					2,
+				]
+			}`,
+			json: `{"a": 1, "b": [1, 2]}`,
+		},
+		{
+			name:  "unquoted keys",
+			input: `{a: 1, b-c_d: 2, 'e f': 3}`,
+			json:  `{"a": 1, "b-c_d": 2, "e f": 3}`,
+		},
+		{
+			name: "quoteless values",
+			input: `{
+				a: hello world
+				b: 12 monkeys
+				c: true # a comment
+				d: null, e: 3
+				f: http://example.com # not a comment
+			}`,
+			json: `{"a": "hello world", "b": "12 monkeys", "c": true, "d": null, "e": 3, "f": "http://example.com # not a comment"}`,
+		},
+		{
+			name:  "single quoted strings",
+			input: `['it\'s', 'say "hi"']`,
+			json:  `["it's", "say \"hi\""]`,
+		},
+		{
+			name: "multiline strings",
+			input: strings.Join([]string{
+				"{",
+				"  text:",
+				"    '''",
+				"    first line",
+				"      indented",
+				"    last line",
+				"    '''",
+				"  inline: '''one line'''",
+				"}",
+			}, "\n"),
+			json: `{"text": "first line\n  indented\nlast line", "inline": "one line"}`,
+		},
+		{
+			name: "root braces omitted",
+			input: `
+				# config
+				name: jp
+				version: 2
+			`,
+			json: `{"name": "jp", "version": 2}`,
+		},
+		{
+			name:  "root scalar",
+			input: `just a string`,
+			json:  `"just a string"`,
+		},
+		{
+			name:  "unterminated object",
+			input: `{a: 1`,
+			err:   true,
+		},
+		{
+			name:  "missing colon",
+			input: `{a 1}`,
+			err:   true,
+		},
+		{
+			name:  "unterminated comment",
+			input: `{a: 1 /* oops`,
+			err:   true,
+		},
+		{
+			name:  "unterminated multiline string",
+			input: `{a: '''oops}`,
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeToJSONString(t, jsonstream.NewHJSONDecoder(strings.NewReader(c.input)))
+			if c.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			want, err := decodeToJSONString(t, jsonstream.NewJSONDecoder(strings.NewReader(c.json)))
+			if err != nil {
+				t.Fatalf("Invalid test JSON: %s", err)
+			}
+			if got != want {
+				t.Fatalf("Expected %q, got %q", want, got)
+			}
+		})
+	}
+}
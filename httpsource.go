@@ -0,0 +1,122 @@
+package jsonstream
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// DefaultHTTPRetryDelay is the default value of HTTPSource.RetryDelay.
+const DefaultHTTPRetryDelay = time.Second
+
+// HTTPSource is a token.StreamSource which fetches its input with a GET
+// request and decodes the response body as it is received, so that streamed
+// (e.g. chunked) responses are processed without waiting for their end.
+//
+// The request is retried up to Retries times when it fails or the server
+// responds with status 429 or 5xx, waiting RetryDelay (or DefaultHTTPRetryDelay
+// if it is not positive) before the first retry and twice as long before each
+// subsequent one.  Once the response body is being decoded, errors are not
+// retried as values would be output twice.
+//
+// Responses compressed with gzip are decompressed.
+type HTTPSource struct {
+	URL        string
+	Header     http.Header
+	Client     *http.Client // http.DefaultClient if nil
+	Retries    int
+	RetryDelay time.Duration
+
+	// NewDecoder returns the decoder for the response body.
+	NewDecoder func(io.Reader) token.StreamSource
+}
+
+var _ token.StreamSource = (*HTTPSource)(nil)
+
+// Produce implements token.StreamSource.
+func (s *HTTPSource) Produce(out chan<- token.Token) error {
+	body, err := s.Open()
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return s.NewDecoder(body).Produce(out)
+}
+
+// Open sends the request and returns the body of the response, decompressed if
+// necessary.  It returns an error if the response status is not 2xx.
+func (s *HTTPSource) Open() (io.ReadCloser, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	delay := s.RetryDelay
+	if delay <= 0 {
+		delay = DefaultHTTPRetryDelay
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := s.get(client)
+		retry := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if retry && attempt < s.Retries {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			time.Sleep(delay)
+			delay *= 2
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", s.URL, resp.Status)
+		}
+		return decompressedBody(resp)
+	}
+}
+
+func (s *HTTPSource) get(client *http.Client) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	// Setting Accept-Encoding explicitly disables the transparent
+	// decompression of http.Transport, so gzip is handled in decompressedBody
+	// whatever the client.
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	return client.Do(req)
+}
+
+// decompressedBody returns the body of resp, decompressing it if it is
+// compressed with gzip.
+func decompressedBody(resp *http.Response) (io.ReadCloser, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Uncompressed {
+		return resp.Body, nil
+	}
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return &gzipBody{Reader: reader, body: resp.Body}, nil
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
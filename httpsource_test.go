@@ -0,0 +1,148 @@
+package jsonstream_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func newJSONDecoder(r io.Reader) token.StreamSource {
+	return jsonstream.NewJSONDecoder(r)
+}
+
+func TestHTTPSource(t *testing.T) {
+	type testCase struct {
+		name     string
+		handler  func(w http.ResponseWriter, r *http.Request, attempt int)
+		header   http.Header
+		retries  int
+		output   string
+		err      string
+		attempts int
+	}
+	var testCases = []testCase{
+		{
+			name: "headers",
+			handler: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				io.WriteString(w, `{"token": "`+r.Header.Get("X-Token")+`"} [1]`)
+			},
+			header:   http.Header{"X-Token": {"secret"}},
+			output:   `{"token": "secret"}` + "\n" + `[1]` + "\n",
+			attempts: 1,
+		},
+		{
+			name: "gzip",
+			handler: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				if r.Header.Get("Accept-Encoding") != "gzip" {
+					t.Errorf("Expected gzip to be accepted")
+				}
+				w.Header().Set("Content-Encoding", "gzip")
+				zw := gzip.NewWriter(w)
+				io.WriteString(zw, `{"zipped": true}`)
+				zw.Close()
+			},
+			output:   `{"zipped": true}` + "\n",
+			attempts: 1,
+		},
+		{
+			name: "retries",
+			handler: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				if attempt < 3 {
+					http.Error(w, "busy", http.StatusServiceUnavailable)
+					return
+				}
+				io.WriteString(w, `"done"`)
+			},
+			retries:  2,
+			output:   `"done"` + "\n",
+			attempts: 3,
+		},
+		{
+			name: "too many failures",
+			handler: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+			},
+			retries:  1,
+			err:      ": 429 Too Many Requests",
+			attempts: 2,
+		},
+		{
+			name: "client errors are not retried",
+			handler: func(w http.ResponseWriter, r *http.Request, attempt int) {
+				http.NotFound(w, r)
+			},
+			retries:  3,
+			err:      ": 404 Not Found",
+			attempts: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				tc.handler(w, r, attempts)
+			}))
+			defer server.Close()
+			source := &jsonstream.HTTPSource{
+				URL:        server.URL,
+				Header:     tc.header,
+				Retries:    tc.retries,
+				RetryDelay: time.Millisecond,
+				NewDecoder: newJSONDecoder,
+			}
+			output, err := decodeToJSONString(t, source)
+			if tc.err != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), tc.err) {
+					t.Fatalf("Expected error ending with %q, got %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			} else if output != tc.output {
+				t.Fatalf("Expected %q, got %q", tc.output, output)
+			}
+			if attempts != tc.attempts {
+				t.Fatalf("Expected %d attempts, got %d", tc.attempts, attempts)
+			}
+		})
+	}
+}
+
+// TestHTTPSourceStreaming checks that values in the response are output before
+// the response is complete.
+func TestHTTPSourceStreaming(t *testing.T) {
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"event": 1}`+"\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			// The first value was not output, so the test will fail.
+			return
+		}
+		io.WriteString(w, `{"event": 2}`+"\n")
+	}))
+	defer server.Close()
+	source := &jsonstream.HTTPSource{URL: server.URL, NewDecoder: newJSONDecoder}
+	stream := token.StartStream(source, func(err error) { t.Errorf("Unexpected error: %s", err) })
+	var count int
+	for tok := range stream {
+		if _, ok := tok.(*token.EndObject); ok {
+			count++
+			if count == 1 {
+				close(received)
+			}
+		}
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 objects, got %d", count)
+	}
+}
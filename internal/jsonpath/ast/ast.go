@@ -135,7 +135,7 @@ const (
 //
 
 type Literal struct {
-	Value any // string, float64, bool, nil
+	Value any // string, json.Number, bool, nil
 }
 
 //
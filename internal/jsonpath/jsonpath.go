@@ -10,7 +10,17 @@ import (
 )
 
 func ParseQueryString(s string) (ast.Query, error) {
-	stream, err := parser.TokeniseJsonPathString(s)
+	return parseQueryString(s, parser.TokeniseJsonPathString)
+}
+
+// ParseQueryStringRelaxedNames is like ParseQueryString but accepts dashes in
+// member name shorthands, e.g. "$.user-id" is the same as "$['user-id']".
+func ParseQueryStringRelaxedNames(s string) (ast.Query, error) {
+	return parseQueryString(s, parser.TokeniseJsonPathStringRelaxedNames)
+}
+
+func parseQueryString(s string, tokenise func(string) (*grammar.SimpleTokenStream, error)) (ast.Query, error) {
+	stream, err := tokenise(s)
 	if err != nil {
 		return ast.Query{}, err
 	}
@@ -18,7 +28,7 @@ func ParseQueryString(s string) (ast.Query, error) {
 	var query parser.Query
 	parseErr := grammar.Parse(&query, stream)
 	if parseErr != nil {
-		return ast.Query{}, err
+		return ast.Query{}, parseErr
 	}
 	if n := stream.Next(); n != grammar.EOF {
 		return ast.Query{}, errors.New("invalid query string")
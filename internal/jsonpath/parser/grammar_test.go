@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"encoding/json"
 	"os"
 	"reflect"
 	"testing"
@@ -481,7 +482,7 @@ func TestGrammar(t *testing.T) {
 										},
 									},
 									Op:    ast.LessThanOp,
-									Right: ast.Literal{Value: float64(10)},
+									Right: ast.Literal{Value: json.Number("10")},
 								},
 							},
 						},
@@ -556,3 +557,88 @@ func TestGrammar(t *testing.T) {
 func pint64(n int64) *int64 {
 	return &n
 }
+
+func TestRelaxedNames(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+		ast   ast.Query
+	}{
+		{
+			name:  "dash in name",
+			input: "$.user-id",
+			ast: ast.Query{
+				RootNode: ast.RootNodeIdentifier,
+				Segments: []ast.Segment{
+					{
+						Type:      ast.ChildSegmentType,
+						Selectors: []ast.Selector{ast.NameSelector{Name: "user-id"}},
+					},
+				},
+			},
+		},
+		{
+			name:  "dash in second name",
+			input: "$.a.b-c",
+			ast: ast.Query{
+				RootNode: ast.RootNodeIdentifier,
+				Segments: []ast.Segment{
+					{
+						Type:      ast.ChildSegmentType,
+						Selectors: []ast.Selector{ast.NameSelector{Name: "a"}},
+					},
+					{
+						Type:      ast.ChildSegmentType,
+						Selectors: []ast.Selector{ast.NameSelector{Name: "b-c"}},
+					},
+				},
+			},
+		},
+		{
+			name:  "dash in descendant name",
+			input: "$..user-id",
+			ast: ast.Query{
+				RootNode: ast.RootNodeIdentifier,
+				Segments: []ast.Segment{
+					{
+						Type:      ast.DescendantSegmentType,
+						Selectors: []ast.Selector{ast.NameSelector{Name: "user-id"}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream, err := TokeniseJsonPathStringRelaxedNames(test.input)
+			if err != nil {
+				t.Fatalf("unexpected error tokenising %s: %s", test.name, err)
+			}
+			var query Query
+			if err := grammar.Parse(&query, stream); err != nil {
+				t.Fatalf("unexpected error parsing %s: %s", test.name, err)
+			}
+			if tok := stream.Next(); tok != grammar.EOF {
+				t.Fatalf("unexpected token %q", tok.Value())
+			}
+			astQuery, err := query.CompileToQuery()
+			if err != nil {
+				t.Fatalf("error compiling query: %s", err)
+			}
+			if !reflect.DeepEqual(astQuery, test.ast) {
+				t.Fatalf("ast query error")
+			}
+		})
+		t.Run(test.name+" (strict)", func(t *testing.T) {
+			stream, err := TokeniseJsonPathString(test.input)
+			if err != nil {
+				return
+			}
+			var query Query
+			if grammar.Parse(&query, stream) == nil && stream.Next() == grammar.EOF {
+				t.Fatalf("expected %q to be rejected in strict mode", test.input)
+			}
+		})
+	}
+}
@@ -30,12 +30,16 @@ func parseSingleQuotedString(s string) (string, error) {
 	return parseDoubleQuotedString(s)
 }
 
-func parseNumber(s string) (float64, error) {
-	tok, err := ParseJsonLiteral(s)
+// parseNumber checks that s is a valid number literal and returns it as it is,
+// so that numbers too large or too precise for a float64 are kept exactly.
+func parseNumber(s string) (json.Number, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	tok, err := dec.Token()
 	if err != nil {
-		return 0, err
+		return "", err
 	}
-	return tok.(float64), nil
+	return tok.(json.Number), nil
 }
 
 func ParseJsonLiteral(s string) (json.Token, error) {
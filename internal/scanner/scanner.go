@@ -6,8 +6,9 @@ import (
 )
 
 type Pos struct {
-	Line int
-	Col  int
+	Line   int
+	Col    int
+	Offset int64 // Only set in positions returned by the scanner
 }
 
 type Scanner struct {
@@ -22,6 +23,9 @@ type Scanner struct {
 	// 0 <= currentIndex <= fillIndex
 	currentIndex int
 
+	// Number of bytes discarded from the start of buf so far
+	shifted int64
+
 	// Records lineno and colno of current position (from when the scanning
 	// started)
 	currentPos, prevPos Pos
@@ -73,6 +77,7 @@ func (s *Scanner) fillBuf() {
 			}
 		}
 		if baseIndex > 0 {
+			s.shifted += int64(baseIndex)
 			copy(s.buf, s.buf[baseIndex:s.fillIndex])
 			s.fillIndex -= baseIndex
 			s.currentIndex -= baseIndex
@@ -122,11 +127,51 @@ func (s *Scanner) StartToken() Pos {
 		panic("already in record mode")
 	}
 	s.tokenStartIndex = s.currentIndex
-	return s.currentPos
+	return s.CurrentPos()
 }
 
 func (s *Scanner) CurrentPos() Pos {
-	return s.currentPos
+	pos := s.currentPos
+	pos.Offset = s.shifted + int64(s.currentIndex)
+	return pos
+}
+
+// Maximum number of bytes on each side of a position returned by Context.
+const contextSize = 40
+
+// Context returns the input around pos on the same line, as far as it is still
+// (or already) in the read buffer, and the index of pos in it.  It is used to
+// show where errors are.
+func (s *Scanner) Context(pos Pos) (string, int) {
+	i := int(pos.Offset - s.shifted)
+	if i < 0 || i > s.fillIndex {
+		return "", 0
+	}
+	return ContextAt(s.buf[:s.fillIndex], i)
+}
+
+// ContextAt returns the bytes of buf around index i on the same line (at most
+// 40 bytes on each side), and the index of i in them.
+func ContextAt(buf []byte, i int) (string, int) {
+	start, end := i, i
+	for start > 0 && i-start < contextSize && buf[start-1] != '\n' {
+		start--
+	}
+	for end < len(buf) && end-i < contextSize && buf[end] != '\n' && buf[end] != '\r' {
+		end++
+	}
+	// Do not cut utf8-encoded codepoints.
+	for start < i && isContinuationByte(buf[start]) {
+		start++
+	}
+	for end > i && end < len(buf) && isContinuationByte(buf[end]) {
+		end--
+	}
+	return string(buf[start:end]), i - start
+}
+
+func isContinuationByte(b byte) bool {
+	return b&0xC0 == 0x80
 }
 
 func (s *Scanner) EndToken() []byte {
@@ -153,6 +198,29 @@ func (s *Scanner) EndToken() []byte {
 	return tokBytes
 }
 
+// EndTokenNoCopy is like EndToken but avoids copying the token bytes when
+// possible.  The returned slice may point into the scanner's buffer, so it is
+// only valid until the next call to Read, Peek or any other method which
+// advances the scanner.
+func (s *Scanner) EndTokenNoCopy() []byte {
+	if s.tokenStartIndex < 0 {
+		panic("not in record mode")
+	}
+	if s.tokenParts != nil {
+		return s.EndToken()
+	}
+	tokBytes := s.buf[s.tokenStartIndex:s.currentIndex]
+	s.tokenStartIndex = -1
+	return tokBytes
+}
+
+// CancelToken stops recording the current token, if any.  It is used to
+// recover from errors which happen in the middle of a token.
+func (s *Scanner) CancelToken() {
+	s.tokenStartIndex = -1
+	s.tokenParts = nil
+}
+
 func (s *Scanner) Back() {
 	if s.currentIndex <= 0 || s.currentIndex <= s.tokenStartIndex {
 		panic("cannot go back from start")
@@ -169,6 +237,26 @@ func (s *Scanner) Back() {
 	s.prevPos.Line = -1
 }
 
+// BackToLineStart moves back to the start of the current line if it is still
+// in the read buffer, and returns true if it did.  It is used to recover from
+// errors, so it also stops recording the current token.
+func (s *Scanner) BackToLineStart() bool {
+	i := s.currentIndex
+	for i > 0 && s.buf[i-1] != '\n' {
+		i--
+	}
+	if i == 0 && s.shifted > 0 {
+		// The start of the line may have been discarded
+		return false
+	}
+	s.CancelToken()
+	s.currentIndex = i
+	s.currentPos.Col = 0
+	s.prevPos.Line = -1
+	s.eofCount = 0
+	return true
+}
+
 func (s *Scanner) Peek() (byte, error) {
 	if s.currentIndex >= s.fillIndex {
 		s.fillBuf()
@@ -219,6 +307,7 @@ func (s *Scanner) SkipSpaceAndRead() (byte, error) {
 				s.currentPos.Col++
 			default:
 				s.currentIndex += i + 1
+				s.prevPos = s.currentPos
 				if b < 0xC0 {
 					s.currentPos.Col++
 				}
@@ -233,6 +322,77 @@ func (s *Scanner) SkipSpaceAndRead() (byte, error) {
 	}
 }
 
+// A ByteSet is a set of bytes, used to skip over runs of bytes with ReadRun.  It
+// cannot contain '\n'.
+type ByteSet [256]bool
+
+// NewByteSet returns the set of bytes for which contains returns true.  It
+// panics if that includes '\n'.
+func NewByteSet(contains func(b byte) bool) *ByteSet {
+	var set ByteSet
+	for i := range set {
+		set[i] = contains(byte(i))
+	}
+	if set['\n'] {
+		panic("a ByteSet cannot contain '\\n'")
+	}
+	return &set
+}
+
+// ReadRun reads the bytes in set from the current position, stopping at the
+// first byte not in set or at the end of the read buffer, and returns them.  As
+// it does not refill a non-empty buffer, the run may continue after the
+// returned bytes so the caller should carry on with Read, which may return a
+// byte in set.
+// This is much faster than calling Read for each byte of long runs (e.g. the
+// contents of strings).
+//
+// The returned slice points into the read buffer, so it is only valid until the
+// next call to a method which advances the scanner.  Calling Back afterwards
+// goes back one byte, as after Read.
+func (s *Scanner) ReadRun(set *ByteSet) []byte {
+	if s.currentIndex >= s.fillIndex {
+		s.fillBuf()
+	}
+	buf := s.buf[s.currentIndex:s.fillIndex]
+	col := s.currentPos.Col
+	i := 0
+	// Unrolled loop for the common case of long runs.
+	for ; i+4 <= len(buf); i += 4 {
+		b0, b1, b2, b3 := buf[i], buf[i+1], buf[i+2], buf[i+3]
+		if !set[b0] || !set[b1] || !set[b2] || !set[b3] {
+			break
+		}
+		col += colWidth[b0] + colWidth[b1] + colWidth[b2] + colWidth[b3]
+	}
+	for ; i < len(buf); i++ {
+		b := buf[i]
+		if !set[b] {
+			break
+		}
+		col += colWidth[b]
+	}
+	if i == 0 {
+		return nil
+	}
+	s.currentIndex += i
+	s.prevPos = Pos{Line: s.currentPos.Line, Col: col - colWidth[buf[i-1]]}
+	s.currentPos.Col = col
+	return buf[:i]
+}
+
+// colWidth[b] is how much the column number increases when reading b (other
+// than '\n'): only the last byte of an utf8-encoded codepoint counts, as in
+// Read.
+var colWidth = func() (w [256]int) {
+	for i := range w {
+		if i < 0xC0 {
+			w[i] = 1
+		}
+	}
+	return
+}()
+
 const (
 	lookBackSize             = 1
 	maxConsecutiveEmptyReads = 100
@@ -114,3 +114,71 @@ func TestLargeInput(t *testing.T) {
 		assertEndToken(t, scanner, strings.Repeat(line, 10))
 	}
 }
+
+func TestReadRun(t *testing.T) {
+	letters := NewByteSet(func(b byte) bool { return b >= 'a' && b <= 'z' || b >= 0x80 })
+	scanner := strScanner("héllo, world\nabc")
+	assertStartToken(t, scanner, 0, 0)
+	if run := scanner.ReadRun(letters); string(run) != "héllo" {
+		t.Fatalf("ReadRun: expected %q, got %q", "héllo", run)
+	}
+	assertCurrentPos(t, scanner, 0, 5)
+	scanner.Back()
+	assertCurrentPos(t, scanner, 0, 4)
+	assertRead(t, scanner, 'o', nil)
+	if run := scanner.ReadRun(letters); len(run) != 0 {
+		t.Fatalf("ReadRun: expected empty run, got %q", run)
+	}
+	assertRead(t, scanner, ',', nil)
+	assertEndToken(t, scanner, "héllo,")
+	assertRead(t, scanner, ' ', nil)
+	scanner.ReadRun(letters)
+	assertRead(t, scanner, '\n', nil)
+	scanner.ReadRun(letters)
+	assertCurrentPos(t, scanner, 1, 3)
+	assertRead(t, scanner, EOF, nil)
+	scanner.Back()
+	assertRead(t, scanner, EOF, nil)
+}
+
+func TestBackToLineStart(t *testing.T) {
+	scanner := strScanner("ab\ncd")
+	if !scanner.BackToLineStart() {
+		t.Fatal("Expected to go back to the start of the first line")
+	}
+	assertRead(t, scanner, 'a', nil)
+	assertRead(t, scanner, 'b', nil)
+	assertRead(t, scanner, '\n', nil)
+	assertRead(t, scanner, 'c', nil)
+	if !scanner.BackToLineStart() {
+		t.Fatal("Expected to go back to the start of the second line")
+	}
+	assertCurrentPos(t, scanner, 1, 0)
+	assertRead(t, scanner, 'c', nil)
+	assertRead(t, scanner, 'd', nil)
+	assertRead(t, scanner, EOF, nil)
+	scanner.BackToLineStart()
+	assertRead(t, scanner, 'c', nil)
+}
+
+func TestReadRunLargeInput(t *testing.T) {
+	const line = "averylongstring\n"
+	letters := NewByteSet(func(b byte) bool { return b >= 'a' && b <= 'z' })
+	scanner := NewScannerSize(strings.NewReader(strings.Repeat(line, 10)), 16)
+	// Runs stop at the end of the buffer, so they must be continued with Read.
+	for i := 0; i < 10; i++ {
+		assertStartToken(t, scanner, i, 0)
+		for {
+			scanner.ReadRun(letters)
+			b, err := scanner.Read()
+			if err != nil {
+				t.Fatal("unexpected error")
+			}
+			if b == '\n' {
+				break
+			}
+		}
+		assertEndToken(t, scanner, line)
+	}
+	assertRead(t, scanner, EOF, nil)
+}
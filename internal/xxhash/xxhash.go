@@ -0,0 +1,133 @@
+// Package xxhash implements the 64-bit xxHash algorithm (XXH64) with a seed of
+// 0, a fast non-cryptographic hash.  See
+// https://github.com/Cyan4973/xxHash/blob/dev/doc/xxhash_spec.md
+package xxhash
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	prime1 uint64 = 11400714785074694791
+	prime2 uint64 = 14029467366897019727
+	prime3 uint64 = 1609587929392839161
+	prime4 uint64 = 9650029242287828579
+	prime5 uint64 = 2870177450012600261
+)
+
+// Digest computes an XXH64 hash.  It implements hash.Hash64.
+type Digest struct {
+	v1, v2, v3, v4 uint64
+	total          uint64
+	buf            [32]byte
+	n              int // Number of bytes in buf
+}
+
+var _ hash.Hash64 = &Digest{}
+
+// New returns a new Digest.
+func New() *Digest {
+	d := &Digest{}
+	d.Reset()
+	return d
+}
+
+// Reset implements hash.Hash.
+func (d *Digest) Reset() {
+	p1, p2 := prime1, prime2 // Variables so the arithmetic wraps around
+	d.v1 = p1 + p2
+	d.v2 = p2
+	d.v3 = 0
+	d.v4 = -p1
+	d.total = 0
+	d.n = 0
+}
+
+// Size implements hash.Hash.
+func (d *Digest) Size() int {
+	return 8
+}
+
+// BlockSize implements hash.Hash.
+func (d *Digest) BlockSize() int {
+	return 32
+}
+
+// Write implements hash.Hash.  It never fails.
+func (d *Digest) Write(b []byte) (int, error) {
+	n := len(b)
+	d.total += uint64(n)
+	if d.n+len(b) < 32 {
+		d.n += copy(d.buf[d.n:], b)
+		return n, nil
+	}
+	if d.n > 0 {
+		c := copy(d.buf[d.n:], b)
+		d.writeBlock(d.buf[:])
+		b = b[c:]
+		d.n = 0
+	}
+	for ; len(b) >= 32; b = b[32:] {
+		d.writeBlock(b)
+	}
+	d.n = copy(d.buf[:], b)
+	return n, nil
+}
+
+func (d *Digest) writeBlock(b []byte) {
+	d.v1 = round(d.v1, binary.LittleEndian.Uint64(b))
+	d.v2 = round(d.v2, binary.LittleEndian.Uint64(b[8:]))
+	d.v3 = round(d.v3, binary.LittleEndian.Uint64(b[16:]))
+	d.v4 = round(d.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+// Sum implements hash.Hash, appending the hash to b in big-endian order.
+func (d *Digest) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, d.Sum64())
+}
+
+// Sum64 implements hash.Hash64.
+func (d *Digest) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) +
+			bits.RotateLeft64(d.v3, 12) + bits.RotateLeft64(d.v4, 18)
+		h = merge(h, d.v1)
+		h = merge(h, d.v2)
+		h = merge(h, d.v3)
+		h = merge(h, d.v4)
+	} else {
+		h = prime5
+	}
+	h += d.total
+	b := d.buf[:d.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*prime1 + prime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * prime1
+		h = bits.RotateLeft64(h, 23)*prime2 + prime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * prime5
+		h = bits.RotateLeft64(h, 11) * prime1
+	}
+	h ^= h >> 33
+	h *= prime2
+	h ^= h >> 29
+	h *= prime3
+	h ^= h >> 32
+	return h
+}
+
+func round(acc, input uint64) uint64 {
+	return bits.RotateLeft64(acc+input*prime2, 31) * prime1
+}
+
+func merge(h, v uint64) uint64 {
+	return (h^round(0, v))*prime1 + prime4
+}
@@ -0,0 +1,52 @@
+package xxhash
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSum64(t *testing.T) {
+	type testCase struct {
+		input string
+		sum   uint64
+	}
+	var testCases = []testCase{
+		{input: "", sum: 0xef46db3751d8e999},
+		{input: "a", sum: 0xd24ec4f1a98c6e5b},
+		{input: "abc", sum: 0x44bc2cf5ad770999},
+		{input: "Nobody inspects the spammish repetition", sum: 0xfbcea83c8a378bf1},
+	}
+	for _, c := range testCases {
+		t.Run(c.input, func(t *testing.T) {
+			d := New()
+			d.Write([]byte(c.input))
+			if got := d.Sum64(); got != c.sum {
+				t.Fatalf("Expected %x, got %x", c.sum, got)
+			}
+		})
+	}
+}
+
+func TestWriteInChunks(t *testing.T) {
+	input := []byte(strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 10))
+	whole := New()
+	whole.Write(input)
+	for _, size := range []int{1, 5, 31, 32, 33, 100} {
+		d := New()
+		for b := input; len(b) > 0; {
+			n := size
+			if n > len(b) {
+				n = len(b)
+			}
+			d.Write(b[:n])
+			b = b[n:]
+		}
+		if d.Sum64() != whole.Sum64() {
+			t.Fatalf("Writing in chunks of %d bytes gives %x, expected %x", size, d.Sum64(), whole.Sum64())
+		}
+		d.Reset()
+		if d.Sum64() != 0xef46db3751d8e999 {
+			t.Fatalf("Reset did not reset the digest")
+		}
+	}
+}
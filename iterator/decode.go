@@ -0,0 +1,556 @@
+package iterator
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// Decode stores value in the Go value pointed to by v, consuming value.  It
+// follows the same rules as json.Unmarshal in the standard library:
+//   - struct fields are matched with object keys using their name or their
+//     "json" tag (preferring an exact match but accepting a case-insensitive
+//     one), fields tagged "-" are ignored, as are keys with no matching field;
+//   - the fields of embedded structs are promoted;
+//   - numbers, strings, booleans, arrays and objects are stored in Go values of
+//     the matching kinds ([]byte from base64 encoded strings), null sets
+//     pointers, interfaces, maps and slices to nil and is ignored otherwise;
+//   - values are stored in an empty interface as bool, float64, string, []any
+//     or map[string]any;
+//   - types implementing json.Unmarshaler (or encoding.TextUnmarshaler for
+//     strings and map keys) decode themselves.
+//
+// Tag options such as "string" are ignored.
+//
+// Together with an Iterator, this allows streaming through a huge array and
+// only decoding the items which are kept, e.g.
+//
+//	for array.Advance() {
+//		var item Item
+//		if err := iterator.Decode(array.CurrentValue(), &item); err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// If part of value cannot be stored in v (e.g. a string for an int field),
+// that part is skipped and decoding carries on, then the first such error is
+// returned.
+func Decode(value Value, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		value.Discard()
+		return fmt.Errorf("decode: expected a non-nil pointer, got %T", v)
+	}
+	d := decodeState{}
+	d.decode(value, rv.Elem())
+	return d.err
+}
+
+type decodeState struct {
+	path []any // Keys (strings) and indices (ints) to the current value
+	err  error
+}
+
+// typeError records the first error about a value which does not fit in a Go
+// value of type t.
+func (d *decodeState) typeError(kind string, t reflect.Type) {
+	if d.err == nil {
+		d.err = fmt.Errorf("decode %s: cannot store %s in Go value of type %s", d.pathString(), kind, t)
+	}
+}
+
+func (d *decodeState) error(err error) {
+	if d.err == nil {
+		d.err = fmt.Errorf("decode %s: %w", d.pathString(), err)
+	}
+}
+
+func (d *decodeState) pathString() string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, p := range d.path {
+		switch p := p.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", p)
+		case string:
+			if isIdentifier(p) {
+				b.WriteByte('.')
+				b.WriteString(p)
+			} else {
+				fmt.Fprintf(&b, "[%s]", strconv.Quote(p))
+			}
+		}
+	}
+	return b.String()
+}
+
+func isIdentifier(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+func (d *decodeState) decode(value Value, rv reflect.Value) {
+	scalar, isScalar := value.AsScalar()
+	if isScalar && scalar.Type() == token.Null {
+		switch rv.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice:
+			rv.Set(reflect.Zero(rv.Type()))
+		}
+		return
+	}
+	// Allocate pointers as needed, stopping at a type which decodes itself.
+	for {
+		if rv.CanAddr() && rv.Addr().Type().Implements(jsonUnmarshalerType) {
+			d.decodeUnmarshaler(value, rv.Addr().Interface().(json.Unmarshaler))
+			return
+		}
+		if isScalar && scalar.Type() == token.String && rv.CanAddr() && rv.Addr().Type().Implements(textUnmarshalerType) {
+			if err := rv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(scalar.ToString())); err != nil {
+				d.error(err)
+			}
+			return
+		}
+		if rv.Kind() != reflect.Pointer {
+			break
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Interface {
+		if rv.NumMethod() > 0 {
+			d.typeError(valueKind(value), rv.Type())
+			value.Discard()
+			return
+		}
+		rv.Set(reflect.ValueOf(d.decodeAny(value)))
+		return
+	}
+	switch x := value.(type) {
+	case *Scalar:
+		d.decodeScalar(x.Scalar(), rv)
+	case *Array:
+		d.decodeArray(x, rv)
+	case *Object:
+		d.decodeObject(x, rv)
+	}
+}
+
+func (d *decodeState) decodeUnmarshaler(value Value, u json.Unmarshaler) {
+	acc := token.NewAccumulatorStream()
+	value.Copy(acc)
+	if err := u.UnmarshalJSON(appendTokensJSON(nil, acc.GetTokens())); err != nil {
+		d.error(err)
+	}
+}
+
+func (d *decodeState) decodeScalar(scalar *token.Scalar, rv reflect.Value) {
+	switch scalar.Type() {
+	case token.Boolean:
+		if rv.Kind() != reflect.Bool {
+			d.typeError("boolean", rv.Type())
+			return
+		}
+		rv.SetBool(scalar.Bytes[0] == 't')
+	case token.String:
+		switch {
+		case rv.Kind() == reflect.String:
+			rv.SetString(scalar.ToString())
+		case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+			b, err := base64.StdEncoding.DecodeString(scalar.ToString())
+			if err != nil {
+				d.error(err)
+				return
+			}
+			rv.SetBytes(b)
+		default:
+			d.typeError("string", rv.Type())
+		}
+	case token.Number:
+		d.decodeNumber(string(scalar.Bytes), rv)
+	}
+}
+
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+func (d *decodeState) decodeNumber(s string, rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || rv.OverflowInt(n) {
+			d.typeError("number "+s, rv.Type())
+			return
+		}
+		rv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil || rv.OverflowUint(n) {
+			d.typeError("number "+s, rv.Type())
+			return
+		}
+		rv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, rv.Type().Bits())
+		if err != nil {
+			d.typeError("number "+s, rv.Type())
+			return
+		}
+		rv.SetFloat(x)
+	case reflect.String:
+		if rv.Type() != jsonNumberType {
+			d.typeError("number", rv.Type())
+			return
+		}
+		rv.SetString(s)
+	default:
+		d.typeError("number", rv.Type())
+	}
+}
+
+func (d *decodeState) decodeArray(array *Array, rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Slice:
+		rv.SetLen(0)
+	case reflect.Array:
+	default:
+		d.typeError("array", rv.Type())
+		array.Discard()
+		return
+	}
+	i := 0
+	d.path = append(d.path, 0)
+	for array.Advance() {
+		d.path[len(d.path)-1] = i
+		switch {
+		case rv.Kind() == reflect.Array:
+			if i < rv.Len() {
+				d.decode(array.CurrentValue(), rv.Index(i))
+			}
+		case i < rv.Cap():
+			rv.SetLen(i + 1)
+			d.decode(array.CurrentValue(), rv.Index(i))
+		default:
+			rv.Set(reflect.Append(rv, reflect.Zero(rv.Type().Elem())))
+			d.decode(array.CurrentValue(), rv.Index(i))
+		}
+		i++
+	}
+	d.path = d.path[:len(d.path)-1]
+	if rv.Kind() == reflect.Array {
+		for ; i < rv.Len(); i++ {
+			rv.Index(i).Set(reflect.Zero(rv.Type().Elem()))
+		}
+	} else if rv.IsNil() {
+		// An empty array is decoded as an empty slice, not nil.
+		rv.Set(reflect.MakeSlice(rv.Type(), 0, 0))
+	}
+}
+
+func (d *decodeState) decodeObject(obj *Object, rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Map:
+		d.decodeMap(obj, rv)
+	case reflect.Struct:
+		d.decodeStruct(obj, rv)
+	default:
+		d.typeError("object", rv.Type())
+		obj.Discard()
+	}
+}
+
+func (d *decodeState) decodeMap(obj *Object, rv reflect.Value) {
+	t := rv.Type()
+	keyType := t.Key()
+	switch keyType.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !reflect.PointerTo(keyType).Implements(textUnmarshalerType) {
+			d.typeError("object", t)
+			obj.Discard()
+			return
+		}
+	}
+	if rv.IsNil() {
+		rv.Set(reflect.MakeMap(t))
+	}
+	d.path = append(d.path, "")
+	for obj.Advance() {
+		key, value := obj.CurrentKeyVal()
+		keyStr := key.ToString()
+		d.path[len(d.path)-1] = keyStr
+		kv := reflect.New(keyType).Elem()
+		if reflect.PointerTo(keyType).Implements(textUnmarshalerType) {
+			if err := kv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(keyStr)); err != nil {
+				d.error(err)
+				continue
+			}
+		} else if keyType.Kind() == reflect.String {
+			kv.SetString(keyStr)
+		} else {
+			prevErr := d.err
+			d.decodeNumber(keyStr, kv)
+			if d.err != prevErr {
+				continue
+			}
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if existing := rv.MapIndex(kv); existing.IsValid() {
+			elem.Set(existing)
+		}
+		d.decode(value, elem)
+		rv.SetMapIndex(kv, elem)
+	}
+	d.path = d.path[:len(d.path)-1]
+}
+
+func (d *decodeState) decodeStruct(obj *Object, rv reflect.Value) {
+	fields := cachedStructFields(rv.Type())
+	d.path = append(d.path, "")
+	for obj.Advance() {
+		key, value := obj.CurrentKeyVal()
+		keyStr := key.ToString()
+		f, ok := fields.byName[keyStr]
+		if !ok {
+			f, ok = fields.byFoldedName[strings.ToLower(keyStr)]
+		}
+		if !ok {
+			continue
+		}
+		d.path[len(d.path)-1] = keyStr
+		fv, err := fieldByIndex(rv, f.index)
+		if err != nil {
+			d.error(err)
+			continue
+		}
+		d.decode(value, fv)
+	}
+	d.path = d.path[:len(d.path)-1]
+}
+
+// fieldByIndex returns the field of the struct rv with the given index,
+// allocating the embedded structs it is in if needed.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				if !rv.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", rv.Type().Elem())
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, nil
+}
+
+type structField struct {
+	index []int
+}
+
+type structFields struct {
+	byName       map[string]structField
+	byFoldedName map[string]structField
+}
+
+var structFieldsCache sync.Map // map[reflect.Type]*structFields
+
+func cachedStructFields(t reflect.Type) *structFields {
+	if f, ok := structFieldsCache.Load(t); ok {
+		return f.(*structFields)
+	}
+	f, _ := structFieldsCache.LoadOrStore(t, getStructFields(t))
+	return f.(*structFields)
+}
+
+// getStructFields returns the fields of t which can be decoded, by name.  When
+// several fields have the same name, the least nested one is kept, and if
+// there is more than one they are all ignored (as in encoding/json).
+func getStructFields(t reflect.Type) *structFields {
+	type candidate struct {
+		index     []int
+		ambiguous bool
+	}
+	candidates := map[string]*candidate{}
+	var names []string
+	var tagged [][]int // Index of embedded structs with a tag, whose fields are not promoted
+fieldLoop:
+	for _, f := range reflect.VisibleFields(t) {
+		for _, prefix := range tagged {
+			if len(f.Index) > len(prefix) && slicesHasPrefix(f.Index, prefix) {
+				continue fieldLoop
+			}
+		}
+		tag, hasTag := f.Tag.Lookup("json")
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "-" && tag == "-" {
+			continue
+		}
+		if f.Anonymous {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if !hasTag || name == "" {
+				if ft.Kind() == reflect.Struct {
+					// Its fields are promoted.
+					continue
+				}
+			} else {
+				tagged = append(tagged, f.Index)
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		c := candidates[name]
+		switch {
+		case c == nil:
+			candidates[name] = &candidate{index: f.Index}
+			names = append(names, name)
+		case len(f.Index) < len(c.index):
+			*c = candidate{index: f.Index}
+		case len(f.Index) == len(c.index):
+			c.ambiguous = true
+		}
+	}
+	fields := &structFields{
+		byName:       map[string]structField{},
+		byFoldedName: map[string]structField{},
+	}
+	for _, name := range names {
+		c := candidates[name]
+		if c.ambiguous {
+			continue
+		}
+		fields.byName[name] = structField{index: c.index}
+		folded := strings.ToLower(name)
+		if _, ok := fields.byFoldedName[folded]; !ok {
+			fields.byFoldedName[folded] = structField{index: c.index}
+		}
+	}
+	return fields
+}
+
+func slicesHasPrefix(s, prefix []int) bool {
+	for i, x := range prefix {
+		if s[i] != x {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeAny returns value as a Go value as stored in an empty interface.
+func (d *decodeState) decodeAny(value Value) any {
+	switch x := value.(type) {
+	case *Scalar:
+		return x.Scalar().ToGo()
+	case *Array:
+		items := []any{}
+		d.path = append(d.path, 0)
+		for i := 0; x.Advance(); i++ {
+			d.path[len(d.path)-1] = i
+			items = append(items, d.decodeAny(x.CurrentValue()))
+		}
+		d.path = d.path[:len(d.path)-1]
+		return items
+	case *Object:
+		m := map[string]any{}
+		d.path = append(d.path, "")
+		for x.Advance() {
+			key, val := x.CurrentKeyVal()
+			keyStr := key.ToString()
+			d.path[len(d.path)-1] = keyStr
+			m[keyStr] = d.decodeAny(val)
+		}
+		d.path = d.path[:len(d.path)-1]
+		return m
+	default:
+		return nil
+	}
+}
+
+func valueKind(value Value) string {
+	switch x := value.(type) {
+	case *Scalar:
+		switch x.Scalar().Type() {
+		case token.Boolean:
+			return "boolean"
+		case token.Number:
+			return "number"
+		case token.String:
+			return "string"
+		default:
+			return "null"
+		}
+	case *Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// appendTokensJSON appends the tokens of a value to b as compact JSON.
+func appendTokensJSON(b []byte, toks []token.Token) []byte {
+	needComma := false
+	for _, tok := range toks {
+		switch x := tok.(type) {
+		case *token.StartArray:
+			b = appendComma(b, needComma)
+			b = append(b, '[')
+			needComma = false
+		case *token.StartObject:
+			b = appendComma(b, needComma)
+			b = append(b, '{')
+			needComma = false
+		case *token.EndArray:
+			b = append(b, ']')
+			needComma = true
+		case *token.EndObject:
+			b = append(b, '}')
+			needComma = true
+		case *token.Scalar:
+			b = appendComma(b, needComma)
+			b = append(b, x.Bytes...)
+			if x.IsKey() {
+				b = append(b, ':')
+				needComma = false
+			} else {
+				needComma = true
+			}
+		}
+	}
+	return b
+}
+
+func appendComma(b []byte, needComma bool) []byte {
+	if needComma {
+		return append(b, ',')
+	}
+	return b
+}
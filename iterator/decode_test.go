@@ -0,0 +1,158 @@
+package iterator_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// valueOf returns the first value in the JSON string s.
+func valueOf(t *testing.T, s string) iterator.Value {
+	decoder := jsonstream.NewJSONDecoder(strings.NewReader(s))
+	stream := token.StartStream(decoder, func(err error) { t.Fatalf("Unexpected error: %s", err) })
+	it := iterator.New(token.ChannelReadStream(stream))
+	if !it.Advance() {
+		t.Fatalf("No value in %s", s)
+	}
+	return it.CurrentValue()
+}
+
+type Base struct {
+	ID   int `json:"id"`
+	Kind string
+}
+
+type Item struct {
+	Base
+	Name    string            `json:"name"`
+	Tags    []string          `json:"tags"`
+	Attrs   map[string]any    `json:"attrs"`
+	Counts  map[int]uint8     `json:"counts"`
+	Score   *float64          `json:"score"`
+	Data    []byte            `json:"data"`
+	When    time.Time         `json:"when"`
+	Raw     json.RawMessage   `json:"raw"`
+	Number  json.Number       `json:"number"`
+	Pair    [2]int            `json:"pair"`
+	Ignored string            `json:"-"`
+	Nested  map[string][]bool `json:"nested"`
+	secret  string
+}
+
+func TestDecode(t *testing.T) {
+	score := 2.5
+	type testCase struct {
+		name   string
+		input  string
+		target any
+		output any
+		err    string
+	}
+	var testCases = []testCase{
+		{
+			name: "struct",
+			input: `{
+				"id": 12, "kind": "thing", "NAME": "foo", "tags": ["a", "b"],
+				"attrs": {"x": [1, "y", null, {"z": true}]}, "counts": {"3": 4},
+				"score": 2.5, "data": "aGk=", "when": "2023-11-14T22:13:20Z",
+				"raw": {"a": [1, 2]}, "number": 1e100, "pair": [1],
+				"Ignored": "no", "secret": "no", "unknown": {"a": [1]},
+				"nested": {"n": [true, false]}
+			}`,
+			target: &Item{},
+			output: &Item{
+				Base:   Base{ID: 12, Kind: "thing"},
+				Name:   "foo",
+				Tags:   []string{"a", "b"},
+				Attrs:  map[string]any{"x": []any{1.0, "y", nil, map[string]any{"z": true}}},
+				Counts: map[int]uint8{3: 4},
+				Score:  &score,
+				Data:   []byte("hi"),
+				When:   time.Unix(1700000000, 0).UTC(),
+				Raw:    json.RawMessage(`{"a":[1,2]}`),
+				Number: "1e100",
+				Pair:   [2]int{1, 0},
+				Nested: map[string][]bool{"n": {true, false}},
+			},
+		},
+		{
+			name:   "null",
+			input:  `{"name": null, "tags": null, "score": null}`,
+			target: &Item{Name: "keep", Tags: []string{"x"}, Score: &score},
+			output: &Item{Name: "keep"},
+		},
+		{
+			name:   "empty array",
+			input:  `[]`,
+			target: new([]int),
+			output: &[]int{},
+		},
+		{
+			name:   "any",
+			input:  `[1, {"a": "b"}]`,
+			target: new(any),
+			output: func() *any { var x any = []any{1.0, map[string]any{"a": "b"}}; return &x }(),
+		},
+		{
+			name:   "type mismatch",
+			input:  `[{"id": 1}, {"id": "2", "name": "two"}, {"id": 3}]`,
+			target: new([]Item),
+			output: &[]Item{{Base: Base{ID: 1}}, {Name: "two"}, {Base: Base{ID: 3}}},
+			err:    "decode $[1].id: cannot store string in Go value of type int",
+		},
+		{
+			name:   "overflow",
+			input:  `{"a b": 300}`,
+			target: new(map[string]int8),
+			output: &map[string]int8{"a b": 0},
+			err:    `decode $["a b"]: cannot store number 300 in Go value of type int8`,
+		},
+		{
+			name:   "not a pointer",
+			input:  `1`,
+			target: 1,
+			err:    "decode: expected a non-nil pointer, got int",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := iterator.Decode(valueOf(t, tc.input), tc.target)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("Expected error %q, got %v", tc.err, err)
+				}
+			} else if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if tc.output != nil && !reflect.DeepEqual(tc.target, tc.output) {
+				t.Fatalf("Expected %#v, got %#v", tc.output, tc.target)
+			}
+		})
+	}
+}
+
+// TestDecodeConsumesValue checks that items of an array can be decoded one by
+// one.
+func TestDecodeConsumesValue(t *testing.T) {
+	array, _ := valueOf(t, `[{"id": 1, "x": [1, 2]}, "skipped", {"id": 3}]`).AsArray()
+	var ids []int
+	for array.Advance() {
+		if _, ok := array.CurrentValue().AsObject(); !ok {
+			continue
+		}
+		var b Base
+		if err := iterator.Decode(array.CurrentValue(), &b); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		ids = append(ids, b.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Fatalf("Expected [1 3], got %v", ids)
+	}
+}
@@ -97,6 +97,7 @@ type Collection interface {
 	Advance() bool
 	Done() bool
 	Elided() bool
+	ElidedCount() int
 	CurrentValue() Value
 }
 
@@ -108,6 +109,9 @@ type collectionBase struct {
 	done    bool
 	elided  bool
 
+	// Number of elided items, or 0 if not known
+	elidedCount int
+
 	currentValue Value
 }
 
@@ -177,6 +181,13 @@ func (c *collectionBase) Elided() bool {
 	return c.elided
 }
 
+// ElidedCount returns the number of items which were elided from the
+// collection, or 0 if it is not known.  It is only meaningful once the
+// collection has been advanced to its end.
+func (c *collectionBase) ElidedCount() int {
+	return c.elidedCount
+}
+
 func (c *collectionBase) CurrentValue() Value {
 	if c.done {
 		panic("iterator done")
@@ -223,7 +234,11 @@ func (o *Object) Advance() bool {
 	}
 	item := o.stream.Next()
 	if item == nil {
-		panic("stream ended inside object - expected key")
+		// The stream was cut short (e.g. because the decoder producing it
+		// failed), so the rest of the object is missing.
+		o.elided = true
+		o.done = true
+		return false
 	}
 	switch v := item.(type) {
 	case *token.Scalar:
@@ -231,7 +246,9 @@ func (o *Object) Advance() bool {
 		o.currentKey = v
 		item := o.stream.Next()
 		if item == nil {
-			panic("stream ended inside obejct - expected value")
+			// The stream was cut short after the key, the object will be
+			// elided when advancing again.
+			item = token.NullScalar
 		}
 		o.currentValue = nextStreamedValue(item, o.stream)
 		return true
@@ -240,6 +257,7 @@ func (o *Object) Advance() bool {
 		return false
 	case *token.Elision:
 		o.elided = true
+		o.elidedCount = v.Count
 		// After this we expect o.done to be true
 		return o.Advance()
 	default:
@@ -343,14 +361,19 @@ func (a *Array) Advance() bool {
 	}
 	item := a.stream.Next()
 	if item == nil {
-		panic("stream ended inside array")
+		// The stream was cut short (e.g. because the decoder producing it
+		// failed), so the rest of the array is missing.
+		a.elided = true
+		a.done = true
+		return false
 	}
-	switch item.(type) {
+	switch v := item.(type) {
 	case *token.EndArray:
 		a.done = true
 		return false
 	case *token.Elision:
 		a.elided = true
+		a.elidedCount = v.Count
 		return a.Advance()
 		// After this we expect a.done to be true
 	default:
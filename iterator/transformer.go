@@ -15,13 +15,25 @@ func AsStreamTransformer(transformer ValueTransformer) token.StreamTransformer {
 	return &valueTransformerAdapter{valueTransformer: transformer}
 }
 
+// ValueTransformerOf returns the ValueTransformer that transformer was made
+// from with AsStreamTransformer, if any.
+func ValueTransformerOf(transformer token.StreamTransformer) (ValueTransformer, bool) {
+	adapter, ok := transformer.(*valueTransformerAdapter)
+	if !ok {
+		return nil, false
+	}
+	return adapter.valueTransformer, true
+}
+
 type valueTransformerAdapter struct {
 	valueTransformer ValueTransformer
 }
 
 func (f *valueTransformerAdapter) Transform(in <-chan token.Token, out token.WriteStream) {
-	iterator := New(token.ChannelReadStream(in))
+	stream := token.NewStreamErrorReader(in)
+	iterator := New(stream)
 	for iterator.Advance() {
 		f.valueTransformer.TransformValue(iterator.CurrentValue(), out)
 	}
+	stream.ForwardError(out)
 }
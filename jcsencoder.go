@@ -0,0 +1,183 @@
+package jsonstream
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A JCSEncoder outputs each value of a stream in the canonical form defined by
+// the JSON Canonicalization Scheme (RFC 8785), followed by a new line.  The
+// output of two values is the same if and only if they are equal (regardless
+// of the order of keys, the formatting of numbers or the escaping of strings),
+// which makes it suitable for hashing or comparing documents.  In canonical
+// form:
+//   - there is no whitespace;
+//   - object members are sorted by key, comparing keys as arrays of UTF-16 code
+//     units;
+//   - numbers are converted to float64 and formatted as JavaScript does, so
+//     integers larger than 2^53 may lose precision;
+//   - strings are only escaped where JSON requires it.
+//
+// Each object is held in memory until it ends, as its last member may come
+// first.  Arrays which are not inside an object are streamed.  The encoder
+// fails if an object's output exceeds MaxObjectSize bytes (approximately), on
+// numbers which are not finite or out of float64 range and on elided values,
+// which cannot be canonicalized.
+type JCSEncoder struct {
+	Printer
+	MaxObjectSize int // DefaultMaxObjectSize if 0 or less
+}
+
+var _ token.StreamSink = &JCSEncoder{}
+
+// ErrNotCanonicalizable is wrapped in the errors returned by a JCSEncoder when
+// a value has no canonical form.
+var ErrNotCanonicalizable = errors.New("value cannot be canonicalized")
+
+// Consume outputs the values in the given channel in canonical form.  It
+// returns an error if the Printer could not write or a value could not be
+// canonicalized.
+func (e *JCSEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	reader := token.NewStreamErrorReader(stream)
+	iter := iterator.New(reader)
+	for iter.Advance() {
+		e.writeValue(iter.CurrentValue())
+		e.Printer.Reset()
+	}
+	return reader.Err()
+}
+
+// writeValue outputs value in canonical form.  Arrays are streamed.
+func (e *JCSEncoder) writeValue(value iterator.Value) {
+	arr, ok := value.(*iterator.Array)
+	if !ok {
+		var size int
+		e.PrintBytes(e.appendValue(nil, value, &size))
+		return
+	}
+	e.PrintBytes(openArrayBytes)
+	for i := 0; arr.Advance(); i++ {
+		if i > 0 {
+			e.PrintBytes(commaBytes)
+		}
+		e.writeValue(arr.CurrentValue())
+	}
+	if arr.Elided() {
+		e.fail("elided array items")
+	}
+	e.PrintBytes(closeArrayBytes)
+}
+
+// appendValue appends value in canonical form to b.  size is the number of
+// bytes of the object being buffered so far.
+func (e *JCSEncoder) appendValue(b []byte, value iterator.Value, size *int) []byte {
+	start := len(b)
+	switch v := value.(type) {
+	case *iterator.Object:
+		b = e.appendObject(b, v, size)
+	case *iterator.Array:
+		b = append(b, '[')
+		for i := 0; v.Advance(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = e.appendValue(b, v.CurrentValue(), size)
+		}
+		if v.Elided() {
+			e.fail("elided array items")
+		}
+		b = append(b, ']')
+	case *iterator.Scalar:
+		b = e.appendScalar(b, v.Scalar())
+		e.addSize(size, len(b)-start)
+	default:
+		e.fail("invalid value %#v", value)
+	}
+	return b
+}
+
+func (e *JCSEncoder) appendObject(b []byte, obj *iterator.Object, size *int) []byte {
+	type member struct {
+		key   []uint16 // To sort by UTF-16 code units
+		bytes []byte   // The canonical key and value
+	}
+	var members []member
+	for obj.Advance() {
+		key, value := obj.CurrentKeyVal()
+		name := key.ToString()
+		mb := appendQuotedString(nil, name)
+		mb = append(mb, ':')
+		e.addSize(size, len(mb)+1) // Count the comma too
+		mb = e.appendValue(mb, value, size)
+		members = append(members, member{key: utf16.Encode([]rune(name)), bytes: mb})
+	}
+	if obj.Elided() {
+		e.fail("elided object members")
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return compareUTF16(members[i].key, members[j].key) < 0
+	})
+	b = append(b, '{')
+	for i, m := range members {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, m.bytes...)
+	}
+	return append(b, '}')
+}
+
+func (e *JCSEncoder) appendScalar(b []byte, scalar *token.Scalar) []byte {
+	switch scalar.Type() {
+	case token.String:
+		return appendQuotedString(b, scalar.ToString())
+	case token.Number:
+		x, err := strconv.ParseFloat(string(scalar.Bytes), 64)
+		switch {
+		case math.IsNaN(x) || math.IsInf(x, 0) && err == nil:
+			e.fail("non-finite number %s", scalar.Bytes)
+		case err != nil:
+			e.fail("number %s out of float64 range", scalar.Bytes)
+		}
+		return appendJSFloat(b, x)
+	default:
+		return append(b, scalar.Bytes...)
+	}
+}
+
+// addSize adds n to size, failing if it exceeds MaxObjectSize.
+func (e *JCSEncoder) addSize(size *int, n int) {
+	*size += n
+	maxSize := e.MaxObjectSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxObjectSize
+	}
+	if *size > maxSize {
+		panic(&PrinterError{Err: fmt.Errorf("object larger than %d bytes", maxSize)})
+	}
+}
+
+func (e *JCSEncoder) fail(format string, args ...any) {
+	panic(&PrinterError{Err: fmt.Errorf("%w: %s", ErrNotCanonicalizable, fmt.Sprintf(format, args...))})
+}
+
+// compareUTF16 compares two strings encoded as UTF-16 code units.
+func compareUTF16(a, b []uint16) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
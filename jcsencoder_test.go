@@ -0,0 +1,124 @@
+package jsonstream_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestJCSEncoder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "keys sorted at any depth",
+			input:  `{"b": [1, {"z": 1, "a": 2}], "a": {"d": null, "c": true}}`,
+			output: "{\"a\":{\"c\":true,\"d\":null},\"b\":[1,{\"a\":2,\"z\":1}]}\n",
+		},
+		{
+			name:   "keys sorted by UTF-16 code units",
+			input:  `{"\uffff": 1, "😀": 2, "é": 3, "\r": 4, "1": 5}`,
+			output: "{\"\\r\":4,\"1\":5,\"é\":3,\"😀\":2,\"\uffff\":1}\n",
+		},
+		{
+			name:   "numbers",
+			input:  `[1.0, 1e2, -0, 0.000001, 1e-7, 1e21, 123456789012345678901, 4.50]`,
+			output: "[1,100,0,0.000001,1e-7,1e+21,123456789012345680000,4.5]\n",
+		},
+		{
+			name:   "strings",
+			input:  `["\u0041\u00e9\/", "\u001f\t\"\\", "\ud83d\ude00"]`,
+			output: "[\"Aé/\",\"\\u001f\\t\\\"\\\\\",\"😀\"]\n",
+		},
+		{
+			name:   "several values",
+			input:  `{"b": 1, "a": 2} "x" 1E0 [] {}`,
+			output: "{\"a\":2,\"b\":1}\n\"x\"\n1\n[]\n{}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			encoder := &jsonstream.JCSEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b}}
+			if err := token.ConsumeStream(streamJSONString(c.input), encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := b.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJCSEncoderErrors(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		stream []token.Token
+		err    string
+		canon  bool // Whether err wraps ErrNotCanonicalizable
+	}
+	var testCases = []testCase{
+		{
+			name:  "out of range number",
+			input: `[1e400]`,
+			err:   "number 1e400 out of float64 range",
+			canon: true,
+		},
+		{
+			name: "non-finite number",
+			stream: []token.Token{
+				token.NewScalar(token.Number, []byte("NaN")),
+			},
+			err:   "non-finite number NaN",
+			canon: true,
+		},
+		{
+			name: "elided members",
+			stream: []token.Token{
+				&token.StartObject{},
+				&token.Elision{},
+				&token.EndObject{},
+			},
+			err:   "elided object members",
+			canon: true,
+		},
+		{
+			name:  "object too large",
+			input: `{"a": "` + strings.Repeat("x", 100) + `"}`,
+			err:   "object larger than 100 bytes",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var stream <-chan token.Token
+			if c.stream != nil {
+				ch := make(chan token.Token, len(c.stream))
+				for _, tok := range c.stream {
+					ch <- tok
+				}
+				close(ch)
+				stream = ch
+			} else {
+				stream = streamJSONString(c.input)
+			}
+			encoder := &jsonstream.JCSEncoder{
+				Printer:       &jsonstream.DefaultPrinter{Writer: &strings.Builder{}},
+				MaxObjectSize: 100,
+			}
+			err := token.ConsumeStream(stream, encoder)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("Expected error %q, got %v", c.err, err)
+			}
+			if errors.Is(err, jsonstream.ErrNotCanonicalizable) != c.canon {
+				t.Fatalf("Expected errors.Is(err, ErrNotCanonicalizable) to be %t", c.canon)
+			}
+		})
+	}
+}
@@ -30,9 +30,13 @@ import (
 type JPVDecoder struct {
 	scanr    *scanner.Scanner
 	lastPath []*token.Scalar
+	queue    valueQueue
 }
 
-var _ token.StreamSource = &JPVDecoder{}
+var (
+	_ token.StreamSource = &JPVDecoder{}
+	_ token.TokenSource  = &JPVDecoder{}
+)
 
 // NewJPVDecoder sets up a new GRONDecoder instance to read from the given
 // input.
@@ -43,22 +47,34 @@ func NewJPVDecoder(in io.Reader) *JPVDecoder {
 // Produce reads a stream of JPV values and streams them, until it runs out of
 // input or encounters invalid JPV, in which case it will return an error.
 func (d *JPVDecoder) Produce(out chan<- token.Token) error {
+	return produceValues(d, out)
+}
+
+// NextToken returns the next token in the stream, or io.EOF when there is no
+// more input.  The tokens of each line are buffered.
+func (d *JPVDecoder) NextToken() (token.Token, error) {
+	return d.queue.nextToken(d)
+}
+
+// decodeValue reads one line (so it does not always stream a whole value).  At
+// the end of the input or on error, it closes the values which are still open.
+func (d *JPVDecoder) decodeValue(out token.WriteStream) (more bool, err error) {
 	defer func() {
-		unwindPath(d.lastPath, false, out)
-	}()
-	for {
-		b, err := d.scanr.SkipSpaceAndPeek()
-		if err != nil || b == scanner.EOF {
-			return err
-		}
-		err = d.parseLine(out)
-		if err != nil {
-			return err
+		if !more {
+			unwindPath(d.lastPath, false, out)
+			d.lastPath = nil
 		}
+	}()
+	b, err := d.scanr.SkipSpaceAndPeek()
+	if err != nil || b == scanner.EOF {
+		return false, err
 	}
+	err = d.parseLine(out)
+	return err == nil, err
 }
 
-func (d *JPVDecoder) parseLine(out chan<- token.Token) error {
+func (d *JPVDecoder) parseLine(out token.WriteStream) error {
+	pos := d.scanr.CurrentPos()
 	err := expectByte(d.scanr, '$')
 	if err != nil {
 		return err
@@ -77,14 +93,14 @@ func (d *JPVDecoder) parseLine(out chan<- token.Token) error {
 	}
 	err = d.updatePath(linePath, out)
 	if err != nil {
-		return err
+		return syntaxError(d.scanr, pos, "%s", err)
 	}
 	// TODO: tidy this up
 	jsonDecoder := JSONDecoder{scanr: d.scanr}
 	return jsonDecoder.parseValue(out)
 }
 
-func (d *JPVDecoder) updatePath(newPath []*token.Scalar, out chan<- token.Token) error {
+func (d *JPVDecoder) updatePath(newPath []*token.Scalar, out token.WriteStream) error {
 	if len(d.lastPath) == 0 {
 		followPath(newPath, false, out)
 		d.lastPath = newPath
@@ -117,14 +133,14 @@ func (d *JPVDecoder) updatePath(newPath []*token.Scalar, out chan<- token.Token)
 	return nil
 }
 
-func unwindPath(path []*token.Scalar, inCollection bool, out chan<- token.Token) {
+func unwindPath(path []*token.Scalar, inCollection bool, out token.WriteStream) {
 	for i := len(path) - 1; i >= 0; i-- {
 		if i > 0 || !inCollection {
 			switch path[i].Type() {
 			case token.String:
-				out <- &token.EndObject{}
+				out.Put(&token.EndObject{})
 			case token.Number:
-				out <- &token.EndArray{}
+				out.Put(&token.EndArray{})
 			default:
 				panic("invalid key type (must be string or number)")
 			}
@@ -132,17 +148,17 @@ func unwindPath(path []*token.Scalar, inCollection bool, out chan<- token.Token)
 	}
 }
 
-func followPath(path []*token.Scalar, inCollection bool, out chan<- token.Token) {
+func followPath(path []*token.Scalar, inCollection bool, out token.WriteStream) {
 	for _, key := range path {
 		switch key.Type() {
 		case token.String:
 			if !inCollection {
-				out <- &token.StartObject{}
+				out.Put(&token.StartObject{})
 			}
-			out <- key
+			out.Put(key)
 		case token.Number:
 			if !inCollection {
-				out <- &token.StartArray{}
+				out.Put(&token.StartArray{})
 			}
 		default:
 			panic("invalid key type (must be string or number)")
@@ -187,10 +203,13 @@ func parsePath(scanr *scanner.Scanner) ([]*token.Scalar, error) {
 					scanr.Back()
 					return nil, unexpectedByte(scanr, "expected digit, got")
 				}
+				scanr.Back()
 				path = append(path, token.NewKey(token.Number, scanr.EndToken()))
+				b, _ = scanr.Read()
 			}
 			if b != ']' {
-				return nil, errors.New("syntax error: expected ']'")
+				scanr.Back()
+				return nil, unexpectedByte(scanr, "expected ']', got")
 			}
 		case b == '.':
 			scanr.StartToken()
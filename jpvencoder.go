@@ -31,13 +31,14 @@ var _ token.StreamSink = &JPVEncoder{}
 // operation.  A typical example is if it attempt to write to a closed pipe.
 func (e *JPVEncoder) Consume(stream <-chan token.Token) (err error) {
 	defer CatchPrinterError(&err)
-	iterator := iterator.New(token.ChannelReadStream(stream))
+	reader := token.NewStreamErrorReader(stream)
+	iterator := iterator.New(reader)
 	for iterator.Advance() {
 		e.writeValue(iterator.CurrentValue())
 		e.path = e.path[:0]
 		e.Reset()
 	}
-	return nil
+	return reader.Err()
 }
 
 func (e *JPVEncoder) writeValue(value iterator.Value) {
@@ -1,6 +1,7 @@
 package jsonstream
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 
@@ -9,11 +10,109 @@ import (
 )
 
 // A JSONDecoder reads JSON input and streams it into a JSON stream.
+//
+// If InternKeys is true, the decoder keeps a cache of the object keys it has
+// seen so that repeated keys are streamed as the same *token.Scalar instance
+// instead of allocating a new one each time (scalars are never mutated once
+// streamed).  This saves allocations and memory when decoding large datasets
+// of similarly shaped objects.  The cache is bounded in size so it is
+// ineffective but harmless when keys are arbitrary (e.g. ids).
+//
+// If MaxKeyLength is positive, the decoder fails when it reads an object key
+// longer than MaxKeyLength bytes (as written in the input, escape sequences
+// included), without reading the rest of the key.  This guards against
+// adversarial input.  It does not apply to other strings.
+//
+// If OnSkippedError is not nil, the decoder recovers from errors in JSON Lines
+// input instead of stopping: the value being read is dropped, OnSkippedError is
+// called with the error and decoding resumes at the start of the next line.
+// If the error is found on a later line than the one where the value began
+// (i.e. the value was cut short), decoding resumes at the start of the line
+// where the error is found instead.  So that no part of a dropped value is
+// streamed, each top-level value is buffered until it is complete.  Errors
+// reading the input still stop the decoder.
+//
+// DuplicateKeys says what to do with objects which have several members with
+// the same key (see DuplicateKeyPolicy).  By default they are all streamed.
+//
+// The remaining options relax the JSON syntax, which is useful to process hand
+// written files such as configuration files (they are all enabled for JSON5
+// input, although other JSON5 extensions like hexadecimal numbers are not
+// supported).
+//   - AllowComments allows "// ..." comments until the end of the line and
+//     "/* ... */" comments wherever whitespace is allowed.
+//   - AllowTrailingCommas allows a comma after the last item of arrays and
+//     objects.
+//   - AllowSingleQuotes allows strings (including keys) to be enclosed in single
+//     quotes.  In these, "\'" is an escaped single quote.
+//   - AllowUnquotedKeys allows object keys to be identifiers without quotes,
+//     made of letters, digits, "_" and "$" (and non-ASCII characters), not
+//     starting with a digit.
+//   - AllowNonFinite allows the number literals NaN, Infinity and -Infinity,
+//     which Python's json module and some APIs output.  They are streamed as
+//     they are, so the encoder needs to be told what to do with them (see
+//     JSONEncoder.NonFinite).
 type JSONDecoder struct {
+	InternKeys     bool
+	MaxKeyLength   int
+	OnSkippedError func(error)
+	DuplicateKeys  DuplicateKeyPolicy
+
+	AllowComments       bool
+	AllowTrailingCommas bool
+	AllowSingleQuotes   bool
+	AllowUnquotedKeys   bool
+	AllowNonFinite      bool
+
 	scanr *scanner.Scanner
+	keys  map[string]*token.Scalar
+
+	stack  []byte // '[' or '{' for each array or object being read
+	expect uint8  // What is expected next (see jsonExpectValue etc.)
+	err    error  // The error returned by NextToken, if any
+
+	value     []token.Token // Tokens of the current value (with OnSkippedError)
+	complete  []token.Token // Tokens of a complete value still to be returned
+	skipPos   scanner.Pos   // Where the last error was skipped
+	valueLine int           // Line where the current value began (with OnSkippedError)
+
+	keyPos   scanner.Pos           // Where the last key read started
+	keySets  []map[string]struct{} // Keys seen in each open object (nil for arrays)
+	objDepth int                   // Depth of the object being buffered (with DuplicateKeysLastWins)
+	object   []token.Token         // Tokens of the object being buffered
+	deduped  []token.Token         // Tokens of a deduplicated object still to be returned
 }
 
-var _ token.StreamSource = &JSONDecoder{}
+// A DuplicateKeyPolicy tells a JSONDecoder what to do when an object has
+// several members with the same key.  JSON parsers disagree about what this
+// means, and JSONPath name selectors only select one of them unless told
+// otherwise.
+type DuplicateKeyPolicy uint8
+
+const (
+	// Stream all the members (this is the default).
+	DuplicateKeysKeepAll DuplicateKeyPolicy = iota
+
+	// Drop the members whose key was already seen in the object.
+	DuplicateKeysFirstWins
+
+	// Keep the value of the last member with a given key, at the position of
+	// the first one (like JavaScript's JSON.parse).  As the last value is only
+	// known when the object ends, each object is buffered in memory (arrays
+	// which are not inside an object are still streamed).
+	DuplicateKeysLastWins
+
+	// Fail with an error on the first duplicate key.
+	DuplicateKeysError
+)
+
+// Maximum number of keys that a JSONDecoder interns.
+const maxInternedKeys = 4096
+
+var (
+	_ token.StreamSource = &JSONDecoder{}
+	_ token.TokenSource  = &JSONDecoder{}
+)
 
 // NewJSONDecoder sets up a new JSONDecoder instance to read from the giver input.
 func NewJSONDecoder(in io.Reader) *JSONDecoder {
@@ -25,165 +124,519 @@ func NewJSONDecoder(in io.Reader) *JSONDecoder {
 // error.
 func (d *JSONDecoder) Produce(out chan<- token.Token) error {
 	for {
-		b, err := d.scanr.SkipSpaceAndPeek()
-		if err != nil || b == scanner.EOF {
-			return err
-		}
-		err = d.parseValue(out)
+		tok, err := d.NextToken()
 		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
 			return err
 		}
+		out <- tok
 	}
 }
 
-// parseValue reads a single JSON value and streams it.  It can return a
-// non-nil error if the input is invalid JSON.
-func (d *JSONDecoder) parseValue(out chan<- token.Token) error {
-	b, err := d.scanr.SkipSpaceAndPeek()
+// NextToken returns the next token in the stream, or io.EOF when there is no
+// more input.  Unlike other decoders, the JSONDecoder does not buffer values:
+// it reads just enough input to return the next token.
+func (d *JSONDecoder) NextToken() (token.Token, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	var tok token.Token
+	var err error
+	if d.OnSkippedError != nil {
+		tok, err = d.nextTokenSkippingErrors()
+	} else {
+		tok, err = d.nextTokenWithPolicy()
+	}
 	if err != nil {
-		return err
+		d.err = err
 	}
-	if b == scanner.EOF {
-		return io.EOF
+	return tok, err
+}
+
+// nextTokenSkippingErrors returns the next token when OnSkippedError is set,
+// buffering top-level values until they are complete.
+func (d *JSONDecoder) nextTokenSkippingErrors() (token.Token, error) {
+	if len(d.complete) > 0 {
+		tok := d.complete[0]
+		d.complete = d.complete[1:]
+		return tok, nil
 	}
-	switch b {
-	case '"':
-		s, err := parseString(d.scanr)
-		if err != nil {
-			return err
+	for {
+		tok, err := d.nextTokenWithPolicy()
+		if err == io.EOF {
+			return nil, err
 		}
-		out <- s
-		return nil
-	case '[':
-		return d.parseArray(out)
-	case '{':
-		return d.parseObject(out)
-	case 't':
-		err := checkBytes(d.scanr, trueBytes)
 		if err != nil {
-			return err
+			if err := d.skipLine(len(d.value) > 0); err != nil {
+				return nil, err
+			}
+			d.value = d.value[:0]
+			d.OnSkippedError(err)
+			continue
 		}
-		out <- trueInstance
+		if len(d.stack) > 0 {
+			if len(d.value) == 0 {
+				d.valueLine = d.scanr.CurrentPos().Line
+			}
+			d.value = append(d.value, tok)
+			continue
+		}
+		if len(d.value) == 0 {
+			// A top-level scalar
+			return tok, nil
+		}
+		d.complete = append(d.value, tok)
+		d.value = nil
+		return d.nextTokenSkippingErrors()
+	}
+}
+
+// skipLine resets the state of the decoder after an error and skips the rest of
+// the line where the error occurred (unless the error ended the line).  If
+// inValue is true and the value which failed began on an earlier line, it was
+// cut short and the line is where the next value starts, so decoding resumes
+// at the start of the line instead.
+func (d *JSONDecoder) skipLine(inValue bool) error {
+	d.scanr.CancelToken()
+	d.stack = d.stack[:0]
+	d.expect = jsonExpectValue
+	d.keySets = d.keySets[:0]
+	d.object = d.object[:0]
+	if inValue && d.scanr.CurrentPos().Line > d.valueLine && d.scanr.BackToLineStart() {
+		d.skipPos = d.scanr.CurrentPos()
 		return nil
-	case 'f':
-		err := checkBytes(d.scanr, falseBytes)
+	}
+	// If the error happened at the start of a line without reading anything,
+	// the line must be skipped so that the same error does not happen again.
+	if pos := d.scanr.CurrentPos(); pos.Col == 0 && pos != d.skipPos {
+		d.skipPos = pos
+		return nil
+	}
+	for {
+		b, err := d.scanr.Read()
 		if err != nil {
 			return err
 		}
-		out <- falseInstance
-		return nil
-	case 'n':
-		err := checkBytes(d.scanr, nullBytes)
+		if b == '\n' || b == scanner.EOF {
+			return nil
+		}
+	}
+}
+
+// What the JSONDecoder expects to read next.
+const (
+	jsonExpectValue     uint8 = iota // A value (or the end of input at the top level)
+	jsonExpectFirstItem              // An array item or ']'
+	jsonExpectItem                   // An array item after ','
+	jsonExpectItemSep                // ',' or ']'
+	jsonExpectFirstKey               // An object key or '}'
+	jsonExpectKey                    // An object key after ','
+	jsonExpectColon                  // ':' after an object key
+	jsonExpectMemberSep              // ',' or '}'
+)
+
+func (d *JSONDecoder) nextToken() (token.Token, error) {
+	for {
+		b, err := d.skipSpaceAndPeek()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		out <- nullInstance
-		return nil
-	default:
-		if b == '-' || b >= '0' && b <= '9' {
-			n, err := parseNumber(d.scanr)
+		switch d.expect {
+		case jsonExpectValue:
+			if b == scanner.EOF && len(d.stack) == 0 {
+				return nil, io.EOF
+			}
+			return d.startValue(b)
+		case jsonExpectFirstItem, jsonExpectItem:
+			if b == ']' && (d.expect == jsonExpectFirstItem || d.AllowTrailingCommas) {
+				d.scanr.Read()
+				return d.endValue(&token.EndArray{}), nil
+			}
+			return d.startValue(b)
+		case jsonExpectItemSep:
+			switch b {
+			case ']':
+				d.scanr.Read()
+				return d.endValue(&token.EndArray{}), nil
+			case ',':
+				d.scanr.Read()
+				d.expect = jsonExpectItem
+			default:
+				return nil, unexpectedByte(d.scanr, "expected ']' or ',', got")
+			}
+		case jsonExpectFirstKey, jsonExpectKey:
+			if b == '}' && (d.expect == jsonExpectFirstKey || d.AllowTrailingCommas) {
+				d.scanr.Read()
+				return d.endValue(&token.EndObject{}), nil
+			}
+			d.keyPos = d.scanr.CurrentPos()
+			key, err := d.parseKey()
 			if err != nil {
-				return err
+				return nil, err
+			}
+			d.expect = jsonExpectColon
+			return key, nil
+		case jsonExpectColon:
+			if b != ':' {
+				return nil, unexpectedByte(d.scanr, "expected ':', got")
+			}
+			d.scanr.Read()
+			d.expect = jsonExpectValue
+		case jsonExpectMemberSep:
+			switch b {
+			case '}':
+				d.scanr.Read()
+				return d.endValue(&token.EndObject{}), nil
+			case ',':
+				d.scanr.Read()
+				d.expect = jsonExpectKey
+			default:
+				return nil, unexpectedByte(d.scanr, "expected '}' or ',' got")
 			}
-			out <- n
-			return nil
 		}
-		return unexpectedByte(d.scanr, "unexpected")
 	}
 }
 
-func (d *JSONDecoder) parseArray(out chan<- token.Token) error {
-	var b byte
-	var err error
-	err = expectByte(d.scanr, '[')
-	if err != nil {
-		return err
-	}
-	out <- &token.StartArray{}
-	b, err = d.scanr.SkipSpaceAndPeek()
-	if err != nil {
-		return err
-	}
-	if b == ']' {
-		d.scanr.Read()
-		out <- &token.EndArray{}
-		return nil
+// nextTokenWithPolicy returns the next token, applying the DuplicateKeys
+// policy.
+func (d *JSONDecoder) nextTokenWithPolicy() (token.Token, error) {
+	switch d.DuplicateKeys {
+	case DuplicateKeysFirstWins, DuplicateKeysError:
+		return d.nextTokenUniqueKeys()
+	case DuplicateKeysLastWins:
+		return d.nextTokenLastWins()
+	default:
+		return d.nextToken()
 	}
+}
+
+// nextTokenUniqueKeys returns the next token, skipping the members whose key
+// was already seen in their object with DuplicateKeysFirstWins, or failing with
+// DuplicateKeysError.
+func (d *JSONDecoder) nextTokenUniqueKeys() (token.Token, error) {
 	for {
-		err = d.parseValue(out)
+		tok, err := d.nextToken()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		b, err = d.scanr.SkipSpaceAndPeek()
-		if err != nil {
+		switch t := tok.(type) {
+		case *token.StartObject:
+			d.keySets = append(d.keySets, map[string]struct{}{})
+		case *token.StartArray:
+			d.keySets = append(d.keySets, nil)
+		case *token.EndObject, *token.EndArray:
+			d.keySets = d.keySets[:len(d.keySets)-1]
+		case *token.Scalar:
+			if !t.IsKey() {
+				break
+			}
+			keys := d.keySets[len(d.keySets)-1]
+			key := t.ToString()
+			if _, ok := keys[key]; !ok {
+				keys[key] = struct{}{}
+				break
+			}
+			if d.DuplicateKeys == DuplicateKeysError {
+				return nil, syntaxError(d.scanr, d.keyPos, "duplicate key %q", key)
+			}
+			if err := d.skipMemberValue(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return tok, nil
+	}
+}
+
+// skipMemberValue reads the value of the object member whose key was just
+// read, without returning its tokens.
+func (d *JSONDecoder) skipMemberValue() error {
+	depth := len(d.stack)
+	for {
+		if _, err := d.nextToken(); err != nil {
 			return err
 		}
-		switch b {
-		case ']':
-			d.scanr.Read()
-			out <- &token.EndArray{}
+		if len(d.stack) == depth {
 			return nil
-		case ',':
-			d.scanr.Read()
-		default:
-			return unexpectedByte(d.scanr, "expected ']' or ',', got")
 		}
 	}
 }
 
-func (d *JSONDecoder) parseObject(out chan<- token.Token) error {
-	var b byte
-	err := expectByte(d.scanr, '{')
-	if err != nil {
-		return err
+// nextTokenLastWins returns the next token with DuplicateKeysLastWins,
+// buffering objects until they are complete so that their duplicate keys can
+// be removed.
+func (d *JSONDecoder) nextTokenLastWins() (token.Token, error) {
+	if len(d.deduped) > 0 {
+		tok := d.deduped[0]
+		d.deduped = d.deduped[1:]
+		return tok, nil
 	}
-	out <- &token.StartObject{}
-	b, err = d.scanr.SkipSpaceAndPeek()
-	if err != nil {
-		return err
+	for {
+		tok, err := d.nextToken()
+		if err != nil {
+			return nil, err
+		}
+		if len(d.object) == 0 {
+			if _, ok := tok.(*token.StartObject); !ok {
+				return tok, nil
+			}
+			d.objDepth = len(d.stack) - 1
+		}
+		d.object = append(d.object, tok)
+		if len(d.stack) == d.objDepth {
+			d.deduped, _ = appendLastWins(d.deduped[:0], d.object)
+			d.object = d.object[:0]
+			return d.nextTokenLastWins()
+		}
 	}
-	if b == '}' {
+}
+
+// appendLastWins appends the tokens of the value at the start of toks to out,
+// keeping only the last value of members with the same key in objects.  It
+// returns the new out and the tokens after the value.
+func appendLastWins(out, toks []token.Token) ([]token.Token, []token.Token) {
+	switch toks[0].(type) {
+	case *token.StartObject:
+		type member struct {
+			key   *token.Scalar
+			value []token.Token
+		}
+		var members []member
+		index := map[string]int{}
+		rest := toks[1:]
+		for {
+			if _, ok := rest[0].(*token.EndObject); ok {
+				break
+			}
+			key := rest[0].(*token.Scalar)
+			var value []token.Token
+			value, rest = appendLastWins(nil, rest[1:])
+			if i, ok := index[key.ToString()]; ok {
+				members[i].value = value
+			} else {
+				index[key.ToString()] = len(members)
+				members = append(members, member{key: key, value: value})
+			}
+		}
+		out = append(out, toks[0])
+		for _, m := range members {
+			out = append(out, m.key)
+			out = append(out, m.value...)
+		}
+		return append(out, rest[0]), rest[1:]
+	case *token.StartArray:
+		out = append(out, toks[0])
+		rest := toks[1:]
+		for {
+			if _, ok := rest[0].(*token.EndArray); ok {
+				return append(out, rest[0]), rest[1:]
+			}
+			out, rest = appendLastWins(out, rest)
+		}
+	default:
+		return append(out, toks[0]), toks[1:]
+	}
+}
+
+// startValue reads the start of a value whose first byte is b and returns its
+// first token.
+func (d *JSONDecoder) startValue(b byte) (token.Token, error) {
+	switch b {
+	case '[':
 		d.scanr.Read()
-		out <- &token.EndObject{}
-		return nil
+		d.stack = append(d.stack, '[')
+		d.expect = jsonExpectFirstItem
+		return &token.StartArray{}, nil
+	case '{':
+		d.scanr.Read()
+		d.stack = append(d.stack, '{')
+		d.expect = jsonExpectFirstKey
+		return &token.StartObject{}, nil
+	}
+	scalar, err := d.parseScalar(b)
+	if err != nil {
+		return nil, err
+	}
+	return d.endValue(scalar), nil
+}
+
+// endValue records that a value ended with tok, and returns tok.
+func (d *JSONDecoder) endValue(tok token.Token) token.Token {
+	switch tok.(type) {
+	case *token.EndArray, *token.EndObject:
+		d.stack = d.stack[:len(d.stack)-1]
 	}
+	switch {
+	case len(d.stack) == 0:
+		d.expect = jsonExpectValue
+	case d.stack[len(d.stack)-1] == '[':
+		d.expect = jsonExpectItemSep
+	default:
+		d.expect = jsonExpectMemberSep
+	}
+	return tok
+}
+
+// parseValue reads a single JSON value and streams it.  It can return a
+// non-nil error if the input is invalid JSON.  It must be called before any
+// other token is read.
+func (d *JSONDecoder) parseValue(out token.WriteStream) error {
 	for {
-		key, err := parseString(d.scanr)
+		tok, err := d.nextToken()
 		if err != nil {
 			return err
 		}
-		key.TypeAndFlags |= token.KeyMask
-		out <- key
-		b, err = d.scanr.SkipSpaceAndPeek()
-		if err != nil {
-			return err
+		out.Put(tok)
+		if len(d.stack) == 0 {
+			return nil
 		}
-		if b != ':' {
-			return unexpectedByte(d.scanr, "expected ':', got")
+	}
+}
+
+// parseScalar reads a scalar whose first byte is b.
+func (d *JSONDecoder) parseScalar(b byte) (*token.Scalar, error) {
+	switch b {
+	case '"':
+		return parseString(d.scanr)
+	case '\'':
+		if !d.AllowSingleQuotes {
+			return nil, unexpectedByte(d.scanr, "unexpected")
 		}
-		d.scanr.Read()
-		err = d.parseValue(out)
+		strBytes, flags, err := scanSingleQuotedString(d.scanr, 0)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		b, err = d.scanr.SkipSpaceAndPeek()
-		if err != nil {
-			return err
+		s := token.NewScalar(token.String, strBytes)
+		s.TypeAndFlags |= flags
+		return s, nil
+	case 't':
+		if err := checkBytes(d.scanr, trueBytes); err != nil {
+			return nil, err
 		}
-		switch b {
-		case '}':
-			d.scanr.Read()
-			out <- &token.EndObject{}
-			return nil
-		case ',':
-			d.scanr.Read()
-			_, err = d.scanr.SkipSpaceAndPeek()
+		return trueInstance, nil
+	case 'f':
+		if err := checkBytes(d.scanr, falseBytes); err != nil {
+			return nil, err
+		}
+		return falseInstance, nil
+	case 'n':
+		if err := checkBytes(d.scanr, nullBytes); err != nil {
+			return nil, err
+		}
+		return nullInstance, nil
+	case 'N', 'I':
+		if !d.AllowNonFinite {
+			return nil, unexpectedByte(d.scanr, "unexpected")
+		}
+		return parseNonFinite(d.scanr)
+	default:
+		if b == '-' && d.AllowNonFinite {
+			return parseNonFinite(d.scanr)
+		}
+		if b == '-' || b >= '0' && b <= '9' {
+			return parseNumber(d.scanr)
+		}
+		return nil, unexpectedByte(d.scanr, "unexpected")
+	}
+}
+
+// parseKey reads an object key, using the interned keys if InternKeys is true.
+func (d *JSONDecoder) parseKey() (*token.Scalar, error) {
+	keyBytes, flags, err := d.scanKey()
+	if err != nil {
+		return nil, err
+	}
+	if !d.InternKeys {
+		key := token.NewScalar(token.String, bytes.Clone(keyBytes))
+		key.TypeAndFlags |= flags | token.KeyMask
+		return key, nil
+	}
+	// This lookup does not allocate as the compiler optimises the conversion
+	// to string away.
+	if key, ok := d.keys[string(keyBytes)]; ok {
+		return key, nil
+	}
+	key := token.NewScalar(token.String, bytes.Clone(keyBytes))
+	key.TypeAndFlags |= flags | token.KeyMask
+	if d.keys == nil {
+		d.keys = make(map[string]*token.Scalar)
+	}
+	if len(d.keys) < maxInternedKeys {
+		d.keys[string(key.Bytes)] = key
+	}
+	return key, nil
+}
+
+// scanKey reads an object key and returns its bytes (as a JSON string) and
+// flags.  The returned bytes are only valid until the scanner advances.
+func (d *JSONDecoder) scanKey() ([]byte, uint8, error) {
+	b, err := d.scanr.Peek()
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case b == '\'' && d.AllowSingleQuotes:
+		return scanSingleQuotedString(d.scanr, d.MaxKeyLength)
+	case b != '"' && d.AllowUnquotedKeys:
+		return scanIdentifier(d.scanr, d.MaxKeyLength)
+	}
+	flags, err := scanString(d.scanr, d.MaxKeyLength)
+	if err != nil {
+		return nil, 0, err
+	}
+	return d.scanr.EndTokenNoCopy(), flags, nil
+}
+
+// skipSpaceAndPeek skips whitespace, and comments if AllowComments is true,
+// then returns the next byte without consuming it.
+func (d *JSONDecoder) skipSpaceAndPeek() (byte, error) {
+	for {
+		b, err := d.scanr.SkipSpaceAndPeek()
+		if err != nil || b != '/' || !d.AllowComments {
+			return b, err
+		}
+		if err := skipComment(d.scanr); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// skipComment reads a "//" or "/* */" comment.
+func skipComment(scanr *scanner.Scanner) error {
+	pos := scanr.CurrentPos()
+	scanr.Read()
+	b, err := scanr.Peek()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case '/':
+		for b != '\n' && b != scanner.EOF {
+			if b, err = scanr.Read(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case '*':
+		scanr.Read()
+		var prev byte
+		for {
+			b, err := scanr.Read()
 			if err != nil {
 				return err
 			}
-		default:
-			return unexpectedByte(d.scanr, "expected '}' or ',' got")
+			if b == scanner.EOF {
+				return syntaxError(scanr, pos, "unterminated comment")
+			}
+			if prev == '*' && b == '/' {
+				return nil
+			}
+			prev = b
 		}
+	default:
+		return unexpectedByte(scanr, "expected '/' or '*' after '/', got")
 	}
 }
 
@@ -199,6 +652,19 @@ func expectByte(scanr *scanner.Scanner, xb byte) error {
 	return nil
 }
 
+// syntaxError returns a *token.ParseError for an error at pos.
+func syntaxError(scanr *scanner.Scanner, pos scanner.Pos, format string, args ...any) error {
+	context, contextPos := scanr.Context(pos)
+	return &token.ParseError{
+		Line:       pos.Line + 1,
+		Col:        pos.Col + 1,
+		Offset:     pos.Offset,
+		Context:    context,
+		ContextPos: contextPos,
+		Msg:        fmt.Sprintf(format, args...),
+	}
+}
+
 func unexpectedByte(scanr *scanner.Scanner, expected string, args ...interface{}) error {
 	pos := scanr.CurrentPos()
 	b, err := scanr.Read()
@@ -206,73 +672,242 @@ func unexpectedByte(scanr *scanner.Scanner, expected string, args ...interface{}
 		return err
 	}
 	if b == scanner.EOF {
-		return fmt.Errorf("syntax error at L%d,C%d: %s: <EOF>", pos.Line+1, pos.Col+1, fmt.Sprintf(expected, args...))
+		return syntaxError(scanr, pos, "%s: <EOF>", fmt.Sprintf(expected, args...))
 	} else {
-		return fmt.Errorf("syntax error at L%d,C%d: %s: %q", pos.Line+1, pos.Col+1, fmt.Sprintf(expected, args...), b)
+		return syntaxError(scanr, pos, "%s: %q", fmt.Sprintf(expected, args...), b)
 	}
 }
 
 func parseString(scanr *scanner.Scanner) (*token.Scalar, error) {
-	scanr.StartToken()
-	err := expectByte(scanr, '"')
+	flags, err := scanString(scanr, 0)
 	if err != nil {
 		return nil, err
 	}
+	scalar := token.NewScalar(token.String, scanr.EndToken())
+	scalar.TypeAndFlags |= flags
+	return scalar, nil
+}
+
+// scanString reads a JSON string, recording it as the current token of the
+// scanner.  If successful, the caller should call scanr.EndToken() (or a
+// variant) to get its bytes.  The returned flags are the AlnumMask and
+// UnescapedMask flags which apply to the string.  If maxLen is positive, it
+// fails when the contents of the string is longer than maxLen bytes (which is
+// used to limit the length of keys).
+func scanString(scanr *scanner.Scanner, maxLen int) (uint8, error) {
+	pos := scanr.StartToken()
+	err := expectByte(scanr, '"')
+	if err != nil {
+		return 0, err
+	}
 	isAlnum := true
 	isUnescaped := true
-	firstChar := true
+	length := 0
 	for {
+		// Most of the string is usually plain characters, which are skipped in
+		// one go.
+		if run := scanr.ReadRun(plainStringBytes); len(run) > 0 {
+			if isAlnum {
+				isAlnum = isAlnumRun(run, length == 0)
+			}
+			length += len(run)
+			if maxLen > 0 && length > maxLen {
+				return 0, syntaxError(scanr, pos, "object key longer than %d bytes", maxLen)
+			}
+		}
 		b, err := scanr.Read()
 		if err != nil {
-			return nil, err
+			return 0, err
+		}
+		if b != '"' {
+			length++
+		}
+		if maxLen > 0 && length > maxLen {
+			return 0, syntaxError(scanr, pos, "object key longer than %d bytes", maxLen)
 		}
 		switch b {
 		case '\\':
 			isUnescaped = false
 			x, err := scanr.Read()
 			if err != nil {
-				return nil, err
+				return 0, err
 			}
+			length++
 			switch x {
 			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
 				continue
 			case 'u':
+				length += 4
 				for i := 0; i < 4; i++ {
 					b, err = scanr.Read()
 					if err != nil {
-						return nil, err
+						return 0, err
 					}
 					if !(b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F') {
 						scanr.Back()
-						return nil, unexpectedByte(scanr, "expected hex, got")
+						return 0, unexpectedByte(scanr, "expected hex, got")
 					}
 				}
 			}
 		case '"':
-			stringBytes := scanr.EndToken()
-			scalar := token.NewScalar(token.String, stringBytes)
+			var flags uint8
 			if isAlnum {
-				scalar.TypeAndFlags |= token.AlnumMask
+				flags |= token.AlnumMask
 			}
 			if isUnescaped {
-				scalar.TypeAndFlags |= token.UnescapedMask
+				flags |= token.UnescapedMask
 			}
-			return scalar, nil
+			return flags, nil
 		default:
+			if b == scanner.EOF {
+				return 0, syntaxError(scanr, pos, "unterminated string")
+			}
 			if isctrl(b) {
 				scanr.Back()
-				return nil, unexpectedByte(scanr, "invalid control character in string")
+				return 0, unexpectedByte(scanr, "invalid control character in string")
 			}
 			if isAlnum {
-				if firstChar {
+				isAlnum = isAlnumRun([]byte{b}, length == 1)
+			}
+		}
+	}
+}
+
+// plainStringBytes contains the bytes which stand for themselves in a JSON
+// string, i.e. all but '"', '\\' and control characters (and scanner.EOF).
+var plainStringBytes = scanner.NewByteSet(func(b byte) bool {
+	return b != '"' && b != '\\' && !isctrl(b) && b != scanner.EOF
+})
+
+// isAlnumRun returns true if all the bytes in run are alphanumeric and, if it
+// is at the start of a string, the first one is alphabetic.
+func isAlnumRun(run []byte, atStart bool) bool {
+	if atStart && !isalpha(run[0]) {
+		return false
+	}
+	for _, b := range run {
+		if !isalnum(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// scanSingleQuotedString reads a string enclosed in single quotes and returns
+// it converted to a JSON string, with its flags.  If maxLen is positive, it
+// fails when the contents of the string is longer than maxLen bytes (as for
+// scanString).
+func scanSingleQuotedString(scanr *scanner.Scanner, maxLen int) ([]byte, uint8, error) {
+	pos := scanr.CurrentPos()
+	err := expectByte(scanr, '\'')
+	if err != nil {
+		return nil, 0, err
+	}
+	strBytes := []byte{'"'}
+	isAlnum := true
+	isUnescaped := true
+	length := 0
+	for {
+		b, err := scanr.Read()
+		if err != nil {
+			return nil, 0, err
+		}
+		if b != '\'' {
+			length++
+		}
+		if maxLen > 0 && length > maxLen {
+			return nil, 0, syntaxError(scanr, pos, "object key longer than %d bytes", maxLen)
+		}
+		switch b {
+		case '\'':
+			var flags uint8
+			if isAlnum {
+				flags |= token.AlnumMask
+			}
+			if isUnescaped {
+				flags |= token.UnescapedMask
+			}
+			return append(strBytes, '"'), flags, nil
+		case '"':
+			isAlnum, isUnescaped = false, false
+			strBytes = append(strBytes, '\\', '"')
+		case '\\':
+			isAlnum, isUnescaped = false, false
+			x, err := scanr.Read()
+			if err != nil {
+				return nil, 0, err
+			}
+			length++
+			switch x {
+			case '\'':
+				strBytes = append(strBytes, '\'')
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+				strBytes = append(strBytes, '\\', x)
+			case 'u':
+				length += 4
+				strBytes = append(strBytes, '\\', 'u')
+				for i := 0; i < 4; i++ {
+					b, err = scanr.Read()
+					if err != nil {
+						return nil, 0, err
+					}
+					if !(b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F') {
+						scanr.Back()
+						return nil, 0, unexpectedByte(scanr, "expected hex, got")
+					}
+					strBytes = append(strBytes, b)
+				}
+			default:
+				scanr.Back()
+				return nil, 0, unexpectedByte(scanr, "invalid escape sequence, got")
+			}
+		default:
+			if isctrl(b) || b == scanner.EOF {
+				scanr.Back()
+				return nil, 0, unexpectedByte(scanr, "invalid character in string")
+			}
+			if isAlnum {
+				if len(strBytes) == 1 {
 					isAlnum = isalpha(b)
-					firstChar = false
 				} else {
 					isAlnum = isalnum(b)
 				}
 			}
+			strBytes = append(strBytes, b)
+		}
+	}
+}
+
+// scanIdentifier reads an unquoted object key and returns it as a JSON string,
+// with its flags.  If maxLen is positive, it fails when the key is longer than
+// maxLen bytes.
+func scanIdentifier(scanr *scanner.Scanner, maxLen int) ([]byte, uint8, error) {
+	pos := scanr.CurrentPos()
+	keyBytes := []byte{'"'}
+	isAlnum := true
+	for {
+		b, err := scanr.Read()
+		if err != nil {
+			return nil, 0, err
+		}
+		if !(isalnum(b) || b == '$' || b >= 0x80 && b != scanner.EOF) || len(keyBytes) == 1 && isdigit(b) {
+			scanr.Back()
+			break
+		}
+		if maxLen > 0 && len(keyBytes) > maxLen {
+			return nil, 0, syntaxError(scanr, pos, "object key longer than %d bytes", maxLen)
 		}
+		isAlnum = isAlnum && isalnum(b)
+		keyBytes = append(keyBytes, b)
 	}
+	if len(keyBytes) == 1 {
+		return nil, 0, unexpectedByte(scanr, "expected key, got")
+	}
+	var flags uint8 = token.UnescapedMask
+	if isAlnum {
+		flags |= token.AlnumMask
+	}
+	return append(keyBytes, '"'), flags, nil
 }
 
 func parseNumber(scanr *scanner.Scanner) (*token.Scalar, error) {
@@ -338,9 +973,43 @@ func parseNumber(scanr *scanner.Scanner) (*token.Scalar, error) {
 	return token.NewScalar(token.Number, scanr.EndToken()), nil
 }
 
+// parseNonFinite reads a number which may be NaN, Infinity or -Infinity.
+func parseNonFinite(scanr *scanner.Scanner) (*token.Scalar, error) {
+	b, err := scanr.Read()
+	if err != nil {
+		return nil, err
+	}
+	switch b {
+	case 'N':
+		if err := checkBytes(scanr, nanBytes[1:]); err != nil {
+			return nil, err
+		}
+		return nanInstance, nil
+	case 'I':
+		if err := checkBytes(scanr, infinityBytes[1:]); err != nil {
+			return nil, err
+		}
+		return infinityInstance, nil
+	}
+	// b is '-'
+	b, err = scanr.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if b != 'I' {
+		scanr.Back()
+		return parseNumber(scanr)
+	}
+	if err := checkBytes(scanr, infinityBytes); err != nil {
+		return nil, err
+	}
+	return minusInfinityInstance, nil
+}
+
 func readDigits(scanr *scanner.Scanner) (byte, int, error) {
 	var n int
 	for {
+		n += len(scanr.ReadRun(digitBytes))
 		b, err := scanr.Read()
 		if err != nil {
 			return 0, n, err
@@ -352,6 +1021,8 @@ func readDigits(scanr *scanner.Scanner) (byte, int, error) {
 	}
 }
 
+var digitBytes = scanner.NewByteSet(isdigit[byte])
+
 func checkBytes(scanr *scanner.Scanner, expected []byte) error {
 	for _, xb := range expected {
 		if err := expectByte(scanr, xb); err != nil {
@@ -365,10 +1036,17 @@ var (
 	trueBytes  = []byte("true")
 	falseBytes = []byte("false")
 	nullBytes  = []byte("null")
+
+	nanBytes      = []byte("NaN")
+	infinityBytes = []byte("Infinity")
 )
 
 var (
 	trueInstance  = token.NewScalar(token.Boolean, trueBytes)
 	falseInstance = token.NewScalar(token.Boolean, falseBytes)
 	nullInstance  = token.NewScalar(token.Null, nullBytes)
+
+	nanInstance           = token.NewScalar(token.Number, nanBytes)
+	infinityInstance      = token.NewScalar(token.Number, infinityBytes)
+	minusInfinityInstance = token.NewScalar(token.Number, []byte("-Infinity"))
 )
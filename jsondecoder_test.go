@@ -0,0 +1,533 @@
+package jsonstream_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestJSONDecoderInternKeys(t *testing.T) {
+	input := `[{"id": 1, "name": "a", "tags": {"id": "x"}}, {"id": 2, "name": "b\"c", "na\"me": null}, {"": 3}]`
+	decode := func(internKeys bool) []token.Token {
+		decoder := jsonstream.NewJSONDecoder(strings.NewReader(input))
+		decoder.InternKeys = internKeys
+		var toks []token.Token
+		for tok := range token.StartStream(decoder, func(err error) { t.Fatalf("Unexpected error: %s", err) }) {
+			toks = append(toks, tok)
+		}
+		return toks
+	}
+	expected := decode(false)
+	got := decode(true)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), len(got))
+	}
+	keys := map[string]*token.Scalar{}
+	for i, tok := range got {
+		if tok.String() != expected[i].String() {
+			t.Fatalf("Token %d: expected %s, got %s", i, expected[i], tok)
+		}
+		scalar, ok := tok.(*token.Scalar)
+		if !ok {
+			continue
+		}
+		if scalar.TypeAndFlags != expected[i].(*token.Scalar).TypeAndFlags {
+			t.Fatalf("Token %d: expected flags %d, got %d", i, expected[i].(*token.Scalar).TypeAndFlags, scalar.TypeAndFlags)
+		}
+		if !scalar.IsKey() {
+			continue
+		}
+		if prev, ok := keys[string(scalar.Bytes)]; ok && prev != scalar {
+			t.Fatalf("Token %d: key %s is not interned", i, scalar)
+		}
+		keys[string(scalar.Bytes)] = scalar
+	}
+}
+
+func BenchmarkJSONDecoderInternKeys(b *testing.B) {
+	var input strings.Builder
+	input.WriteString("[")
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			input.WriteString(",")
+		}
+		fmt.Fprintf(&input, `{"id": %d, "first_name": "John", "last_name": "Doe", "address": {"street": "Main", "city": "Town"}}`, i)
+	}
+	input.WriteString("]")
+	for _, internKeys := range []bool{false, true} {
+		b.Run(fmt.Sprintf("InternKeys=%t", internKeys), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				decoder := jsonstream.NewJSONDecoder(strings.NewReader(input.String()))
+				decoder.InternKeys = internKeys
+				for range token.StartStream(decoder, nil) {
+				}
+			}
+		})
+	}
+}
+
+func TestJSONDecoderMaxKeyLength(t *testing.T) {
+	type testCase struct {
+		name       string
+		input      string
+		internKeys bool
+		output     string
+		err        string
+	}
+	var testCases = []testCase{
+		{
+			name:   "key at the limit",
+			input:  `{"abcde": 1, "x": "a long string value"}`,
+			output: `{"abcde": 1,"x": "a long string value"}`,
+		},
+		{
+			name:  "key over the limit",
+			input: `{"x": 1,` + "\n" + ` "abcdef": 2}`,
+			err:   "syntax error at L2,C2: object key longer than 5 bytes",
+		},
+		{
+			name:       "key over the limit with interned keys",
+			input:      `{"abcdef": 2}`,
+			internKeys: true,
+			err:        "syntax error at L1,C2: object key longer than 5 bytes",
+		},
+		{
+			name:  "escape sequences count in full",
+			input: `{"a\u00e9": 1}`,
+			err:   "syntax error at L1,C2: object key longer than 5 bytes",
+		},
+		{
+			name:   "string values are not limited",
+			input:  `["abcdefghij", {"k": "abcdefghij"}] "abcdefghij"`,
+			output: "[\"abcdefghij\",{\"k\": \"abcdefghij\"}]\n\"abcdefghij\"",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewJSONDecoder(strings.NewReader(c.input))
+			decoder.MaxKeyLength = 5
+			decoder.InternKeys = c.internKeys
+			got, err := decodeToJSONString(t, decoder)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Unexpected error: %s", err)
+			case c.err == "" && got != c.output+"\n":
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			case c.err != "" && err == nil:
+				t.Fatalf("Expected error %q", c.err)
+			case c.err != "" && err.Error() != c.err:
+				t.Fatalf("Expected error %q, got %q", c.err, err)
+			}
+		})
+	}
+}
+
+func TestJSONDecoderRelaxedSyntax(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		relaxed bool
+		output  string
+		err     string
+	}
+	var testCases = []testCase{
+		{
+			name:    "line comments",
+			input:   "// header\n[1, // one\n2] // end",
+			relaxed: true,
+			output:  "[1,2]",
+		},
+		{
+			name:    "block comments",
+			input:   `/* a */ {/* b */"x"/* c */: /* d */ 1 /* e */}`,
+			relaxed: true,
+			output:  `{"x": 1}`,
+		},
+		{
+			name:    "unterminated comment",
+			input:   "[1] /* oops",
+			relaxed: true,
+			err:     "syntax error at L1,C5: unterminated comment",
+		},
+		{
+			name:    "lone slash",
+			input:   "[1, /2]",
+			relaxed: true,
+			err:     `syntax error at L1,C6: expected '/' or '*' after '/', got: '2'`,
+		},
+		{
+			name:  "unterminated string",
+			input: `["abc`,
+			err:   "syntax error at L1,C2: unterminated string",
+		},
+		{
+			name:  "control character in string",
+			input: "[\"ab\tc\"]",
+			err:   `syntax error at L1,C5: invalid control character in string: '\t'`,
+		},
+		{
+			name:  "comments not allowed",
+			input: "[1] // end",
+			err:   `syntax error at L1,C5: unexpected: '/'`,
+		},
+		{
+			name:    "trailing commas",
+			input:   `[1, 2, ] {"a": [], "b": {},}`,
+			relaxed: true,
+			output:  "[1,2]\n{\"a\": [],\"b\": {}}",
+		},
+		{
+			name:    "empty item is still invalid",
+			input:   `[1,,]`,
+			relaxed: true,
+			err:     `syntax error at L1,C4: unexpected: ','`,
+		},
+		{
+			name:  "trailing commas not allowed",
+			input: `[1, 2,]`,
+			err:   `syntax error at L1,C7: unexpected: ']'`,
+		},
+		{
+			name:    "single quotes",
+			input:   `['it\'s', 'say "hi"', '\u00e9\n']`,
+			relaxed: true,
+			output:  `["it's","say \"hi\"","\u00e9\n"]`,
+		},
+		{
+			name:  "single quotes not allowed",
+			input: `['a']`,
+			err:   `syntax error at L1,C2: unexpected: '\''`,
+		},
+		{
+			name:    "unquoted keys",
+			input:   `{a: 1, $b_2: 2, 'c d': 3, "e": 4}`,
+			relaxed: true,
+			output:  `{"a": 1,"$b_2": 2,"c d": 3,"e": 4}`,
+		},
+		{
+			name:    "key cannot start with a digit",
+			input:   `{1a: 1}`,
+			relaxed: true,
+			err:     `syntax error at L1,C2: expected key, got: '1'`,
+		},
+		{
+			name:  "unquoted keys not allowed",
+			input: `{a: 1}`,
+			err:   `syntax error at L1,C2: expected '"', got: 'a'`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewJSONDecoder(strings.NewReader(c.input))
+			decoder.AllowComments = c.relaxed
+			decoder.AllowTrailingCommas = c.relaxed
+			decoder.AllowSingleQuotes = c.relaxed
+			decoder.AllowUnquotedKeys = c.relaxed
+			got, err := decodeToJSONString(t, decoder)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Unexpected error: %s", err)
+			case c.err == "" && got != c.output+"\n":
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			case c.err != "" && err == nil:
+				t.Fatalf("Expected error %q", c.err)
+			case c.err != "" && err.Error() != c.err:
+				t.Fatalf("Expected error %q, got %q", c.err, err)
+			}
+		})
+	}
+}
+
+func TestJSONDecoderAllowNonFinite(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		allow  bool
+		output string
+		err    string
+	}
+	var testCases = []testCase{
+		{
+			name:   "python output",
+			input:  `{"a": NaN, "b": [Infinity, -Infinity, -1.5]}`,
+			allow:  true,
+			output: `{"a": NaN,"b": [Infinity,-Infinity,-1.5]}`,
+		},
+		{
+			name:   "top-level values",
+			input:  "NaN -Infinity -0 Infinity",
+			allow:  true,
+			output: "NaN\n-Infinity\n-0\nInfinity",
+		},
+		{
+			name:  "misspelt",
+			input: `[Inf]`,
+			allow: true,
+			err:   `syntax error at L1,C5: expected 'i', got: ']'`,
+		},
+		{
+			name:  "minus sign alone",
+			input: `[-]`,
+			allow: true,
+			err:   `syntax error at L1,C3: expected digit, got: ']'`,
+		},
+		{
+			name:  "not allowed",
+			input: `[NaN]`,
+			err:   `syntax error at L1,C2: unexpected: 'N'`,
+		},
+		{
+			name:  "negative not allowed",
+			input: `[-Infinity]`,
+			err:   `syntax error at L1,C3: expected digit, got: 'I'`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewJSONDecoder(strings.NewReader(c.input))
+			decoder.AllowNonFinite = c.allow
+			got, err := decodeToJSONString(t, decoder)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Unexpected error: %s", err)
+			case c.err == "" && got != c.output+"\n":
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			case c.err != "" && err == nil:
+				t.Fatalf("Expected error %q", c.err)
+			case c.err != "" && err.Error() != c.err:
+				t.Fatalf("Expected error %q, got %q", c.err, err)
+			}
+		})
+	}
+}
+
+func TestJSONDecoderDuplicateKeys(t *testing.T) {
+	type testCase struct {
+		name       string
+		input      string
+		policy     jsonstream.DuplicateKeyPolicy
+		skipErrors bool
+		output     string
+		err        string
+	}
+	input := `{"a": 1, "b": {"x": [1], "\u0078": 2}, "a": {"c": 3, "c": 4}} [{"a": 1, "a": [2]}, 3]`
+	var testCases = []testCase{
+		{
+			name:   "keep all",
+			input:  input,
+			output: "{\"a\": 1,\"b\": {\"x\": [1],\"\\u0078\": 2},\"a\": {\"c\": 3,\"c\": 4}}\n[{\"a\": 1,\"a\": [2]},3]",
+		},
+		{
+			name:   "first wins",
+			input:  input,
+			policy: jsonstream.DuplicateKeysFirstWins,
+			output: "{\"a\": 1,\"b\": {\"x\": [1]}}\n[{\"a\": 1},3]",
+		},
+		{
+			name:   "last wins",
+			input:  input,
+			policy: jsonstream.DuplicateKeysLastWins,
+			output: "{\"a\": {\"c\": 4},\"b\": {\"x\": 2}}\n[{\"a\": [2]},3]",
+		},
+		{
+			name:   "error",
+			input:  input,
+			policy: jsonstream.DuplicateKeysError,
+			err:    `syntax error at L1,C26: duplicate key "x"`,
+		},
+		{
+			name:   "keys in different objects",
+			input:  `{"a": {"a": 1}, "b": [{"a": 2}, {"a": 3}]}`,
+			policy: jsonstream.DuplicateKeysError,
+			output: `{"a": {"a": 1},"b": [{"a": 2},{"a": 3}]}`,
+		},
+		{
+			name:       "skip errors",
+			input:      "{\"a\": 1, \"a\": 2}\n{\"a\": 3}\n",
+			policy:     jsonstream.DuplicateKeysError,
+			skipErrors: true,
+			output:     `{"a": 3}`,
+		},
+		{
+			name:       "last wins with skipped errors",
+			input:      "{\"a\": 1, \"a\": [2,,]}\n{\"a\": 3, \"a\": 4}\n",
+			policy:     jsonstream.DuplicateKeysLastWins,
+			skipErrors: true,
+			output:     `{"a": 4}`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewJSONDecoder(strings.NewReader(c.input))
+			decoder.DuplicateKeys = c.policy
+			if c.skipErrors {
+				decoder.OnSkippedError = func(error) {}
+			}
+			got, err := decodeToJSONString(t, decoder)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Unexpected error: %s", err)
+			case c.err == "" && got != c.output+"\n":
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			case c.err != "" && err == nil:
+				t.Fatalf("Expected error %q", c.err)
+			case c.err != "" && err.Error() != c.err:
+				t.Fatalf("Expected error %q, got %q", c.err, err)
+			}
+		})
+	}
+}
+
+// BenchmarkJSONDecoder measures the throughput of the decoder on documents
+// dominated by strings, numbers and whitespace respectively.
+func BenchmarkJSONDecoder(b *testing.B) {
+	inputs := []struct {
+		name string
+		item string
+	}{
+		{"strings", `{"title": "The quick brown fox jumps over the lazy dog", "body": "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor.", "tag": "café \"quoted\""}`},
+		{"long strings", `"` + strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 20) + `"`},
+		{"numbers", `[1, 23.5, -456, 7.8e9, 0, 123456789, 3.14159, -0.5]`},
+		{"indented", "{\n    \"a\": [\n        1,\n        2\n    ],\n    \"b\": {\n        \"c\": null\n    }\n}"},
+	}
+	for _, input := range inputs {
+		var doc strings.Builder
+		doc.WriteString("[")
+		for doc.Len() < 1<<20 {
+			if doc.Len() > 1 {
+				doc.WriteString(",\n")
+			}
+			doc.WriteString(input.item)
+		}
+		doc.WriteString("]")
+		b.Run(input.name, func(b *testing.B) {
+			b.SetBytes(int64(doc.Len()))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				decoder := jsonstream.NewJSONDecoder(strings.NewReader(doc.String()))
+				for range token.StartStream(decoder, func(err error) { b.Fatal(err) }) {
+				}
+			}
+		})
+		b.Run(input.name+"/NextToken", func(b *testing.B) {
+			b.SetBytes(int64(doc.Len()))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				decoder := jsonstream.NewJSONDecoder(strings.NewReader(doc.String()))
+				for {
+					_, err := decoder.NextToken()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestJSONDecoderSkipErrors(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+		errs   []string
+	}
+	var testCases = []testCase{
+		{
+			name:   "no errors",
+			input:  "{\"a\": 1}\n[2]\n3\n",
+			output: "{\"a\": 1}\n[2]\n3\n",
+		},
+		{
+			name:   "bad records",
+			input:  "{\"a\": 1}\n{\"a\": [2,, 3]}\n[true]\n{\"a\" 4}\n\"ok\"\n",
+			output: "{\"a\": 1}\n[true]\n\"ok\"\n",
+			errs: []string{
+				"syntax error at L2,C10: unexpected: ','",
+				"syntax error at L4,C6: expected ':', got: '4'",
+			},
+		},
+		{
+			name:   "error at end of line",
+			input:  "[1, \"x\n[2]\n",
+			output: "[2]\n",
+			errs:   []string{"syntax error at L1,C7: invalid control character in string: '\\n'"},
+		},
+		{
+			name:   "error at start of line",
+			input:  "}\n[1]\n",
+			output: "[1]\n",
+			errs:   []string{"syntax error at L1,C1: unexpected: '}'"},
+		},
+		{
+			name:   "truncated last record",
+			input:  "[1]\n{\"a\": [1, 2",
+			output: "[1]\n",
+			errs:   []string{"syntax error at L2,C12: expected ']' or ',', got: <EOF>"},
+		},
+		{
+			name:   "record cut short",
+			input:  "{\"a\": {\"b\": 1,\n{\"c\": 3}\n",
+			output: "{\"c\": 3}\n",
+			errs:   []string{"syntax error at L2,C1: expected '\"', got: '{'"},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			decoder := jsonstream.NewJSONDecoder(strings.NewReader(c.input))
+			var errs []string
+			decoder.OnSkippedError = func(err error) {
+				errs = append(errs, err.Error())
+			}
+			got, err := decodeToJSONString(t, decoder)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+			if fmt.Sprint(errs) != fmt.Sprint(c.errs) {
+				t.Fatalf("Expected errors %q, got %q", c.errs, errs)
+			}
+		})
+	}
+}
+
+// TestJSONDecoderNextTokenIncremental checks that NextToken returns tokens
+// without waiting for the rest of the value.
+func TestJSONDecoderNextTokenIncremental(t *testing.T) {
+	r, w := io.Pipe()
+	go w.Write([]byte(`[{"a": 1`))
+	decoder := jsonstream.NewJSONDecoder(r)
+	for _, expected := range []string{"StartArray", "StartObject", `Scalar("a")`} {
+		tok, err := decoder.NextToken()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if tok.String() != expected {
+			t.Fatalf("Expected %s, got %s", expected, tok)
+		}
+	}
+	go w.Write([]byte(`}]`))
+	tok, err := decoder.NextToken()
+	if err != nil || tok.String() != "Scalar(1)" {
+		t.Fatalf("Expected Scalar(1), got %s, %v", tok, err)
+	}
+	w.Close()
+	for _, expected := range []string{"EndObject", "EndArray"} {
+		if tok, err := decoder.NextToken(); err != nil || tok.String() != expected {
+			t.Fatalf("Expected %s, got %s, %v", expected, tok, err)
+		}
+	}
+	if _, err := decoder.NextToken(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
@@ -1,7 +1,14 @@
 package jsonstream
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/arnodel/jsonstream/iterator"
 	"github.com/arnodel/jsonstream/token"
@@ -9,13 +16,76 @@ import (
 
 // A JSONEncoder can output a stream encoding a (stream of) JSON values
 // using the given Printer instance for formatting.
+// If FloatFormat is not 0, numbers which are not integer literals are
+// reformatted using it as the format of [strconv.FormatFloat] (i.e. 'g', 'f'
+// or 'e').
+// If StableFloatRepr is true, numbers which are not integer literals are
+// reformatted like JavaScript's Number.prototype.toString() would, which is
+// the representation used by most JSON tools (it takes precedence over
+// FloatFormat).
+// ArrayCompactWidth and ObjectCompactWidth are the maximum widths of compact
+// arrays and objects respectively.  If 0, CompactWidthLimit is used instead.
+// If RawStrings is true, top-level strings are output without quotes and
+// unescaped, which is useful to feed them to other commands.
+// If AlignValues is true, the keys of objects which are not output on a single
+// line are padded so that their values line up in a column.  This requires
+// buffering each such object until its last key is known (so it is not suitable
+// for very large objects).
+// If NoSpaceAfterColon is true, keys are followed by ":" instead of ": ".  If
+// NoSpaceAfterComma is true, items grouped on the same line are separated with
+// "," instead of ", ".
+// If QuoteIntegersOver is not 0, integer literals whose magnitude exceeds it
+// are output as strings.  Set it to MaxSafeInteger so that consumers which
+// parse numbers as float64 (e.g. JavaScript) do not lose precision.
+// If ElisionMarker is not empty, it is output instead of "..." in arrays and
+// objects whose number of elided items is known, with "%d" replaced by that
+// number (e.g. "…%d more" outputs {…47 more}).
+// If MaxStringLength is positive, string values longer than that many bytes
+// are cut and end with the number of bytes removed, e.g. "abc…(+12034 bytes)",
+// which also says if the string looks like base64 encoded data.  This keeps
+// documents containing huge strings readable in a terminal.
+// NonFinite says what to do with numbers which are NaN or infinite (see
+// NonFiniteNumbers), which are output as they are by default although they are
+// not valid JSON.
 type JSONEncoder struct {
 	Printer
 	*Colorizer
 	CompactWidthLimit     int
+	ArrayCompactWidth     int
+	ObjectCompactWidth    int
 	CompactObjectMaxItems int
+	FloatFormat           byte
+	StableFloatRepr       bool
+	QuoteIntegersOver     uint64
+	RawStrings            bool
+	AlignValues           bool
+	NoSpaceAfterColon     bool
+	NoSpaceAfterComma     bool
+	ElisionMarker         string
+	MaxStringLength       int
+	NonFinite             NonFiniteNumbers
 }
 
+// NonFiniteNumbers tells a JSONEncoder how to output numbers which are NaN or
+// infinite, e.g. the result of dividing by zero.
+type NonFiniteNumbers uint8
+
+const (
+	NonFiniteVerbatim   NonFiniteNumbers = iota // Output them as they are (e.g. NaN or +Inf)
+	NonFiniteEmitNull                           // Output null, like JavaScript's JSON.stringify
+	NonFiniteEmitString                         // Output "NaN", "Infinity" or "-Infinity"
+	NonFiniteError                              // Stop with an error wrapping ErrNonFiniteNumber
+)
+
+// ErrNonFiniteNumber is wrapped in the error returned by a JSONEncoder whose
+// NonFinite option is NonFiniteError when it meets a non-finite number.
+var ErrNonFiniteNumber = errors.New("non-finite number cannot be encoded as JSON")
+
+// MaxSafeInteger is the largest integer n such that all integers up to n can
+// be represented exactly as a float64 (it is Number.MAX_SAFE_INTEGER in
+// JavaScript).
+const MaxSafeInteger = 1<<53 - 1
+
 var _ token.StreamSink = &JSONEncoder{}
 
 // Consume formats the JSON stream encoded in the given channel using the
@@ -27,18 +97,64 @@ var _ token.StreamSink = &JSONEncoder{}
 // operation.  A typical example is if it attempt to write to a closed pipe.
 func (sw *JSONEncoder) Consume(stream <-chan token.Token) (err error) {
 	defer CatchPrinterError(&err)
-	iterator := iterator.New(token.ChannelReadStream(stream))
+	reader := token.NewStreamErrorReader(stream)
+	iterator := iterator.New(reader)
 	for iterator.Advance() {
-		sw.writeValue(iterator.CurrentValue())
+		value := iterator.CurrentValue()
+		if scalar, ok := value.AsScalar(); ok && sw.RawStrings && scalar.Type() == token.String {
+			sw.PrintBytes([]byte(scalar.ToString()))
+		} else {
+			sw.writeValue(value)
+		}
 		sw.Printer.Reset()
 	}
+	return reader.Err()
+}
+
+// EncodeValue formats a single value using the instance's Printer, consuming
+// the value.  Unlike Consume, it does not reset the Printer afterwards.
+func (sw *JSONEncoder) EncodeValue(value iterator.Value) (err error) {
+	defer CatchPrinterError(&err)
+	sw.writeValue(value)
 	return nil
 }
 
+// A WriteJSONOption changes the configuration of the encoder used by WriteJSON.
+type WriteJSONOption func(*JSONEncoder, *DefaultPrinter)
+
+// WithIndent makes WriteJSON use indentSize spaces for each indent level (see
+// DefaultPrinter for the meaning of negative values).
+func WithIndent(indentSize int) WriteJSONOption {
+	return func(e *JSONEncoder, p *DefaultPrinter) {
+		p.IndentSize = indentSize
+	}
+}
+
+// WithCompactWidth makes WriteJSON group small scalar items in arrays and
+// objects on the same line up to the given width (see JSONEncoder).
+func WithCompactWidth(width int) WriteJSONOption {
+	return func(e *JSONEncoder, p *DefaultPrinter) {
+		e.CompactWidthLimit = width
+		e.CompactObjectMaxItems = 2
+	}
+}
+
+// WriteJSON writes value to w as JSON, consuming the value.  By default the
+// output is compact and on a single line, with no trailing new line.  This can
+// be changed by passing options.
+func WriteJSON(w io.Writer, value iterator.Value, options ...WriteJSONOption) error {
+	printer := &DefaultPrinter{Writer: w, IndentSize: -1}
+	encoder := &JSONEncoder{Printer: printer}
+	for _, option := range options {
+		option(encoder, printer)
+	}
+	return encoder.EncodeValue(value)
+}
+
 func (sw *JSONEncoder) writeValue(value iterator.Value) {
 	switch v := value.(type) {
 	case *iterator.Scalar:
-		sw.Colorizer.PrintScalar(sw.Printer, v.Scalar())
+		sw.Colorizer.PrintScalar(sw.Printer, sw.formatScalar(v.Scalar()))
 	case *iterator.Object:
 		if sw.CompactObjectMaxItems > 0 {
 			sw.writeObjectCompact(v)
@@ -46,7 +162,7 @@ func (sw *JSONEncoder) writeValue(value iterator.Value) {
 			sw.writeObject(v)
 		}
 	case *iterator.Array:
-		if sw.CompactWidthLimit > 0 {
+		if sw.arrayCompactWidth() > 0 {
 			sw.writeArrayCompact(v)
 		} else {
 			sw.writeArray(v)
@@ -56,7 +172,244 @@ func (sw *JSONEncoder) writeValue(value iterator.Value) {
 	}
 }
 
+func (sw *JSONEncoder) keyValueSeparator() []byte {
+	if sw.NoSpaceAfterColon {
+		return colonBytes
+	}
+	return keyValueSeparatorBytes
+}
+
+func (sw *JSONEncoder) compactItemSeparator() []byte {
+	if sw.NoSpaceAfterComma {
+		return commaBytes
+	}
+	return compactItemSeparatorBytes
+}
+
+func (sw *JSONEncoder) arrayCompactWidth() int {
+	if sw.ArrayCompactWidth != 0 {
+		return sw.ArrayCompactWidth
+	}
+	return sw.CompactWidthLimit
+}
+
+func (sw *JSONEncoder) objectCompactWidth() int {
+	if sw.ObjectCompactWidth != 0 {
+		return sw.ObjectCompactWidth
+	}
+	return sw.CompactWidthLimit
+}
+
+// formatScalar returns the scalar to output in place of the given scalar,
+// according to the encoder's options.
+func (sw *JSONEncoder) formatScalar(scalar *token.Scalar) *token.Scalar {
+	if scalar.Type() == token.String && sw.MaxStringLength > 0 {
+		return sw.previewString(scalar)
+	}
+	if scalar.Type() != token.Number {
+		return scalar
+	}
+	if sw.NonFinite != NonFiniteVerbatim && scalar.IsNonFinite() {
+		return sw.formatNonFinite(scalar)
+	}
+	if isIntegerLiteral(scalar.Bytes) {
+		if sw.QuoteIntegersOver != 0 && integerExceeds(scalar.Bytes, sw.QuoteIntegersOver) {
+			quoted := make([]byte, 0, len(scalar.Bytes)+2)
+			quoted = append(quoted, '"')
+			quoted = append(quoted, scalar.Bytes...)
+			quoted = append(quoted, '"')
+			return token.NewScalar(token.String, quoted)
+		}
+		return scalar
+	}
+	if sw.FloatFormat == 0 && !sw.StableFloatRepr {
+		return scalar
+	}
+	x, err := strconv.ParseFloat(string(scalar.Bytes), 64)
+	if err != nil {
+		return scalar
+	}
+	if sw.StableFloatRepr {
+		return token.NewScalar(token.Number, appendJSFloat(nil, x))
+	}
+	return token.NewScalar(token.Number, strconv.AppendFloat(nil, x, sw.FloatFormat, -1, 64))
+}
+
+// formatNonFinite returns the scalar to output in place of a NaN or infinite
+// number, according to the NonFinite option.
+func (sw *JSONEncoder) formatNonFinite(scalar *token.Scalar) *token.Scalar {
+	switch sw.NonFinite {
+	case NonFiniteEmitNull:
+		return nullInstance
+	case NonFiniteEmitString:
+		x, _ := strconv.ParseFloat(string(scalar.Bytes), 64)
+		switch {
+		case math.IsNaN(x):
+			return stringScalar("NaN")
+		case x > 0:
+			return stringScalar("Infinity")
+		default:
+			return stringScalar("-Infinity")
+		}
+	case NonFiniteError:
+		panic(&PrinterError{Err: fmt.Errorf("%w: %s", ErrNonFiniteNumber, scalar.Bytes)})
+	default:
+		return scalar
+	}
+}
+
+// previewString returns the string scalar s cut to MaxStringLength bytes if it
+// is longer.
+func (sw *JSONEncoder) previewString(s *token.Scalar) *token.Scalar {
+	// The literal is at least as long as the string, so short enough literals
+	// do not need to be unescaped.
+	if len(s.Bytes)-2 <= sw.MaxStringLength {
+		return s
+	}
+	str := s.ToString()
+	if len(str) <= sw.MaxStringLength {
+		return s
+	}
+	n := sw.MaxStringLength
+	for n > 0 && !utf8.RuneStart(str[n]) {
+		n--
+	}
+	var kind string
+	if looksLikeBase64(str) {
+		kind = " of base64"
+	}
+	return stringScalar(fmt.Sprintf("%s…(+%d bytes%s)", str[:n], len(str)-n, kind))
+}
+
+// looksLikeBase64 returns true if s is likely to be base64 encoded data (with
+// the standard or URL alphabet), i.e. it only contains base64 characters,
+// including upper and lower case letters and digits, and possibly padding.
+func looksLikeBase64(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if len(s) < 16 {
+		return false
+	}
+	var upper, lower, digit bool
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'A' <= c && c <= 'Z':
+			upper = true
+		case 'a' <= c && c <= 'z':
+			lower = true
+		case '0' <= c && c <= '9':
+			digit = true
+		case c == '+' || c == '/' || c == '-' || c == '_':
+		default:
+			return false
+		}
+	}
+	return upper && lower && digit
+}
+
+// appendJSFloat appends to b the shortest representation of x which round
+// trips, formatted as in the ECMAScript specification of Number::toString.  x
+// must be finite.
+func appendJSFloat(b []byte, x float64) []byte {
+	if x == 0 {
+		return append(b, '0')
+	}
+	if x < 0 {
+		b = append(b, '-')
+		x = -x
+	}
+	// Get the digits and exponent from the 'e' format, e.g. "1.2345e-07"
+	e := strconv.AppendFloat(nil, x, 'e', -1, 64)
+	i := bytes.IndexByte(e, 'e')
+	exp, _ := strconv.Atoi(string(e[i+1:]))
+	digits := e[:i]
+	if len(digits) > 1 {
+		digits = append(digits[:1:1], digits[2:]...)
+	}
+	k := len(digits)
+	n := exp + 1 // The position of the decimal point relative to digits
+	switch {
+	case k <= n && n <= 21:
+		b = append(b, digits...)
+		for ; n > k; n-- {
+			b = append(b, '0')
+		}
+	case 0 < n && n <= 21:
+		b = append(b, digits[:n]...)
+		b = append(b, '.')
+		b = append(b, digits[n:]...)
+	case -6 < n && n <= 0:
+		b = append(b, '0', '.')
+		for ; n < 0; n++ {
+			b = append(b, '0')
+		}
+		b = append(b, digits...)
+	default:
+		b = append(b, digits[0])
+		if k > 1 {
+			b = append(b, '.')
+			b = append(b, digits[1:]...)
+		}
+		b = append(b, 'e')
+		if exp > 0 {
+			b = append(b, '+')
+		}
+		b = strconv.AppendInt(b, int64(exp), 10)
+	}
+	return b
+}
+
+// integerExceeds returns true if the magnitude of the JSON integer literal b is
+// greater than n.  This works for integers of any size.
+func integerExceeds(b []byte, n uint64) bool {
+	if len(b) > 0 && b[0] == '-' {
+		b = b[1:]
+	}
+	var nBytes [20]byte
+	limit := strconv.AppendUint(nBytes[:0], n, 10)
+	if len(b) != len(limit) {
+		return len(b) > len(limit)
+	}
+	return bytes.Compare(b, limit) > 0
+}
+
+// isIntegerLiteral returns true if the JSON number literal b has no fraction
+// or exponent part.
+func isIntegerLiteral(b []byte) bool {
+	return bytes.IndexAny(b, ".eE") < 0
+}
+
+// maxKeyWidth returns the width of the longest key in obj if AlignValues is
+// true, without consuming obj.  Otherwise it returns 0.
+func (sw *JSONEncoder) maxKeyWidth(obj *iterator.Object) int {
+	if !sw.AlignValues {
+		return 0
+	}
+	clone, detach := obj.Clone()
+	if detach != nil {
+		defer detach()
+	}
+	cloneObj := clone.(*iterator.Object)
+	width := 0
+	for cloneObj.Advance() {
+		key, _ := cloneObj.CurrentKeyVal()
+		if w := utf8.RuneCount(key.Bytes); w > width {
+			width = w
+		}
+	}
+	return width
+}
+
+// writeKey outputs key and the key-value separator, padded to keyWidth.
+func (sw *JSONEncoder) writeKey(key *token.Scalar, keyWidth int) {
+	sw.Colorizer.PrintScalar(sw.Printer, key)
+	sw.PrintBytes(sw.keyValueSeparator())
+	if pad := keyWidth - utf8.RuneCount(key.Bytes); pad > 0 {
+		sw.PrintBytes(bytes.Repeat(spaceBytes, pad))
+	}
+}
+
 func (sw *JSONEncoder) writeObject(obj *iterator.Object) {
+	keyWidth := sw.maxKeyWidth(obj)
 	sw.PrintBytes(openObjectBytes)
 	firstItem := true
 	for obj.Advance() {
@@ -68,15 +421,14 @@ func (sw *JSONEncoder) writeObject(obj *iterator.Object) {
 			sw.Indent()
 			firstItem = false
 		}
-		sw.Colorizer.PrintScalar(sw.Printer, key)
-		sw.PrintBytes(keyValueSeparatorBytes)
+		sw.writeKey(key, keyWidth)
 		sw.writeValue(value)
 	}
 	if obj.Elided() {
 		if !firstItem {
 			sw.NewLine()
 		}
-		sw.PrintBytes(elisionBytes)
+		sw.PrintBytes(sw.elisionMarker(obj.ElidedCount()))
 	}
 	if !firstItem {
 		sw.Dedent()
@@ -89,6 +441,7 @@ func (sw *JSONEncoder) writeObjectCompact(obj *iterator.Object) {
 		key   *token.Scalar
 		value iterator.Value
 	}
+	keyWidth := sw.maxKeyWidth(obj)
 	pendingItems := make([]keyValue, 0, 10)
 	totalWidth := -2
 	compact := true
@@ -99,7 +452,7 @@ func (sw *JSONEncoder) writeObjectCompact(obj *iterator.Object) {
 		if isScalar {
 			totalWidth += len(key.Bytes) + len(scalar.Bytes) + 4 // 4 for ": " and ", "
 		}
-		compact = isScalar && len(pendingItems) <= sw.CompactObjectMaxItems && totalWidth <= sw.CompactWidthLimit
+		compact = isScalar && len(pendingItems) <= sw.CompactObjectMaxItems && totalWidth <= sw.objectCompactWidth()
 	}
 
 	sw.PrintBytes(openObjectBytes)
@@ -107,14 +460,14 @@ func (sw *JSONEncoder) writeObjectCompact(obj *iterator.Object) {
 	if compact {
 		for i, item := range pendingItems {
 			if i > 0 {
-				sw.PrintBytes(compactItemSeparatorBytes)
+				sw.PrintBytes(sw.compactItemSeparator())
 			}
 			sw.Colorizer.PrintScalar(sw.Printer, item.key)
-			sw.PrintBytes(keyValueSeparatorBytes)
+			sw.PrintBytes(sw.keyValueSeparator())
 			sw.writeValue(item.value)
 		}
 		if obj.Elided() {
-			sw.PrintBytes(elisionBytes)
+			sw.PrintBytes(sw.elisionMarker(obj.ElidedCount()))
 		}
 	} else {
 		sw.Indent()
@@ -123,8 +476,7 @@ func (sw *JSONEncoder) writeObjectCompact(obj *iterator.Object) {
 				sw.PrintBytes(itemSeparatorBytes)
 				sw.NewLine()
 			}
-			sw.Colorizer.PrintScalar(sw.Printer, item.key)
-			sw.PrintBytes(keyValueSeparatorBytes)
+			sw.writeKey(item.key, keyWidth)
 			sw.writeValue(item.value)
 		}
 		for obj.Advance() {
@@ -134,13 +486,12 @@ func (sw *JSONEncoder) writeObjectCompact(obj *iterator.Object) {
 			sw.PrintBytes(itemSeparatorBytes)
 			sw.NewLine()
 
-			sw.Colorizer.PrintScalar(sw.Printer, key)
-			sw.PrintBytes(keyValueSeparatorBytes)
+			sw.writeKey(key, keyWidth)
 			sw.writeValue(value)
 		}
 		if obj.Elided() {
 			sw.NewLine()
-			sw.PrintBytes(elisionBytes)
+			sw.PrintBytes(sw.elisionMarker(obj.ElidedCount()))
 		}
 		sw.Dedent()
 	}
@@ -148,6 +499,15 @@ func (sw *JSONEncoder) writeObjectCompact(obj *iterator.Object) {
 	sw.PrintBytes(closeObjectBytes)
 }
 
+// elisionMarker returns the marker to output for count elided items (0 if not
+// known).
+func (sw *JSONEncoder) elisionMarker(count int) []byte {
+	if count > 0 && sw.ElisionMarker != "" {
+		return []byte(strings.ReplaceAll(sw.ElisionMarker, "%d", strconv.Itoa(count)))
+	}
+	return elisionBytes
+}
+
 func (sw *JSONEncoder) writeArray(arr *iterator.Array) {
 	sw.PrintBytes(openArrayBytes)
 	firstItem := true
@@ -166,7 +526,7 @@ func (sw *JSONEncoder) writeArray(arr *iterator.Array) {
 		if !firstItem {
 			sw.NewLine()
 		}
-		sw.PrintBytes(elisionBytes)
+		sw.PrintBytes(sw.elisionMarker(arr.ElidedCount()))
 	}
 	if !firstItem {
 		sw.Dedent()
@@ -202,7 +562,7 @@ func (sw *JSONEncoder) writeArrayCompact(arr *iterator.Array) {
 			totalWidth += len(scalar.Bytes) + 2
 		}
 	AddValueToCompactItems:
-		if isScalar && totalWidth <= sw.CompactWidthLimit {
+		if isScalar && totalWidth <= sw.arrayCompactWidth() {
 			compactItems = append(compactItems, value)
 			continue
 		}
@@ -216,7 +576,7 @@ func (sw *JSONEncoder) writeArrayCompact(arr *iterator.Array) {
 		if len(compactItems) > 0 {
 			for i, item := range compactItems {
 				if i > 0 {
-					sw.PrintBytes(compactItemSeparatorBytes)
+					sw.PrintBytes(sw.compactItemSeparator())
 				}
 				sw.writeValue(item)
 			}
@@ -240,7 +600,7 @@ func (sw *JSONEncoder) writeArrayCompact(arr *iterator.Array) {
 		}
 		for i, item := range compactItems {
 			if i > 0 {
-				sw.PrintBytes(compactItemSeparatorBytes)
+				sw.PrintBytes(sw.compactItemSeparator())
 			}
 			sw.writeValue(item)
 		}
@@ -249,7 +609,7 @@ func (sw *JSONEncoder) writeArrayCompact(arr *iterator.Array) {
 		if !firstItem {
 			sw.NewLine()
 		}
-		sw.PrintBytes(elisionBytes)
+		sw.PrintBytes(sw.elisionMarker(arr.ElidedCount()))
 	}
 	if !firstItem {
 		sw.Dedent()
@@ -266,4 +626,7 @@ var (
 	itemSeparatorBytes        = []byte(",")
 	compactItemSeparatorBytes = []byte(", ")
 	keyValueSeparatorBytes    = []byte(": ")
+	commaBytes                = []byte(",")
+	colonBytes                = []byte(":")
+	spaceBytes                = []byte(" ")
 )
@@ -0,0 +1,622 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func encodeJSONString(t *testing.T, input string, printer *jsonstream.DefaultPrinter, encoder *jsonstream.JSONEncoder) string {
+	var buf bytes.Buffer
+	printer.Writer = &buf
+	encoder.Printer = printer
+	err := token.ConsumeStream(streamJSONString(input), encoder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return buf.String()
+}
+
+func TestJSONEncoderIndentFirstLevelOnly(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "nested object",
+			input:  `{"a": [1, 2, {"b": [3]}], "c": {"d": {"e": 4}, "f": 5}, "g": 6}`,
+			output: "{\n  \"a\": [1, 2, {\"b\": [3]}],\n  \"c\": {\"d\": {\"e\": 4}, \"f\": 5},\n  \"g\": 6\n}\n",
+		},
+		{
+			name:   "nested array",
+			input:  `[[1, [2, 3]], {"x": []}]`,
+			output: "[\n  [1, [2, 3]],\n  {\"x\": []}\n]\n",
+		},
+		{
+			name:   "scalar",
+			input:  `"hello"`,
+			output: "\"hello\"\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: 2, MaxIndentLevel: 1}
+			got := encodeJSONString(t, c.input, printer, &jsonstream.JSONEncoder{})
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		options []jsonstream.WriteJSONOption
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:   "scalar",
+			input:  `"hello"`,
+			output: `"hello"`,
+		},
+		{
+			name:   "array",
+			input:  `[1, [2, 3], {}]`,
+			output: `[1,[2,3],{}]`,
+		},
+		{
+			name:   "object",
+			input:  `{"a": 1, "b": {"c": null}}`,
+			output: `{"a": 1,"b": {"c": null}}`,
+		},
+		{
+			name:    "indented object",
+			input:   `{"a": 1, "b": [true]}`,
+			options: []jsonstream.WriteJSONOption{jsonstream.WithIndent(2)},
+			output:  "{\n  \"a\": 1,\n  \"b\": [\n    true\n  ]\n}",
+		},
+		{
+			name:    "compact width",
+			input:   `{"a": 1, "b": [true, false]}`,
+			options: []jsonstream.WriteJSONOption{jsonstream.WithIndent(2), jsonstream.WithCompactWidth(20)},
+			output:  "{\n  \"a\": 1,\n  \"b\": [true, false]\n}",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			iter := iterator.New(token.ChannelReadStream(streamJSONString(c.input)))
+			if !iter.Advance() {
+				t.Fatal("Expected a value")
+			}
+			var buf bytes.Buffer
+			if err := jsonstream.WriteJSON(&buf, iter.CurrentValue(), c.options...); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if buf.String() != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, buf.String())
+			}
+		})
+	}
+}
+
+func streamJSONString(s string) <-chan token.Token {
+	return token.StartStream(jsonstream.NewJSONDecoder(strings.NewReader(s)), nil)
+}
+
+func TestJSONEncoderFloatFormat(t *testing.T) {
+	type testCase struct {
+		name   string
+		format byte
+		output string
+	}
+	input := `[1500000.0, 1500000, -2, 0.000015, 25e-1]`
+	var testCases = []testCase{
+		{
+			name:   "none",
+			format: 0,
+			output: "[1500000.0,1500000,-2,0.000015,25e-1]\n",
+		},
+		{
+			name:   "g",
+			format: 'g',
+			output: "[1.5e+06,1500000,-2,1.5e-05,2.5]\n",
+		},
+		{
+			name:   "f",
+			format: 'f',
+			output: "[1500000,1500000,-2,0.000015,2.5]\n",
+		},
+		{
+			name:   "e",
+			format: 'e',
+			output: "[1.5e+06,1500000,-2,1.5e-05,2.5e+00]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: -1}
+			got := encodeJSONString(t, input, printer, &jsonstream.JSONEncoder{FloatFormat: c.format})
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderNewline(t *testing.T) {
+	type testCase struct {
+		name       string
+		input      string
+		indentSize int
+		output     string
+	}
+	var testCases = []testCase{
+		{
+			name:       "pretty",
+			input:      `{"a": [1, 2], "b": {}}`,
+			indentSize: 2,
+			output:     "{\r\n  \"a\": [\r\n    1,\r\n    2\r\n  ],\r\n  \"b\": {}\r\n}\r\n",
+		},
+		{
+			name:       "json lines",
+			input:      `{"a": [1, 2]} 3 "x"`,
+			indentSize: -1,
+			output:     "{\"a\": [1,2]}\r\n3\r\n\"x\"\r\n",
+		},
+		{
+			name:       "compact single value",
+			input:      `[true, null]`,
+			indentSize: -1,
+			output:     "[true,null]\r\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: c.indentSize, Newline: "\r\n"}
+			got := encodeJSONString(t, c.input, printer, &jsonstream.JSONEncoder{})
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderStableFloatRepr(t *testing.T) {
+	type testCase struct {
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{input: `0.1`, output: `0.1`},
+		{input: `1e21`, output: `1e+21`},
+		{input: `1e20`, output: `100000000000000000000`},
+		{input: `5e-7`, output: `5e-7`},
+		{input: `1e-6`, output: `0.000001`},
+		{input: `1.2345e-7`, output: `1.2345e-7`},
+		{input: `1.5e300`, output: `1.5e+300`},
+		{input: `1.5E3`, output: `1500`},
+		{input: `2.50`, output: `2.5`},
+		{input: `-0.0`, output: `0`},
+		{input: `-3.25e-2`, output: `-0.0325`},
+		{input: `0.30000000000000004`, output: `0.30000000000000004`},
+		{input: `100`, output: `100`},
+	}
+	for _, c := range testCases {
+		t.Run(c.input, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: -1}
+			got := encodeJSONString(t, c.input, printer, &jsonstream.JSONEncoder{StableFloatRepr: true})
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderQuoteIntegersOver(t *testing.T) {
+	type testCase struct {
+		name      string
+		threshold uint64
+		input     string
+		output    string
+	}
+	var testCases = []testCase{
+		{
+			name:      "max safe integer",
+			threshold: jsonstream.MaxSafeInteger,
+			input:     `[9007199254740993, 9007199254740991, -9007199254740993, 42, 12345678901234567890123, 9007199254740993.5, 1e300]`,
+			output:    "[\"9007199254740993\",9007199254740991,\"-9007199254740993\",42,\"12345678901234567890123\",9007199254740993.5,1e300]\n",
+		},
+		{
+			name:      "small threshold",
+			threshold: 100,
+			input:     `{"a": 100, "b": 101, "c": -1000, "d": 0.5}`,
+			output:    "{\"a\": 100,\"b\": \"101\",\"c\": \"-1000\",\"d\": 0.5}\n",
+		},
+		{
+			name:   "disabled",
+			input:  `[9007199254740993]`,
+			output: "[9007199254740993]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: -1}
+			got := encodeJSONString(t, c.input, printer, &jsonstream.JSONEncoder{QuoteIntegersOver: c.threshold})
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderNonFinite(t *testing.T) {
+	type testCase struct {
+		name      string
+		nonFinite jsonstream.NonFiniteNumbers
+		output    string
+		err       string
+	}
+	var testCases = []testCase{
+		{
+			name:   "verbatim",
+			output: "[NaN,+Inf,-Inf,Infinity,1.5e+00]\n",
+		},
+		{
+			name:      "null",
+			nonFinite: jsonstream.NonFiniteEmitNull,
+			output:    "[null,null,null,null,1.5e+00]\n",
+		},
+		{
+			name:      "string",
+			nonFinite: jsonstream.NonFiniteEmitString,
+			output:    "[\"NaN\",\"Infinity\",\"-Infinity\",\"Infinity\",1.5e+00]\n",
+		},
+		{
+			name:      "error",
+			nonFinite: jsonstream.NonFiniteError,
+			output:    "[",
+			err:       "printer error: non-finite number cannot be encoded as JSON: NaN",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			stream := make(chan token.Token, 10)
+			for _, tok := range []token.Token{
+				&token.StartArray{},
+				token.Float64Scalar(math.NaN()),
+				token.Float64Scalar(math.Inf(1)),
+				token.Float64Scalar(math.Inf(-1)),
+				token.NewScalar(token.Number, []byte("Infinity")),
+				token.Float64Scalar(1.5),
+				&token.EndArray{},
+			} {
+				stream <- tok
+			}
+			close(stream)
+			var buf bytes.Buffer
+			encoder := &jsonstream.JSONEncoder{
+				Printer:   &jsonstream.DefaultPrinter{Writer: &buf, IndentSize: -1},
+				NonFinite: c.nonFinite,
+			}
+			err := encoder.Consume(stream)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("Unexpected error: %s", err)
+			case c.err != "" && (err == nil || err.Error() != c.err):
+				t.Fatalf("Expected error %q, got %v", c.err, err)
+			case c.err != "" && !errors.Is(err, jsonstream.ErrNonFiniteNumber):
+				t.Fatalf("Expected error to wrap ErrNonFiniteNumber")
+			}
+			if got := buf.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderCompactWidths(t *testing.T) {
+	// The array and the object both have a compact width of 14
+	input := `{"arr": [1000, 2000, 3000], "obj": {"a": 1, "b": 2}}`
+	type testCase struct {
+		name    string
+		encoder jsonstream.JSONEncoder
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:    "single width inlines both",
+			encoder: jsonstream.JSONEncoder{CompactWidthLimit: 20},
+			output:  "{\n  \"arr\": [1000, 2000, 3000],\n  \"obj\": {\"a\": 1, \"b\": 2}\n}\n",
+		},
+		{
+			name:    "array width only",
+			encoder: jsonstream.JSONEncoder{CompactWidthLimit: 20, ObjectCompactWidth: 10},
+			output:  "{\n  \"arr\": [1000, 2000, 3000],\n  \"obj\": {\n    \"a\": 1,\n    \"b\": 2\n  }\n}\n",
+		},
+		{
+			name:    "object width only",
+			encoder: jsonstream.JSONEncoder{CompactWidthLimit: 20, ArrayCompactWidth: 10},
+			output:  "{\n  \"arr\": [\n    1000, 2000,\n    3000\n  ],\n  \"obj\": {\"a\": 1, \"b\": 2}\n}\n",
+		},
+		{
+			name:    "no default width",
+			encoder: jsonstream.JSONEncoder{ArrayCompactWidth: 20},
+			output:  "{\n  \"arr\": [1000, 2000, 3000],\n  \"obj\": {\n    \"a\": 1,\n    \"b\": 2\n  }\n}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			c.encoder.CompactObjectMaxItems = 2
+			printer := &jsonstream.DefaultPrinter{IndentSize: 2}
+			got := encodeJSONString(t, input, printer, &c.encoder)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderAlignValues(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		encoder jsonstream.JSONEncoder
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:    "keys of differing lengths",
+			input:   `{"a": 1, "long_key": true, "mid": "x"}`,
+			encoder: jsonstream.JSONEncoder{AlignValues: true},
+			output:  "{\n  \"a\":        1,\n  \"long_key\": true,\n  \"mid\":      \"x\"\n}\n",
+		},
+		{
+			name:    "nested objects align independently",
+			input:   `{"a": {"xx": 1, "y": 2}, "bbbb": {"z": 3, "wwwwww": 4}}`,
+			encoder: jsonstream.JSONEncoder{AlignValues: true},
+			output: `{
+  "a":    {
+    "xx": 1,
+    "y":  2
+  },
+  "bbbb": {
+    "z":      3,
+    "wwwwww": 4
+  }
+}
+`,
+		},
+		{
+			name:    "compact objects are not padded",
+			input:   `{"a": 1, "bbb": {"c": 1, "dd": 2}}`,
+			encoder: jsonstream.JSONEncoder{AlignValues: true, CompactWidthLimit: 20, CompactObjectMaxItems: 2},
+			output:  "{\n  \"a\":   1,\n  \"bbb\": {\"c\": 1, \"dd\": 2}\n}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: 2}
+			got := encodeJSONString(t, c.input, printer, &c.encoder)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderSpaces(t *testing.T) {
+	input := `{"a": [1, 2], "b": {"c": true, "d": null}, "e": [{"f": 0}]}`
+	type testCase struct {
+		name    string
+		encoder jsonstream.JSONEncoder
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:    "default",
+			encoder: jsonstream.JSONEncoder{},
+			output:  "{\n  \"a\": [1, 2],\n  \"b\": {\"c\": true, \"d\": null},\n  \"e\": [\n    {\"f\": 0}\n  ]\n}\n",
+		},
+		{
+			name:    "no space after colon",
+			encoder: jsonstream.JSONEncoder{NoSpaceAfterColon: true},
+			output:  "{\n  \"a\":[1, 2],\n  \"b\":{\"c\":true, \"d\":null},\n  \"e\":[\n    {\"f\":0}\n  ]\n}\n",
+		},
+		{
+			name:    "no space after comma",
+			encoder: jsonstream.JSONEncoder{NoSpaceAfterComma: true},
+			output:  "{\n  \"a\": [1,2],\n  \"b\": {\"c\": true,\"d\": null},\n  \"e\": [\n    {\"f\": 0}\n  ]\n}\n",
+		},
+		{
+			name:    "no spaces",
+			encoder: jsonstream.JSONEncoder{NoSpaceAfterColon: true, NoSpaceAfterComma: true},
+			output:  "{\n  \"a\":[1,2],\n  \"b\":{\"c\":true,\"d\":null},\n  \"e\":[\n    {\"f\":0}\n  ]\n}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			c.encoder.CompactWidthLimit = 30
+			c.encoder.CompactObjectMaxItems = 2
+			printer := &jsonstream.DefaultPrinter{IndentSize: 2}
+			got := encodeJSONString(t, input, printer, &c.encoder)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderIndentSize(t *testing.T) {
+	type testCase struct {
+		name       string
+		indentSize int
+		output     string
+	}
+	const input = `{"a": [1, {"b": 2}], "c": {}} [3]`
+	var testCases = []testCase{
+		{
+			name:       "indented",
+			indentSize: 2,
+			output:     "{\n  \"a\": [\n    1,\n    {\n      \"b\": 2\n    }\n  ],\n  \"c\": {}\n}\n[\n  3\n]\n",
+		},
+		{
+			name:       "new lines without indentation",
+			indentSize: 0,
+			output:     "{\n\"a\": [\n1,\n{\n\"b\": 2\n}\n],\n\"c\": {}\n}\n[\n3\n]\n",
+		},
+		{
+			name:       "compact",
+			indentSize: -1,
+			output:     "{\"a\": [1,{\"b\": 2}],\"c\": {}}\n[3]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: c.indentSize}
+			got := encodeJSONString(t, input, printer, &jsonstream.JSONEncoder{})
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderElisionMarker(t *testing.T) {
+	type testCase struct {
+		name        string
+		input       string
+		depth       int
+		transformer token.StreamTransformer
+		marker      string
+		output      string
+	}
+	var testCases = []testCase{
+		{
+			name:   "default marker",
+			input:  `[1, {"a": 1, "b": [2, 3]}, [4, [5], 6]]`,
+			depth:  1,
+			output: "[1,{...},[...]]\n",
+		},
+		{
+			name:   "counts",
+			input:  `[1, {"a": 1, "b": [2, 3]}, [4, [5], 6]]`,
+			depth:  1,
+			marker: "…%d more",
+			output: "[1,{…2 more},[…3 more]]\n",
+		},
+		{
+			name:   "empty values",
+			input:  `{"a": [], "b": {}}`,
+			depth:  1,
+			marker: "…%d more",
+			output: "{\"a\": [...],\"b\": {...}}\n",
+		},
+		{
+			name:        "count kept by transforms",
+			input:       `{"a": {"x": 1, "y": 2, "z": 3}, "b": 2}`,
+			depth:       1,
+			transformer: iterator.AsStreamTransformer(&jsonstream.OmitEmpty{}),
+			marker:      "<%d>",
+			output:      "{\"a\": {<3>},\"b\": 2}\n",
+		},
+		{
+			name:        "count of elided items added",
+			input:       `[[1, 2, 3], [4, 5]]`,
+			depth:       1,
+			transformer: &jsonstream.MaxDepthFilter{MaxDepth: 0},
+			marker:      "…%d more",
+			output:      "[…2 more]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			stream := token.TransformStream(streamJSONString(c.input), &jsonstream.MaxDepthFilter{MaxDepth: c.depth})
+			if c.transformer != nil {
+				stream = token.TransformStream(stream, c.transformer)
+			}
+			var buf bytes.Buffer
+			encoder := &jsonstream.JSONEncoder{
+				Printer:       &jsonstream.DefaultPrinter{Writer: &buf, IndentSize: -1},
+				ElisionMarker: c.marker,
+			}
+			if err := token.ConsumeStream(stream, encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := buf.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestJSONEncoderMaxStringLength(t *testing.T) {
+	type testCase struct {
+		name   string
+		max    int
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "no limit",
+			input:  `"abcdefghij"`,
+			output: `"abcdefghij"`,
+		},
+		{
+			name:   "short strings",
+			max:    10,
+			input:  `["abcdefghij", "a\nb", 1234567890123]`,
+			output: `["abcdefghij","a\nb",1234567890123]`,
+		},
+		{
+			name:   "long string",
+			max:    4,
+			input:  `"abcdefghij"`,
+			output: `"abcd…(+6 bytes)"`,
+		},
+		{
+			name:   "escapes",
+			max:    4,
+			input:  `"a\nb\"cdef"`,
+			output: `"a\nb\"…(+4 bytes)"`,
+		},
+		{
+			name:   "no cut inside a character",
+			max:    4,
+			input:  `"ééé"`,
+			output: `"éé…(+2 bytes)"`,
+		},
+		{
+			name:   "keys are not cut",
+			max:    2,
+			input:  `{"long key": "value"}`,
+			output: `{"long key": "va…(+3 bytes)"}`,
+		},
+		{
+			name:   "base64",
+			max:    8,
+			input:  `["SGVsbG8sIFdvcmxkISBIb3cgYXJlIHlvdT8=", "Hello, World! How are you?"]`,
+			output: `["SGVsbG8s…(+28 bytes of base64)","Hello, W…(+18 bytes)"]`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			printer := &jsonstream.DefaultPrinter{IndentSize: -1}
+			got := encodeJSONString(t, c.input, printer, &jsonstream.JSONEncoder{MaxStringLength: c.max})
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %s, got %s", c.output, got)
+			}
+		})
+	}
+}
@@ -38,6 +38,9 @@ func (r SingularQueryRunner) Evaluate(ctx *RunContext, value iterator.Value) ite
 			value = selector.SelectFromObject(x)
 		case *iterator.Array:
 			value = selector.SelectFromArray(x)
+		default:
+			// Scalars have no children
+			return nil
 		}
 		if value == nil {
 			break
@@ -73,7 +76,7 @@ type NameSingularSelectorRunner struct {
 func (r NameSingularSelectorRunner) SelectFromObject(obj *iterator.Object) iterator.Value {
 	for obj.Advance() {
 		key, value := obj.CurrentKeyVal()
-		if r.nameSelector.SelectsFromKey(key.ToString()).IsYes() {
+		if r.nameSelector.SelectsFromKey(key).IsYes() {
 			return value
 		}
 	}
@@ -13,11 +13,49 @@ import (
 
 var ErrUnimplementedFeature = errors.New("unimplemented feature")
 
+// A CompileOption changes the configuration of the compiler used by
+// CompileQuery.
+type CompileOption func(*compiler)
+
+// WithFunctionRegistry makes CompileQuery look up the functions called in the
+// query in registry instead of DefaultFunctionRegistry.
+func WithFunctionRegistry(registry FunctionRegistry) CompileOption {
+	return func(c *compiler) {
+		c.functionRegistry = registry
+	}
+}
+
+// WithStrictOrder(false) makes the compiled query output the nodes it selects
+// in document order, each of them once, instead of in the order specified by
+// RFC 9535.  E.g. $[1, 0] selects 1 then 2 from [1, 2] instead of 2 then 1.
+// This means that no items need to be held back, so the output of the query can
+// be streamed (and memory usage is bounded) whatever its selectors.  Queries are
+// compiled with the strict RFC 9535 order by default.
+func WithStrictOrder(strict bool) CompileOption {
+	return func(c *compiler) {
+		c.documentOrder = !strict
+	}
+}
+
+// WithAllDuplicateKeys(true) makes name selectors select all the members of an
+// object with the given name, instead of only the first one, for input whose
+// objects may have duplicate keys.  This means that the rest of an object is
+// always looked at after a match.  Singular queries (e.g. @.a in a filter)
+// still select the first member, as they select at most one node.
+func WithAllDuplicateKeys(all bool) CompileOption {
+	return func(c *compiler) {
+		c.allDuplicateKeys = all
+	}
+}
+
 // CompileQuery compiles a JSON query AST to a QueryRunner.
-func CompileQuery(query ast.Query) (MainQueryRunner, error) {
+func CompileQuery(query ast.Query, options ...CompileOption) (MainQueryRunner, error) {
 	c := compiler{
 		functionRegistry: DefaultFunctionRegistry,
 	}
+	for _, option := range options {
+		option(&c)
+	}
 	runner, err := c.compileQuery(query)
 	if err != nil {
 		return MainQueryRunner{}, err
@@ -46,6 +84,12 @@ type compiler struct {
 	innerQueries         []innerQueryEntry
 
 	functionRegistry FunctionRegistry
+
+	// True if segments output the nodes they select in document order.
+	documentOrder bool
+
+	// True if name selectors select all the members with their name.
+	allDuplicateKeys bool
 }
 
 func (c *compiler) getInnerQueries() ([]SingularQueryRunner, []QueryEvaluator) {
@@ -122,6 +166,10 @@ func (c *compiler) compileSegment(segment ast.Segment) (r SegmentRunner, err err
 		selectors:           selectors,
 		lookahead:           lookahead,
 		isDescendantSegment: segment.Type == ast.DescendantSegmentType,
+		documentOrder:       c.documentOrder,
+	}
+	if len(selectors) == 1 && !r.isDescendantSegment {
+		_, r.isWildcardOnly = selectors[0].(WildcardSelectorRunner)
 	}
 	return
 }
@@ -129,7 +177,7 @@ func (c *compiler) compileSegment(segment ast.Segment) (r SegmentRunner, err err
 func (c *compiler) compileSelector(selector ast.Selector) (r SelectorRunner, err error) {
 	switch x := selector.(type) {
 	case ast.NameSelector:
-		r = NameSelectorRunner{name: x.Name}
+		r = NameSelectorRunner{name: []byte(x.Name), all: c.allDuplicateKeys}
 	case ast.WildcardSelector:
 		r = WildcardSelectorRunner{}
 	case ast.IndexSelector:
@@ -333,7 +381,7 @@ func (c *compiler) compileSingularQuery(query ast.SingularQuery) ComparableEvalu
 func (c *compiler) compileSingularQuerySegment(segment ast.SingularQuerySegment) SingularSelectorRunner {
 	switch x := segment.(type) {
 	case ast.NameSegment:
-		return NameSingularSelectorRunner{nameSelector: NameSelectorRunner{name: x.Name}}
+		return NameSingularSelectorRunner{nameSelector: NameSelectorRunner{name: []byte(x.Name)}}
 	case ast.IndexSegment:
 		return IndexSingularSelectorRunner{indexSelector: IndexSelectorRunner{index: int64(x.Index)}}
 	default:
@@ -121,6 +121,7 @@ func runCTSTestData(t *testing.T, testData ctsData) {
 	if testData.invalid_selector {
 		t.Fatalf("query expected to be invalid")
 	}
+	checkCTSPaths(t, runner, testData)
 	expectedArr, ok := testData.result.AsArray()
 	if !ok {
 		t.Fatal("Expected result to be an array")
@@ -135,4 +136,73 @@ func runCTSTestData(t *testing.T, testData ctsData) {
 		}
 		return true
 	})
+	if expectedArr.Advance() {
+		t.Fatal("got fewer nodes than expected in query result")
+	}
+}
+
+// checkCTSPaths checks that the paths returned by runner.EvaluatePaths locate
+// the expected results in the document, without consuming them.
+func checkCTSPaths(t *testing.T, runner jsonpathtransformer.MainQueryRunner, testData ctsData) {
+	doc, detachDoc := testData.document.Clone()
+	paths := runner.EvaluatePaths(doc)
+	if detachDoc != nil {
+		detachDoc()
+	}
+	result, detachResult := testData.result.Clone()
+	if detachResult != nil {
+		defer detachResult()
+	}
+	expectedArr, ok := result.AsArray()
+	if !ok {
+		t.Fatal("Expected result to be an array")
+	}
+	for _, path := range paths {
+		if !expectedArr.Advance() {
+			t.Fatalf("got more paths than expected in query result")
+		}
+		doc, detachDoc := testData.document.Clone()
+		val := resolvePath(doc, path)
+		if val == nil || !iterator.ValuesEqual(val, expectedArr.CurrentValue()) {
+			t.Fatalf("path %s does not locate the expected result", path)
+		}
+		if detachDoc != nil {
+			detachDoc()
+		}
+	}
+	if expectedArr.Advance() {
+		t.Fatalf("got fewer paths than expected in query result")
+	}
+}
+
+// resolvePath returns the node located by path in value, or nil if there is
+// none.
+func resolvePath(value iterator.Value, path jsonpathtransformer.NormalizedPath) iterator.Value {
+	for _, elt := range path {
+		switch x := value.(type) {
+		case *iterator.Object:
+			value = nil
+			for x.Advance() {
+				key, item := x.CurrentKeyVal()
+				if elt.Key != nil && key.Equal(elt.Key) {
+					value = item
+					break
+				}
+			}
+		case *iterator.Array:
+			value = nil
+			for i := int64(0); x.Advance(); i++ {
+				if elt.Key == nil && i == elt.Index {
+					value = x.CurrentValue()
+					break
+				}
+			}
+		default:
+			return nil
+		}
+		if value == nil {
+			return nil
+		}
+	}
+	return value
 }
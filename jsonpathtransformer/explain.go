@@ -0,0 +1,192 @@
+package jsonpathtransformer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Explain returns a description of how the compiled query runs, to help
+// understand why a query needs to buffer input and how it could be rewritten
+// to avoid it.  There is a line for the query, then one for each segment with
+// its selectors and whether it streams items or holds them in memory, e.g. for
+// $.a[-3:, 0]
+//
+//	query $['a'][-3:, 0] (RFC 9535 order)
+//	  segment 1: child ['a']: streams
+//	  segment 2: child [-3:, 0]: looks 3 items ahead in arrays to know the negative index of items; holds back items selected by later selectors until the earlier ones are done (RFC 9535 order)
+//
+// The queries on the root node ($) which filters use are listed last, as they
+// are evaluated on each value before the query runs, so the whole value is
+// held in memory.
+func (r MainQueryRunner) Explain() string {
+	var b strings.Builder
+	main, _ := r.mainRunner.ValueMapper.(QueryRunner)
+	fmt.Fprintf(&b, "query %s (%s)", describeQuery(main), main.orderDescription())
+	if r.maxDepth > 0 {
+		fmt.Fprintf(&b, ", max depth %d", r.maxDepth)
+	}
+	if r.maxWindowSize > 0 {
+		fmt.Fprintf(&b, ", max window %d tokens", r.maxWindowSize)
+	}
+	b.WriteByte('\n')
+	main.explainSegments(&b, "  ")
+	for _, q := range r.innerSingularQueries {
+		fmt.Fprintf(&b, "  inner query %s: evaluated on the whole value first\n", describeSingularQuery(q))
+	}
+	for _, q := range r.innerQueries {
+		inner, _ := q.ValueMapper.(QueryRunner)
+		fmt.Fprintf(&b, "  inner query %s: evaluated on the whole value first\n", describeQuery(inner))
+		inner.explainSegments(&b, "    ")
+	}
+	return b.String()
+}
+
+func (r QueryRunner) orderDescription() string {
+	for _, s := range r.segments {
+		if s.documentOrder {
+			return "document order"
+		}
+	}
+	return "RFC 9535 order"
+}
+
+// explainSegments writes a line describing each segment of r to b, starting
+// with indent.
+func (r QueryRunner) explainSegments(b *strings.Builder, indent string) {
+	for i, s := range r.segments {
+		kind := "child"
+		if s.isDescendantSegment {
+			kind = "descendant"
+		}
+		fmt.Fprintf(b, "%ssegment %d: %s %s: %s\n", indent, i+1, kind, describeSelectors(s.selectors), s.explain())
+	}
+}
+
+// explain describes how the segment processes the items of arrays and
+// objects.
+func (r SegmentRunner) explain() string {
+	if r.isWildcardOnly {
+		return "streams"
+	}
+	var notes []string
+	switch {
+	case r.lookahead == math.MaxInt64:
+		notes = append(notes, "holds whole arrays in memory to know the negative index of items")
+	case r.lookahead == 1:
+		notes = append(notes, "looks 1 item ahead in arrays to know the negative index of items")
+	case r.lookahead > 0:
+		notes = append(notes, fmt.Sprintf("looks %d items ahead in arrays to know the negative index of items", r.lookahead))
+	}
+	if !r.documentOrder {
+		if len(r.selectors) > 1 {
+			notes = append(notes, "holds back items selected by later selectors until the earlier ones are done (RFC 9535 order)")
+		}
+		for _, s := range r.selectors {
+			if s.ReversesSelection() {
+				notes = append(notes, fmt.Sprintf("holds back the items selected by %s to output them in reverse order", describeSelector(s)))
+			}
+		}
+	}
+	for _, s := range r.selectors {
+		if _, ok := s.(FilterSelectorRunner); ok {
+			notes = append(notes, "reads items twice to evaluate the filter")
+			break
+		}
+	}
+	if r.isDescendantSegment {
+		notes = append(notes, "reads items twice to look into their descendants")
+	}
+	if len(notes) == 0 {
+		return "streams"
+	}
+	return strings.Join(notes, "; ")
+}
+
+// describeQuery returns the query run by r, e.g. $['a'][*]..[0].  Filters are
+// shown as "?...".
+func describeQuery(r QueryRunner) string {
+	var b strings.Builder
+	if r.isRootNodeQuery {
+		b.WriteByte('$')
+	} else {
+		b.WriteByte('@')
+	}
+	for _, s := range r.segments {
+		if s.isDescendantSegment {
+			b.WriteString("..")
+		}
+		b.WriteString(describeSelectors(s.selectors))
+	}
+	return b.String()
+}
+
+func describeSingularQuery(r SingularQueryRunner) string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, s := range r.selectors {
+		switch x := s.(type) {
+		case NameSingularSelectorRunner:
+			b.WriteString(describeSelectors([]SelectorRunner{x.nameSelector}))
+		case IndexSingularSelectorRunner:
+			b.WriteString(describeSelectors([]SelectorRunner{x.indexSelector}))
+		default:
+			b.WriteString("[]")
+		}
+	}
+	return b.String()
+}
+
+func describeSelectors(selectors []SelectorRunner) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, s := range selectors {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(describeSelector(s))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func describeSelector(s SelectorRunner) string {
+	switch x := s.(type) {
+	case NameSelectorRunner:
+		var b strings.Builder
+		writeNormalizedName(&b, string(x.name))
+		return b.String()
+	case WildcardSelectorRunner:
+		return "*"
+	case IndexSelectorRunner:
+		return strconv.FormatInt(x.index, 10)
+	case SliceSelectorRunner:
+		return describeSlice(x.start, x.end, x.step, 0, math.MaxInt64)
+	case ReverseSliceSelectorRunner:
+		return describeSlice(x.start, x.end, x.step, -1, math.MinInt64)
+	case FilterSelectorRunner:
+		return "?..."
+	default:
+		// Selectors which never select anything (e.g. slices with a step of 0)
+		return "nothing"
+	}
+}
+
+// describeSlice returns the slice selector start:end:step, omitting the parts
+// which have their default value.
+func describeSlice(start, end, step, defaultStart, defaultEnd int64) string {
+	var b strings.Builder
+	if start != defaultStart {
+		b.WriteString(strconv.FormatInt(start, 10))
+	}
+	b.WriteByte(':')
+	if end != defaultEnd {
+		b.WriteString(strconv.FormatInt(end, 10))
+	}
+	if step != 1 {
+		b.WriteByte(':')
+		b.WriteString(strconv.FormatInt(step, 10))
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package jsonpathtransformer_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+)
+
+func TestExplain(t *testing.T) {
+	type testCase struct {
+		name    string
+		query   string
+		options []jsonpathtransformer.CompileOption
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:  "streaming",
+			query: `$.a[*]`,
+			output: `query $['a'][*] (RFC 9535 order)
+  segment 1: child ['a']: streams
+  segment 2: child [*]: streams
+`,
+		},
+		{
+			name:  "lookahead and order",
+			query: `$[-3:, 0]`,
+			output: `query $[-3:, 0] (RFC 9535 order)
+  segment 1: child [-3:, 0]: looks 3 items ahead in arrays to know the negative index of items; holds back items selected by later selectors until the earlier ones are done (RFC 9535 order)
+`,
+		},
+		{
+			name:    "document order",
+			query:   `$[-3:, 0]`,
+			options: []jsonpathtransformer.CompileOption{jsonpathtransformer.WithStrictOrder(false)},
+			output: `query $[-3:, 0] (document order)
+  segment 1: child [-3:, 0]: looks 3 items ahead in arrays to know the negative index of items
+`,
+		},
+		{
+			name:  "reverse slices",
+			query: `$[::-1, 1:-2:2]`,
+			output: `query $[::-1, 1:-2:2] (RFC 9535 order)
+  segment 1: child [::-1, 1:-2:2]: looks 2 items ahead in arrays to know the negative index of items; holds back items selected by later selectors until the earlier ones are done (RFC 9535 order); holds back the items selected by ::-1 to output them in reverse order
+`,
+		},
+		{
+			name:  "filters and inner queries",
+			query: `$..[?@.x > $[0].x && $..y]`,
+			output: `query $..[?...] (RFC 9535 order)
+  segment 1: descendant [?...]: reads items twice to evaluate the filter; reads items twice to look into their descendants
+  inner query $[0]['x']: evaluated on the whole value first
+  inner query $..['y']: evaluated on the whole value first
+    segment 1: descendant ['y']: reads items twice to look into their descendants
+`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			runner, err := compileQueryString(c.query, c.options...)
+			if err != nil {
+				t.Fatalf("Invalid query: %s", err)
+			}
+			if got := runner.Explain(); got != c.output {
+				t.Fatalf("Expected\n%s\ngot\n%s", c.output, got)
+			}
+		})
+	}
+}
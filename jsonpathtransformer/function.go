@@ -1,7 +1,10 @@
 package jsonpathtransformer
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
+	"sync"
 	"unicode/utf8"
 
 	"github.com/arnodel/jsonstream/internal/jsonpath/parser"
@@ -9,6 +12,19 @@ import (
 	"github.com/arnodel/jsonstream/token"
 )
 
+// A FunctionDef defines a function which can be called in JSONPath filter
+// expressions, e.g. length(@.name) or match(@.id, "[a-z]+").
+//
+// When the function is called, Run is passed one argument per item of
+// InputTypes, which depends on the type:
+//   - ValueType: an iterator.Value, or nil if there is no value (e.g. the
+//     argument is a query which selects nothing);
+//   - LogicalType: a bool;
+//   - NodesType: a NodesResult.
+//
+// Run must return an iterator.Value (or nil for no value) if OutputType is
+// ValueType, or a bool if it is LogicalType.  Arguments are only valid during
+// the call, so Run must not retain them.
 type FunctionDef struct {
 	Name       string
 	InputTypes []Type
@@ -16,12 +32,13 @@ type FunctionDef struct {
 	Run        func([]any) any
 }
 
+// A Type is the type of a function argument or result, as defined in RFC 9535.
 type Type uint8
 
 const (
-	ValueType Type = iota
-	LogicalType
-	NodesType
+	ValueType   Type = iota // A JSON value or nothing
+	LogicalType             // True or false
+	NodesType               // A list of nodes (only valid as an input type)
 )
 
 func (t Type) ConvertsTo(u Type) bool {
@@ -41,60 +58,138 @@ func (t Type) String() string {
 	}
 }
 
+// A FunctionRegistry holds the functions which can be called in queries.  The
+// compiler looks up functions in DefaultFunctionRegistry unless another
+// registry is given with the WithFunctionRegistry option.
 type FunctionRegistry interface {
+	// GetFunctionDef returns the definition of the function with the given
+	// name, or nil if there is none.
 	GetFunctionDef(name string) *FunctionDef
+
+	// AddFunctionDef adds a function to the registry.  It returns an error if
+	// the definition is invalid or a function with the same name exists.
 	AddFunctionDef(def FunctionDef) error
 }
 
+// NewFunctionRegistry returns an empty FunctionRegistry.  It is safe for
+// concurrent use, so functions can be added to it while queries are compiled
+// in other goroutines.
 func NewFunctionRegistry() FunctionRegistry {
-	return functionDefMap{}
+	return &functionDefMap{defs: map[string]*FunctionDef{}}
+}
+
+// NewStandardFunctionRegistry returns a FunctionRegistry containing the
+// functions defined in RFC 9535, to which more functions can be added without
+// changing DefaultFunctionRegistry.
+func NewStandardFunctionRegistry() FunctionRegistry {
+	r := NewFunctionRegistry()
+	for _, def := range standardFunctionDefs {
+		if err := r.AddFunctionDef(def); err != nil {
+			panic(err)
+		}
+	}
+	return r
 }
 
-type functionDefMap map[string]*FunctionDef
+type functionDefMap struct {
+	mu   sync.RWMutex
+	defs map[string]*FunctionDef
+}
 
-func (m functionDefMap) AddFunctionDef(def FunctionDef) error {
-	m[def.Name] = &def
+func (m *functionDefMap) AddFunctionDef(def FunctionDef) error {
+	if err := checkFunctionDef(def); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.defs[def.Name] != nil {
+		return fmt.Errorf("function %q already exists", def.Name)
+	}
+	m.defs[def.Name] = &def
 	return nil
 }
 
-func (m functionDefMap) GetFunctionDef(name string) *FunctionDef {
-	return m[name]
+func (m *functionDefMap) GetFunctionDef(name string) *FunctionDef {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.defs[name]
 }
 
-// This contains the functions defined in the jsonpath spec
-var DefaultFunctionRegistry = NewFunctionRegistry()
+var functionNameRegexp = regexp.MustCompile(`^[a-z][a-z_0-9]*$`)
 
-func init() {
-	DefaultFunctionRegistry.AddFunctionDef(FunctionDef{
+func checkFunctionDef(def FunctionDef) error {
+	if !functionNameRegexp.MatchString(def.Name) {
+		return fmt.Errorf("invalid function name %q", def.Name)
+	}
+	if def.Run == nil {
+		return fmt.Errorf("function %q: missing Run", def.Name)
+	}
+	if def.OutputType != ValueType && def.OutputType != LogicalType {
+		return fmt.Errorf("function %q: invalid output type", def.Name)
+	}
+	for _, t := range def.InputTypes {
+		if t > NodesType {
+			return fmt.Errorf("function %q: invalid input type", def.Name)
+		}
+	}
+	return nil
+}
+
+// DefaultFunctionRegistry contains the functions defined in the jsonpath spec,
+// and the ones added with RegisterFunction.
+var DefaultFunctionRegistry = NewStandardFunctionRegistry()
+
+// RegisterFunction adds a function called name to DefaultFunctionRegistry, so
+// that it can be used in all queries compiled afterwards.  It is safe to call
+// while other goroutines compile or run queries.  It returns an error
+// if the name is not a valid function name (a lowercase letter followed by
+// lowercase letters, digits and underscores), the definition is invalid or a
+// function with that name already exists.  E.g.
+//
+//	jsonpathtransformer.RegisterFunction("is_even", jsonpathtransformer.FunctionDef{
+//		InputTypes: []jsonpathtransformer.Type{jsonpathtransformer.ValueType},
+//		OutputType: jsonpathtransformer.LogicalType,
+//		Run: func(args []any) any { ... },
+//	})
+//
+// allows the query $[?is_even(@.id)].
+func RegisterFunction(name string, def FunctionDef) error {
+	def.Name = name
+	return DefaultFunctionRegistry.AddFunctionDef(def)
+}
+
+// These are the functions defined in the jsonpath spec.
+var standardFunctionDefs = []FunctionDef{
+	{
 		Name:       "length",
 		InputTypes: []Type{ValueType},
 		OutputType: ValueType,
 		Run:        run_length,
-	})
-	DefaultFunctionRegistry.AddFunctionDef(FunctionDef{
+	},
+	{
 		Name:       "count",
 		InputTypes: []Type{NodesType},
 		OutputType: ValueType,
 		Run:        run_count,
-	})
-	DefaultFunctionRegistry.AddFunctionDef(FunctionDef{
+	},
+	{
 		Name:       "match",
 		InputTypes: []Type{ValueType, ValueType},
 		OutputType: LogicalType,
 		Run:        run_match,
-	})
-	DefaultFunctionRegistry.AddFunctionDef(FunctionDef{
+	},
+	{
 		Name:       "search",
 		InputTypes: []Type{ValueType, ValueType},
 		OutputType: LogicalType,
 		Run:        run_search,
-	})
-	DefaultFunctionRegistry.AddFunctionDef(FunctionDef{
+	},
+	{
 		Name:       "value",
 		InputTypes: []Type{NodesType},
 		OutputType: ValueType,
 		Run:        run_value,
-	})
+	},
 }
 
 func run_length(args []any) any {
@@ -105,7 +200,6 @@ func run_length(args []any) any {
 			n++
 		}
 	case *iterator.Object:
-		var n int64
 		for x.Advance() {
 			n++
 		}
@@ -131,45 +225,100 @@ func run_count(args []any) any {
 }
 
 func run_match(args []any) any {
-	loc := matchIndex(args)
-	return loc != nil && loc[0] == 0 && loc[1] == 0
+	return matchRegexp(args, true)
 }
 
 func run_search(args []any) any {
-	loc := matchIndex(args)
-	return loc != nil
+	return matchRegexp(args, false)
 }
 
-func matchIndex(args []any) (loc []int) {
+// matchRegexp returns true if the first argument is a string which matches the
+// I-regexp (RFC 9485) in the second argument.  If anchored is true the whole
+// string must match, otherwise a substring can match.
+func matchRegexp(args []any, anchored bool) bool {
 	arg1, ok := args[0].(*iterator.Scalar)
 	if !ok {
-		return nil
+		return false
 	}
 	arg2, ok := args[1].(*iterator.Scalar)
 	if !ok {
-		return nil
+		return false
 	}
 	if arg1.Scalar().Type() != token.String || arg2.Scalar().Type() != token.String {
-		return nil
+		return false
 	}
-	ptnString := parser.ParseJsonLiteralBytes(arg2.Bytes).(string)
-	ptn, err := regexp.Compile(ptnString)
-	if err != nil {
-		return nil
+	ptn := compileIRegexp(parser.ParseJsonLiteralBytes(arg2.Bytes).(string), anchored)
+	if ptn == nil {
+		return false
 	}
 	if arg1.Scalar().IsUnescaped() {
-		loc = ptn.FindIndex(arg1.Bytes[1 : len(arg1.Bytes)-1])
-		if loc != nil {
-			loc[1] -= len(arg1.Bytes) - 2
-		}
-	} else {
-		arg1String := parser.ParseJsonLiteralBytes(arg1.Bytes).(string)
-		loc = ptn.FindStringIndex(arg1String)
-		if loc != nil {
-			loc[1] -= len(arg1String)
+		return ptn.Match(arg1.Bytes[1 : len(arg1.Bytes)-1])
+	}
+	return ptn.MatchString(parser.ParseJsonLiteralBytes(arg1.Bytes).(string))
+}
+
+// The maximum number of compiled regular expressions kept by compileIRegexp.
+const maxRegexpCacheSize = 256
+
+var (
+	regexpCacheMx sync.Mutex
+	regexpCache   = map[regexpCacheKey]*regexp.Regexp{}
+)
+
+type regexpCacheKey struct {
+	ptn      string
+	anchored bool
+}
+
+// compileIRegexp returns the Go regular expression for the I-regexp ptn, or nil
+// if ptn is invalid.  Results are cached as patterns are usually the same for
+// all the nodes a filter is applied to.
+func compileIRegexp(ptn string, anchored bool) *regexp.Regexp {
+	key := regexpCacheKey{ptn: ptn, anchored: anchored}
+	regexpCacheMx.Lock()
+	defer regexpCacheMx.Unlock()
+	if re, ok := regexpCache[key]; ok {
+		return re
+	}
+	goPtn := translateIRegexp(ptn)
+	if anchored {
+		goPtn = `\A(?:` + goPtn + `)\z`
+	}
+	re, err := regexp.Compile(goPtn)
+	if err != nil {
+		re = nil
+	}
+	if len(regexpCache) >= maxRegexpCacheSize {
+		regexpCache = map[regexpCacheKey]*regexp.Regexp{}
+	}
+	regexpCache[key] = re
+	return re
+}
+
+// translateIRegexp translates an I-regexp to Go syntax.  They only differ in
+// that '.' outside of character classes does not match '\n' or '\r' in an
+// I-regexp, whereas in Go it matches '\r'.
+func translateIRegexp(ptn string) string {
+	var b strings.Builder
+	inClass := false
+	for i := 0; i < len(ptn); i++ {
+		c := ptn[i]
+		switch {
+		case c == '\\' && i+1 < len(ptn):
+			b.WriteByte(c)
+			i++
+			c = ptn[i]
+		case inClass:
+			inClass = c != ']'
+		case c == '[':
+			inClass = true
+		case c == '.':
+			b.WriteString(`[^\n\r]`)
+			continue
 		}
+		b.WriteByte(c)
 	}
-	return loc
+	return b.String()
 }
 
 func run_value(args []any) any {
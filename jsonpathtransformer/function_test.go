@@ -0,0 +1,197 @@
+package jsonpathtransformer_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/arnodel/jsonstream/internal/jsonpath"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+	"github.com/arnodel/jsonstream/token"
+)
+
+var lowerFunctionDef = jsonpathtransformer.FunctionDef{
+	InputTypes: []jsonpathtransformer.Type{jsonpathtransformer.ValueType},
+	OutputType: jsonpathtransformer.ValueType,
+	Run: func(args []any) any {
+		arg, ok := args[0].(*iterator.Scalar)
+		if !ok || arg.Scalar().Type() != token.String {
+			return nil
+		}
+		lower := strings.ToLower(arg.Scalar().ToString())
+		return (*iterator.Scalar)(token.NewScalar(token.String, []byte(`"`+lower+`"`)))
+	},
+}
+
+func TestWithFunctionRegistry(t *testing.T) {
+	registry := jsonpathtransformer.NewStandardFunctionRegistry()
+	def := lowerFunctionDef
+	def.Name = "lower"
+	if err := registry.AddFunctionDef(def); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	query, err := jsonpath.ParseQueryString(`$[?lower(@.name) == "bob" && length(@.name) == 3].id`)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	if _, err := jsonpathtransformer.CompileQuery(query); err == nil || err.Error() != `unknown function "lower"` {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	runner, err := jsonpathtransformer.CompileQuery(query, jsonpathtransformer.WithFunctionRegistry(registry))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var b strings.Builder
+	for tok := range token.TransformStream(streamJsonString(`[{"name": "BoB", "id": 1}, {"name": "Al", "id": 2}, {"name": "bob", "id": 3}]`), runner) {
+		b.WriteString(tok.String())
+	}
+	if got, expected := b.String(), "Scalar(1)Scalar(3)"; got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	if err := jsonpathtransformer.RegisterFunction("test_lower", lowerFunctionDef); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	runSimpleTest(t, `["A", 1, "b"]`, `$[?test_lower(@) == "a"]`, `"A"`)
+}
+
+// Functions can be added to a registry while queries are compiled with it in
+// other goroutines (run with -race to check).
+func TestFunctionRegistryConcurrentUse(t *testing.T) {
+	registry := jsonpathtransformer.NewStandardFunctionRegistry()
+	query, err := jsonpath.ParseQueryString(`$[?length(@) == 1]`)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			def := lowerFunctionDef
+			def.Name = fmt.Sprintf("lower_%d", i)
+			if err := registry.AddFunctionDef(def); err != nil {
+				t.Errorf("Unexpected error: %s", err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := jsonpathtransformer.CompileQuery(query, jsonpathtransformer.WithFunctionRegistry(registry)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestRegisterFunctionError(t *testing.T) {
+	type testCase struct {
+		name    string
+		fname   string
+		def     jsonpathtransformer.FunctionDef
+		message string
+	}
+	var testCases = []testCase{
+		{
+			name:    "existing function",
+			fname:   "length",
+			def:     lowerFunctionDef,
+			message: `function "length" already exists`,
+		},
+		{
+			name:    "invalid name",
+			fname:   "Lower",
+			def:     lowerFunctionDef,
+			message: `invalid function name "Lower"`,
+		},
+		{
+			name:    "missing Run",
+			fname:   "no_run",
+			def:     jsonpathtransformer.FunctionDef{OutputType: jsonpathtransformer.LogicalType},
+			message: `function "no_run": missing Run`,
+		},
+		{
+			name:  "nodes output",
+			fname: "nodes_out",
+			def: jsonpathtransformer.FunctionDef{
+				OutputType: jsonpathtransformer.NodesType,
+				Run:        func([]any) any { return nil },
+			},
+			message: `function "nodes_out": invalid output type`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			err := jsonpathtransformer.RegisterFunction(c.fname, c.def)
+			if err == nil || err.Error() != c.message {
+				t.Fatalf("Expected error %q, got %v", c.message, err)
+			}
+		})
+	}
+}
+
+// These complement the functions tests in cts.json.
+func TestStandardFunctions(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		query  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "length of an object",
+			input:  `[{"a": 1, "b": 2}, {"a": 1}]`,
+			query:  `$[?length(@) == 2]`,
+			output: `{"a": 1, "b": 2}`,
+		},
+		{
+			name:   "match alternative matching the whole string",
+			input:  `["ab", "a", "abc"]`,
+			query:  `$[?match(@, "a|ab")]`,
+			output: `"ab" "a"`,
+		},
+		{
+			name:   "match dot does not match carriage return",
+			input:  `["a\rb", "a b", "axb"]`,
+			query:  `$[?match(@, "a.b")]`,
+			output: `"a b" "axb"`,
+		},
+		{
+			name:   "search dot does not match new line",
+			input:  `["a\nb", "xa-by"]`,
+			query:  `$[?search(@, "a.b")]`,
+			output: `"xa-by"`,
+		},
+		{
+			name:   "escaped dot",
+			input:  `["a.b", "axb"]`,
+			query:  `$[?match(@, "a\\.b")]`,
+			output: `"a.b"`,
+		},
+		{
+			name:   "dot in character class",
+			input:  `["a.b", "axb"]`,
+			query:  `$[?match(@, "a[.]b")]`,
+			output: `"a.b"`,
+		},
+		{
+			name:   "invalid regexp",
+			input:  `["a"]`,
+			query:  `$[?search(@, "(")]`,
+			output: ``,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runQueryString(t, c.input, c.query)
+			expected := runQueryString(t, c.output, `$`)
+			if got != expected {
+				t.Fatalf("Expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
@@ -115,9 +115,7 @@ func checkLessThan(left iterator.Value, right iterator.Value) bool {
 	}
 	switch xs.Type() {
 	case token.Number:
-		xx := parser.ParseJsonLiteralBytes(xs.Bytes).(float64)
-		yy := parser.ParseJsonLiteralBytes(ys.Bytes).(float64)
-		return xx < yy
+		return token.CompareNumbers(xs, ys) < 0
 	case token.String:
 		xx := parser.ParseJsonLiteralBytes(xs.Bytes).(string)
 		yy := parser.ParseJsonLiteralBytes(ys.Bytes).(string)
@@ -0,0 +1,117 @@
+package jsonpathtransformer
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A NormalizedPath locates a node in a value as the sequence of object keys
+// and array indices leading to it from the value.  The empty path locates the
+// value itself.
+type NormalizedPath []PathElement
+
+// A PathElement is an object key if Key is not nil, otherwise an array index.
+type PathElement struct {
+	Key   *token.Scalar
+	Index int64
+}
+
+// String returns the path in the format of RFC 9535 normalized paths, e.g.
+// $['users'][0]['name'].
+func (p NormalizedPath) String() string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, elt := range p {
+		b.WriteByte('[')
+		if elt.Key == nil {
+			b.WriteString(strconv.FormatInt(elt.Index, 10))
+		} else {
+			writeNormalizedName(&b, elt.Key.ToString())
+		}
+		b.WriteByte(']')
+	}
+	return b.String()
+}
+
+// writeNormalizedName writes name as a single quoted string, escaped as
+// specified for normalized paths.
+func writeNormalizedName(b *strings.Builder, name string) {
+	b.WriteByte('\'')
+	for _, r := range name {
+		switch {
+		case r == '\'' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20:
+			b.WriteString(`\u00`)
+			b.WriteString(strconv.FormatInt(int64(r)>>4, 16))
+			b.WriteString(strconv.FormatInt(int64(r)&0xF, 16))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+}
+
+// EvaluatePaths returns the normalized paths of the nodes selected by the query
+// in value, in the order in which the nodes would be output.  It consumes
+// value, so callers should pass a clone if they need the value afterwards.
+func (r MainQueryRunner) EvaluatePaths(value iterator.Value) []NormalizedPath {
+	ctx := r.computeRunContext(value)
+	ctx.trackPaths = true
+	var paths []NormalizedPath
+	r.mainRunner.MapValue(ctx, value, pathCollector{paths: &paths})
+	return paths
+}
+
+// pathCollector is a valueProcessor which records the paths of the values it
+// processes.
+type pathCollector struct {
+	paths *[]NormalizedPath
+}
+
+func (c pathCollector) ProcessValue(ctx *RunContext, value iterator.Value) bool {
+	*c.paths = append(*c.paths, slices.Clone(ctx.path))
+	return true
+}
+
+var (
+	pathKey  = token.NewKey(token.String, []byte(`"path"`))
+	valueKey = token.NewKey(token.String, []byte(`"value"`))
+)
+
+// pathWritingProcessor is a valueProcessor which outputs the paths of the
+// values it processes (see OutputPaths and OutputPathValues).
+type pathWritingProcessor struct {
+	out        token.WriteStream
+	withValues bool
+}
+
+func (p pathWritingProcessor) ProcessValue(ctx *RunContext, value iterator.Value) bool {
+	path := token.StringScalar(ctx.path.String())
+	if !p.withValues {
+		p.out.Put(path)
+		return true
+	}
+	p.out.Put(&token.StartObject{})
+	p.out.Put(pathKey)
+	p.out.Put(path)
+	p.out.Put(valueKey)
+	value.Copy(p.out)
+	p.out.Put(&token.EndObject{})
+	return true
+}
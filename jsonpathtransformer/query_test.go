@@ -1,11 +1,14 @@
 package jsonpathtransformer_test
 
 import (
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/arnodel/jsonstream"
 	"github.com/arnodel/jsonstream/internal/jsonpath"
+	"github.com/arnodel/jsonstream/iterator"
 	"github.com/arnodel/jsonstream/jsonpathtransformer"
 	"github.com/arnodel/jsonstream/token"
 )
@@ -32,20 +35,20 @@ func runSimpleTest(t *testing.T, input, query, output string) {
 
 }
 
-func compileQueryString(s string) (jsonpathtransformer.MainQueryRunner, error) {
+func compileQueryString(s string, options ...jsonpathtransformer.CompileOption) (jsonpathtransformer.MainQueryRunner, error) {
 	query, err := jsonpath.ParseQueryString(s)
 	if err != nil {
 		return jsonpathtransformer.MainQueryRunner{}, err
 	}
-	return jsonpathtransformer.CompileQuery(query)
+	return jsonpathtransformer.CompileQuery(query, options...)
 }
 
-func compileQueryStringStrict(s string) (jsonpathtransformer.MainQueryRunner, error) {
+func compileQueryStringStrict(s string, options ...jsonpathtransformer.CompileOption) (jsonpathtransformer.MainQueryRunner, error) {
 	query, err := jsonpath.ParseQueryStringStrict(s)
 	if err != nil {
 		return jsonpathtransformer.MainQueryRunner{}, err
 	}
-	return jsonpathtransformer.CompileQuery(query)
+	return jsonpathtransformer.CompileQuery(query, options...)
 }
 
 func streamJsonString(s string) <-chan token.Token {
@@ -167,6 +170,54 @@ func TestSimplQueries(t *testing.T) {
 			query:  `$..["b"]`,
 			output: `1 {"a": 2}`,
 		},
+		{
+			name:   "descendant wildcard in nested arrays",
+			input:  `[[[1]]]`,
+			query:  `$..*`,
+			output: `[[1]] [1] 1`,
+		},
+		{
+			name:   "reverse slice of collections",
+			input:  `[[1], {"a": 2}, 3]`,
+			query:  `$[::-1]`,
+			output: `3 {"a": 2} [1]`,
+		},
+		{
+			name:   "reverse slice with step and default end",
+			input:  `[0, 1, 2, 3, 4, 5, 6]`,
+			query:  `$[4::-3]`,
+			output: `4 1`,
+		},
+		{
+			name:   "filter does not select into scalars",
+			input:  `[2, {"x": 2}]`,
+			query:  `$[?@.x == 2]`,
+			output: `{"x": 2}`,
+		},
+		{
+			name:   "big integer equality",
+			input:  `[9007199254740992, 9007199254740993, 9007199254740993.0]`,
+			query:  `$[?@ == 9007199254740993]`,
+			output: `9007199254740993 9007199254740993.0`,
+		},
+		{
+			name:   "big integer comparison",
+			input:  `[12345678901234567890123, 12345678901234567890124]`,
+			query:  `$[?@ > 12345678901234567890123]`,
+			output: `12345678901234567890124`,
+		},
+		{
+			name:   "precise decimal comparison",
+			input:  `[0.10000000000000000001, 0.1, 0.09999999999999999999]`,
+			query:  `$[?@ < 0.1]`,
+			output: `0.09999999999999999999`,
+		},
+		{
+			name:   "number out of float64 range",
+			input:  `[1e400, 2e400, 1]`,
+			query:  `$[?@ > 1e400]`,
+			output: `2e400`,
+		},
 	}
 	for _, c := range testCases {
 		t.Run(c.name, func(t *testing.T) {
@@ -174,3 +225,606 @@ func TestSimplQueries(t *testing.T) {
 		})
 	}
 }
+
+func runQueryString(t testing.TB, input, query string) string {
+	runner, err := compileQueryString(query)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	var b strings.Builder
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	if err := token.ConsumeStream(token.TransformStream(streamJsonString(input), runner), encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return b.String()
+}
+
+// The wildcard selector has a fast path when it is alone in a child segment,
+// so check it gives the same output as the equivalent slice selector.
+func TestWildcardSegment(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		query    string
+		expected string
+	}
+	var testCases = []testCase{
+		{
+			name:     "array items",
+			input:    `{"items": [1, {"a": [2]}, [3, 4]]} {"items": []} {"items": 5}`,
+			query:    `$.items[*]`,
+			expected: `$.items[0:]`,
+		},
+		{
+			name:     "nested wildcards",
+			input:    `{"items": [[1, 2], [], [[3], 4], 5]}`,
+			query:    `$.items[*][*]`,
+			expected: `$.items[0:][0:]`,
+		},
+		{
+			name:     "followed by filter",
+			input:    `{"items": [{"id": 1}, {"id": 2}, [{"id": 3}]]}`,
+			query:    `$.items[*][?@.id > 1]`,
+			expected: `$.items[0:][?@.id > 1]`,
+		},
+		{
+			name:     "object values",
+			input:    `{"a": 1, "b": [2], "c": {"d": 3}}`,
+			query:    `$.*.*`,
+			expected: `$[?@][?@]`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := runQueryString(t, c.input, c.query)
+			expected := runQueryString(t, c.input, c.expected)
+			if got != expected {
+				t.Fatalf("Expected %q, got %q", expected, got)
+			}
+		})
+	}
+}
+
+func BenchmarkWildcardSegment(b *testing.B) {
+	var input strings.Builder
+	input.WriteString(`{"items": [`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			input.WriteString(", ")
+		}
+		input.WriteString(`{"id": 1, "tags": ["x", "y"], "name": "item"}`)
+	}
+	input.WriteString(`]}`)
+	for _, query := range []string{`$.items[*]`, `$.items[0:]`} {
+		b.Run(query, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				runQueryString(b, input.String(), query)
+			}
+		})
+	}
+}
+
+func BenchmarkNameSelector(b *testing.B) {
+	var input strings.Builder
+	input.WriteString(`[`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			input.WriteString(", ")
+		}
+		input.WriteString(`{"id": 1, "tags": ["x", "y"], "name": "item", "description": "an item"}`)
+	}
+	input.WriteString(`]`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runQueryString(b, input.String(), `$[*].name`)
+	}
+}
+
+func TestDescendantMaxDepth(t *testing.T) {
+	nested := func(depth int) string {
+		return strings.Repeat(`{"x": 1, "a": `, depth) + `{"x": 2}` + strings.Repeat("}", depth)
+	}
+	type testCase struct {
+		name     string
+		input    string
+		query    string
+		maxDepth int
+		output   string
+		err      string
+	}
+	var testCases = []testCase{
+		{
+			name:     "within limit",
+			input:    nested(3),
+			query:    `$..x`,
+			maxDepth: 10,
+			output:   "1\n1\n1\n2\n",
+		},
+		{
+			name:     "no limit",
+			input:    nested(1000),
+			query:    `$..[?@.x == 2]`,
+			maxDepth: 0,
+			output:   "{\"x\": 2}\n",
+		},
+		{
+			name:     "limit exceeded",
+			input:    nested(1000),
+			query:    `$..x`,
+			maxDepth: 100,
+			err:      "jsonpath: descendant segment exceeds max depth of 100",
+		},
+		{
+			name:     "limit exceeded in array",
+			input:    strings.Repeat("[", 50) + strings.Repeat("]", 50),
+			query:    `$..[?@ == 0]`,
+			maxDepth: 10,
+			err:      "jsonpath: descendant segment exceeds max depth of 10",
+		},
+	}
+	for _, c := range testCases {
+		for _, strict := range []bool{false, true} {
+			name := c.name
+			compile := compileQueryString
+			if strict {
+				name += " (strict)"
+				compile = compileQueryStringStrict
+			}
+			t.Run(name, func(t *testing.T) {
+				runner, err := compile(c.query)
+				if err != nil {
+					t.Fatalf("Invalid query: %s", err)
+				}
+				var transformErr error
+				stream := token.TransformStreamWithErrorHandler(
+					streamJsonString(c.input),
+					runner.WithMaxDepth(c.maxDepth),
+					func(err error) { transformErr = err },
+				)
+				var b strings.Builder
+				encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+				if err := token.ConsumeStream(stream, encoder); err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if c.err != "" {
+					if transformErr == nil || transformErr.Error() != c.err {
+						t.Fatalf("Expected error %q, got %v", c.err, transformErr)
+					}
+					return
+				}
+				if transformErr != nil {
+					t.Fatalf("Unexpected error: %s", transformErr)
+				}
+				if b.String() != c.output {
+					t.Fatalf("Expected %q, got %q", c.output, b.String())
+				}
+			})
+		}
+	}
+}
+
+func TestMaxWindowSize(t *testing.T) {
+	var many strings.Builder
+	for i := 0; i < 1000; i++ {
+		many.WriteString(`{"a": {"x": 1}, "b": [{"x": 2}]} `)
+	}
+	type testCase struct {
+		name          string
+		input         string
+		query         string
+		maxWindowSize int
+		outputLen     int
+		err           string
+	}
+	var testCases = []testCase{
+		{
+			name:          "many small values",
+			input:         many.String(),
+			query:         `$..x`,
+			maxWindowSize: 50,
+			outputLen:     2000,
+		},
+		{
+			name:          "descendant in large value",
+			input:         `[` + strings.Repeat(`{"x": 1, "y": [{"x": 2}]}, `, 1000) + `{"x": 3}]`,
+			query:         `$..x`,
+			maxWindowSize: 50,
+			outputLen:     2001,
+		},
+		{
+			name:          "no limit",
+			input:         `[` + strings.Repeat(`{"x": 1}, `, 1000) + `{"x": 2}]`,
+			query:         `$[?@.x == $[-1].x]`,
+			maxWindowSize: 0,
+			outputLen:     1,
+		},
+		{
+			name:          "limit exceeded",
+			input:         `[` + strings.Repeat(`{"x": 1}, `, 1000) + `{"x": 2}]`,
+			query:         `$[?@.x == $[-1].x]`,
+			maxWindowSize: 50,
+			err:           "cursors diverge by more than the max window size of 50 tokens",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			runner, err := compileQueryString(c.query)
+			if err != nil {
+				t.Fatalf("Invalid query: %s", err)
+			}
+			var transformErr error
+			stream := token.TransformStreamWithErrorHandler(
+				streamJsonString(c.input),
+				runner.WithMaxWindowSize(c.maxWindowSize),
+				func(err error) { transformErr = err },
+			)
+			outputLen := 0
+			depth := 0
+			for tok := range stream {
+				if depth == 0 {
+					outputLen++
+				}
+				switch tok.(type) {
+				case *token.StartObject, *token.StartArray:
+					depth++
+				case *token.EndObject, *token.EndArray:
+					depth--
+				}
+			}
+			if c.err != "" {
+				if transformErr == nil || transformErr.Error() != c.err {
+					t.Fatalf("Expected error %q, got %v", c.err, transformErr)
+				}
+				return
+			}
+			if transformErr != nil {
+				t.Fatalf("Unexpected error: %s", transformErr)
+			}
+			if outputLen != c.outputLen {
+				t.Fatalf("Expected %d values, got %d", c.outputLen, outputLen)
+			}
+		})
+	}
+}
+
+func TestEvaluatePaths(t *testing.T) {
+	type testCase struct {
+		name  string
+		input string
+		query string
+		paths []string
+	}
+	var testCases = []testCase{
+		{
+			name:  "root",
+			input: `[1]`,
+			query: `$`,
+			paths: []string{`$`},
+		},
+		{
+			name:  "child segments",
+			input: `{"users": [{"name": "a"}, {"id": 1}, {"name": "b"}]}`,
+			query: `$.users[*].name`,
+			paths: []string{`$['users'][0]['name']`, `$['users'][2]['name']`},
+		},
+		{
+			name:  "selection order",
+			input: `[0, 1, 2, 3]`,
+			query: `$[2, 0, ::-2]`,
+			paths: []string{`$[2]`, `$[0]`, `$[3]`, `$[1]`},
+		},
+		{
+			name:  "descendant segment",
+			input: `{"a": {"b": 1, "a": [2]}}`,
+			query: `$..a`,
+			paths: []string{`$['a']`, `$['a']['a']`},
+		},
+		{
+			name:  "filter",
+			input: `[{"x": 1}, {"x": 2}, {"y": 2}]`,
+			query: `$[?@.x > 1 || @.y]`,
+			paths: []string{`$[1]`, `$[2]`},
+		},
+		{
+			name:  "escaped names",
+			input: `{"it's": {"a\nb": 1}}`,
+			query: `$.*.*`,
+			paths: []string{`$['it\'s']['a\nb']`},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			runner, err := compileQueryString(c.query)
+			if err != nil {
+				t.Fatalf("Invalid query: %s", err)
+			}
+			iter := iterator.New(token.ChannelReadStream(streamJsonString(c.input)))
+			if !iter.Advance() {
+				t.Fatal("Expected a value")
+			}
+			var got []string
+			for _, path := range runner.EvaluatePaths(iter.CurrentValue()) {
+				got = append(got, path.String())
+			}
+			if !slices.Equal(got, c.paths) {
+				t.Fatalf("Expected %q, got %q", c.paths, got)
+			}
+		})
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	type testCase struct {
+		name   string
+		mode   jsonpathtransformer.OutputMode
+		query  string
+		output string
+	}
+	input := `{"a": [{"x": 1}, {"y": 2}, {"x": 3}]} {"b": 1}`
+	var testCases = []testCase{
+		{
+			name:   "values",
+			mode:   jsonpathtransformer.OutputValues,
+			query:  `$.a[*].x`,
+			output: "1\n3\n",
+		},
+		{
+			name:   "paths",
+			mode:   jsonpathtransformer.OutputPaths,
+			query:  `$..x`,
+			output: "\"$['a'][0]['x']\"\n\"$['a'][2]['x']\"\n",
+		},
+		{
+			name:   "path values",
+			mode:   jsonpathtransformer.OutputPathValues,
+			query:  `$.a[?@.y]`,
+			output: "{\"path\": \"$['a'][1]\",\"value\": {\"y\": 2}}\n",
+		},
+		{
+			name:   "exists",
+			mode:   jsonpathtransformer.OutputExists,
+			query:  `$.a[*].x`,
+			output: "true\nfalse\n",
+		},
+		{
+			name:   "count",
+			mode:   jsonpathtransformer.OutputCount,
+			query:  `$.a[*].x`,
+			output: "2\n0\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			runner, err := compileQueryString(c.query)
+			if err != nil {
+				t.Fatalf("Invalid query: %s", err)
+			}
+			var b strings.Builder
+			encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+			stream := token.TransformStream(streamJsonString(input), runner.WithOutputMode(c.mode))
+			if err := token.ConsumeStream(stream, encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := b.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestStrictOrder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		query  string
+		strict string
+		lax    string
+	}
+	var testCases = []testCase{
+		{
+			name:   "reversed indices",
+			input:  `[1, 2, 3]`,
+			query:  `$[2, 0]`,
+			strict: "3\n1\n",
+			lax:    "1\n3\n",
+		},
+		{
+			name:   "duplicates",
+			input:  `[1, 2, 3]`,
+			query:  `$[0, 0:2]`,
+			strict: "1\n1\n2\n",
+			lax:    "1\n2\n",
+		},
+		{
+			name:   "reversed slice",
+			input:  `[1, 2, 3]`,
+			query:  `$[::-1]`,
+			strict: "3\n2\n1\n",
+			lax:    "1\n2\n3\n",
+		},
+		{
+			name:   "names",
+			input:  `[{"a": 1, "c": 2}, {"a": 3}]`,
+			query:  `$..['c', 'a']`,
+			strict: "2\n1\n3\n",
+			lax:    "1\n2\n3\n",
+		},
+		{
+			name:   "following segments",
+			input:  `[{"x": 1}, {"x": 2}]`,
+			query:  `$[1, 0].x`,
+			strict: "2\n1\n",
+			lax:    "1\n2\n",
+		},
+	}
+	for _, c := range testCases {
+		for _, strict := range []bool{true, false} {
+			name, output := c.name+" (strict)", c.strict
+			if !strict {
+				name, output = c.name+" (lax)", c.lax
+			}
+			t.Run(name, func(t *testing.T) {
+				runner, err := compileQueryString(c.query, jsonpathtransformer.WithStrictOrder(strict))
+				if err != nil {
+					t.Fatalf("Invalid query: %s", err)
+				}
+				var b strings.Builder
+				encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+				if err := token.ConsumeStream(token.TransformStream(streamJsonString(c.input), runner), encoder); err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if got := b.String(); got != output {
+					t.Fatalf("Expected %q, got %q", output, got)
+				}
+			})
+		}
+	}
+}
+
+func TestAllDuplicateKeys(t *testing.T) {
+	type testCase struct {
+		name  string
+		input string
+		query string
+		first string
+		all   string
+	}
+	var testCases = []testCase{
+		{
+			name:  "name selector",
+			input: `{"a": 1, "b": 2, "a": 3}`,
+			query: `$.a`,
+			first: "1\n",
+			all:   "1\n3\n",
+		},
+		{
+			name:  "several names",
+			input: `{"a": 1, "b": 2, "a": 3, "b": 4}`,
+			query: `$['b', 'a']`,
+			first: "2\n1\n",
+			all:   "2\n4\n1\n3\n",
+		},
+		{
+			name:  "singular query in filter",
+			input: `[{"a": 1, "a": 2}, {"a": 2, "a": 1}]`,
+			query: `$[?@.a == 1]`,
+			first: "{\"a\": 1,\"a\": 2}\n",
+			all:   "{\"a\": 1,\"a\": 2}\n",
+		},
+	}
+	for _, c := range testCases {
+		for _, all := range []bool{false, true} {
+			name, output := c.name+" (first)", c.first
+			if all {
+				name, output = c.name+" (all)", c.all
+			}
+			t.Run(name, func(t *testing.T) {
+				runner, err := compileQueryString(c.query, jsonpathtransformer.WithAllDuplicateKeys(all))
+				if err != nil {
+					t.Fatalf("Invalid query: %s", err)
+				}
+				var b strings.Builder
+				encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+				if err := token.ConsumeStream(token.TransformStream(streamJsonString(c.input), runner), encoder); err != nil {
+					t.Fatalf("Unexpected error: %s", err)
+				}
+				if got := b.String(); got != output {
+					t.Fatalf("Expected %q, got %q", output, got)
+				}
+			})
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	runner, err := compileQueryString(`$[?@.x > $[0].x].x`)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	type testCase struct {
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{input: `[{"x": 1}, {"x": 3}, {"x": 0}, {"x": 2}]`, output: "3\n2\n"},
+		{input: `[{"x": 5}, {"x": 3}] [{"x": 1}, {"x": 6}]`, output: "6\n"},
+		{input: `{"a": {"x": 2}}`, output: ""},
+	}
+	// The same runner is used for all the documents, concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, c := range testCases {
+			c := c
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var b strings.Builder
+				out := token.NewAccumulatorStream()
+				if err := runner.Run(token.ChannelReadStream(streamJsonString(c.input)), out); err != nil {
+					t.Errorf("Unexpected error: %s", err)
+					return
+				}
+				encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+				if err := encoder.Consume(sliceChannel(out.GetTokens())); err != nil {
+					t.Errorf("Unexpected error: %s", err)
+				}
+				if got := b.String(); got != c.output {
+					t.Errorf("Expected %q, got %q", c.output, got)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestRunError(t *testing.T) {
+	runner, err := compileQueryString(`$..x`)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	err = runner.WithMaxDepth(2).Run(token.ChannelReadStream(streamJsonString(`[[[{"x": 1}]]]`)), token.NewAccumulatorStream())
+	expected := "jsonpath: descendant segment exceeds max depth of 2"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error %q, got %v", expected, err)
+	}
+}
+
+func TestEvaluateOnValue(t *testing.T) {
+	runner, err := compileQueryString(`$.items[?@.price < $.max].name`)
+	if err != nil {
+		t.Fatalf("Invalid query: %s", err)
+	}
+	input := `{"items": [{"name": "a", "price": 3}, {"name": {"first": "b"}, "price": 1}], "max": 2}
+{"items": [{"name": "c", "price": 1}, {"name": "d", "price": 0}], "max": 5}`
+	var got []string
+	iter := iterator.New(token.ChannelReadStream(streamJsonString(input)))
+	for iter.Advance() {
+		nodes, err := runner.EvaluateOnValue(iter.CurrentValue())
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		// The nodes can be used after the value has been consumed.
+		for _, node := range nodes {
+			var b strings.Builder
+			encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+			if err := encoder.EncodeValue(node); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			got = append(got, b.String())
+		}
+	}
+	expected := []string{`{"first": "b"}`, `"c"`, `"d"`}
+	if !slices.Equal(got, expected) {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
+
+// sliceChannel returns a closed channel containing toks.
+func sliceChannel(toks []token.Token) <-chan token.Token {
+	ch := make(chan token.Token, len(toks))
+	for _, tok := range toks {
+		ch <- tok
+	}
+	close(ch)
+	return ch
+}
@@ -1,6 +1,8 @@
 package jsonpathtransformer
 
 import (
+	"slices"
+
 	"github.com/arnodel/jsonstream/iterator"
 	"github.com/arnodel/jsonstream/token"
 )
@@ -9,14 +11,114 @@ type MainQueryRunner struct {
 	mainRunner           QueryEvaluator
 	innerSingularQueries []SingularQueryRunner
 	innerQueries         []QueryEvaluator
+	maxDepth             int
+	maxWindowSize        int
+	outputMode           OutputMode
+}
+
+// An OutputMode determines what MainQueryRunner.Transform outputs for the
+// nodes selected by the query in each value of the stream.
+type OutputMode uint8
+
+const (
+	OutputValues     OutputMode = iota // The selected nodes (the default)
+	OutputPaths                        // The normalized path of each node, as a string
+	OutputPathValues                   // {"path": <path>, "value": <node>} for each node
+	OutputExists                       // true if a node is selected, false otherwise
+	OutputCount                        // The number of selected nodes
+)
+
+// WithOutputMode returns a copy of the runner which outputs the nodes selected
+// by the query in the given mode when used as a StreamTransformer.  Note that
+// OutputExists and OutputCount output exactly one value for each input value.
+func (r MainQueryRunner) WithOutputMode(mode OutputMode) MainQueryRunner {
+	r.outputMode = mode
+	return r
+}
+
+// WithMaxDepth returns a copy of the runner which fails with a
+// *token.TransformError when a descendant segment (e.g. $..x) needs to look
+// more than maxDepth levels deep into a value.  This bounds the recursion for
+// pathologically nested input.  If maxDepth is 0 or less, there is no limit.
+func (r MainQueryRunner) WithMaxDepth(maxDepth int) MainQueryRunner {
+	r.maxDepth = maxDepth
+	return r
 }
 
+// WithMaxWindowSize returns a copy of the runner which bounds the number of
+// input tokens buffered while the query reads values more than once (e.g. when
+// evaluating filters or descendant segments).  When the bound is exceeded, the
+// runner fails with a *token.TransformError.  If maxWindowSize is 0 or less,
+// there is no limit.  See token.CursorPool for details.
+func (r MainQueryRunner) WithMaxWindowSize(maxWindowSize int) MainQueryRunner {
+	r.maxWindowSize = maxWindowSize
+	return r
+}
+
+// Transform implements token.StreamTransformer, running the query on each value
+// of the stream.  It panics with a *token.TransformError if the query fails.
 func (r MainQueryRunner) Transform(in <-chan token.Token, out token.WriteStream) {
+	stream := token.NewStreamErrorReader(in)
+	r.run(stream, out)
+	stream.ForwardError(out)
+}
+
+// Run runs the query on each value read from src and writes the nodes it
+// selects to out (according to the output mode of the runner).  It is like
+// Transform but does not need channels, and returns the error which made the
+// query fail (a *token.TransformError) instead of panicking.
+//
+// Running a query does not change the runner, so a query can be compiled once
+// and run on many independent documents (e.g. one per HTTP request), also
+// concurrently from several goroutines.
+func (r MainQueryRunner) Run(src token.ReadStream, out token.WriteStream) (err error) {
+	defer token.CatchTransformError(&err)
+	r.run(src, out)
+	return nil
+}
+
+// EvaluateOnValue returns the nodes the query selects in value, in the order
+// they would be output (the output mode of the runner is ignored).  The nodes
+// are copied so they can still be used after value has been consumed.  Like
+// Run, it can be called many times on the same runner.
+func (r MainQueryRunner) EvaluateOnValue(value iterator.Value) (nodes []iterator.Value, err error) {
+	defer token.CatchTransformError(&err)
+	ctx := r.computeRunContext(value)
+	r.mainRunner.MapValue(ctx, value, callbackProcessor(func(node iterator.Value) bool {
+		nodes = append(nodes, copyValue(node))
+		return true
+	}))
+	return nodes, nil
+}
+
+func (r MainQueryRunner) run(src token.ReadStream, out token.WriteStream) {
 	next := streamWritingProcessor{out: out}
-	iter := iterator.New(token.ChannelReadStream(in))
+	pool := token.NewCursorPool(src)
+	pool.MaxWindowSize = r.maxWindowSize
+	iter := iterator.New(pool.NewCursor())
 	for iter.Advance() {
 		value := iter.CurrentValue()
-		r.mainRunner.MapValue(r.computeRunContext(value), value, next)
+		ctx := r.computeRunContext(value)
+		switch r.outputMode {
+		case OutputPaths:
+			ctx.trackPaths = true
+			r.mainRunner.MapValue(ctx, value, pathWritingProcessor{out: out})
+		case OutputPathValues:
+			ctx.trackPaths = true
+			r.mainRunner.MapValue(ctx, value, pathWritingProcessor{out: out, withValues: true})
+		case OutputExists:
+			found := !r.mainRunner.MapValue(ctx, value, haltingProcessor{})
+			out.Put(token.BoolScalar(found))
+		case OutputCount:
+			var n int64
+			r.mainRunner.MapValue(ctx, value, callbackProcessor(func(iterator.Value) bool {
+				n++
+				return true
+			}))
+			out.Put(token.Int64Scalar(n))
+		default:
+			r.mainRunner.MapValue(ctx, value, next)
+		}
 	}
 }
 
@@ -24,23 +126,14 @@ func (r MainQueryRunner) computeRunContext(value iterator.Value) *RunContext {
 	ctx := &RunContext{
 		innerSingularQueries: make([]iterator.Value, len(r.innerSingularQueries)),
 		innerQueries:         make([]*iterator.Iterator, len(r.innerQueries)),
+		maxDepth:             r.maxDepth,
 	}
 	for i, q := range r.innerSingularQueries {
 		clone, detach := value.Clone()
 
 		// There is nothing useful in the context to compute a singular query
 		// value, so it is safe to pass nil.
-		val := q.Evaluate(nil, clone)
-		if scalar, ok := val.(*iterator.Scalar); ok {
-			ctx.innerSingularQueries[i] = scalar
-		} else {
-			dest := token.NewAccumulatorStream()
-			val.Copy(dest)
-			cursor := token.NewCursorFromData(dest.GetTokens())
-			iter := iterator.New(cursor)
-			iter.Advance()
-			ctx.innerSingularQueries[i] = iter.CurrentValue()
-		}
+		ctx.innerSingularQueries[i] = copyValue(q.Evaluate(nil, clone))
 		detach()
 	}
 	for i, q := range r.innerQueries {
@@ -58,6 +151,20 @@ func (r MainQueryRunner) computeRunContext(value iterator.Value) *RunContext {
 	return ctx
 }
 
+// copyValue returns a copy of value which does not depend on the stream value
+// is read from, consuming value.  A nil value (i.e. nothing) is returned as is.
+func copyValue(value iterator.Value) iterator.Value {
+	switch value.(type) {
+	case nil, *iterator.Scalar:
+		return value
+	}
+	dest := token.NewAccumulatorStream()
+	value.Copy(dest)
+	iter := iterator.New(token.NewCursorFromData(dest.GetTokens()))
+	iter.Advance()
+	return iter.CurrentValue()
+}
+
 func (r MainQueryRunner) TransformValue(value iterator.Value, out token.WriteStream) {
 	r.mainRunner.TransformValue(r.computeRunContext(value), value, out)
 }
@@ -73,6 +180,39 @@ func (r MainQueryRunner) EvaluateNodesResult(value iterator.Value) NodesResult {
 type RunContext struct {
 	innerSingularQueries []iterator.Value
 	innerQueries         []*iterator.Iterator
+
+	// Current and maximum depth of descendant segments (see
+	// MainQueryRunner.WithMaxDepth)
+	depth, maxDepth int
+
+	// When trackPaths is true, path is the path of the value being processed
+	// relative to the value the query runs on (see
+	// MainQueryRunner.EvaluatePaths).
+	trackPaths bool
+	path       NormalizedPath
+}
+
+// pushPath appends an element to the current path if paths are tracked.
+func (ctx *RunContext) pushPath(elt PathElement) {
+	if ctx.trackPaths {
+		ctx.path = append(ctx.path, elt)
+	}
+}
+
+// popPath removes the last element of the current path if paths are tracked.
+func (ctx *RunContext) popPath() {
+	if ctx.trackPaths {
+		ctx.path = ctx.path[:len(ctx.path)-1]
+	}
+}
+
+// savePath returns a copy of the current path if paths are tracked, so that it
+// can be restored when processing a value later.
+func (ctx *RunContext) savePath() NormalizedPath {
+	if ctx.trackPaths {
+		return slices.Clone(ctx.path)
+	}
+	return nil
 }
 
 type ValueMapper interface {
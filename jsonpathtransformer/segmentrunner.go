@@ -4,6 +4,7 @@ import (
 	"math"
 
 	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
 )
 
 //
@@ -23,9 +24,21 @@ type SegmentRunner struct {
 	// There are two kinds of segments, child or descendant.  This field is true
 	// iff this is a descendant segment.
 	isDescendantSegment bool
+
+	// True iff this is a child segment with a single wildcard selector (e.g.
+	// $[*] or $.*).  Such a segment selects all items in order, so they can be
+	// passed on directly without going through an itemDispatcher.
+	isWildcardOnly bool
+
+	// True if the selected items are output in document order, each of them
+	// once, rather than in the order of the selectors (see WithStrictOrder).
+	documentOrder bool
 }
 
 func (r SegmentRunner) transformValue(ctx *RunContext, value iterator.Value, next valueProcessor, followingSegments []SegmentRunner) bool {
+	if r.isWildcardOnly {
+		return r.transformAllItems(ctx, value, next, followingSegments)
+	}
 	switch x := value.(type) {
 	case *iterator.Object:
 		return r.transformObject(ctx, x, next, followingSegments)
@@ -39,24 +52,22 @@ func (r SegmentRunner) transformValue(ctx *RunContext, value iterator.Value, nex
 
 func (r SegmentRunner) transformObject(ctx *RunContext, obj *iterator.Object, next valueProcessor, followingSegments []SegmentRunner) (result bool) {
 	dispatcher := newItemDispatcher(r.selectors, next)
+	dispatcher.reusesValues = r.isDescendantSegment
+	dispatcher.documentOrder = r.documentOrder
 
 	defer func() { dispatcher.flush(ctx, result) }()
 
 	for obj.Advance() {
-		keyScalar, value := obj.CurrentKeyVal()
-		key := keyScalar.ToString()
+		key, value := obj.CurrentKeyVal()
+		ctx.pushPath(PathElement{Key: key})
 		result = dispatcher.dispatchItem(ctx, value, func(s SelectorRunner) Decision { return s.SelectsFromKey(key) }, followingSegments)
-		if !result {
-			return
-		}
 
 		// Lastly if this is a descendant segment, we need to dive into value
-		if r.isDescendantSegment {
-			result = r.transformValue(ctx, value, next, followingSegments)
-			if !result {
-				return
-			}
-		} else if len(dispatcher.selectorStates) == 0 {
+		if result && r.isDescendantSegment {
+			result = r.transformDescendants(ctx, value, next, followingSegments)
+		}
+		ctx.popPath()
+		if !result || !r.isDescendantSegment && len(dispatcher.selectorStates) == 0 {
 			return
 		}
 	}
@@ -65,6 +76,8 @@ func (r SegmentRunner) transformObject(ctx *RunContext, obj *iterator.Object, ne
 
 func (r SegmentRunner) transformArray(ctx *RunContext, arr *iterator.Array, next valueProcessor, followingSegments []SegmentRunner) (result bool) {
 	dispatcher := newItemDispatcher(r.selectors, next)
+	dispatcher.reusesValues = r.isDescendantSegment
+	dispatcher.documentOrder = r.documentOrder
 
 	defer func() { dispatcher.flush(ctx, result) }()
 
@@ -85,10 +98,8 @@ func (r SegmentRunner) transformArray(ctx *RunContext, arr *iterator.Array, next
 	for arr.Advance() {
 		value := arr.CurrentValue()
 
+		ctx.pushPath(PathElement{Index: index})
 		result = dispatcher.dispatchItem(ctx, value, func(s SelectorRunner) Decision { return s.SelectsFromIndex(index, negIndex) }, followingSegments)
-		if !result {
-			return
-		}
 
 		// Update the index
 		index++
@@ -97,12 +108,11 @@ func (r SegmentRunner) transformArray(ctx *RunContext, arr *iterator.Array, next
 		}
 
 		// Lastly if this is a descendant segment, we need to dive into value
-		if r.isDescendantSegment {
-			result = r.transformValue(ctx, value, next, followingSegments)
-			if !result {
-				return
-			}
-		} else if len(dispatcher.selectorStates) == 0 {
+		if result && r.isDescendantSegment {
+			result = r.transformDescendants(ctx, value, next, followingSegments)
+		}
+		ctx.popPath()
+		if !result || !r.isDescendantSegment && len(dispatcher.selectorStates) == 0 {
 			return
 		}
 	}
@@ -110,6 +120,59 @@ func (r SegmentRunner) transformArray(ctx *RunContext, arr *iterator.Array, next
 	return true
 }
 
+// transformDescendants applies the segment to the value's descendants, checking
+// that the maximum depth is not exceeded.
+func (r SegmentRunner) transformDescendants(ctx *RunContext, value iterator.Value, next valueProcessor, followingSegments []SegmentRunner) bool {
+	if ctx.maxDepth > 0 {
+		if _, ok := value.AsScalar(); ok {
+			value.Discard()
+			return true
+		}
+		if ctx.depth >= ctx.maxDepth {
+			panic(token.TransformErrorf("jsonpath: descendant segment exceeds max depth of %d", ctx.maxDepth))
+		}
+		ctx.depth++
+		defer func() { ctx.depth-- }()
+	}
+	return r.transformValue(ctx, value, next, followingSegments)
+}
+
+// transformAllItems is the fast path for segments which select all items in
+// order.  As items are never reordered or selected twice, there is no need to
+// clone them or keep any pending items.
+func (r SegmentRunner) transformAllItems(ctx *RunContext, value iterator.Value, next valueProcessor, followingSegments []SegmentRunner) bool {
+	processItem := next.ProcessValue
+	if len(followingSegments) > 0 {
+		processItem = func(ctx *RunContext, item iterator.Value) bool {
+			return followingSegments[0].transformValue(ctx, item, next, followingSegments[1:])
+		}
+	}
+	switch x := value.(type) {
+	case *iterator.Object:
+		for x.Advance() {
+			key, item := x.CurrentKeyVal()
+			ctx.pushPath(PathElement{Key: key})
+			ok := processItem(ctx, item)
+			ctx.popPath()
+			if !ok {
+				return false
+			}
+		}
+	case *iterator.Array:
+		for index := int64(0); x.Advance(); index++ {
+			ctx.pushPath(PathElement{Index: index})
+			ok := processItem(ctx, x.CurrentValue())
+			ctx.popPath()
+			if !ok {
+				return false
+			}
+		}
+	default:
+		value.Discard()
+	}
+	return true
+}
+
 // itemDispatcher is a helper class for implementing the transformArray and
 // transformObject methods of SegmentRunner.  It is able to process individual
 // items in either type of collection.  A single itemDispatcher instance should
@@ -132,6 +195,14 @@ type itemDispatcher struct {
 	shouldClone    bool
 	selectorStates []selectorState
 	next           valueProcessor
+
+	// True if items are used again after being dispatched (in descendant
+	// segments), so they must be cloned before being processed.
+	reusesValues bool
+
+	// True if selected items are passed on straight away, whatever selectors
+	// select them.
+	documentOrder bool
 }
 
 var _ valueProcessor = &itemDispatcher{}
@@ -212,7 +283,16 @@ func (d *itemDispatcher) dispatchItem(ctx *RunContext, value iterator.Value, dec
 
 	// Process the value if selected
 	if selectedCount > 0 {
-		d.shouldClone = selectedCount > 1 || !d.selectorStates[0].selected
+		if d.reusesValues {
+			clone, detach := value.Clone()
+			if detach != nil {
+				defer detach()
+			}
+			value = clone
+		}
+		// Values which are not passed on straight away must be cloned as they
+		// are processed after the next item has been read.
+		d.shouldClone = !d.documentOrder && (selectedCount > 1 || !d.selectorStates[0].selected || d.selectorStates[0].reversesSelection)
 		if len(followingSegments) == 0 {
 			result = d.ProcessValue(ctx, value)
 		} else {
@@ -223,6 +303,9 @@ func (d *itemDispatcher) dispatchItem(ctx *RunContext, value iterator.Value, dec
 }
 
 func (d *itemDispatcher) ProcessValue(ctx *RunContext, value iterator.Value) bool {
+	if d.documentOrder {
+		return d.next.ProcessValue(ctx, value)
+	}
 	// Then apply the eligible selectors, but only the first one is
 	// passed to next straight away
 	for i := range d.selectorStates {
@@ -239,7 +322,7 @@ func (d *itemDispatcher) ProcessValue(ctx *RunContext, value iterator.Value) boo
 					return false
 				}
 			} else {
-				state.pending = append(state.pending, detachableValue{clone, detach})
+				state.pending = append(state.pending, detachableValue{clone, detach, ctx.savePath()})
 			}
 		}
 	}
@@ -267,14 +350,14 @@ func (s *selectorState) flush(ctx *RunContext, result bool, next valueProcessor)
 		for i := len(s.pending) - 1; i >= 0; i-- {
 			dv := s.pending[i]
 			if result {
-				result = next.ProcessValue(ctx, dv.value)
+				result = dv.process(ctx, next)
 			}
 			dv.detach()
 		}
 	} else {
 		for _, dv := range s.pending {
 			if result {
-				result = next.ProcessValue(ctx, dv.value)
+				result = dv.process(ctx, next)
 			}
 			dv.detach()
 		}
@@ -286,6 +369,19 @@ func (s *selectorState) flush(ctx *RunContext, result bool, next valueProcessor)
 type detachableValue struct {
 	value      iterator.Value
 	detachFunc func()
+	path       NormalizedPath // Path of the value, if paths are tracked
+}
+
+// process passes the value to next, with the path of the value restored in ctx
+// if paths are tracked.
+func (dv detachableValue) process(ctx *RunContext, next valueProcessor) bool {
+	if !ctx.trackPaths {
+		return next.ProcessValue(ctx, dv.value)
+	}
+	path := ctx.path
+	ctx.path = dv.path
+	defer func() { ctx.path = path }()
+	return next.ProcessValue(ctx, dv.value)
 }
 
 func (dv detachableValue) detach() {
@@ -1,7 +1,10 @@
 package jsonpathtransformer
 
 import (
+	"math"
+
 	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
 )
 
 //
@@ -28,7 +31,7 @@ type SelectorRunner interface {
 	// SelectsFromKey makes a Decision on whether the current object item is
 	// selected based on its key.  If no decision can be made then it should
 	// return DontKnow.
-	SelectsFromKey(key string) Decision
+	SelectsFromKey(key *token.Scalar) Decision
 
 	// SelectsFromKey makes a Decision on whether the current array item is
 	// selected based on its index or negative index.  If no decision can be
@@ -85,7 +88,7 @@ func (r DefaultSelectorRunner) SelectsFromIndex(index, negIndex int64) Decision
 }
 
 // SelectsFromKey returns No.
-func (r DefaultSelectorRunner) SelectsFromKey(key string) Decision {
+func (r DefaultSelectorRunner) SelectsFromKey(key *token.Scalar) Decision {
 	return No | NoMoreAfter
 }
 
@@ -99,15 +102,20 @@ func (r DefaultSelectorRunner) ReversesSelection() bool {
 }
 
 // NameSelectorRunner implements the SelectorRunner that selects a value in an
-// object by the name of its key.
+// object by the name of its key.  If all is true, it selects all the members
+// with that name in case the object has duplicate keys.
 type NameSelectorRunner struct {
 	DefaultSelectorRunner
-	name string
+	name []byte
+	all  bool
 }
 
 // SelectsFromKey returns Yes if key is the name that can be selected, else No.
-func (r NameSelectorRunner) SelectsFromKey(key string) Decision {
-	if key == r.name {
+func (r NameSelectorRunner) SelectsFromKey(key *token.Scalar) Decision {
+	if key.EqualsBytes(r.name) {
+		if r.all {
+			return Yes
+		}
 		return Yes | NoMoreAfter
 	}
 	return No
@@ -125,7 +133,7 @@ func (r WildcardSelectorRunner) SelectsFromIndex(index, negIndex int64) Decision
 }
 
 // SelectsFromKey returns Yes
-func (r WildcardSelectorRunner) SelectsFromKey(key string) Decision {
+func (r WildcardSelectorRunner) SelectsFromKey(key *token.Scalar) Decision {
 	return Yes
 }
 
@@ -228,6 +236,11 @@ type ReverseSliceSelectorRunner struct {
 // Lookahead returns a value that allows deciding whether we have reached the
 // start or end index of the slice.
 func (r ReverseSliceSelectorRunner) Lookahead() int64 {
+	if r.start < 0 && r.step != -1 {
+		// Items before the start are selected depending on their distance to
+		// the start, so the exact negative index of every item is needed.
+		return math.MaxInt64
+	}
 	// max(-r.start, -r.end, 0)
 	lookahead := -r.start
 	if -r.end > lookahead {
@@ -248,9 +261,14 @@ func (r ReverseSliceSelectorRunner) SelectsFromIndex(index, negIndex int64) Deci
 	} else {
 		startOffset = index - r.start
 	}
-	if r.end < 0 {
+	switch {
+	case r.end == math.MinInt64:
+		// The default end, i.e. the slice extends to the start of the array
+		// (negIndex - r.end would overflow).
+		endOffset = 1
+	case r.end < 0:
 		endOffset = negIndex - r.end
-	} else {
+	default:
 		endOffset = index - r.end
 	}
 
@@ -275,7 +293,7 @@ type FilterSelectorRunner struct {
 	condition LogicalEvaluator
 }
 
-func (r FilterSelectorRunner) SelectsFromKey(key string) Decision {
+func (r FilterSelectorRunner) SelectsFromKey(key *token.Scalar) Decision {
 	return DontKnow
 }
 
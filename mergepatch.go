@@ -0,0 +1,116 @@
+package jsonstream
+
+import (
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// MergePatch is a ValueTransformer which applies a JSON Merge Patch (RFC 7386)
+// to each value.  Use NewMergePatch to create one from a patch document.
+//
+// If the patch is an object, it is merged into the value recursively: members
+// of the patch whose value is null are removed from the value, other members
+// are merged into the value's members (or added to the value if they are
+// missing).  If the patch or the value is not an object, the patch replaces
+// the value (with null members removed).  E.g. with the patch
+//
+//	{"b": null, "c": {"d": 4}, "e": 5}
+//
+// the value {"a": 1, "b": 2, "c": {"x": 3}} becomes
+//
+//	{"a": 1, "c": {"x": 3, "d": 4}, "e": 5}
+//
+// The patch is held in memory but values are streamed.
+type MergePatch struct {
+	keys    []string               // Keys of an object patch, in order
+	members map[string]*MergePatch // Nil if the patch is not an object
+	toks    []token.Token          // The value of a patch which is not an object
+}
+
+// NewMergePatch returns a MergePatch transformer for the given patch, which is
+// consumed.
+func NewMergePatch(patch iterator.Value) *MergePatch {
+	obj, ok := patch.(*iterator.Object)
+	if !ok {
+		return &MergePatch{toks: copyValueTokens(patch)}
+	}
+	p := &MergePatch{members: map[string]*MergePatch{}}
+	for obj.Advance() {
+		key, value := obj.CurrentKeyVal()
+		name := key.ToString()
+		if _, ok := p.members[name]; !ok {
+			p.keys = append(p.keys, name)
+		}
+		p.members[name] = NewMergePatch(value)
+	}
+	return p
+}
+
+// TransformValue implements the MergePatch transform.
+func (p *MergePatch) TransformValue(value iterator.Value, out token.WriteStream) {
+	if p.members == nil {
+		value.Discard()
+		p.putTokens(out)
+		return
+	}
+	obj, ok := value.(*iterator.Object)
+	if !ok {
+		value.Discard()
+		p.putTokens(out)
+		return
+	}
+	out.Put(&token.StartObject{})
+	merged := map[string]bool{}
+	for obj.Advance() {
+		key, item := obj.CurrentKeyVal()
+		name := key.ToString()
+		member, ok := p.members[name]
+		if !ok {
+			out.Put(key)
+			item.Copy(out)
+			continue
+		}
+		merged[name] = true
+		if !member.isNull() {
+			out.Put(key)
+			member.TransformValue(item, out)
+		}
+	}
+	for _, name := range p.keys {
+		if member := p.members[name]; !merged[name] && !member.isNull() {
+			out.Put(keyScalar(name))
+			member.putTokens(out)
+		}
+	}
+	if obj.Elided() {
+		out.Put(&token.Elision{Count: obj.ElidedCount()})
+	}
+	out.Put(&token.EndObject{})
+}
+
+// putTokens outputs the result of merging the patch into a missing value (or a
+// value which is not an object), i.e. the patch with null members removed.
+func (p *MergePatch) putTokens(out token.WriteStream) {
+	if p.members == nil {
+		for _, tok := range p.toks {
+			out.Put(tok)
+		}
+		return
+	}
+	out.Put(&token.StartObject{})
+	for _, name := range p.keys {
+		if member := p.members[name]; !member.isNull() {
+			out.Put(keyScalar(name))
+			member.putTokens(out)
+		}
+	}
+	out.Put(&token.EndObject{})
+}
+
+func (p *MergePatch) isNull() bool {
+	if p.members != nil || len(p.toks) != 1 {
+		return false
+	}
+	scalar, ok := p.toks[0].(*token.Scalar)
+	return ok && scalar.Type() == token.Null
+}
@@ -0,0 +1,61 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestMergePatch(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		patch  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "merge objects",
+			input:  `{"a": 1, "b": 2, "c": {"x": 3, "y": 4}}`,
+			patch:  `{"b": null, "c": {"d": 4, "y": null}, "e": 5}`,
+			output: "{\"a\": 1,\"c\": {\"x\": 3,\"d\": 4},\"e\": 5}\n",
+		},
+		{
+			name:   "replace non objects",
+			input:  `{"a": [1, 2], "b": "x"} [1] 2`,
+			patch:  `{"a": [3], "b": {"c": 1, "d": null}}`,
+			output: "{\"a\": [3],\"b\": {\"c\": 1}}\n{\"a\": [3],\"b\": {\"c\": 1}}\n{\"a\": [3],\"b\": {\"c\": 1}}\n",
+		},
+		{
+			name:   "patch not an object",
+			input:  `{"a": 1} 2`,
+			patch:  `["x"]`,
+			output: "[\"x\"]\n[\"x\"]\n",
+		},
+		{
+			name:   "remove missing member",
+			input:  `{"a": 1}`,
+			patch:  `{"b": null}`,
+			output: "{\"a\": 1}\n",
+		},
+		{
+			name:   "empty patch",
+			input:  `{"a": {"b": null}}`,
+			patch:  `{}`,
+			output: "{\"a\": {\"b\": null}}\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			iter := iterator.New(token.ChannelReadStream(streamJSONString(c.patch)))
+			iter.Advance()
+			mergePatch := jsonstream.NewMergePatch(iter.CurrentValue())
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(mergePatch))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
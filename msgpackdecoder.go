@@ -0,0 +1,300 @@
+package jsonstream
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A MsgPackDecoder reads input in the MessagePack binary format and streams it
+// into a JSON stream.
+//
+// The input is a sequence of MessagePack values (see
+// https://github.com/msgpack/msgpack/blob/master/spec.md), each of which is
+// streamed as a JSON value.  MessagePack values without a JSON equivalent are
+// converted as follows.
+//   - Binary values are streamed as base64 encoded strings.
+//   - Timestamps (extension type -1) are streamed as RFC 3339 strings.
+//   - Map keys which are not strings must be scalars and are streamed as keys
+//     made of their JSON representation (e.g. the key 1 becomes "1").
+//
+// Other extension types and non-finite floats cause an error.
+type MsgPackDecoder struct {
+	reader *binaryInput
+}
+
+var _ token.StreamSource = &MsgPackDecoder{}
+
+// NewMsgPackDecoder sets up a new MsgPackDecoder instance to read from the
+// given input.
+func NewMsgPackDecoder(in io.Reader) *MsgPackDecoder {
+	return &MsgPackDecoder{reader: newBinaryInput(in)}
+}
+
+// Produce reads a stream of MessagePack values and streams them, until it runs
+// out of input or encounters invalid MessagePack, in which case it will return
+// an error.
+func (d *MsgPackDecoder) Produce(out chan<- token.Token) error {
+	return produceValues(d, out)
+}
+
+func (d *MsgPackDecoder) decodeValue(out token.WriteStream) (bool, error) {
+	b, err := d.reader.ReadByte()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, d.reader.parseError("msgpack", d.parseValue(b, out))
+}
+
+// parseValue reads a single MessagePack value whose first byte b has already
+// been read and streams it.
+func (d *MsgPackDecoder) parseValue(b byte, out token.WriteStream) error {
+	switch {
+	case b <= 0x7F:
+		out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, int64(b), 10)))
+	case b >= 0xE0:
+		out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, int64(int8(b)), 10)))
+	case b >= 0x80 && b <= 0x8F:
+		return d.parseMap(int(b&0x0F), out)
+	case b >= 0x90 && b <= 0x9F:
+		return d.parseArray(int(b&0x0F), out)
+	case b >= 0xA0 && b <= 0xBF:
+		s, err := d.readString(uint64(b & 0x1F))
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(s))
+	case b == 0xC0:
+		out.Put(nullInstance)
+	case b == 0xC2:
+		out.Put(falseInstance)
+	case b == 0xC3:
+		out.Put(trueInstance)
+	case b >= 0xC4 && b <= 0xC6:
+		n, err := d.readUint(1 << (b - 0xC4))
+		if err != nil {
+			return err
+		}
+		raw, err := d.readBytes(n)
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(base64.StdEncoding.EncodeToString(raw)))
+	case b >= 0xC7 && b <= 0xC9:
+		n, err := d.readUint(1 << (b - 0xC7))
+		if err != nil {
+			return err
+		}
+		return d.parseExt(n, out)
+	case b == 0xCA:
+		bits, err := d.readUint(4)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(float64(math.Float32frombits(uint32(bits))), 32, out)
+	case b == 0xCB:
+		bits, err := d.readUint(8)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(math.Float64frombits(bits), 64, out)
+	case b >= 0xCC && b <= 0xCF:
+		n, err := d.readUint(1 << (b - 0xCC))
+		if err != nil {
+			return err
+		}
+		out.Put(token.NewScalar(token.Number, strconv.AppendUint(nil, n, 10)))
+	case b >= 0xD0 && b <= 0xD3:
+		size := 1 << (b - 0xD0)
+		n, err := d.readUint(size)
+		if err != nil {
+			return err
+		}
+		// Sign-extend the size*8 bits integer
+		shift := 64 - 8*size
+		out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, int64(n<<shift)>>shift, 10)))
+	case b >= 0xD4 && b <= 0xD8:
+		return d.parseExt(1<<(b-0xD4), out)
+	case b >= 0xD9 && b <= 0xDB:
+		n, err := d.readUint(1 << (b - 0xD9))
+		if err != nil {
+			return err
+		}
+		s, err := d.readString(n)
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(s))
+	case b == 0xDC || b == 0xDD:
+		n, err := d.readUint(2 << (b - 0xDC))
+		if err != nil {
+			return err
+		}
+		return d.parseArray(int(n), out)
+	case b == 0xDE || b == 0xDF:
+		n, err := d.readUint(2 << (b - 0xDE))
+		if err != nil {
+			return err
+		}
+		return d.parseMap(int(n), out)
+	default:
+		return fmt.Errorf("msgpack: unexpected value token 0x%02X", b)
+	}
+	return nil
+}
+
+func (d *MsgPackDecoder) parseArray(n int, out token.WriteStream) error {
+	out.Put(&token.StartArray{})
+	for i := 0; i < n; i++ {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return msgPackUnexpectedEOF(err)
+		}
+		if err := d.parseValue(b, out); err != nil {
+			return err
+		}
+	}
+	out.Put(&token.EndArray{})
+	return nil
+}
+
+func (d *MsgPackDecoder) parseMap(n int, out token.WriteStream) error {
+	out.Put(&token.StartObject{})
+	for i := 0; i < n; i++ {
+		key, err := d.parseKey()
+		if err != nil {
+			return err
+		}
+		out.Put(key)
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return msgPackUnexpectedEOF(err)
+		}
+		if err := d.parseValue(b, out); err != nil {
+			return err
+		}
+	}
+	out.Put(&token.EndObject{})
+	return nil
+}
+
+// parseKey reads a map key.  Keys which are not strings are converted to
+// strings using their JSON representation.
+func (d *MsgPackDecoder) parseKey() (*token.Scalar, error) {
+	b, err := d.reader.ReadByte()
+	if err != nil {
+		return nil, msgPackUnexpectedEOF(err)
+	}
+	switch {
+	case b >= 0xA0 && b <= 0xBF:
+		s, err := d.readString(uint64(b & 0x1F))
+		if err != nil {
+			return nil, err
+		}
+		return keyScalar(s), nil
+	case b >= 0x80 && b <= 0x9F, b == 0xDC, b == 0xDD, b == 0xDE, b == 0xDF:
+		return nil, fmt.Errorf("msgpack: unsupported map key token 0x%02X", b)
+	}
+	// Decode the key as a value, which must be a single scalar.
+	keyOut := token.NewAccumulatorStream()
+	if err := d.parseValue(b, keyOut); err != nil {
+		return nil, err
+	}
+	scalar := keyOut.GetTokens()[0].(*token.Scalar)
+	if scalar.Type() == token.String {
+		return keyScalar(scalar.ToString()), nil
+	}
+	return keyScalar(string(scalar.Bytes)), nil
+}
+
+// parseExt reads an extension value with n bytes of data (the type byte has
+// not been read yet).
+func (d *MsgPackDecoder) parseExt(n uint64, out token.WriteStream) error {
+	tp, err := d.reader.ReadByte()
+	if err != nil {
+		return msgPackUnexpectedEOF(err)
+	}
+	if int8(tp) != msgPackTimestampType {
+		return fmt.Errorf("msgpack: unsupported extension type %d", int8(tp))
+	}
+	data, err := d.readBytes(n)
+	if err != nil {
+		return err
+	}
+	var t time.Time
+	switch n {
+	case 4:
+		t = time.Unix(int64(binary.BigEndian.Uint32(data)), 0)
+	case 8:
+		x := binary.BigEndian.Uint64(data)
+		t = time.Unix(int64(x&(1<<34-1)), int64(x>>34))
+	case 12:
+		t = time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data)))
+	default:
+		return fmt.Errorf("msgpack: invalid timestamp length %d", n)
+	}
+	out.Put(stringScalar(t.UTC().Format(time.RFC3339Nano)))
+	return nil
+}
+
+func (d *MsgPackDecoder) putFloat(x float64, bitSize int, out token.WriteStream) error {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return fmt.Errorf("msgpack: non-finite number %g cannot be represented in JSON", x)
+	}
+	out.Put(token.NewScalar(token.Number, strconv.AppendFloat(nil, x, 'g', -1, bitSize)))
+	return nil
+}
+
+// readUint reads a big-endian unsigned integer of the given size in bytes.
+func (d *MsgPackDecoder) readUint(size int) (uint64, error) {
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return 0, msgPackUnexpectedEOF(err)
+		}
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func (d *MsgPackDecoder) readString(n uint64) (string, error) {
+	raw, err := d.readBytes(n)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func (d *MsgPackDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > msgPackMaxLength {
+		return nil, errors.New("msgpack: value too long")
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(d.reader, raw); err != nil {
+		return nil, msgPackUnexpectedEOF(err)
+	}
+	return raw, nil
+}
+
+func msgPackUnexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("msgpack: unexpected end of input")
+	}
+	return err
+}
+
+const (
+	msgPackTimestampType = -1
+	msgPackMaxLength     = 1 << 30
+)
@@ -0,0 +1,128 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestMsgPackDecoder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  []byte
+		output string
+		err    bool
+	}
+	var testCases = []testCase{
+		{
+			name:   "simple map",
+			input:  []byte{0x81, 0xA1, 'a', 0x01},
+			output: `{"a": 1}`,
+		},
+		{
+			name:   "literals",
+			input:  []byte{0x94, 0xC0, 0xC2, 0xC3, 0xA0},
+			output: `[null,false,true,""]`,
+		},
+		{
+			name: "integers",
+			input: []byte{
+				0x99,
+				0x7F,       // positive fixint
+				0xE0,       // negative fixint -32
+				0xCC, 0xC8, // uint8 200
+				0xCD, 0x01, 0x00, // uint16 256
+				0xCF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, // uint64 max
+				0xD0, 0x80, // int8 -128
+				0xD1, 0xFF, 0x38, // int16 -200
+				0xD2, 0xFF, 0xFE, 0xEE, 0x90, // int32 -70000
+				0xD3, 0xFF, 0xFF, 0xFF, 0xFE, 0xD5, 0xFA, 0x0E, 0x00, // int64 -5000000000
+			},
+			output: `[127,-32,200,256,18446744073709551615,-128,-200,-70000,-5000000000]`,
+		},
+		{
+			name: "floats",
+			input: []byte{
+				0x92,
+				0xCA, 0xBE, 0x80, 0x00, 0x00, // float32 -0.25
+				0xCB, 0x3F, 0xF8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // float64 1.5
+			},
+			output: `[-0.25,1.5]`,
+		},
+		{
+			name: "strings",
+			input: []byte{
+				0x93,
+				0xA2, 0xC3, 0xA9, // fixstr "é"
+				0xD9, 0x03, 'a', '"', 'b', // str8
+				0xC4, 0x03, 0x01, 0x02, 0x03, // bin8
+			},
+			output: `["é","a\"b","AQID"]`,
+		},
+		{
+			name:   "non-string keys",
+			input:  []byte{0x83, 0x01, 0xC3, 0xC0, 0xC2, 0xD9, 0x01, 'k', 0x90},
+			output: `{"1": true,"null": false,"k": []}`,
+		},
+		{
+			name: "16 bits sizes",
+			input: []byte{
+				0xDE, 0x00, 0x01, 0xA1, 'x',
+				0xDC, 0x00, 0x02, 0x01, 0x02,
+			},
+			output: `{"x": [1,2]}`,
+		},
+		{
+			name: "timestamps",
+			input: []byte{
+				0x92,
+				0xD6, 0xFF, 0x00, 0x00, 0x00, 0x3C, // timestamp32
+				0xD7, 0xFF, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x3C, // timestamp64 with 1ns
+			},
+			output: `["1970-01-01T00:01:00Z","1970-01-01T00:01:00.000000001Z"]`,
+		},
+		{
+			name:   "multiple values",
+			input:  []byte{0x01, 0x91, 0x02},
+			output: "1\n[2]",
+		},
+		{
+			name:  "unknown extension",
+			input: []byte{0xD4, 0x01, 0x00},
+			err:   true,
+		},
+		{
+			name:  "map key",
+			input: []byte{0x81, 0x80, 0x01},
+			err:   true,
+		},
+		{
+			name:  "never used byte",
+			input: []byte{0xC1},
+			err:   true,
+		},
+		{
+			name:  "truncated input",
+			input: []byte{0x92, 0x01},
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeToJSONString(t, jsonstream.NewMsgPackDecoder(bytes.NewReader(c.input)))
+			if c.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package jsonstream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A MsgPackEncoder outputs a stream of JSON values in the MessagePack binary
+// format, using the given Printer to send output (note that the Printer's
+// indentation is not used).  Each value in the stream is output as a
+// MessagePack value, with no separator.
+//
+// MessagePack arrays and maps start with their number of items so each array
+// and object is read twice: once to count its items and once to output them.
+// This means that each top-level value is buffered in memory while it is
+// output.
+//
+// Integer literals are output with the most compact integer encoding which can
+// represent them, other numbers as 64 bits floats.  Elided content is omitted
+// as it has no equivalent in MessagePack.
+type MsgPackEncoder struct {
+	Printer
+
+	buf []byte
+}
+
+var _ token.StreamSink = &MsgPackEncoder{}
+
+// Consume outputs the JSON stream encoded in the given channel as MessagePack.
+// It assumes that the stream is well-formed, i.e. is a valid encoding for a
+// stream of JSON values and may panic if that is not the case.
+//
+// And error can be returned if the Printer could not perform some writing
+// operation.  A typical example is if it attempt to write to a closed pipe.
+func (e *MsgPackEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	reader := token.NewStreamErrorReader(stream)
+	iterator := iterator.New(reader)
+	for iterator.Advance() {
+		e.writeValue(iterator.CurrentValue())
+	}
+	return reader.Err()
+}
+
+func (e *MsgPackEncoder) writeValue(value iterator.Value) {
+	switch v := value.(type) {
+	case *iterator.Scalar:
+		e.writeScalar(v.Scalar())
+	case *iterator.Object:
+		e.writeHeader(0x80, 0xDE, countObjectItems(v))
+		for v.Advance() {
+			key, value := v.CurrentKeyVal()
+			e.writeString(key.ToString())
+			e.writeValue(value)
+		}
+	case *iterator.Array:
+		e.writeHeader(0x90, 0xDC, countArrayItems(v))
+		for v.Advance() {
+			e.writeValue(v.CurrentValue())
+		}
+	default:
+		panic(fmt.Sprintf("invalid stream item: %#v", value))
+	}
+}
+
+func (e *MsgPackEncoder) writeScalar(scalar *token.Scalar) {
+	switch scalar.Type() {
+	case token.Null:
+		e.PrintBytes([]byte{0xC0})
+	case token.Boolean:
+		if scalar.Bytes[0] == 't' {
+			e.PrintBytes([]byte{0xC3})
+		} else {
+			e.PrintBytes([]byte{0xC2})
+		}
+	case token.Number:
+		e.writeNumber(scalar.Bytes)
+	case token.String:
+		e.writeString(scalar.ToString())
+	}
+}
+
+func (e *MsgPackEncoder) writeNumber(b []byte) {
+	if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+		e.writeInt(n)
+		return
+	}
+	if n, err := strconv.ParseUint(string(b), 10, 64); err == nil {
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf[:0], 0xCF), n)
+	} else {
+		// The literal is valid JSON so this cannot fail (but can overflow to
+		// an infinity, which is the best we can do).
+		x, _ := strconv.ParseFloat(string(b), 64)
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf[:0], 0xCB), math.Float64bits(x))
+	}
+	e.PrintBytes(e.buf)
+}
+
+func (e *MsgPackEncoder) writeInt(n int64) {
+	buf := e.buf[:0]
+	switch {
+	case n >= 0 && n <= math.MaxInt8, n < 0 && n >= -32:
+		buf = append(buf, byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		buf = append(buf, 0xCC, byte(n))
+	case n >= 0 && n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xCD), uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xCE), uint32(n))
+	case n >= 0:
+		buf = binary.BigEndian.AppendUint64(append(buf, 0xCF), uint64(n))
+	case n >= math.MinInt8:
+		buf = append(buf, 0xD0, byte(n))
+	case n >= math.MinInt16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xD1), uint16(n))
+	case n >= math.MinInt32:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xD2), uint32(n))
+	default:
+		buf = binary.BigEndian.AppendUint64(append(buf, 0xD3), uint64(n))
+	}
+	e.buf = buf
+	e.PrintBytes(buf)
+}
+
+func (e *MsgPackEncoder) writeString(s string) {
+	buf := e.buf[:0]
+	n := len(s)
+	switch {
+	case n < 32:
+		buf = append(buf, 0xA0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xD9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xDA), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xDB), uint32(n))
+	}
+	e.buf = append(buf, s...)
+	e.PrintBytes(e.buf)
+}
+
+// writeHeader outputs the header of an array or a map with n items, given the
+// byte for the fixed size form and for the 16 bits size form (the 32 bits
+// size form is the next one).
+func (e *MsgPackEncoder) writeHeader(fixByte, byte16 byte, n int) {
+	buf := e.buf[:0]
+	switch {
+	case n < 16:
+		buf = append(buf, fixByte|byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, byte16), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, byte16+1), uint32(n))
+	}
+	e.buf = buf
+	e.PrintBytes(buf)
+}
+
+// countObjectItems returns the number of items in obj without consuming it.
+func countObjectItems(obj *iterator.Object) int {
+	clone, detach := obj.Clone()
+	defer detach()
+	count := 0
+	for clone.(*iterator.Object).Advance() {
+		count++
+	}
+	return count
+}
+
+// countArrayItems returns the number of items in arr without consuming it.
+func countArrayItems(arr *iterator.Array) int {
+	clone, detach := arr.CloneArray()
+	defer detach()
+	count := 0
+	for clone.Advance() {
+		count++
+	}
+	return count
+}
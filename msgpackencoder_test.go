@@ -0,0 +1,92 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func encodeMsgPack(t *testing.T, input string) []byte {
+	var buf bytes.Buffer
+	encoder := &jsonstream.MsgPackEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &buf}}
+	if err := token.ConsumeStream(streamJSONString(input), encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestMsgPackEncoder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output []byte
+	}
+	var testCases = []testCase{
+		{
+			name:   "map",
+			input:  `{"a": [1, -1], "b": null}`,
+			output: []byte{0x82, 0xA1, 'a', 0x92, 0x01, 0xFF, 0xA1, 'b', 0xC0},
+		},
+		{
+			name:  "integers",
+			input: `[200, -200, 70000, 18446744073709551615]`,
+			output: []byte{
+				0x94,
+				0xCC, 0xC8,
+				0xD1, 0xFF, 0x38,
+				0xCE, 0x00, 0x01, 0x11, 0x70,
+				0xCF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF,
+			},
+		},
+		{
+			name:   "float",
+			input:  `1.5`,
+			output: []byte{0xCB, 0x3F, 0xF8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{
+			name:   "escaped string",
+			input:  `"a\né"`,
+			output: []byte{0xA4, 'a', '\n', 0xC3, 0xA9},
+		},
+		{
+			name:   "several values",
+			input:  `true false {}`,
+			output: []byte{0xC3, 0xC2, 0x80},
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := encodeMsgPack(t, c.input)
+			if !bytes.Equal(got, c.output) {
+				t.Fatalf("Expected % X, got % X", c.output, got)
+			}
+		})
+	}
+}
+
+func TestMsgPackRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	var items []string
+	for i := 0; i < 20; i++ {
+		items = append(items, `{"k": "`+long+`"}`)
+	}
+	inputs := []string{
+		`{"a": [1, 2.5, -3, "x"], "b": {"c": null, "d": [true, false]}}`,
+		`[` + strings.Join(items, ",") + `]`,
+		`[] {} "" 0 -5000000000`,
+	}
+	for _, input := range inputs {
+		encoded := encodeMsgPack(t, input)
+		got, err := decodeToJSONString(t, jsonstream.NewMsgPackDecoder(bytes.NewReader(encoded)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		expected := encodeJSONStream(t, streamJSONString(input))
+		if got != expected {
+			t.Fatalf("Expected %q, got %q", expected, got)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package jsonstream
+
+import (
+	"bytes"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// An NDJSONEncoder outputs a stream of JSON values as newline delimited JSON
+// (see https://github.com/ndjson/ndjson-spec), so that the output can safely be
+// processed by line-oriented tools.  Each value is output on exactly one line,
+// in the most compact form, followed by a new line.
+//
+// The options of the embedded JSONEncoder which change how scalars are
+// formatted (e.g. StableFloatRepr) apply, whereas the options which change the
+// layout (indentation, compact widths, RawStrings, AlignValues, spacing) are
+// ignored.  The Printer's indentation is not used but its line endings are.
+// As a safeguard against decoders producing invalid scalars, new lines and
+// carriage returns are escaped if they occur in the output of a value.
+type NDJSONEncoder struct {
+	JSONEncoder
+}
+
+var _ token.StreamSink = &NDJSONEncoder{}
+
+// Consume outputs the JSON stream encoded in the given channel as NDJSON.  It
+// assumes that the stream is well-formed, i.e. is a valid encoding for a
+// stream of JSON values and may panic if that is not the case.
+//
+// And error can be returned if the Printer could not perform some writing
+// operation.  A typical example is if it attempt to write to a closed pipe.
+func (e *NDJSONEncoder) Consume(stream <-chan token.Token) error {
+	encoder := singleLineEncoder(e.JSONEncoder)
+	return encoder.Consume(stream)
+}
+
+// singleLineEncoder returns a copy of encoder which outputs each value on a
+// single line, in the most compact form.
+func singleLineEncoder(encoder JSONEncoder) *JSONEncoder {
+	encoder.Printer = ndjsonPrinter{encoder.Printer}
+	encoder.CompactWidthLimit = 0
+	encoder.ArrayCompactWidth = 0
+	encoder.ObjectCompactWidth = 0
+	encoder.CompactObjectMaxItems = 0
+	encoder.RawStrings = false
+	encoder.AlignValues = false
+	encoder.NoSpaceAfterColon = true
+	return &encoder
+}
+
+// ndjsonPrinter outputs everything on a single line until it is reset.
+type ndjsonPrinter struct {
+	Printer
+}
+
+func (p ndjsonPrinter) Indent()  {}
+func (p ndjsonPrinter) Dedent()  {}
+func (p ndjsonPrinter) NewLine() {}
+
+func (p ndjsonPrinter) PrintBytes(b []byte) {
+	if bytes.IndexAny(b, "\r\n") >= 0 {
+		b = bytes.ReplaceAll(b, []byte("\n"), []byte(`\n`))
+		b = bytes.ReplaceAll(b, []byte("\r"), []byte(`\r`))
+	}
+	p.Printer.PrintBytes(b)
+}
@@ -0,0 +1,74 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestNDJSONEncoder(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		encoder jsonstream.JSONEncoder
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:   "one value per line",
+			input:  `{"a": [1, 2, {"b": "x\ny"}], "c": {}} "s" [] null`,
+			output: "{\"a\":[1,2,{\"b\":\"x\\ny\"}],\"c\":{}}\n\"s\"\n[]\nnull\n",
+		},
+		{
+			name:  "layout options are ignored",
+			input: `{"key": [1, 2, 3], "k": "v"} "raw"`,
+			encoder: jsonstream.JSONEncoder{
+				CompactWidthLimit:     60,
+				CompactObjectMaxItems: 2,
+				AlignValues:           true,
+				RawStrings:            true,
+			},
+			output: "{\"key\":[1,2,3],\"k\":\"v\"}\n\"raw\"\n",
+		},
+		{
+			name:    "scalar options apply",
+			input:   `[2.50, 1e3]`,
+			encoder: jsonstream.JSONEncoder{StableFloatRepr: true},
+			output:  "[2.5,1000]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			encoder := &jsonstream.NDJSONEncoder{JSONEncoder: c.encoder}
+			encoder.Printer = &jsonstream.DefaultPrinter{Writer: &buf, IndentSize: 2}
+			if err := token.ConsumeStream(streamJSONString(c.input), encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := buf.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestNDJSONEncoderEscapesNewLines(t *testing.T) {
+	stream := make(chan token.Token, 5)
+	stream <- &token.StartObject{}
+	stream <- token.NewScalar(token.String, []byte("\"a\nb\""))
+	stream <- token.NewScalar(token.String, []byte("\"c\r\nd\""))
+	stream <- &token.EndObject{}
+	close(stream)
+	var buf bytes.Buffer
+	encoder := &jsonstream.NDJSONEncoder{}
+	encoder.Printer = &jsonstream.DefaultPrinter{Writer: &buf}
+	if err := token.ConsumeStream(stream, encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `{"a\nb":"c\r\nd"}` + "\n"
+	if got := buf.String(); got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
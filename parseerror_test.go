@@ -0,0 +1,94 @@
+package jsonstream_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestParseError(t *testing.T) {
+	type testCase struct {
+		name    string
+		decoder func(io.Reader) token.StreamSource
+		input   string
+		err     token.ParseError
+	}
+	var testCases = []testCase{
+		{
+			name:    "json",
+			decoder: func(r io.Reader) token.StreamSource { return jsonstream.NewJSONDecoder(r) },
+			input:   "{\"a\": 1}\n{\"a\": [1,, 2]}\n",
+			err: token.ParseError{
+				Line: 2, Col: 10, Offset: 18,
+				Context: `{"a": [1,, 2]}`, ContextPos: 9,
+				Msg: "unexpected: ','",
+			},
+		},
+		{
+			name:    "json long line",
+			decoder: func(r io.Reader) token.StreamSource { return jsonstream.NewJSONDecoder(r) },
+			input:   "[" + strings.Repeat(`"x", `, 20) + "}" + strings.Repeat(`, "y"`, 20) + "]",
+			err: token.ParseError{
+				Line: 1, Col: 102, Offset: 101,
+				Context: strings.Repeat(`"x", `, 8) + `}` + strings.Repeat(`, "y"`, 8)[:39], ContextPos: 40,
+				Msg: "unexpected: '}'",
+			},
+		},
+		{
+			name:    "jpv",
+			decoder: func(r io.Reader) token.StreamSource { return jsonstream.NewJPVDecoder(r) },
+			input:   "$.a = 1\n$.a.b = 2\n",
+			err: token.ParseError{
+				Line: 2, Col: 1, Offset: 8,
+				Context: "$.a.b = 2", ContextPos: 0,
+				Msg: "inconsistent path: cannot extend previous path",
+			},
+		},
+		{
+			name:    "hjson",
+			decoder: func(r io.Reader) token.StreamSource { return jsonstream.NewHJSONDecoder(r) },
+			input:   "{\n  a: 1\n  b\n}",
+			err: token.ParseError{
+				Format: "hjson", Line: 4, Col: 1, Offset: 13,
+				Context: "}", ContextPos: 0,
+				Msg: `expected ':' after key "b"`,
+			},
+		},
+		{
+			name:    "csv",
+			decoder: func(r io.Reader) token.StreamSource { return jsonstream.NewCSVDecoder(r) },
+			input:   "a,b\n1,\"x\"y\n",
+			err: token.ParseError{
+				Format: "csv", Line: 2, Col: 5, Offset: 11,
+				Msg: `extraneous or missing " in quoted-field`,
+			},
+		},
+		{
+			name:    "cbor",
+			decoder: func(r io.Reader) token.StreamSource { return jsonstream.NewCBORDecoder(r) },
+			input:   "\x01\x82\x01",
+			err: token.ParseError{
+				Format: "cbor", Offset: 3,
+				Msg: "unexpected end of input",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := decodeToJSONString(t, tc.decoder(strings.NewReader(tc.input)))
+			var parseErr *token.ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected a *token.ParseError, got %v", err)
+			}
+			got := *parseErr
+			got.Err = nil
+			if got != tc.err {
+				t.Fatalf("Expected %+v, got %+v", tc.err, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+package jsonstream
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// ApplyPatch is a StreamTransformer which applies a JSON Patch (RFC 6902) to
+// each value in the stream.  Use NewApplyPatch to create one from a patch
+// document.
+//
+// The "add", "remove" and "replace" operations are applied in a streaming
+// fashion: each operation runs in its own goroutine and copies its input to
+// its output, editing it on the fly.  The "move" and "copy" operations need to
+// read the value twice so it is buffered in memory while they are applied.
+// The "test" operation is not supported.
+//
+// When an operation cannot be applied (e.g. its path does not exist), the
+// transform fails with a *TransformError and the operations which come after
+// it are not applied to the rest of the value.  As the value is streamed, the
+// output contains the value with the operations which come before the failed
+// operation applied, and the later operations may have been applied to the
+// part of the value which comes before the place where the failure was found
+// (e.g. the end of the object missing a member).  As a special case, removing
+// the whole value (with the path "") removes it from the stream.
+type ApplyPatch struct {
+	ops []*patchOp
+}
+
+// NewApplyPatch returns an ApplyPatch transformer for the given patch, which
+// is consumed.  It returns an error if the patch is not a valid JSON Patch
+// document or it contains unsupported operations.
+func NewApplyPatch(patch iterator.Value) (*ApplyPatch, error) {
+	arr, ok := patch.(*iterator.Array)
+	if !ok {
+		patch.Discard()
+		return nil, errors.New("patch: expected an array of operations")
+	}
+	var ops []*patchOp
+	for i := 0; arr.Advance(); i++ {
+		op, err := parsePatchOp(arr.CurrentValue())
+		if err != nil {
+			arr.Discard()
+			return nil, fmt.Errorf("patch: operation #%d: %w", i+1, err)
+		}
+		ops = append(ops, op)
+	}
+	return &ApplyPatch{ops: ops}, nil
+}
+
+// Transform implements the ApplyPatch transform.
+func (p *ApplyPatch) Transform(in <-chan token.Token, out token.WriteStream) {
+	var (
+		mx       sync.Mutex
+		errs     = make([]*token.TransformError, len(p.ops))
+		failedOp atomic.Int64
+	)
+	failedOp.Store(int64(len(p.ops)))
+	stream := in
+	for i, op := range p.ops {
+		stageIn, stageOut := stream, make(chan token.Token)
+		stage := &patchStage{patchOp: op, index: i, failedOp: &failedOp}
+		go func(i int) {
+			defer close(stageOut)
+			defer func() {
+				if r := recover(); r != nil {
+					err, ok := r.(*token.TransformError)
+					if !ok {
+						panic(r)
+					}
+					stage.fail()
+					mx.Lock()
+					errs[i] = err
+					mx.Unlock()
+					// Drain the input so the previous stages are not blocked.
+					for range stageIn {
+					}
+				}
+			}()
+			iterator.AsStreamTransformer(stage).Transform(stageIn, token.ChannelWriteStream(stageOut))
+		}(i)
+		stream = stageOut
+	}
+	for tok := range stream {
+		out.Put(tok)
+	}
+	// The stages have all returned, as each one closes its output after
+	// its input is closed.  The error reported is the one of the first
+	// operation which failed in the patch, which may not be the first one
+	// found.
+	mx.Lock()
+	defer mx.Unlock()
+	for _, err := range errs {
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// A patchOp is an operation of a JSON Patch.
+type patchOp struct {
+	op    string
+	path  []string // Reference tokens of the path, unescaped
+	from  []string // For move and copy
+	value []token.Token
+}
+
+func parsePatchOp(value iterator.Value) (*patchOp, error) {
+	obj, ok := value.(*iterator.Object)
+	if !ok {
+		value.Discard()
+		return nil, errors.New("expected an object")
+	}
+	op := &patchOp{}
+	var path, from *string
+	hasValue := false
+	for obj.Advance() {
+		key, val := obj.CurrentKeyVal()
+		switch name := key.ToString(); name {
+		case "op", "path", "from":
+			scalar, ok := val.AsScalar()
+			if !ok || scalar.Type() != token.String {
+				obj.Discard()
+				return nil, fmt.Errorf("%q must be a string", name)
+			}
+			s := scalar.ToString()
+			switch name {
+			case "op":
+				op.op = s
+			case "path":
+				path = &s
+			default:
+				from = &s
+			}
+		case "value":
+			op.value = copyValueTokens(val)
+			hasValue = true
+		}
+	}
+	var err error
+	switch {
+	case path == nil:
+		return nil, errors.New(`missing "path"`)
+	case op.op == "test":
+		return nil, errors.New(`unsupported operation "test"`)
+	case op.op != "add" && op.op != "remove" && op.op != "replace" && op.op != "move" && op.op != "copy":
+		return nil, fmt.Errorf("invalid operation %q", op.op)
+	case (op.op == "add" || op.op == "replace") && !hasValue:
+		return nil, errors.New(`missing "value"`)
+	case (op.op == "move" || op.op == "copy") && from == nil:
+		return nil, errors.New(`missing "from"`)
+	}
+	if op.path, err = parseJSONPointer(*path); err != nil {
+		return nil, err
+	}
+	if from != nil {
+		if op.from, err = parseJSONPointer(*from); err != nil {
+			return nil, err
+		}
+	}
+	if op.op == "move" && isPointerPrefix(op.from, op.path) && len(op.from) < len(op.path) {
+		return nil, errors.New("cannot move a value into one of its children")
+	}
+	return op, nil
+}
+
+// A patchStage is a ValueTransformer which applies an operation of a patch in
+// ApplyPatch.Transform.  Once an operation has failed, the stages of the
+// operations after it copy their input unchanged.
+type patchStage struct {
+	*patchOp
+	index    int           // Index of the operation in the patch
+	failedOp *atomic.Int64 // Index of the first operation which has failed
+}
+
+// stopped returns true if an operation before the stage's has failed.
+func (s *patchStage) stopped() bool {
+	return s.failedOp.Load() < int64(s.index)
+}
+
+// fail records that the stage's operation has failed, so that the stages
+// after it stop applying their operations.
+func (s *patchStage) fail() {
+	for {
+		failed := s.failedOp.Load()
+		if failed <= int64(s.index) || s.failedOp.CompareAndSwap(failed, int64(s.index)) {
+			return
+		}
+	}
+}
+
+// TransformValue applies the operation to value.
+func (s *patchStage) TransformValue(value iterator.Value, out token.WriteStream) {
+	op := s.patchOp
+	if s.stopped() {
+		value.Copy(out)
+		return
+	}
+	switch op.op {
+	case "move", "copy":
+		if op.op == "move" && len(op.from) == len(op.path) && isPointerPrefix(op.from, op.path) {
+			// Moving a value to where it is does nothing
+			value.Copy(out)
+			return
+		}
+		clone, detach := value.Clone()
+		moved, err := extractPointer(clone, op.from)
+		if detach != nil {
+			detach()
+		}
+		if err != nil {
+			s.fail()
+			value.Copy(out)
+			panic(token.TransformErrorf("patch: %s from %s: %s", op.op, formatJSONPointer(op.from), err))
+		}
+		if op.op == "move" {
+			removed := token.NewAccumulatorStream()
+			edit := &patchEdit{op: "remove", path: op.from, stage: s}
+			edit.apply(value, removed)
+			edit.check()
+			value = tokensValue(removed.GetTokens())
+		}
+		edit := &patchEdit{op: "add", path: op.path, value: moved, stage: s}
+		edit.apply(value, out)
+		edit.check()
+	default:
+		edit := &patchEdit{op: op.op, path: op.path, value: op.value, stage: s}
+		edit.apply(value, out)
+		edit.check()
+	}
+}
+
+// A patchEdit applies an add, remove or replace operation to a value while
+// copying it.  It stops editing the value as soon as the stage it belongs to
+// is stopped.
+type patchEdit struct {
+	op    string
+	path  []string
+	value []token.Token
+	stage *patchStage
+	err   error // Set if the operation could not be applied
+}
+
+// apply copies value to out with the operation applied.  If the operation
+// cannot be applied, the value is copied unchanged and e.err is set.
+func (e *patchEdit) apply(value iterator.Value, out token.WriteStream) {
+	if len(e.path) == 0 {
+		if e.stage.stopped() {
+			value.Copy(out)
+			return
+		}
+		value.Discard()
+		if e.op != "remove" {
+			e.putValue(out)
+		}
+		return
+	}
+	e.applyAt(value, 0, out)
+}
+
+// check panics with a *TransformError if the operation could not be applied.
+func (e *patchEdit) check() {
+	if e.err != nil {
+		panic(token.TransformErrorf("patch: %s at %s: %s", e.op, formatJSONPointer(e.path), e.err))
+	}
+}
+
+func (e *patchEdit) putValue(out token.WriteStream) {
+	for _, tok := range e.value {
+		out.Put(tok)
+	}
+}
+
+// applyAt copies value, which is at depth in the path, to out.
+func (e *patchEdit) applyAt(value iterator.Value, depth int, out token.WriteStream) {
+	name := e.path[depth]
+	isTarget := depth == len(e.path)-1
+	switch v := value.(type) {
+	case *iterator.Object:
+		out.Put(&token.StartObject{})
+		found := false
+		for v.Advance() {
+			key, item := v.CurrentKeyVal()
+			if found || key.ToString() != name {
+				out.Put(key)
+				item.Copy(out)
+				continue
+			}
+			found = true
+			switch {
+			case !isTarget:
+				out.Put(key)
+				e.applyAt(item, depth+1, out)
+			case e.stage.stopped():
+				out.Put(key)
+				item.Copy(out)
+			case e.op != "remove":
+				out.Put(key)
+				e.putValue(out)
+			}
+		}
+		if !found {
+			if isTarget && e.op == "add" {
+				if !e.stage.stopped() {
+					out.Put(keyScalar(name))
+					e.putValue(out)
+				}
+			} else {
+				e.setError("member %q not found", name)
+			}
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndObject{})
+	case *iterator.Array:
+		index, err := parseArrayIndex(name, isTarget && e.op == "add")
+		if err != nil {
+			e.setError("%s", err)
+			value.Copy(out)
+			return
+		}
+		out.Put(&token.StartArray{})
+		i := 0
+		for ; v.Advance(); i++ {
+			item := v.CurrentValue()
+			if i != index {
+				item.Copy(out)
+				continue
+			}
+			switch {
+			case !isTarget:
+				e.applyAt(item, depth+1, out)
+			case e.stage.stopped():
+				item.Copy(out)
+			case e.op == "add":
+				e.putValue(out)
+				item.Copy(out)
+			case e.op == "replace":
+				item.Discard()
+				e.putValue(out)
+			default:
+				item.Discard()
+			}
+		}
+		switch {
+		case isTarget && e.op == "add" && (index == i || index < 0):
+			if !e.stage.stopped() {
+				e.putValue(out)
+			}
+		case index < 0 || index >= i:
+			e.setError("index %s out of range", name)
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndArray{})
+	default:
+		e.setError("cannot find %q in a scalar", name)
+		value.Copy(out)
+	}
+}
+
+// setError records that the operation cannot be applied, unless the stage is
+// stopped (the value is then copied unchanged and it does not matter).
+func (e *patchEdit) setError(format string, args ...any) {
+	if e.err == nil && !e.stage.stopped() {
+		e.err = fmt.Errorf(format, args...)
+		e.stage.fail()
+	}
+}
+
+// extractPointer returns the tokens of the value at the given path in value,
+// consuming value.
+func extractPointer(value iterator.Value, path []string) ([]token.Token, error) {
+	for _, name := range path {
+		switch v := value.(type) {
+		case *iterator.Object:
+			var found iterator.Value
+			for v.Advance() {
+				key, item := v.CurrentKeyVal()
+				if key.ToString() == name {
+					found = item
+					break
+				}
+			}
+			if found == nil {
+				return nil, fmt.Errorf("member %q not found", name)
+			}
+			value = found
+		case *iterator.Array:
+			index, err := parseArrayIndex(name, false)
+			if err != nil {
+				return nil, err
+			}
+			var found iterator.Value
+			for i := 0; v.Advance(); i++ {
+				if i == index {
+					found = v.CurrentValue()
+					break
+				}
+			}
+			if found == nil {
+				return nil, fmt.Errorf("index %s out of range", name)
+			}
+			value = found
+		default:
+			return nil, fmt.Errorf("cannot find %q in a scalar", name)
+		}
+	}
+	return copyValueTokens(value), nil
+}
+
+// parseArrayIndex parses a reference token used as an array index.  If
+// allowEnd is true, "-" is allowed (meaning after the last item) and -1 is
+// returned for it.
+func parseArrayIndex(s string, allowEnd bool) (int, error) {
+	if s == "-" && allowEnd {
+		return -1, nil
+	}
+	if s == "" || len(s) > 1 && s[0] == '0' || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", s)
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", s)
+	}
+	return index, nil
+}
+
+// parseJSONPointer returns the unescaped reference tokens of a JSON Pointer
+// (RFC 6901).
+func parseJSONPointer(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if s[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q", s)
+	}
+	path := strings.Split(s[1:], "/")
+	for i, name := range path {
+		path[i] = pointerTokenUnescaper.Replace(name)
+	}
+	return path, nil
+}
+
+func formatJSONPointer(path []string) string {
+	var b strings.Builder
+	for _, name := range path {
+		b.WriteByte('/')
+		b.WriteString(escapePointerToken(name))
+	}
+	return b.String()
+}
+
+var pointerTokenUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func isPointerPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, name := range prefix {
+		if path[i] != name {
+			return false
+		}
+	}
+	return true
+}
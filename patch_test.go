@@ -0,0 +1,177 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func mustNewApplyPatch(t *testing.T, patch string) *jsonstream.ApplyPatch {
+	iter := iterator.New(token.ChannelReadStream(streamJSONString(patch)))
+	iter.Advance()
+	applyPatch, err := jsonstream.NewApplyPatch(iter.CurrentValue())
+	if err != nil {
+		t.Fatalf("Invalid patch: %s", err)
+	}
+	return applyPatch
+}
+
+func TestApplyPatch(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		patch  string
+		output string
+		err    string
+	}
+	var testCases = []testCase{
+		{
+			name:   "add",
+			input:  `{"a": {"b": [1, 2]}}`,
+			patch:  `[{"op": "add", "path": "/a/c", "value": 3}, {"op": "add", "path": "/a/b/1", "value": 5}, {"op": "add", "path": "/a/b/-", "value": [6]}, {"op": "add", "path": "/a/b/4", "value": 7}]`,
+			output: "{\"a\": {\"b\": [1,5,2,[6],7],\"c\": 3}}\n",
+		},
+		{
+			name:   "add existing member",
+			input:  `{"a": 1, "b": 2}`,
+			patch:  `[{"op": "add", "path": "/a", "value": {"x": null}}]`,
+			output: "{\"a\": {\"x\": null},\"b\": 2}\n",
+		},
+		{
+			name:   "remove and replace",
+			input:  `{"a": [1, 2, 3], "b~/c": 4, "d": 5} {"a": [0, 0, 0], "b~/c": 1, "d": 1}`,
+			patch:  `[{"op": "remove", "path": "/a/0"}, {"op": "replace", "path": "/a/1", "value": "x"}, {"op": "remove", "path": "/b~0~1c"}, {"op": "replace", "path": "/d", "value": 6}]`,
+			output: "{\"a\": [2,\"x\"],\"d\": 6}\n{\"a\": [0,\"x\"],\"d\": 6}\n",
+		},
+		{
+			name:   "whole value",
+			input:  `1 2`,
+			patch:  `[{"op": "replace", "path": "", "value": [3]}]`,
+			output: "[3]\n[3]\n",
+		},
+		{
+			name:   "remove whole value",
+			input:  `1 2`,
+			patch:  `[{"op": "remove", "path": ""}]`,
+			output: "",
+		},
+		{
+			name:   "move and copy",
+			input:  `{"a": {"b": 1}, "c": [2]}`,
+			patch:  `[{"op": "move", "from": "/a/b", "path": "/c/0"}, {"op": "copy", "from": "/c", "path": "/d"}, {"op": "move", "from": "/d", "path": "/d"}]`,
+			output: "{\"a\": {},\"c\": [1,2],\"d\": [1,2]}\n",
+		},
+		{
+			name:   "missing member",
+			input:  `{"a": 1} {"b": 2}`,
+			patch:  `[{"op": "replace", "path": "/a", "value": 2}]`,
+			output: "{\"a\": 2}\n{\"b\": 2}\n",
+			err:    `patch: replace at /a: member "a" not found`,
+		},
+		{
+			name:   "index out of range",
+			input:  `[1, 2]`,
+			patch:  `[{"op": "add", "path": "/3", "value": 0}]`,
+			output: "[1,2]\n",
+			err:    `patch: add at /3: index 3 out of range`,
+		},
+		{
+			name:   "invalid index",
+			input:  `[[1, 2]]`,
+			patch:  `[{"op": "remove", "path": "/0/01"}]`,
+			output: "[[1,2]]\n",
+			err:    `patch: remove at /0/01: invalid array index "01"`,
+		},
+		{
+			name:   "operations after a failed one",
+			input:  `{"a": {"x": 1}, "c": 3}`,
+			patch:  `[{"op": "replace", "path": "/a/zz", "value": 2}, {"op": "add", "path": "/b", "value": 2}, {"op": "remove", "path": "/c"}]`,
+			output: "{\"a\": {\"x\": 1},\"c\": 3}\n",
+			err:    `patch: replace at /a/zz: member "zz" not found`,
+		},
+		{
+			name:   "first failed operation in the patch",
+			input:  `{"a": {"x": 1}}`,
+			patch:  `[{"op": "remove", "path": "/b"}, {"op": "remove", "path": "/a/y"}]`,
+			output: "{\"a\": {\"x\": 1}}\n",
+			err:    `patch: remove at /b: member "b" not found`,
+		},
+		{
+			name:   "missing from",
+			input:  `{}`,
+			patch:  `[{"op": "copy", "from": "/a", "path": "/b"}]`,
+			output: "{}\n",
+			err:    `patch: copy from /a: member "a" not found`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var err error
+			applyPatch := mustNewApplyPatch(t, c.patch)
+			stream := token.TransformStreamWithErrorHandler(streamJSONString(c.input), applyPatch, func(e error) { err = e })
+			got := encodeJSONStream(t, stream)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+			errString := ""
+			if err != nil {
+				errString = err.Error()
+			}
+			if errString != c.err {
+				t.Fatalf("Expected error %q, got %q", c.err, errString)
+			}
+		})
+	}
+}
+
+func TestNewApplyPatchError(t *testing.T) {
+	type testCase struct {
+		name  string
+		patch string
+		err   string
+	}
+	var testCases = []testCase{
+		{
+			name:  "not an array",
+			patch: `{}`,
+			err:   "patch: expected an array of operations",
+		},
+		{
+			name:  "invalid operation",
+			patch: `[{"op": "add", "path": "", "value": 1}, {"op": "delete", "path": "/a"}]`,
+			err:   `patch: operation #2: invalid operation "delete"`,
+		},
+		{
+			name:  "test operation",
+			patch: `[{"op": "test", "path": "/a", "value": 1}]`,
+			err:   `patch: operation #1: unsupported operation "test"`,
+		},
+		{
+			name:  "missing value",
+			patch: `[{"op": "replace", "path": "/a"}]`,
+			err:   `patch: operation #1: missing "value"`,
+		},
+		{
+			name:  "invalid pointer",
+			patch: `[{"op": "remove", "path": "a"}]`,
+			err:   `patch: operation #1: invalid JSON pointer "a"`,
+		},
+		{
+			name:  "move into child",
+			patch: `[{"op": "move", "from": "/a", "path": "/a/b"}]`,
+			err:   `patch: operation #1: cannot move a value into one of its children`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			iter := iterator.New(token.ChannelReadStream(streamJSONString(c.patch)))
+			iter.Advance()
+			_, err := jsonstream.NewApplyPatch(iter.CurrentValue())
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("Expected error %q, got %v", c.err, err)
+			}
+		})
+	}
+}
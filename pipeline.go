@@ -0,0 +1,102 @@
+package jsonstream
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A Pipeline decodes a json stream, applies transformers to it in order and
+// encodes the result.  It takes care of running each stage in its own
+// goroutine and of reporting errors, e.g.
+//
+//	err := jsonstream.Pipeline{}.
+//		Decode(jsonstream.NewJSONDecoder(r)).
+//		Transform(query, &jsonstream.Sort{}).
+//		Encode(&jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: w}}).
+//		Run(ctx)
+//
+// The methods building a pipeline return a copy of it, so a pipeline can be
+// used as the start of several others.  Note that the source, transformers and
+// sink are not copied, and most of them cannot be used in several runs.
+type Pipeline struct {
+	source       token.StreamSource
+	transformers []token.StreamTransformer
+	sink         token.StreamSink
+}
+
+// Decode returns a copy of the pipeline which reads its input from source
+// (e.g. a decoder).
+func (p Pipeline) Decode(source token.StreamSource) Pipeline {
+	p.source = source
+	return p
+}
+
+// Transform returns a copy of the pipeline which applies the given
+// transformers after its current ones.
+func (p Pipeline) Transform(transformers ...token.StreamTransformer) Pipeline {
+	p.transformers = append(p.transformers[:len(p.transformers):len(p.transformers)], transformers...)
+	return p
+}
+
+// Encode returns a copy of the pipeline which writes its output to sink (e.g.
+// an encoder).
+func (p Pipeline) Encode(sink token.StreamSink) Pipeline {
+	p.sink = sink
+	return p
+}
+
+// Run runs the pipeline until its input is consumed and returns the first
+// error which happened, if any: an error of the source (e.g. invalid input),
+// a *token.TransformError of a transformer, an error of the sink or the error
+// of ctx if it is done before Run returns.
+//
+// When a stage fails, the stages after it see the end of the stream, with the
+// value being written ended with an elision so that it is still well-formed.
+// When ctx is done, the pipeline stops in the same way but values being
+// processed may be cut at any point.
+func (p Pipeline) Run(ctx context.Context) error {
+	if p.source == nil {
+		return errors.New("pipeline has no source")
+	}
+	if p.sink == nil {
+		return errors.New("pipeline has no sink")
+	}
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Stages report their error before closing their output, so the error
+	// which stopped the pipeline is recorded when the sink returns.  When Run
+	// returns, ctx is cancelled so that stages still running (e.g. the ones
+	// before a stage which failed) stop.
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	stream := token.StartStreamWithContext(ctx, p.source, fail)
+	for _, transformer := range p.transformers {
+		stream = token.TransformStreamWithContext(ctx, stream, transformer, fail)
+	}
+	if err := token.ConsumeStreamWithContext(ctx, stream, p.sink); err != nil {
+		fail(err)
+	}
+
+	// The end of the stream may be seen before ctx is done even if the stream
+	// was cut short because of it.
+	if err := parent.Err(); err != nil {
+		fail(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return firstErr
+}
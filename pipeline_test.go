@@ -0,0 +1,142 @@
+package jsonstream_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// failAfter is a transformer which passes on n tokens then fails.
+type failAfter struct {
+	n int
+}
+
+func (f failAfter) Transform(in <-chan token.Token, out token.WriteStream) {
+	for tok := range in {
+		if f.n == 0 {
+			panic(token.TransformErrorf("too many tokens"))
+		}
+		f.n--
+		out.Put(tok)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	type testCase struct {
+		name         string
+		input        string
+		transformers []token.StreamTransformer
+		sink         token.StreamSink
+		output       string
+		err          string
+	}
+	var testCases = []testCase{
+		{
+			name:         "success",
+			input:        `{"a": [1, 2]} {"a": [3]}`,
+			transformers: []token.StreamTransformer{mustCompileQuery(t, "$.a"), iterator.AsStreamTransformer(jsonstream.ExplodeArray{})},
+			output:       "1\n2\n3\n",
+		},
+		{
+			name:   "no transformers",
+			input:  `[1, 2]`,
+			output: "[1,2]\n",
+		},
+		{
+			name:   "invalid input",
+			input:  `[1] [2,, 3]`,
+			output: "[1]\n[2...]\n",
+			err:    "syntax error at L1,C8: unexpected: ','",
+		},
+		{
+			name:         "transformer error",
+			input:        `[1] [2, 3] [4]`,
+			transformers: []token.StreamTransformer{failAfter{n: 5}},
+			output:       "[1]\n[2...]\n",
+			err:          "too many tokens",
+		},
+		{
+			name:  "sink error",
+			input: `[1] [2]`,
+			sink:  failingSink{},
+			err:   "disk full",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			sink := c.sink
+			if sink == nil {
+				sink = &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+			}
+			err := jsonstream.Pipeline{}.
+				Decode(jsonstream.NewJSONDecoder(strings.NewReader(c.input))).
+				Transform(c.transformers...).
+				Encode(sink).
+				Run(context.Background())
+			if c.err == "" && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if c.err != "" && (err == nil || err.Error() != c.err) {
+				t.Fatalf("Expected error %q, got %v", c.err, err)
+			}
+			if b.String() != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, b.String())
+			}
+		})
+	}
+}
+
+func TestPipelineCopies(t *testing.T) {
+	start := jsonstream.Pipeline{}.Transform(mustCompileQuery(t, "$.a"))
+	run := func(p jsonstream.Pipeline) string {
+		var b strings.Builder
+		err := p.Decode(jsonstream.NewJSONDecoder(strings.NewReader(`{"a": [1, 2]}`))).
+			Encode(&jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}).
+			Run(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		return b.String()
+	}
+	first := start.Transform(mustCompileQuery(t, "$[0]"))
+	second := start.Transform(mustCompileQuery(t, "$[1]"))
+	if got := run(first); got != "1\n" {
+		t.Errorf("Expected %q, got %q", "1\n", got)
+	}
+	if got := run(second); got != "2\n" {
+		t.Errorf("Expected %q, got %q", "2\n", got)
+	}
+	if got := run(start); got != "[1,2]\n" {
+		t.Errorf("Expected %q, got %q", "[1,2]\n", got)
+	}
+}
+
+func TestPipelineCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var b strings.Builder
+	err := jsonstream.Pipeline{}.
+		Decode(jsonstream.NewJSONDecoder(strings.NewReader(`[1] [2]`))).
+		Encode(&jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}).
+		Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPipelineIncomplete(t *testing.T) {
+	err := jsonstream.Pipeline{}.Encode(&jsonstream.JSONEncoder{}).Run(context.Background())
+	if err == nil || err.Error() != "pipeline has no source" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = jsonstream.Pipeline{}.Decode(jsonstream.NewJSONDecoder(strings.NewReader(`1`))).Run(context.Background())
+	if err == nil || err.Error() != "pipeline has no sink" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
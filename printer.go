@@ -73,17 +73,29 @@ type Flusher interface {
 // lines.
 // If Flusher is not nil, then the printer will call Flush() at the end of each
 // line (good when writing to terminal).
+// If MaxIndentLevel is positive, new lines are only started up to that
+// indentation level.  Deeper content is printed inline, with NewLine()
+// outputting a single space instead.
+// If Newline is not empty, it is output to end lines instead of "\n" (e.g. set
+// it to "\r\n" for CRLF line endings).
 type DefaultPrinter struct {
 	io.Writer
 	Flusher
-	IndentSize  int
-	indentLevel int
+	IndentSize     int
+	MaxIndentLevel int
+	Newline        string
+	indentLevel    int
 }
 
 var _ Printer = &DefaultPrinter{}
 
 func (p *DefaultPrinter) writeNL() {
-	_, err := p.Write([]byte{'\n'})
+	var err error
+	if p.Newline == "" {
+		_, err = p.Write([]byte{'\n'})
+	} else {
+		_, err = io.WriteString(p.Writer, p.Newline)
+	}
 	if err == nil && p.Flusher != nil {
 		err = p.Flush()
 	}
@@ -92,12 +104,16 @@ func (p *DefaultPrinter) writeNL() {
 	}
 }
 
-// NewLines outputs '\n' followed by a number of spaces corresponding to the
-// current indentation level.
+// NewLines outputs a new line followed by a number of spaces corresponding to
+// the current indentation level.
 func (p *DefaultPrinter) NewLine() {
 	if p.IndentSize < 0 {
 		return
 	}
+	if p.isInline() {
+		p.PrintBytes([]byte{' '})
+		return
+	}
 	p.writeNL()
 	for i := p.IndentSize * p.indentLevel; i > 0; i-- {
 		_, err := p.Write([]byte{' '})
@@ -110,16 +126,29 @@ func (p *DefaultPrinter) NewLine() {
 // Indent has the effect of incrementing the indentation level and calls NewLine()
 func (p *DefaultPrinter) Indent() {
 	p.indentLevel++
+	if p.isInline() {
+		return
+	}
 	p.NewLine()
 }
 
 // Dedent has the effect of decrementing the indentation level and calls NewLine()
 func (p *DefaultPrinter) Dedent() {
+	wasInline := p.isInline()
 	p.indentLevel--
+	if wasInline {
+		return
+	}
 	p.NewLine()
 }
 
-// Reset outputs '\n' unconditionally and resets the indent level.
+// isInline returns true if the current indentation level is deeper than
+// MaxIndentLevel, in which case no new lines should be started.
+func (p *DefaultPrinter) isInline() bool {
+	return p.MaxIndentLevel > 0 && p.indentLevel > p.MaxIndentLevel
+}
+
+// Reset outputs a new line unconditionally and resets the indent level.
 func (p *DefaultPrinter) Reset() {
 	p.indentLevel = 0
 	p.writeNL()
@@ -0,0 +1,43 @@
+package jsonstream
+
+import (
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A RawEncoder outputs each value of a stream on its own line for consumption
+// by other commands (e.g. with xargs).  Top-level scalars are output as their
+// literal text, strings without quotes and unescaped (so a string containing a
+// new line spans several lines), and arrays and objects are output as JSON on
+// a single line like an NDJSONEncoder would.
+//
+// The options of the embedded JSONEncoder apply to arrays and objects as they
+// do for an NDJSONEncoder.  They do not apply to top-level scalars.
+type RawEncoder struct {
+	JSONEncoder
+}
+
+var _ token.StreamSink = &RawEncoder{}
+
+// Consume outputs the values in the given channel.  An error can be returned
+// if the Printer could not perform some writing operation.
+func (e *RawEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	encoder := singleLineEncoder(e.JSONEncoder)
+	reader := token.NewStreamErrorReader(stream)
+	iter := iterator.New(reader)
+	for iter.Advance() {
+		value := iter.CurrentValue()
+		if scalar, ok := value.AsScalar(); ok {
+			if scalar.Type() == token.String {
+				e.PrintBytes([]byte(scalar.ToString()))
+			} else {
+				e.PrintBytes(scalar.Bytes)
+			}
+		} else {
+			encoder.writeValue(value)
+		}
+		e.Printer.Reset()
+	}
+	return reader.Err()
+}
@@ -0,0 +1,49 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestRawEncoder(t *testing.T) {
+	type testCase struct {
+		name    string
+		input   string
+		encoder jsonstream.JSONEncoder
+		output  string
+	}
+	var testCases = []testCase{
+		{
+			name:   "scalars as literal text",
+			input:  `"a b" "A\t\"" 1e3 -0.50 true null`,
+			output: "a b\nA\t\"\n1e3\n-0.50\ntrue\nnull\n",
+		},
+		{
+			name:   "arrays and objects on one line",
+			input:  `{"a": ["x", {"b": null}], "c": "d\ne"} []`,
+			output: "{\"a\":[\"x\",{\"b\":null}],\"c\":\"d\\ne\"}\n[]\n",
+		},
+		{
+			name:    "options apply inside arrays and objects only",
+			input:   `[2.50] 2.50`,
+			encoder: jsonstream.JSONEncoder{StableFloatRepr: true},
+			output:  "[2.5]\n2.50\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			encoder := &jsonstream.RawEncoder{JSONEncoder: c.encoder}
+			encoder.Printer = &jsonstream.DefaultPrinter{Writer: &b, IndentSize: 2}
+			if err := token.ConsumeStream(streamJSONString(c.input), encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := b.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package jsonstream
+
+import (
+	"unicode/utf8"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// stringScalar returns a scalar representing the string s, escaped as
+// required by JSON.  Unlike token.StringScalar, it does not escape HTML
+// characters and it sets the Alnum and Unescaped flags when appropriate.
+func stringScalar(s string) *token.Scalar {
+	isAlnum := s != "" && isalpha(s[0])
+	isUnescaped := true
+	escapedLen := len(s) + 2
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if isAlnum && i > 0 {
+			isAlnum = isalnum(b)
+		}
+		if b == '"' || b == '\\' || isctrl(b) {
+			isUnescaped = false
+			escapedLen += 5
+		}
+	}
+	var tokenBytes []byte
+	if isUnescaped && utf8.ValidString(s) {
+		tokenBytes = make([]byte, 0, escapedLen)
+		tokenBytes = append(tokenBytes, '"')
+		tokenBytes = append(tokenBytes, s...)
+		tokenBytes = append(tokenBytes, '"')
+	} else {
+		isUnescaped = false
+		tokenBytes = appendQuotedString(make([]byte, 0, escapedLen), s)
+	}
+	scalar := token.NewScalar(token.String, tokenBytes)
+	if isAlnum {
+		scalar.TypeAndFlags |= token.AlnumMask
+	}
+	if isUnescaped {
+		scalar.TypeAndFlags |= token.UnescapedMask
+	}
+	return scalar
+}
+
+// keyScalar is like stringScalar but returns a key.
+func keyScalar(s string) *token.Scalar {
+	scalar := stringScalar(s)
+	scalar.TypeAndFlags |= token.KeyMask
+	return scalar
+}
+
+// appendQuotedString appends to b the JSON representation of the string s.
+// Invalid UTF-8 is replaced with the unicode replacement character.
+func appendQuotedString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b = append(b, '\\', byte(r))
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\b':
+			b = append(b, '\\', 'b')
+		case '\f':
+			b = append(b, '\\', 'f')
+		default:
+			if r < 0x20 {
+				b = append(b, '\\', 'u', '0', '0', hexDigits[r>>4], hexDigits[r&0xF])
+			} else {
+				b = utf8.AppendRune(b, r)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+const hexDigits = "0123456789abcdef"
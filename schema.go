@@ -0,0 +1,291 @@
+package jsonstream
+
+import (
+	"net"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// DefaultSchemaMaxProperties is the default value of InferSchema.MaxProperties.
+const DefaultSchemaMaxProperties = 1000
+
+// InferSchema is a StreamTransformer which reads all the values of a stream and
+// outputs a JSON Schema (draft 2020-12) which they all satisfy, e.g. for the
+// values {"id": 1, "at": "2024-01-02"} and {"id": 2.5, "tags": []}
+//
+//	{
+//	  "$schema": "https://json-schema.org/draft/2020-12/schema",
+//	  "type": "object",
+//	  "properties": {
+//	    "id": {"type": "number", "minimum": 1, "maximum": 2.5},
+//	    "at": {"type": "string", "format": "date"},
+//	    "tags": {"type": "array", "items": {}}
+//	  },
+//	  "required": ["id"]
+//	}
+//
+// The schema lists the types of each value, the properties of objects (in the
+// order they are first seen) and which ones are always present, the schema of
+// array items, the range of numbers and the format of strings when all of them
+// have the same one (among "date-time", "date", "email", "uri", "uuid", "ipv4"
+// and "ipv6").
+//
+// Values are not buffered, so memory use only depends on the number of
+// distinct properties.  To keep it bounded (e.g. for objects used as maps with
+// arbitrary keys), when an object schema has MaxProperties properties (or
+// DefaultSchemaMaxProperties if it is not positive), other properties are
+// merged into its "additionalProperties" schema.
+//
+// If Sample is greater than 1, only one in Sample of the values in the stream
+// and of the items in each array is observed, which is faster for large
+// datasets but may miss some types or properties, and makes ranges and
+// required properties approximate.
+type InferSchema struct {
+	MaxProperties int
+	Sample        int
+}
+
+// Transform implements the InferSchema transform.
+func (f *InferSchema) Transform(in <-chan token.Token, out token.WriteStream) {
+	maxProps := f.MaxProperties
+	if maxProps <= 0 {
+		maxProps = DefaultSchemaMaxProperties
+	}
+	var root schemaNode
+	stream := token.NewStreamErrorReader(in)
+	iter := iterator.New(stream)
+	for i := 0; iter.Advance(); i++ {
+		if f.Sample <= 1 || i%f.Sample == 0 {
+			root.observe(iter.CurrentValue(), maxProps, f.Sample)
+		}
+	}
+	out.Put(&token.StartObject{})
+	out.Put(keyScalar("$schema"))
+	out.Put(stringScalar("https://json-schema.org/draft/2020-12/schema"))
+	root.writeKeywords(out)
+	out.Put(&token.EndObject{})
+	stream.ForwardError(out)
+}
+
+// Types of values, in the order they are listed in schemas.
+const (
+	schemaNull uint8 = 1 << iota
+	schemaBoolean
+	schemaInteger
+	schemaNumber
+	schemaString
+	schemaArray
+	schemaObject
+)
+
+var schemaTypeNames = []string{"null", "boolean", "integer", "number", "string", "array", "object"}
+
+// A schemaNode records what has been observed about the values at some place
+// in the input.
+type schemaNode struct {
+	types uint8 // Bitmask of the types of the values
+
+	min, max *token.Scalar // Range of numbers
+
+	formats uint8 // Formats which all strings have (bitmask of schemaFormats)
+
+	items *schemaNode // Array items
+
+	objectCount int                    // Number of objects observed
+	props       map[string]*schemaNode // Properties of objects
+	propCounts  map[string]int         // Number of objects which have each property
+	propOrder   []string               // Properties in the order they were seen
+	additional  *schemaNode            // Properties beyond the maximum
+}
+
+func (n *schemaNode) observe(value iterator.Value, maxProps, sample int) {
+	switch v := value.(type) {
+	case *iterator.Scalar:
+		n.observeScalar(v.Scalar())
+	case *iterator.Array:
+		n.types |= schemaArray
+		if n.items == nil {
+			n.items = &schemaNode{}
+		}
+		for i := 0; v.Advance(); i++ {
+			if sample <= 1 || i%sample == 0 {
+				n.items.observe(v.CurrentValue(), maxProps, sample)
+			}
+		}
+	case *iterator.Object:
+		if n.types&schemaObject == 0 {
+			n.props = map[string]*schemaNode{}
+			n.propCounts = map[string]int{}
+		}
+		n.types |= schemaObject
+		n.objectCount++
+		for v.Advance() {
+			key, val := v.CurrentKeyVal()
+			name := key.ToString()
+			prop := n.props[name]
+			if prop == nil {
+				if len(n.props) >= maxProps {
+					if n.additional == nil {
+						n.additional = &schemaNode{}
+					}
+					n.additional.observe(val, maxProps, sample)
+					continue
+				}
+				prop = &schemaNode{}
+				n.props[name] = prop
+				n.propOrder = append(n.propOrder, name)
+			}
+			n.propCounts[name]++
+			prop.observe(val, maxProps, sample)
+		}
+	}
+}
+
+func (n *schemaNode) observeScalar(scalar *token.Scalar) {
+	switch scalar.Type() {
+	case token.Null:
+		n.types |= schemaNull
+	case token.Boolean:
+		n.types |= schemaBoolean
+	case token.Number:
+		if isIntegerLiteral(scalar.Bytes) {
+			n.types |= schemaInteger
+		} else {
+			n.types |= schemaNumber
+		}
+		if n.min == nil || compareScalars(scalar, n.min) < 0 {
+			n.min = scalar
+		}
+		if n.max == nil || compareScalars(scalar, n.max) > 0 {
+			n.max = scalar
+		}
+	case token.String:
+		if n.types&schemaString == 0 {
+			n.formats = allSchemaFormats
+		}
+		n.types |= schemaString
+		if n.formats != 0 {
+			n.formats &= stringFormats(scalar.ToString(), n.formats)
+		}
+	}
+}
+
+// writeKeywords writes the keywords of the schema of n, without the enclosing
+// braces.
+func (n *schemaNode) writeKeywords(out token.WriteStream) {
+	types := n.types
+	if types&schemaNumber != 0 {
+		// Integers are numbers.
+		types &^= schemaInteger
+	}
+	var typeNames []string
+	for i, name := range schemaTypeNames {
+		if types&(1<<i) != 0 {
+			typeNames = append(typeNames, name)
+		}
+	}
+	switch len(typeNames) {
+	case 0:
+	case 1:
+		out.Put(keyScalar("type"))
+		out.Put(stringScalar(typeNames[0]))
+	default:
+		out.Put(keyScalar("type"))
+		out.Put(&token.StartArray{})
+		for _, name := range typeNames {
+			out.Put(stringScalar(name))
+		}
+		out.Put(&token.EndArray{})
+	}
+	if n.min != nil {
+		out.Put(keyScalar("minimum"))
+		out.Put(n.min)
+		out.Put(keyScalar("maximum"))
+		out.Put(n.max)
+	}
+	if n.types&schemaString != 0 {
+		for _, format := range schemaFormats {
+			if n.formats&format.mask != 0 {
+				out.Put(keyScalar("format"))
+				out.Put(stringScalar(format.name))
+				break
+			}
+		}
+	}
+	if n.items != nil {
+		out.Put(keyScalar("items"))
+		n.items.write(out)
+	}
+	if n.types&schemaObject != 0 {
+		out.Put(keyScalar("properties"))
+		out.Put(&token.StartObject{})
+		var required []string
+		for _, name := range n.propOrder {
+			out.Put(keyScalar(name))
+			n.props[name].write(out)
+			if n.propCounts[name] == n.objectCount {
+				required = append(required, name)
+			}
+		}
+		out.Put(&token.EndObject{})
+		if required != nil {
+			out.Put(keyScalar("required"))
+			out.Put(&token.StartArray{})
+			for _, name := range required {
+				out.Put(stringScalar(name))
+			}
+			out.Put(&token.EndArray{})
+		}
+		if n.additional != nil {
+			out.Put(keyScalar("additionalProperties"))
+			n.additional.write(out)
+		}
+	}
+}
+
+func (n *schemaNode) write(out token.WriteStream) {
+	out.Put(&token.StartObject{})
+	n.writeKeywords(out)
+	out.Put(&token.EndObject{})
+}
+
+// String formats recognised by InferSchema, in order of preference when a
+// string has several.
+var schemaFormats = []struct {
+	name  string
+	mask  uint8
+	check func(string) bool
+}{
+	{"date-time", 1 << 0, func(s string) bool { _, err := time.Parse(time.RFC3339, s); return err == nil }},
+	{"date", 1 << 1, func(s string) bool { _, err := time.Parse(time.DateOnly, s); return err == nil }},
+	{"uuid", 1 << 2, uuidRegexp.MatchString},
+	{"email", 1 << 3, emailRegexp.MatchString},
+	{"ipv4", 1 << 4, func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() != nil }},
+	{"ipv6", 1 << 5, func(s string) bool { ip := net.ParseIP(s); return ip != nil && ip.To4() == nil }},
+	{"uri", 1 << 6, func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != "" && (u.Host != "" || u.Opaque != "")
+	}},
+}
+
+const allSchemaFormats uint8 = 1<<7 - 1
+
+var (
+	uuidRegexp  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailRegexp = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// stringFormats returns the formats among candidates (a bitmask) which s has.
+func stringFormats(s string, candidates uint8) uint8 {
+	var formats uint8
+	for _, format := range schemaFormats {
+		if candidates&format.mask != 0 && format.check(s) {
+			formats |= format.mask
+		}
+	}
+	return formats
+}
@@ -0,0 +1,69 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestInferSchema(t *testing.T) {
+	type testCase struct {
+		name   string
+		schema jsonstream.InferSchema
+		input  string
+		output string
+	}
+	const header = `{"$schema": "https://json-schema.org/draft/2020-12/schema"`
+	var testCases = []testCase{
+		{
+			name:   "empty stream",
+			output: header + `}`,
+		},
+		{
+			name:   "scalars",
+			input:  `1 null 3 true`,
+			output: header + `,"type": ["null","boolean","integer"],"minimum": 1,"maximum": 3}`,
+		},
+		{
+			name:   "integers and numbers",
+			input:  `[10, -2.5, 1e3]`,
+			output: header + `,"type": "array","items": {"type": "number","minimum": -2.5,"maximum": 1e3}}`,
+		},
+		{
+			name:   "objects",
+			input:  `{"id": 1, "at": "2024-01-02", "tags": []} {"id": 2, "tags": ["x"], "more": {}}`,
+			output: header + `,"type": "object","properties": {"id": {"type": "integer","minimum": 1,"maximum": 2},"at": {"type": "string","format": "date"},"tags": {"type": "array","items": {"type": "string"}},"more": {"type": "object","properties": {}}},"required": ["id","tags"]}`,
+		},
+		{
+			name:   "formats",
+			input:  `{"email": "a@b.com", "dt": "2024-01-02T03:04:05Z", "ip": "192.168.0.1", "ip6": "::1", "uri": "https://x.org/a", "mixed": "2024-01-02"} {"mixed": "2024-01-02T03:04:05Z"}`,
+			output: header + `,"type": "object","properties": {"email": {"type": "string","format": "email"},"dt": {"type": "string","format": "date-time"},"ip": {"type": "string","format": "ipv4"},"ip6": {"type": "string","format": "ipv6"},"uri": {"type": "string","format": "uri"},"mixed": {"type": "string"}},"required": ["mixed"]}`,
+		},
+		{
+			name:   "single format",
+			input:  `"123e4567-e89b-12d3-a456-426614174000" "00000000-0000-0000-0000-000000000000"`,
+			output: header + `,"type": "string","format": "uuid"}`,
+		},
+		{
+			name:   "max properties",
+			schema: jsonstream.InferSchema{MaxProperties: 2},
+			input:  `{"a": 1, "b": 2, "c": "x", "d": null}`,
+			output: header + `,"type": "object","properties": {"a": {"type": "integer","minimum": 1,"maximum": 1},"b": {"type": "integer","minimum": 2,"maximum": 2}},"required": ["a","b"],"additionalProperties": {"type": ["null","string"]}}`,
+		},
+		{
+			name:   "sample",
+			schema: jsonstream.InferSchema{Sample: 2},
+			input:  `[1, "a", 2, "b", 3] "skipped" null`,
+			output: header + `,"type": ["null","array"],"items": {"type": "integer","minimum": 1,"maximum": 3}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schema := tc.schema
+			output := transformJSONString(t, tc.input, &schema)
+			if output != tc.output+"\n" {
+				t.Fatalf("Expected %s, got %s", tc.output, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package jsonstream
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A SmileDecoder reads input in the Smile binary format and streams it into a
+// JSON stream.
+//
+// Smile is a binary encoding of JSON used in some Java ecosystems (see
+// https://github.com/FasterXML/smile-format-specification).  The input must
+// start with a Smile header and may contain several documents, each introduced
+// by a header (an optional end-of-content marker 0xFF can separate them).
+//
+// Binary values have no JSON equivalent so they are streamed as base64
+// encoded strings.
+type SmileDecoder struct {
+	reader *binaryInput
+
+	sharedKeysEnabled   bool
+	sharedValuesEnabled bool
+
+	sharedKeys   []*token.Scalar
+	sharedValues []*token.Scalar
+
+	started bool
+}
+
+var _ token.StreamSource = &SmileDecoder{}
+
+// NewSmileDecoder sets up a new SmileDecoder instance to read from the given
+// input.
+func NewSmileDecoder(in io.Reader) *SmileDecoder {
+	return &SmileDecoder{reader: newBinaryInput(in)}
+}
+
+// Produce reads a stream of Smile documents and streams them, until it runs
+// out of input or encounters invalid Smile, in which case it will return an
+// error.
+func (d *SmileDecoder) Produce(out chan<- token.Token) error {
+	return produceValues(d, out)
+}
+
+func (d *SmileDecoder) decodeValue(out token.WriteStream) (bool, error) {
+	b, err := d.reader.ReadByte()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !d.started {
+		// The input must start with a header.
+		d.started = true
+		return true, d.reader.parseError("smile", d.readHeader(b))
+	}
+	switch b {
+	case smileEndOfContent:
+		return true, nil
+	case smileHeaderStart:
+		return true, d.reader.parseError("smile", d.readHeader(b))
+	default:
+		return true, d.reader.parseError("smile", d.parseValue(b, out))
+	}
+}
+
+// readHeader reads the 4 bytes header of a Smile document (the first byte b
+// has already been read) and resets the decoder's state accordingly.
+func (d *SmileDecoder) readHeader(b byte) error {
+	var header [3]byte
+	header[0] = b
+	if _, err := io.ReadFull(d.reader, header[1:]); err != nil {
+		return smileUnexpectedEOF(err)
+	}
+	if header != [3]byte{':', ')', '\n'} {
+		return errors.New("smile: invalid header")
+	}
+	flags, err := d.reader.ReadByte()
+	if err != nil {
+		return smileUnexpectedEOF(err)
+	}
+	if flags>>4 != 0 {
+		return fmt.Errorf("smile: unsupported version %d", flags>>4)
+	}
+	d.sharedKeysEnabled = flags&0x01 != 0
+	d.sharedValuesEnabled = flags&0x02 != 0
+	d.sharedKeys = d.sharedKeys[:0]
+	d.sharedValues = d.sharedValues[:0]
+	return nil
+}
+
+// parseValue reads a single Smile value whose first byte b has already been
+// read and streams it.
+func (d *SmileDecoder) parseValue(b byte, out token.WriteStream) error {
+	switch {
+	case b > 0x00 && b < 0x20:
+		// Byte 0x00 is avoided so short references are offset by 1
+		return d.sharedValue(int(b)-1, out)
+	case b == 0x20:
+		out.Put(emptyStringInstance)
+	case b == 0x21:
+		out.Put(nullInstance)
+	case b == 0x22:
+		out.Put(falseInstance)
+	case b == 0x23:
+		out.Put(trueInstance)
+	case b == 0x24 || b == 0x25:
+		n, err := d.readVInt()
+		if err != nil {
+			return err
+		}
+		out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, zigzagDecode(n), 10)))
+	case b == 0x26:
+		raw, err := d.read7BitBinary()
+		if err != nil {
+			return err
+		}
+		out.Put(token.NewScalar(token.Number, []byte(bigIntFromTwosComplement(raw).String())))
+	case b == 0x28:
+		bits, err := d.readFixed7Bit(5)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(float64(math.Float32frombits(uint32(bits))), 32, out)
+	case b == 0x29:
+		bits, err := d.readFixed7Bit(10)
+		if err != nil {
+			return err
+		}
+		return d.putFloat(math.Float64frombits(bits), 64, out)
+	case b == 0x2A:
+		scale, err := d.readVInt()
+		if err != nil {
+			return err
+		}
+		raw, err := d.read7BitBinary()
+		if err != nil {
+			return err
+		}
+		numBytes := bigIntFromTwosComplement(raw).Append(nil, 10)
+		if s := -zigzagDecode(scale); s != 0 {
+			numBytes = append(numBytes, 'e')
+			numBytes = strconv.AppendInt(numBytes, s, 10)
+		}
+		out.Put(token.NewScalar(token.Number, numBytes))
+	case b >= 0x40 && b < 0x60:
+		return d.sharableValue(int(b&0x1F)+1, out)
+	case b >= 0x60 && b < 0x80:
+		return d.sharableValue(int(b&0x1F)+33, out)
+	case b >= 0x80 && b < 0xA0:
+		return d.sharableValue(int(b&0x1F)+2, out)
+	case b >= 0xA0 && b < 0xC0:
+		return d.sharableValue(int(b&0x1F)+34, out)
+	case b >= 0xC0 && b < 0xE0:
+		out.Put(token.NewScalar(token.Number, strconv.AppendInt(nil, zigzagDecode(uint64(b&0x1F)), 10)))
+	case b == 0xE0 || b == 0xE4:
+		s, err := d.readUntilEndOfString()
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(s))
+	case b == 0xE8:
+		raw, err := d.read7BitBinary()
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(base64.StdEncoding.EncodeToString(raw)))
+	case b >= 0xEC && b <= 0xEF:
+		b2, err := d.reader.ReadByte()
+		if err != nil {
+			return smileUnexpectedEOF(err)
+		}
+		return d.sharedValue(int(b&0x03)<<8|int(b2), out)
+	case b == 0xF8:
+		return d.parseArray(out)
+	case b == 0xFA:
+		return d.parseObject(out)
+	case b == 0xFD:
+		n, err := d.readVInt()
+		if err != nil {
+			return err
+		}
+		raw, err := d.readBytes(n)
+		if err != nil {
+			return err
+		}
+		out.Put(stringScalar(base64.StdEncoding.EncodeToString(raw)))
+	default:
+		return fmt.Errorf("smile: unexpected value token 0x%02X", b)
+	}
+	return nil
+}
+
+func (d *SmileDecoder) parseArray(out token.WriteStream) error {
+	out.Put(&token.StartArray{})
+	for {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return smileUnexpectedEOF(err)
+		}
+		if b == 0xF9 {
+			out.Put(&token.EndArray{})
+			return nil
+		}
+		if err := d.parseValue(b, out); err != nil {
+			return err
+		}
+	}
+}
+
+func (d *SmileDecoder) parseObject(out token.WriteStream) error {
+	out.Put(&token.StartObject{})
+	for {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return smileUnexpectedEOF(err)
+		}
+		if b == 0xFB {
+			out.Put(&token.EndObject{})
+			return nil
+		}
+		key, err := d.parseKey(b)
+		if err != nil {
+			return err
+		}
+		out.Put(key)
+		b, err = d.reader.ReadByte()
+		if err != nil {
+			return smileUnexpectedEOF(err)
+		}
+		if err := d.parseValue(b, out); err != nil {
+			return err
+		}
+	}
+}
+
+// parseKey reads an object key whose first byte b has already been read.
+func (d *SmileDecoder) parseKey(b byte) (*token.Scalar, error) {
+	switch {
+	case b == 0x20:
+		return emptyKeyInstance, nil
+	case b >= 0x30 && b <= 0x33:
+		b2, err := d.reader.ReadByte()
+		if err != nil {
+			return nil, smileUnexpectedEOF(err)
+		}
+		return d.sharedKey(int(b&0x03)<<8 | int(b2))
+	case b == 0x34:
+		s, err := d.readUntilEndOfString()
+		if err != nil {
+			return nil, err
+		}
+		key := keyScalar(s)
+		if d.sharedKeysEnabled && len(s) <= smileMaxSharedLength {
+			d.sharedKeys = appendShared(d.sharedKeys, key)
+		}
+		return key, nil
+	case b >= 0x40 && b < 0x80:
+		return d.sharedKey(int(b & 0x3F))
+	case b >= 0x80 && b < 0xC0:
+		return d.sharableKey(int(b&0x3F) + 1)
+	case b >= 0xC0 && b < 0xF8:
+		return d.sharableKey(int(b-0xC0) + 2)
+	default:
+		return nil, fmt.Errorf("smile: unexpected key token 0x%02X", b)
+	}
+}
+
+func (d *SmileDecoder) sharableKey(n int) (*token.Scalar, error) {
+	raw, err := d.readBytes(uint64(n))
+	if err != nil {
+		return nil, err
+	}
+	key := keyScalar(string(raw))
+	if d.sharedKeysEnabled {
+		d.sharedKeys = appendShared(d.sharedKeys, key)
+	}
+	return key, nil
+}
+
+func (d *SmileDecoder) sharedKey(i int) (*token.Scalar, error) {
+	if i >= len(d.sharedKeys) {
+		return nil, fmt.Errorf("smile: invalid shared key reference %d", i)
+	}
+	return d.sharedKeys[i], nil
+}
+
+func (d *SmileDecoder) sharableValue(n int, out token.WriteStream) error {
+	raw, err := d.readBytes(uint64(n))
+	if err != nil {
+		return err
+	}
+	value := stringScalar(string(raw))
+	if d.sharedValuesEnabled {
+		d.sharedValues = appendShared(d.sharedValues, value)
+	}
+	out.Put(value)
+	return nil
+}
+
+func (d *SmileDecoder) sharedValue(i int, out token.WriteStream) error {
+	if i >= len(d.sharedValues) {
+		return fmt.Errorf("smile: invalid shared value reference %d", i)
+	}
+	out.Put(d.sharedValues[i])
+	return nil
+}
+
+// appendShared adds a scalar to a table of shared keys or values.  The Smile
+// spec says that the table is reset when it is full.
+func appendShared(table []*token.Scalar, scalar *token.Scalar) []*token.Scalar {
+	if len(table) >= smileMaxSharedEntries {
+		table = table[:0]
+	}
+	return append(table, scalar)
+}
+
+func (d *SmileDecoder) putFloat(x float64, bitSize int, out token.WriteStream) error {
+	if math.IsNaN(x) || math.IsInf(x, 0) {
+		return fmt.Errorf("smile: non-finite number %g cannot be represented in JSON", x)
+	}
+	out.Put(token.NewScalar(token.Number, strconv.AppendFloat(nil, x, 'g', -1, bitSize)))
+	return nil
+}
+
+// readVInt reads an unsigned variable length integer.  All bytes but the last
+// contribute 7 bits, the last one has its highest bit set and contributes 6
+// bits.
+func (d *SmileDecoder) readVInt() (uint64, error) {
+	var n uint64
+	for i := 0; i < 10; i++ {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return 0, smileUnexpectedEOF(err)
+		}
+		if b&0x80 != 0 {
+			return n<<6 | uint64(b&0x3F), nil
+		}
+		n = n<<7 | uint64(b)
+	}
+	return 0, errors.New("smile: variable length integer too long")
+}
+
+// readFixed7Bit reads n bytes each containing 7 bits of a number.
+func (d *SmileDecoder) readFixed7Bit(n int) (uint64, error) {
+	var bits uint64
+	for i := 0; i < n; i++ {
+		b, err := d.reader.ReadByte()
+		if err != nil {
+			return 0, smileUnexpectedEOF(err)
+		}
+		bits = bits<<7 | uint64(b&0x7F)
+	}
+	return bits, nil
+}
+
+// read7BitBinary reads binary data encoded so that each byte contains 7 bits,
+// preceded by its decoded length.
+func (d *SmileDecoder) read7BitBinary() ([]byte, error) {
+	n, err := d.readVInt()
+	if err != nil {
+		return nil, err
+	}
+	if n > smileMaxBinaryLength {
+		return nil, errors.New("smile: binary value too long")
+	}
+	result := make([]byte, 0, n)
+	// Each group of 7 bytes is encoded in 8 bytes.
+	for ; n >= 7; n -= 7 {
+		bits, err := d.readFixed7Bit(8)
+		if err != nil {
+			return nil, err
+		}
+		for shift := 48; shift >= 0; shift -= 8 {
+			result = append(result, byte(bits>>shift))
+		}
+	}
+	// The remaining n bytes are encoded in n+1 bytes, the last one containing
+	// the right-aligned remaining bits.
+	if n > 0 {
+		bits, err := d.readFixed7Bit(int(n))
+		if err != nil {
+			return nil, err
+		}
+		last, err := d.reader.ReadByte()
+		if err != nil {
+			return nil, smileUnexpectedEOF(err)
+		}
+		bits = bits<<n | uint64(last)&(1<<n-1)
+		for shift := int(8 * (n - 1)); shift >= 0; shift -= 8 {
+			result = append(result, byte(bits>>shift))
+		}
+	}
+	return result, nil
+}
+
+func (d *SmileDecoder) readBytes(n uint64) ([]byte, error) {
+	if n > smileMaxBinaryLength {
+		return nil, errors.New("smile: value too long")
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(d.reader, raw); err != nil {
+		return nil, smileUnexpectedEOF(err)
+	}
+	return raw, nil
+}
+
+func (d *SmileDecoder) readUntilEndOfString() (string, error) {
+	raw, err := d.reader.ReadBytes(smileEndOfString)
+	if err != nil {
+		return "", smileUnexpectedEOF(err)
+	}
+	return string(raw[:len(raw)-1]), nil
+}
+
+func zigzagDecode(n uint64) int64 {
+	return int64(n>>1) ^ -int64(n&1)
+}
+
+// bigIntFromTwosComplement interprets b as a big-endian two's complement
+// integer.
+func bigIntFromTwosComplement(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
+
+func smileUnexpectedEOF(err error) error {
+	if err == io.EOF {
+		return errors.New("smile: unexpected end of input")
+	}
+	return err
+}
+
+const (
+	smileHeaderStart      = ':'
+	smileEndOfString      = 0xFC
+	smileEndOfContent     = 0xFF
+	smileMaxSharedEntries = 1024
+	smileMaxSharedLength  = 64
+	smileMaxBinaryLength  = 1 << 30
+)
+
+var (
+	emptyStringInstance = stringScalar("")
+	emptyKeyInstance    = keyScalar("")
+)
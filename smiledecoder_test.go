@@ -0,0 +1,133 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestSmileDecoder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  []byte
+		output string
+		err    bool
+	}
+	header := func(flags byte) []byte { return []byte{':', ')', '\n', flags} }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	var testCases = []testCase{
+		{
+			name:   "simple object",
+			input:  cat(header(0x01), []byte{0xFA, 0x80, 'a', 0xC2, 0xFB}),
+			output: `{"a": 1}`,
+		},
+		{
+			name:   "literals",
+			input:  cat(header(0x01), []byte{0xF8, 0x21, 0x22, 0x23, 0x20, 0xF9}),
+			output: `[null,false,true,""]`,
+		},
+		{
+			name: "shared keys",
+			input: cat(header(0x01), []byte{
+				0xF8,
+				0xFA, 0x83, 'n', 'a', 'm', 'e', 0x40, 'x', 0xFB,
+				0xFA, 0x40, 0x40, 'y', 0xFB,
+				0xF9,
+			}),
+			output: `[{"name": "x"},{"name": "y"}]`,
+		},
+		{
+			name:   "shared values",
+			input:  cat(header(0x03), []byte{0xF8, 0x42, 'a', 'b', 'c', 0x01, 0xF9}),
+			output: `["abc","abc"]`,
+		},
+		{
+			name: "numbers",
+			input: cat(header(0x01), []byte{
+				0xF8,
+				0xC5,             // small int -3
+				0x24, 0x03, 0x87, // int32 -100
+				0x25, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80, // int64 2^40
+				0x28, 0x0B, 0x74, 0x00, 0x00, 0x00, // float32 -0.25
+				0x29, 0x00, 0x3F, 0x7C, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // float64 1.5
+				0xF9,
+			}),
+			output: `[-3,-100,1099511627776,-0.25,1.5]`,
+		},
+		{
+			name: "strings",
+			input: cat(header(0x01), []byte{
+				0xF8,
+				0x80, 0xC3, 0xA9, // tiny unicode "é"
+				0xE0, 'l', 'o', 'n', 'g', ' ', '"', 't', 'e', 'x', 't', '"', 0xFC, // long text
+				0xE8, 0x83, 0x00, 0x40, 0x40, 0x03, // 7-bit binary [1, 2, 3]
+				0xF9,
+			}),
+			output: `["é","long \"text\"","AQID"]`,
+		},
+		{
+			name:   "long key",
+			input:  cat(header(0x01), []byte{0xFA, 0x34, 'k', 'e', 'y', 0xFC, 0x21, 0x40, 0x22, 0xFB}),
+			output: `{"key": null,"key": false}`,
+		},
+		{
+			name:   "multiple documents",
+			input:  cat(header(0x01), []byte{0xC2, 0xFF}, header(0x01), []byte{0xC4}),
+			output: "1\n2",
+		},
+		{
+			name:  "invalid header",
+			input: []byte{':', '(', '\n', 0x01, 0xC2},
+			err:   true,
+		},
+		{
+			name:  "invalid shared key reference",
+			input: cat(header(0x01), []byte{0xFA, 0x41, 0x21, 0xFB}),
+			err:   true,
+		},
+		{
+			name:  "truncated input",
+			input: cat(header(0x01), []byte{0xF8, 0xC2}),
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeToJSONString(t, jsonstream.NewSmileDecoder(bytes.NewReader(c.input)))
+			if c.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
+
+// decodeToJSONString runs the decoder and returns its output as compact JSON
+// with one value per line.  If the decoder fails, the error is returned
+// instead.
+func decodeToJSONString(t *testing.T, decoder token.StreamSource) (string, error) {
+	var decodeErr error
+	var toks []token.Token
+	for tok := range token.StartStream(decoder, func(err error) { decodeErr = err }) {
+		toks = append(toks, tok)
+	}
+	if decodeErr != nil {
+		return "", decodeErr
+	}
+	stream := make(chan token.Token, len(toks))
+	for _, tok := range toks {
+		stream <- tok
+	}
+	close(stream)
+	return encodeJSONStream(t, stream), nil
+}
@@ -0,0 +1,465 @@
+package jsonstream
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/jsonpathtransformer"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// DefaultSortRunSize is the default value of Sort.MaxRunSize.
+const DefaultSortRunSize = 64 << 20
+
+// Sort is a StreamTransformer which outputs the values of a stream in order.
+// If Key is nil values are compared with each other, otherwise they are
+// compared by the first node selected by Key in them (null if there is none).
+// The sort is stable: values which compare equal are output in the order of
+// the input.
+//
+// Values are ordered as follows: null < false < true < numbers < strings <
+// arrays < objects.  Numbers are compared by value and strings by code point.
+// Arrays are compared item by item, and objects member by member in the order
+// of the input (comparing keys, then values), a prefix coming first.
+//
+// Values are held in memory until their size reaches MaxRunSize bytes
+// (approximately).  Then they are sorted and written to a temporary file in
+// TempDir, and the files are merged when the input is exhausted.  So memory
+// usage is bounded by MaxRunSize (plus a value per temporary file) however
+// long the stream is.
+type Sort struct {
+	Key        *jsonpathtransformer.MainQueryRunner
+	MaxRunSize int    // DefaultSortRunSize if 0 or less
+	TempDir    string // Directory for temporary files (os.TempDir() if empty)
+}
+
+// Transform implements the Sort transform.  It fails with a *TransformError if
+// a temporary file cannot be written or read.
+func (f *Sort) Transform(in <-chan token.Token, out token.WriteStream) {
+	maxRunSize := f.MaxRunSize
+	if maxRunSize <= 0 {
+		maxRunSize = DefaultSortRunSize
+	}
+	var (
+		items []sortItem
+		size  int
+		runs  []sortRun
+	)
+	defer func() {
+		for _, run := range runs {
+			run.close()
+		}
+	}()
+	stream := token.NewStreamErrorReader(in)
+	iter := iterator.New(stream)
+	for iter.Advance() {
+		item := f.newItem(iter.CurrentValue())
+		items = append(items, item)
+		size += item.size()
+		if size >= maxRunSize {
+			runs = append(runs, f.spill(items))
+			items, size = nil, 0
+		}
+	}
+	sortItems(items)
+	runs = append(runs, &memorySortRun{items: items})
+	mergeSortRuns(runs, out)
+	stream.ForwardError(out)
+}
+
+// newItem consumes value and returns it with its sort key.
+func (f *Sort) newItem(value iterator.Value) sortItem {
+	var item sortItem
+	if f.Key != nil {
+		item.key = evaluateKey(f.Key, value)
+	}
+	acc := token.NewAccumulatorStream()
+	value.Copy(acc)
+	item.toks = acc.GetTokens()
+	return item
+}
+
+// evaluateKey returns the tokens of the first node selected by query in value,
+// or of null if there is none, without consuming value.
+func evaluateKey(query *jsonpathtransformer.MainQueryRunner, value iterator.Value) []token.Token {
+	clone, detach := value.Clone()
+	if detach != nil {
+		defer detach()
+	}
+	key := token.NewAccumulatorStream()
+	query.EvaluateNodesResult(clone).ForEachNode(func(v iterator.Value) bool {
+		v.Copy(key)
+		return false
+	})
+	if toks := key.GetTokens(); len(toks) > 0 {
+		return toks
+	}
+	return []token.Token{nullInstance}
+}
+
+// spill sorts items and writes them to a new temporary file, returning a run
+// reading them back.
+func (f *Sort) spill(items []sortItem) sortRun {
+	sortItems(items)
+	file, err := os.CreateTemp(f.TempDir, "jp-sort-*")
+	if err != nil {
+		panic(token.TransformErrorf("sort: %w", err))
+	}
+	run := &fileSortRun{file: file, hasKey: f.Key != nil}
+	w := bufio.NewWriter(file)
+	for _, item := range items {
+		if run.hasKey {
+			writeSortTokens(w, item.key)
+		}
+		writeSortTokens(w, item.toks)
+	}
+	if err := w.Flush(); err != nil {
+		run.close()
+		panic(token.TransformErrorf("sort: %w", err))
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		run.close()
+		panic(token.TransformErrorf("sort: %w", err))
+	}
+	run.reader = bufio.NewReader(file)
+	return run
+}
+
+// A sortItem is a value to sort.
+type sortItem struct {
+	key  []token.Token // The tokens of the sort key, or nil if it is the value
+	toks []token.Token // The tokens of the value
+}
+
+func (item sortItem) sortKey() []token.Token {
+	if item.key != nil {
+		return item.key
+	}
+	return item.toks
+}
+
+// size returns an estimate of the memory used by item.
+func (item sortItem) size() int {
+	size := 0
+	for _, toks := range [][]token.Token{item.key, item.toks} {
+		for _, tok := range toks {
+			size += tokenSize(tok)
+		}
+	}
+	return size
+}
+
+// tokenSize returns the approximate number of bytes of memory used by tok.
+func tokenSize(tok token.Token) int {
+	if scalar, ok := tok.(*token.Scalar); ok {
+		return len(scalar.Bytes) + 48
+	}
+	return 16
+}
+
+func sortItems(items []sortItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return compareValues(items[i].sortKey(), items[j].sortKey()) < 0
+	})
+}
+
+// A sortRun is a sorted sequence of items.
+type sortRun interface {
+	next() (sortItem, bool)
+	close()
+}
+
+type memorySortRun struct {
+	items []sortItem
+}
+
+func (r *memorySortRun) next() (sortItem, bool) {
+	if len(r.items) == 0 {
+		return sortItem{}, false
+	}
+	item := r.items[0]
+	r.items = r.items[1:]
+	return item, true
+}
+
+func (r *memorySortRun) close() {}
+
+// A fileSortRun reads back items written to a temporary file by Sort.spill.
+type fileSortRun struct {
+	file   *os.File
+	reader *bufio.Reader
+	hasKey bool
+}
+
+func (r *fileSortRun) next() (item sortItem, ok bool) {
+	var err error
+	if r.hasKey {
+		item.key, err = readSortTokens(r.reader)
+		if err == nil {
+			item.toks, err = readSortTokens(r.reader)
+		}
+	} else {
+		item.toks, err = readSortTokens(r.reader)
+	}
+	if err == io.EOF {
+		return item, false
+	}
+	if err != nil {
+		panic(token.TransformErrorf("sort: %w", err))
+	}
+	return item, true
+}
+
+func (r *fileSortRun) close() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
+
+// mergeSortRuns outputs the items in runs in order.  Items which compare equal
+// are output in the order of their runs.
+func mergeSortRuns(runs []sortRun, out token.WriteStream) {
+	h := make(sortHeap, 0, len(runs))
+	for i, run := range runs {
+		if item, ok := run.next(); ok {
+			h = append(h, sortHeapEntry{item: item, run: i})
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		for _, tok := range h[0].item.toks {
+			out.Put(tok)
+		}
+		if item, ok := runs[h[0].run].next(); ok {
+			h[0].item = item
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+}
+
+type sortHeapEntry struct {
+	item sortItem
+	run  int
+}
+
+// sortHeap implements heap.Interface
+type sortHeap []sortHeapEntry
+
+func (h sortHeap) Len() int { return len(h) }
+
+func (h sortHeap) Less(i, j int) bool {
+	c := compareValues(h[i].item.sortKey(), h[j].item.sortKey())
+	return c < 0 || c == 0 && h[i].run < h[j].run
+}
+
+func (h sortHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *sortHeap) Push(x any) { *h = append(*h, x.(sortHeapEntry)) }
+
+func (h *sortHeap) Pop() any {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// Tags for the encoding of tokens in temporary files.  Scalars are followed by
+// their TypeAndFlags, then their length as a uvarint and their bytes.  Elisions
+// are followed by their count as a uvarint.
+const (
+	sortStartObject byte = iota
+	sortEndObject
+	sortStartArray
+	sortEndArray
+	sortElision
+	sortScalar
+)
+
+func writeSortTokens(w *bufio.Writer, toks []token.Token) {
+	var buf [binary.MaxVarintLen64]byte
+	for _, tok := range toks {
+		switch t := tok.(type) {
+		case *token.StartObject:
+			w.WriteByte(sortStartObject)
+		case *token.EndObject:
+			w.WriteByte(sortEndObject)
+		case *token.StartArray:
+			w.WriteByte(sortStartArray)
+		case *token.EndArray:
+			w.WriteByte(sortEndArray)
+		case *token.Elision:
+			w.WriteByte(sortElision)
+			w.Write(buf[:binary.PutUvarint(buf[:], uint64(t.Count))])
+		case *token.Scalar:
+			w.WriteByte(sortScalar)
+			w.WriteByte(t.TypeAndFlags)
+			w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(t.Bytes)))])
+			w.Write(t.Bytes)
+		}
+	}
+}
+
+// readSortTokens reads the tokens of a value written by writeSortTokens.  It
+// returns io.EOF if there is no more value to read.
+func readSortTokens(r *bufio.Reader) ([]token.Token, error) {
+	var toks []token.Token
+	depth := 0
+	for {
+		tag, err := r.ReadByte()
+		if err == io.EOF && len(toks) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, unexpectedSortEOF(err)
+		}
+		switch tag {
+		case sortStartObject:
+			toks = append(toks, &token.StartObject{})
+			depth++
+		case sortEndObject:
+			toks = append(toks, &token.EndObject{})
+			depth--
+		case sortStartArray:
+			toks = append(toks, &token.StartArray{})
+			depth++
+		case sortEndArray:
+			toks = append(toks, &token.EndArray{})
+			depth--
+		case sortElision:
+			count, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, unexpectedSortEOF(err)
+			}
+			toks = append(toks, &token.Elision{Count: int(count)})
+		case sortScalar:
+			typeAndFlags, err := r.ReadByte()
+			if err != nil {
+				return nil, unexpectedSortEOF(err)
+			}
+			n, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, unexpectedSortEOF(err)
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, unexpectedSortEOF(err)
+			}
+			toks = append(toks, &token.Scalar{Bytes: b, TypeAndFlags: typeAndFlags})
+		default:
+			return nil, errors.New("invalid temporary file")
+		}
+		if depth == 0 {
+			return toks, nil
+		}
+	}
+}
+
+func unexpectedSortEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// compareValues compares the JSON values encoded by the tokens a and b,
+// returning -1, 0 or 1 according to the order described in the Sort
+// documentation.
+func compareValues(a, b []token.Token) int {
+	c, _, _ := compareValuesAt(a, b)
+	return c
+}
+
+// compareValuesAt compares the values at the start of a and b.  If they are
+// equal, it also returns the tokens which follow them.
+func compareValuesAt(a, b []token.Token) (int, []token.Token, []token.Token) {
+	rankA, rankB := sortRank(a[0]), sortRank(b[0])
+	switch {
+	case rankA < rankB:
+		return -1, nil, nil
+	case rankA > rankB:
+		return 1, nil, nil
+	}
+	if scalarA, ok := a[0].(*token.Scalar); ok {
+		return compareScalars(scalarA, b[0].(*token.Scalar)), a[1:], b[1:]
+	}
+	// Both values are arrays or both are objects.  Object keys are scalars
+	// so they are compared like array items.
+	a, b = a[1:], b[1:]
+	for {
+		a, b = skipElision(a), skipElision(b)
+		endA, endB := isEndToken(a[0]), isEndToken(b[0])
+		switch {
+		case endA && endB:
+			return 0, a[1:], b[1:]
+		case endA:
+			return -1, nil, nil
+		case endB:
+			return 1, nil, nil
+		}
+		var c int
+		c, a, b = compareValuesAt(a, b)
+		if c != 0 {
+			return c, nil, nil
+		}
+	}
+}
+
+// sortRank returns the rank of the type of the value starting with tok in the
+// order of values.
+func sortRank(tok token.Token) int {
+	switch t := tok.(type) {
+	case *token.Scalar:
+		switch t.Type() {
+		case token.Null:
+			return 0
+		case token.Boolean:
+			if t.Bytes[0] == 'f' {
+				return 1
+			}
+			return 2
+		case token.Number:
+			return 3
+		default:
+			return 4
+		}
+	case *token.StartArray:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// compareScalars compares scalars of the same rank.
+func compareScalars(a, b *token.Scalar) int {
+	switch a.Type() {
+	case token.Number:
+		return token.CompareNumbers(a, b)
+	case token.String:
+		return strings.Compare(a.ToString(), b.ToString())
+	}
+	return 0
+}
+
+func skipElision(toks []token.Token) []token.Token {
+	if _, ok := toks[0].(*token.Elision); ok {
+		return toks[1:]
+	}
+	return toks
+}
+
+func isEndToken(tok token.Token) bool {
+	switch tok.(type) {
+	case *token.EndArray, *token.EndObject:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package jsonstream_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestSort(t *testing.T) {
+	type testCase struct {
+		name       string
+		input      string
+		key        string
+		maxRunSize int
+		output     string
+	}
+	var testCases = []testCase{
+		{
+			name:   "all types",
+			input:  `{"a": 1} [1, 2] "b" 3 null [1] true "a" 1.5 false {"a": 0} {}`,
+			output: "null\nfalse\ntrue\n1.5\n3\n\"a\"\n\"b\"\n[1]\n[1,2]\n{}\n{\"a\": 0}\n{\"a\": 1}\n",
+		},
+		{
+			name:   "numbers by value",
+			input:  `10 9 -1 1e1 2.5`,
+			output: "-1\n2.5\n9\n10\n1e1\n",
+		},
+		{
+			name:   "big numbers",
+			input:  `9007199254740993 9007199254740992 1e400 -1e400 0.30000000000000000001 0.3`,
+			output: "-1e400\n0.3\n0.30000000000000000001\n9007199254740992\n9007199254740993\n1e400\n",
+		},
+		{
+			name:   "strings with escapes",
+			input:  `"b" "\u0061" "ab"`,
+			output: "\"\\u0061\"\n\"ab\"\n\"b\"\n",
+		},
+		{
+			name:   "sort by key",
+			input:  `{"d": 3, "i": 0} {"d": 1, "i": 1} {"i": 2} {"d": 1, "i": 3}`,
+			key:    `$.d`,
+			output: "{\"i\": 2}\n{\"d\": 1,\"i\": 1}\n{\"d\": 1,\"i\": 3}\n{\"d\": 3,\"i\": 0}\n",
+		},
+		{
+			name:       "external merge",
+			input:      `5 [3] 1 {"x": 2} 4 2 [1, 2] 3 null`,
+			maxRunSize: 50,
+			output:     "null\n1\n2\n3\n4\n5\n[1,2]\n[3]\n{\"x\": 2}\n",
+		},
+		{
+			name:       "stable external merge",
+			input:      `{"d": 2, "i": 0} {"d": 1, "i": 1} {"d": 2, "i": 2} {"d": 1, "i": 3} {"d": 2, "i": 4} {"i": 5}`,
+			key:        `$.d`,
+			maxRunSize: 100,
+			output:     "{\"i\": 5}\n{\"d\": 1,\"i\": 1}\n{\"d\": 1,\"i\": 3}\n{\"d\": 2,\"i\": 0}\n{\"d\": 2,\"i\": 2}\n{\"d\": 2,\"i\": 4}\n",
+		},
+		{
+			name:   "empty stream",
+			input:  ``,
+			output: "",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			sort := &jsonstream.Sort{MaxRunSize: c.maxRunSize, TempDir: tempDir}
+			if c.key != "" {
+				sort.Key = mustCompileQuery(t, c.key)
+			}
+			got := transformJSONString(t, c.input, sort)
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+			if entries, _ := os.ReadDir(tempDir); len(entries) != 0 {
+				t.Fatalf("Expected temporary files to be removed, got %d", len(entries))
+			}
+		})
+	}
+}
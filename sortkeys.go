@@ -0,0 +1,114 @@
+package jsonstream
+
+import (
+	"sort"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// DefaultMaxObjectSize is the default value of SortKeys.MaxObjectSize and
+// JCSEncoder.MaxObjectSize.
+const DefaultMaxObjectSize = 64 << 20
+
+// SortKeys is a Transformer that outputs the members of objects sorted by key
+// (by code point), at any depth.  Members with the same key stay in the order
+// of the input.  This gives stable output, e.g. to compare documents produced
+// by tools which do not preserve the order of keys.
+//
+// Each object is held in memory until it ends, as its last member may come
+// first.  Arrays which are not inside an object are streamed.  If an object
+// takes more than MaxObjectSize bytes (approximately), the transform fails
+// with a *TransformError.
+type SortKeys struct {
+	MaxObjectSize int // DefaultMaxObjectSize if 0 or less
+}
+
+// TransformValue implements the SortKeys transform.
+func (f SortKeys) TransformValue(value iterator.Value, out token.WriteStream) {
+	switch v := value.(type) {
+	case *iterator.Object:
+		var size int
+		for _, tok := range f.appendObject(nil, v, &size) {
+			out.Put(tok)
+		}
+	case *iterator.Array:
+		out.Put(&token.StartArray{})
+		for v.Advance() {
+			f.TransformValue(v.CurrentValue(), out)
+		}
+		if v.Elided() {
+			out.Put(&token.Elision{Count: v.ElidedCount()})
+		}
+		out.Put(&token.EndArray{})
+	default:
+		value.Copy(out)
+	}
+}
+
+// appendObject appends the tokens of obj with its keys sorted to toks, adding
+// their size to size.
+func (f SortKeys) appendObject(toks []token.Token, obj *iterator.Object, size *int) []token.Token {
+	type member struct {
+		key  *token.Scalar
+		name string
+		toks []token.Token
+	}
+	var members []member
+	for obj.Advance() {
+		key, value := obj.CurrentKeyVal()
+		f.addSize(size, key)
+		members = append(members, member{
+			key:  key,
+			name: key.ToString(),
+			toks: f.appendValue(nil, value, size),
+		})
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].name < members[j].name
+	})
+	toks = append(toks, &token.StartObject{})
+	for _, m := range members {
+		toks = append(toks, m.key)
+		toks = append(toks, m.toks...)
+	}
+	if obj.Elided() {
+		toks = append(toks, &token.Elision{Count: obj.ElidedCount()})
+	}
+	return append(toks, &token.EndObject{})
+}
+
+// appendValue appends the tokens of value with the keys of its objects sorted
+// to toks, adding their size to size.
+func (f SortKeys) appendValue(toks []token.Token, value iterator.Value, size *int) []token.Token {
+	switch v := value.(type) {
+	case *iterator.Object:
+		return f.appendObject(toks, v, size)
+	case *iterator.Array:
+		toks = append(toks, &token.StartArray{})
+		for v.Advance() {
+			toks = f.appendValue(toks, v.CurrentValue(), size)
+		}
+		if v.Elided() {
+			toks = append(toks, &token.Elision{Count: v.ElidedCount()})
+		}
+		return append(toks, &token.EndArray{})
+	case *iterator.Scalar:
+		f.addSize(size, v.Scalar())
+		return append(toks, v.Scalar())
+	default:
+		return append(toks, copyValueTokens(value)...)
+	}
+}
+
+// addSize adds the size of tok to size, failing if it exceeds MaxObjectSize.
+func (f SortKeys) addSize(size *int, tok token.Token) {
+	*size += tokenSize(tok)
+	maxSize := f.MaxObjectSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxObjectSize
+	}
+	if *size > maxSize {
+		panic(token.TransformErrorf("sort keys: object larger than %d bytes", maxSize))
+	}
+}
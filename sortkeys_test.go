@@ -0,0 +1,62 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestSortKeys(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "nested objects",
+			input:  `{"b": {"y": 1, "x": 2}, "a": [{"d": 1, "c": 2}]} {"z": 0, "a": 1}`,
+			output: "{\"a\": [{\"c\": 2,\"d\": 1}],\"b\": {\"x\": 2,\"y\": 1}}\n{\"a\": 1,\"z\": 0}\n",
+		},
+		{
+			name:   "duplicate keys stay in order",
+			input:  `{"b": 1, "a": 2, "b": 3, "a": 4}`,
+			output: "{\"a\": 2,\"a\": 4,\"b\": 1,\"b\": 3}\n",
+		},
+		{
+			name:   "by code point",
+			input:  `{"é": 1, "z": 2, "\u0041": 3}`,
+			output: "{\"\\u0041\": 3,\"z\": 2,\"é\": 1}\n",
+		},
+		{
+			name:   "scalars and arrays",
+			input:  `1 "x" [{"b": 1, "a": 2}, 3] []`,
+			output: "1\n\"x\"\n[{\"a\": 2,\"b\": 1},3]\n[]\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got := transformJSONString(t, c.input, iterator.AsStreamTransformer(jsonstream.SortKeys{}))
+			if got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestSortKeysMaxObjectSize(t *testing.T) {
+	transformer := iterator.AsStreamTransformer(jsonstream.SortKeys{MaxObjectSize: 100})
+	stream := token.TransformStreamWithErrorHandler(streamJSONString(`[1, 2] {"b": "`+strings.Repeat("x", 100)+`", "a": 1}`), transformer, nil)
+	var b strings.Builder
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	err := token.ConsumeStream(stream, encoder)
+	if err == nil || !strings.Contains(err.Error(), "object larger than 100 bytes") {
+		t.Fatalf("Expected size error, got %v", err)
+	}
+	if got := b.String(); !strings.HasPrefix(got, "[1,2]\n") || strings.Contains(got, "xxx") {
+		t.Fatalf("Unexpected output %q", got)
+	}
+}
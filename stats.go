@@ -0,0 +1,171 @@
+package jsonstream
+
+import (
+	"sort"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// DefaultStatsTopKeys is the default value of Stats.TopKeys.
+const DefaultStatsTopKeys = 20
+
+// Maximum number of distinct keys whose frequency Stats keeps track of.
+const statsMaxTrackedKeys = 10000
+
+// Stats is a StreamTransformer which consumes the stream and outputs a summary
+// of its structure instead, e.g. for the stream {"a": [1, "x"]} {"a": null}
+//
+//	{
+//	  "values": 2,
+//	  "objects": 2,
+//	  "arrays": 1,
+//	  "strings": 1,
+//	  "numbers": 1,
+//	  "booleans": 0,
+//	  "nulls": 1,
+//	  "max_depth": 2,
+//	  "bytes": 25,
+//	  "keys": {"a": 2}
+//	}
+//
+// "max_depth" is the maximum nesting of arrays and objects, and "bytes" is the
+// size of the stream as compact JSON with one value per line.  "keys" contains
+// the TopKeys most frequent object keys (or DefaultStatsTopKeys if it is not
+// positive) with their number of occurrences, most frequent first.  To keep
+// memory bounded, only the first 10000 distinct keys are counted, and if there
+// are more, the number of occurrences of the others is given as "other_keys".
+type Stats struct {
+	TopKeys int
+}
+
+// Transform implements the Stats transform.
+func (f *Stats) Transform(in <-chan token.Token, out token.WriteStream) {
+	var (
+		values, objects, arrays          int64
+		strings, numbers, booleans, nuls int64
+		maxDepth                         int
+		size                             int64
+		otherKeys                        int64
+		keyCounts                        = map[string]int64{}
+		stack                            []statsFrame
+	)
+	stream := token.NewStreamErrorReader(in)
+	for tok := stream.Next(); tok != nil; tok = stream.Next() {
+		switch tok.(type) {
+		case *token.EndArray, *token.EndObject:
+			stack = stack[:len(stack)-1]
+			size++
+			if len(stack) == 0 {
+				size++ // New line
+			}
+			continue
+		case *token.Elision:
+			continue
+		}
+		// The token starts a new item unless it is the value of an object key.
+		if n := len(stack); n == 0 {
+			values++
+		} else if s, ok := tok.(*token.Scalar); ok && s.IsKey() || !stack[n-1].isObject {
+			if stack[n-1].hasItem {
+				size++ // Comma
+			}
+			stack[n-1].hasItem = true
+		}
+		switch t := tok.(type) {
+		case *token.StartArray:
+			arrays++
+			stack = append(stack, statsFrame{})
+			size++
+		case *token.StartObject:
+			objects++
+			stack = append(stack, statsFrame{isObject: true})
+			size++
+		case *token.Scalar:
+			size += int64(len(t.Bytes))
+			if t.IsKey() {
+				size++ // Colon
+				key := t.ToString()
+				if _, ok := keyCounts[key]; ok || len(keyCounts) < statsMaxTrackedKeys {
+					keyCounts[key]++
+				} else {
+					otherKeys++
+				}
+				continue
+			}
+			switch t.Type() {
+			case token.String:
+				strings++
+			case token.Number:
+				numbers++
+			case token.Boolean:
+				booleans++
+			default:
+				nuls++
+			}
+			if len(stack) == 0 {
+				size++ // New line
+			}
+		}
+		if len(stack) > maxDepth {
+			maxDepth = len(stack)
+		}
+	}
+	out.Put(&token.StartObject{})
+	for _, stat := range []struct {
+		name  string
+		value int64
+	}{
+		{"values", values},
+		{"objects", objects},
+		{"arrays", arrays},
+		{"strings", strings},
+		{"numbers", numbers},
+		{"booleans", booleans},
+		{"nulls", nuls},
+		{"max_depth", int64(maxDepth)},
+		{"bytes", size},
+	} {
+		out.Put(keyScalar(stat.name))
+		out.Put(token.Int64Scalar(stat.value))
+	}
+	out.Put(keyScalar("keys"))
+	out.Put(&token.StartObject{})
+	for _, key := range topKeys(keyCounts, f.TopKeys) {
+		out.Put(keyScalar(key))
+		out.Put(token.Int64Scalar(keyCounts[key]))
+	}
+	out.Put(&token.EndObject{})
+	if otherKeys > 0 {
+		out.Put(keyScalar("other_keys"))
+		out.Put(token.Int64Scalar(otherKeys))
+	}
+	out.Put(&token.EndObject{})
+	stream.ForwardError(out)
+}
+
+// A statsFrame records the state of an array or object which Stats is inside.
+type statsFrame struct {
+	isObject bool
+	hasItem  bool // True after the first item (or key) has been seen
+}
+
+// topKeys returns the n most frequent keys in counts (or DefaultStatsTopKeys if
+// n is not positive), most frequent first and in alphabetical order when they
+// have the same frequency.
+func topKeys(counts map[string]int64, n int) []string {
+	if n <= 0 {
+		n = DefaultStatsTopKeys
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ci, cj := counts[keys[i]], counts[keys[j]]
+		return ci > cj || ci == cj && keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -0,0 +1,52 @@
+package jsonstream_test
+
+import (
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestStats(t *testing.T) {
+	type testCase struct {
+		name   string
+		stats  jsonstream.Stats
+		input  string
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name:   "empty stream",
+			output: `{"values": 0,"objects": 0,"arrays": 0,"strings": 0,"numbers": 0,"booleans": 0,"nulls": 0,"max_depth": 0,"bytes": 0,"keys": {}}`,
+		},
+		{
+			name:   "scalars",
+			input:  `1 "ab" null`,
+			output: `{"values": 3,"objects": 0,"arrays": 0,"strings": 1,"numbers": 1,"booleans": 0,"nulls": 1,"max_depth": 0,"bytes": 12,"keys": {}}`,
+		},
+		{
+			name:   "nesting",
+			input:  `{"a": [1, "x"]} {"a": null}`,
+			output: `{"values": 2,"objects": 2,"arrays": 1,"strings": 1,"numbers": 1,"booleans": 0,"nulls": 1,"max_depth": 2,"bytes": 25,"keys": {"a": 2}}`,
+		},
+		{
+			name:   "empty containers",
+			input:  `[] {} [[], {"t": false}]`,
+			output: `{"values": 3,"objects": 2,"arrays": 3,"strings": 0,"numbers": 0,"booleans": 1,"nulls": 0,"max_depth": 2,"bytes": 23,"keys": {"t": 1}}`,
+		},
+		{
+			name:   "top keys",
+			stats:  jsonstream.Stats{TopKeys: 2},
+			input:  `{"b": 1, "a": 2, "c": 3} {"c": 1, "b": 2}`,
+			output: `{"values": 2,"objects": 2,"arrays": 0,"strings": 0,"numbers": 5,"booleans": 0,"nulls": 0,"max_depth": 1,"bytes": 34,"keys": {"b": 2,"c": 2}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := tc.stats
+			output := transformJSONString(t, tc.input, &stats)
+			if output != tc.output+"\n" {
+				t.Fatalf("Expected %s, got %s", tc.output, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package jsonstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A TemplateEncoder renders each value of a stream with a text/template,
+// followed by a new line, e.g. to produce human-readable reports.  The value
+// is passed to the template as Go data:
+//   - objects as map[string]any (the last member wins if keys are duplicated);
+//   - arrays as []any;
+//   - strings as string, booleans as bool and null as nil;
+//   - numbers as json.Number, so they are output as in the input.  Use e.g.
+//     {{if gt .price.Float64 9.99}} to compare them.
+//
+// Only the members of top-level objects which the template refers to (e.g.
+// "name" in {{.name.first}} or {{$.name}}) are converted, the others are
+// skipped.  If the template uses the whole value (e.g. {{.}} or {{$}} but not
+// {{.}} inside {{range .items}}), all members are converted.
+type TemplateEncoder struct {
+	Printer
+	Template *template.Template
+}
+
+var _ token.StreamSink = &TemplateEncoder{}
+
+// Consume renders the values in the given channel.  It returns an error if
+// the Printer could not write or the template failed to execute.
+func (e *TemplateEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	fields, all := templateFields(e.Template)
+	reader := token.NewStreamErrorReader(stream)
+	iter := iterator.New(reader)
+	var buf bytes.Buffer
+	for iter.Advance() {
+		var data any
+		if obj, ok := iter.CurrentValue().(*iterator.Object); ok && !all {
+			data = objectFieldsToGo(obj, fields)
+		} else {
+			data = valueToGo(iter.CurrentValue())
+		}
+		buf.Reset()
+		if err := e.Template.Execute(&buf, data); err != nil {
+			panic(&PrinterError{Err: err})
+		}
+		e.PrintBytes(buf.Bytes())
+		e.Printer.Reset()
+	}
+	return reader.Err()
+}
+
+// valueToGo converts value to Go data as described in TemplateEncoder.
+func valueToGo(value iterator.Value) any {
+	switch v := value.(type) {
+	case *iterator.Object:
+		m := map[string]any{}
+		for v.Advance() {
+			key, val := v.CurrentKeyVal()
+			m[key.ToString()] = valueToGo(val)
+		}
+		return m
+	case *iterator.Array:
+		s := []any{}
+		for v.Advance() {
+			s = append(s, valueToGo(v.CurrentValue()))
+		}
+		return s
+	case *iterator.Scalar:
+		scalar := v.Scalar()
+		switch scalar.Type() {
+		case token.String:
+			return scalar.ToString()
+		case token.Number:
+			return json.Number(scalar.Bytes)
+		case token.Boolean:
+			return scalar.Bytes[0] == 't'
+		}
+	}
+	return nil
+}
+
+// objectFieldsToGo converts the members of obj whose key is in fields to Go
+// data, skipping the others.
+func objectFieldsToGo(obj *iterator.Object, fields map[string]bool) map[string]any {
+	m := map[string]any{}
+	for obj.Advance() {
+		key, val := obj.CurrentKeyVal()
+		if name := key.ToString(); fields[name] {
+			m[name] = valueToGo(val)
+		} else {
+			val.Discard()
+		}
+	}
+	return m
+}
+
+// templateFields returns the names of the fields of dot that the templates
+// associated with t may refer to, or true if they may use dot as a whole.
+// Inside {{range}} and {{with}}, dot is something else so only fields of $
+// count.  Associated templates are assumed to be called with the top-level dot
+// so there may be more fields than needed.
+func templateFields(t *template.Template) (map[string]bool, bool) {
+	fields := map[string]bool{}
+	all := false
+	// top is true if dot is the top-level value in node.
+	var walk func(node parse.Node, top bool)
+	walk = func(node parse.Node, top bool) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child, top)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, top)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd, top)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg, top)
+			}
+		case *parse.IfNode:
+			walk(n.Pipe, top)
+			walk(n.List, top)
+			walk(n.ElseList, top)
+		case *parse.RangeNode:
+			walk(n.Pipe, top)
+			walk(n.List, false)
+			walk(n.ElseList, top)
+		case *parse.WithNode:
+			walk(n.Pipe, top)
+			walk(n.List, false)
+			walk(n.ElseList, top)
+		case *parse.TemplateNode:
+			walk(n.Pipe, top)
+		case *parse.ChainNode:
+			walk(n.Node, top)
+		case *parse.FieldNode:
+			if top {
+				fields[n.Ident[0]] = true
+			}
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" {
+				if len(n.Ident) > 1 {
+					fields[n.Ident[1]] = true
+				} else {
+					all = true
+				}
+			}
+		case *parse.DotNode:
+			if top {
+				all = true
+			}
+		}
+	}
+	for _, tmpl := range t.Templates() {
+		if tmpl.Tree != nil {
+			walk(tmpl.Tree.Root, true)
+		}
+	}
+	return fields, all
+}
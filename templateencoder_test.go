@@ -0,0 +1,93 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestTemplateEncoder(t *testing.T) {
+	type testCase struct {
+		name     string
+		template string
+		input    string
+		output   string
+	}
+	var testCases = []testCase{
+		{
+			name:     "fields",
+			template: `{{.name}}: {{.price}}`,
+			input:    `{"name": "pen", "price": 1.50, "tags": ["a"]} {"price": 12, "name": "ink"}`,
+			output:   "pen: 1.50\nink: 12\n",
+		},
+		{
+			name:     "number comparison",
+			template: `{{.name}}{{if gt .price.Float64 10.0}} (expensive){{end}}`,
+			input:    `{"name": "pen", "price": 1.50} {"name": "ink", "price": 1.2e1}`,
+			output:   "pen\nink (expensive)\n",
+		},
+		{
+			name:     "nested values",
+			template: `{{.user.name}} {{range .tags}}[{{.}}]{{end}} {{index .ids 1}} {{.ok}} {{.none}}`,
+			input:    `{"user": {"name": "xé"}, "tags": ["a", "b"], "ids": [10, 20], "ok": true, "none": null}`,
+			output:   "xé [a][b] 20 true <no value>\n",
+		},
+		{
+			name:     "dollar fields inside range",
+			template: `{{range .items}}{{$.prefix}}{{.}} {{end}}`,
+			input:    `{"prefix": "#", "items": [1, 2], "other": {}}`,
+			output:   "#1 #2 \n",
+		},
+		{
+			name:     "else branch of with",
+			template: `{{with .a}}a={{.}}{{else}}b={{.b}}{{end}}`,
+			input:    `{"a": "x", "b": "y"} {"b": "z"}`,
+			output:   "a=x\nb=z\n",
+		},
+		{
+			name:     "associated templates",
+			template: `{{define "t"}}{{.x}}{{end}}{{.y}}-{{template "t" $}}`,
+			input:    `{"x": 1, "y": 2}`,
+			output:   "2-1\n",
+		},
+		{
+			name:     "whole value",
+			template: `{{len .}} {{printf "%v" .}}`,
+			input:    `{"a": 1, "b": 2} [true, null] "s"`,
+			output:   "2 map[a:1 b:2]\n2 [true <nil>]\n1 s\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var b strings.Builder
+			encoder := &jsonstream.TemplateEncoder{
+				Printer:  &jsonstream.DefaultPrinter{Writer: &b},
+				Template: template.Must(template.New("test").Parse(c.template)),
+			}
+			if err := token.ConsumeStream(streamJSONString(c.input), encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got := b.String(); got != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, got)
+			}
+		})
+	}
+}
+
+func TestTemplateEncoderError(t *testing.T) {
+	var b strings.Builder
+	encoder := &jsonstream.TemplateEncoder{
+		Printer:  &jsonstream.DefaultPrinter{Writer: &b},
+		Template: template.Must(template.New("test").Parse(`{{.a.Float64}}`)),
+	}
+	err := token.ConsumeStream(streamJSONString(`{"a": 1} {"a": "x"} {"a": 2}`), encoder)
+	if err == nil || !strings.Contains(err.Error(), "Float64") {
+		t.Fatalf("Expected an execution error, got %v", err)
+	}
+	if got := b.String(); got != "1\n" {
+		t.Fatalf("Expected output to stop at the error, got %q", got)
+	}
+}
@@ -0,0 +1,164 @@
+package token
+
+import "context"
+
+// StartStreamWithContext is like StartStream but the returned stream is
+// closed as soon as ctx is done.  The source is not interrupted as it cannot
+// be told to stop, so its output is discarded until it returns.  To stop it
+// promptly, make it fail, e.g. by closing the reader of a decoder.
+//
+// When the stream is closed because ctx is done, the values which were being
+// produced are truncated but still well-formed: each unfinished array or
+// object ends with an Elision token (and a missing object member value is
+// replaced with null).  The same happens if the source fails in the middle of
+// a value.  The error of the source is handled as in StartStream, unless ctx
+// is done.
+func StartStreamWithContext(ctx context.Context, source StreamSource, handleError func(error)) <-chan Token {
+	produced := make(chan Token, streamBufferSize)
+	go func() {
+		defer close(produced)
+		err := source.Produce(produced)
+		if err != nil && ctx.Err() == nil {
+			reportError(produced, handleError, err)
+		}
+	}()
+	out := make(chan Token, streamBufferSize)
+	go func() {
+		defer close(out)
+		forwardWithContext(ctx, produced, out)
+	}()
+	return out
+}
+
+// TransformStreamWithContext is like TransformStreamWithErrorHandler but when
+// ctx is done, the input of the transformer and the returned stream are closed
+// (with values truncated as in StartStreamWithContext), so that the transformer
+// finishes promptly.  The rest of the incoming stream is then discarded so that
+// the goroutines producing it are not blocked.  If the transformer fails in the
+// middle of a value, the value is also truncated.
+func TransformStreamWithContext(ctx context.Context, in <-chan Token, transformer StreamTransformer, handleError func(error)) <-chan Token {
+	transformIn := make(chan Token, streamBufferSize)
+	go func() {
+		defer close(transformIn)
+		forwardWithContext(ctx, in, transformIn)
+	}()
+	transformOut := TransformStreamWithErrorHandler(transformIn, transformer, handleError)
+	out := make(chan Token, streamBufferSize)
+	go func() {
+		defer close(out)
+		forwardWithContext(ctx, transformOut, out)
+	}()
+	return out
+}
+
+// ConsumeStreamWithContext is like ConsumeStream but when ctx is done, the
+// stream given to the sink is closed (with values truncated as in
+// StartStreamWithContext) and the error of ctx is returned, unless the sink
+// returns an error or the stream was cut short by an error before.  The rest of
+// the incoming stream is then discarded so that the goroutines producing it are
+// not blocked.
+func ConsumeStreamWithContext(ctx context.Context, in <-chan Token, sink StreamSink) error {
+	forwarded := make(chan Token, streamBufferSize)
+	cancelled := make(chan bool, 1)
+	go func() {
+		defer close(forwarded)
+		cancelled <- !forwardWithContext(ctx, in, forwarded)
+	}()
+	// ConsumeStream drains the rest of the stream if the sink stops reading
+	// it early.
+	if err := ConsumeStream(forwarded, sink); err != nil {
+		return err
+	}
+	select {
+	case c := <-cancelled:
+		if c {
+			return ctx.Err()
+		}
+	default:
+		// The sink stopped reading before the end of the stream.
+	}
+	return nil
+}
+
+// forwardWithContext copies in to out until in is closed, returning true, or
+// ctx is done, returning false.  In the latter case, in is drained in the
+// background.  In both cases, the unfinished values in out are closed (in
+// closes in the middle of a value when a source or transformer fails, and then
+// it may end with a StreamError, which comes after the closed values).
+func forwardWithContext(ctx context.Context, in <-chan Token, out chan<- Token) bool {
+	var tracker openValueTracker
+	for {
+		select {
+		case <-ctx.Done():
+			tracker.closeValues(out)
+			go drain(in)
+			return false
+		case tok, ok := <-in:
+			if !ok {
+				tracker.closeValues(out)
+				return true
+			}
+			if _, ok := tok.(*StreamError); ok {
+				tracker.closeValues(out)
+			}
+			select {
+			case out <- tok:
+				tracker.update(tok)
+			case <-ctx.Done():
+				tracker.closeValues(out)
+				go drain(in)
+				return false
+			}
+		}
+	}
+}
+
+func drain(in <-chan Token) {
+	for range in {
+	}
+}
+
+// openValueTracker keeps track of the arrays and objects which are not
+// finished in a stream, so that they can be closed if the stream is cut short.
+type openValueTracker struct {
+	open         []Token // Start tokens of unfinished arrays and objects
+	afterKey     bool    // True if an object key has just been seen
+	afterElision bool    // True if an elision has just been seen
+}
+
+func (t *openValueTracker) update(tok Token) {
+	t.afterKey = false
+	t.afterElision = false
+	switch tok := tok.(type) {
+	case *Elision:
+		t.afterElision = true
+	case *StartArray, *StartObject:
+		t.open = append(t.open, tok)
+	case *EndArray, *EndObject:
+		t.open = t.open[:len(t.open)-1]
+	case *Scalar:
+		t.afterKey = tok.IsKey()
+	}
+}
+
+// closeValues writes the tokens which close the unfinished values to out.
+func (t *openValueTracker) closeValues(out chan<- Token) {
+	if t.afterKey {
+		out <- NullScalar
+	}
+	for i := len(t.open) - 1; i >= 0; i-- {
+		// The innermost value may already end with an elision, e.g. if it
+		// was closed by another stage.
+		if !t.afterElision {
+			out <- &Elision{}
+		}
+		t.afterElision = false
+		if _, ok := t.open[i].(*StartArray); ok {
+			out <- &EndArray{}
+		} else {
+			out <- &EndObject{}
+		}
+	}
+	t.open = nil
+	t.afterKey = false
+}
@@ -0,0 +1,115 @@
+package token_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// blockingSource produces some tokens then blocks until done is closed.
+type blockingSource struct {
+	toks []token.Token
+	done <-chan struct{}
+}
+
+func (s blockingSource) Produce(out chan<- token.Token) error {
+	for _, tok := range s.toks {
+		out <- tok
+	}
+	<-s.done
+	return nil
+}
+
+// cancellingSink records the tokens it consumes and cancels after n tokens.
+type cancellingSink struct {
+	n      int
+	cancel func()
+	toks   *[]string
+}
+
+func (s cancellingSink) Consume(in <-chan token.Token) error {
+	for tok := range in {
+		*s.toks = append(*s.toks, tok.String())
+		if len(*s.toks) == s.n {
+			s.cancel()
+		}
+	}
+	return nil
+}
+
+func TestContextCancellation(t *testing.T) {
+	type testCase struct {
+		name   string
+		toks   []token.Token
+		output string
+	}
+	var testCases = []testCase{
+		{
+			name: "unfinished array",
+			toks: []token.Token{
+				&token.StartObject{},
+				token.NewKey(token.String, []byte(`"a"`)),
+				&token.StartArray{},
+				token.Int64Scalar(1),
+			},
+			output: `StartObject Scalar("a") StartArray Scalar(1) Elision EndArray Elision EndObject`,
+		},
+		{
+			name: "missing member value",
+			toks: []token.Token{
+				&token.StartObject{},
+				token.NewKey(token.String, []byte(`"a"`)),
+			},
+			output: `StartObject Scalar("a") Scalar(null) Elision EndObject`,
+		},
+		{
+			name:   "complete value",
+			toks:   []token.Token{token.Int64Scalar(1)},
+			output: `Scalar(1)`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan struct{})
+			defer close(done)
+			stream := token.StartStreamWithContext(ctx, blockingSource{toks: c.toks, done: done}, nil)
+			stream = token.TransformStreamWithContext(ctx, stream, passThrough{}, nil)
+			var got []string
+			err := token.ConsumeStreamWithContext(ctx, stream, cancellingSink{n: len(c.toks), cancel: cancel, toks: &got})
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Expected context.Canceled, got %v", err)
+			}
+			if strings.Join(got, " ") != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, strings.Join(got, " "))
+			}
+		})
+	}
+}
+
+type passThrough struct{}
+
+func (passThrough) Transform(in <-chan token.Token, out token.WriteStream) {
+	for tok := range in {
+		out.Put(tok)
+	}
+}
+
+func TestContextNoCancellation(t *testing.T) {
+	ctx := context.Background()
+	var b strings.Builder
+	stream := token.StartStreamWithContext(ctx, jsonstream.NewJSONDecoder(strings.NewReader(`{"a": [1, 2]} 3`)), nil)
+	stream = token.TransformStreamWithContext(ctx, stream, passThrough{}, nil)
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	if err := token.ConsumeStreamWithContext(ctx, stream, encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := "{\"a\": [1,2]}\n3\n"; b.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, b.String())
+	}
+}
@@ -6,7 +6,22 @@ import (
 	"github.com/arnodel/jsonstream/internal/debug"
 )
 
+// A CursorPool allows several cursors to read the same underlying stream
+// independently.  Tokens read from the stream are kept in a window until all
+// cursors have read them.  The stream is only read when a cursor needs a token
+// that is not in the window yet, so the producer of the stream is blocked until
+// the leading cursor reads more.  However the window grows when cursors diverge
+// (e.g. when a descendant query clones values and reads them later).
+//
+// If MaxWindowSize is positive, the window is compacted as soon as it reaches
+// MaxWindowSize tokens.  If that is not enough because cursors have diverged
+// more than that, advancing the leading cursor panics with a *TransformError.
+// It does not block until the other cursors catch up: all the cursors of a
+// pool are read from the same goroutine, so waiting would deadlock.  The limit
+// bounds the memory used, it does not slow down the leading cursor.
 type CursorPool struct {
+	MaxWindowSize int
+
 	stream       ReadStream
 	window       []Token
 	windowPos    int
@@ -47,6 +62,7 @@ func (p *CursorPool) advanceWindow() {
 		// There are no valid cursors - it is safe to reset the window
 		p.windowPos += len(p.window)
 		p.window = nil
+		return
 	}
 	shiftRight := minPos - p.windowPos
 	if shiftRight < 0 {
@@ -112,8 +128,12 @@ func (p *CursorPool) DetachCursor(c *Cursor) {
 	c.pool = nil
 }
 
+// WindowSize returns the number of tokens currently kept in the window.
+func (p *CursorPool) WindowSize() int {
+	return len(p.window)
+}
+
 func (p *CursorPool) AdvanceCursor(c *Cursor) Token {
-	// TODO: optimize for when there is 1 cursor and empty window
 	i := c.position - p.windowPos
 	if i < len(p.window) {
 		c.position++
@@ -123,12 +143,26 @@ func (p *CursorPool) AdvanceCursor(c *Cursor) Token {
 	if i > len(p.window) {
 		panic("logic error")
 	}
+	if p.MaxWindowSize > 0 && len(p.window) >= p.MaxWindowSize {
+		p.catchupCount = 0
+		p.advanceWindow()
+		if len(p.window) >= p.MaxWindowSize {
+			panic(TransformErrorf("cursors diverge by more than the max window size of %d tokens", p.MaxWindowSize))
+		}
+	}
 	tok := p.stream.Next()
-	if tok != nil {
-		c.position++
-		p.window = append(p.window, tok)
-	} else {
+	if tok == nil {
 		p.DetachCursor(c)
+		return nil
+	}
+	c.position++
+	if len(p.cursors) == 1 {
+		// No other cursor will need the token, so there is no need to keep it
+		// (nor the tokens before it, which c has already read).
+		p.windowPos = c.position
+		p.window = p.window[:0]
+	} else {
+		p.window = append(p.window, tok)
 	}
 	return tok
 }
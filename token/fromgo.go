@@ -0,0 +1,78 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// FromGo returns the tokens of the JSON value which encodes the Go value v.  See
+// WriteValue for how v is encoded.
+func FromGo(v any) ([]Token, error) {
+	acc := NewAccumulatorStream()
+	if err := WriteValue(acc, v); err != nil {
+		return nil, err
+	}
+	return acc.GetTokens(), nil
+}
+
+// WriteValue writes the tokens of the JSON value which encodes the Go value v
+// to out.  This is useful to inject synthesized values into a stream, or to
+// make test fixtures.
+//
+// The value is encoded as by json.Marshal, so struct field tags, omitempty and
+// the json.Marshaler interface are all supported, and numbers are written with
+// the same representation.  If v cannot be encoded, the error is returned and
+// nothing is written.
+func WriteValue(out WriteStream, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	// The objects and arrays being written, and for objects whether a key is
+	// expected next.
+	type container struct{ isObject, keyNext bool }
+	var stack []container
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			// The input is valid JSON, so this is the end of it.
+			return nil
+		}
+		isKey := false
+		if n := len(stack); n > 0 && stack[n-1].isObject {
+			isKey = stack[n-1].keyNext
+			stack[n-1].keyNext = !isKey
+		}
+		switch x := tok.(type) {
+		case json.Delim:
+			switch x {
+			case '{':
+				out.Put(&StartObject{})
+				stack = append(stack, container{isObject: true, keyNext: true})
+			case '[':
+				out.Put(&StartArray{})
+				stack = append(stack, container{})
+			case '}':
+				out.Put(&EndObject{})
+				stack = stack[:len(stack)-1]
+			case ']':
+				out.Put(&EndArray{})
+				stack = stack[:len(stack)-1]
+			}
+		case string:
+			s := StringScalar(x)
+			if isKey {
+				s.TypeAndFlags |= KeyMask
+			}
+			out.Put(s)
+		case json.Number:
+			out.Put(NewScalar(Number, []byte(x)))
+		case bool:
+			out.Put(BoolScalar(x))
+		case nil:
+			out.Put(NullScalar)
+		}
+	}
+}
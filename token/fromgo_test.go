@@ -0,0 +1,83 @@
+package token_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+type point struct {
+	X, Y  int
+	Label string `json:"label,omitempty"`
+	Skip  bool   `json:"-"`
+}
+
+type celsius float64
+
+func (c celsius) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]float64{"celsius": float64(c)})
+}
+
+func TestFromGo(t *testing.T) {
+	type testCase struct {
+		name   string
+		value  any
+		output string
+		err    bool
+	}
+	var testCases = []testCase{
+		{
+			name:   "scalars",
+			value:  []any{nil, true, 12, 1.5, "a\"b", json.Number("1e100")},
+			output: `StartArray Scalar(null) Scalar(true) Scalar(12) Scalar(1.5) Scalar("a\"b") Scalar(1e100) EndArray`,
+		},
+		{
+			name:   "map",
+			value:  map[string]any{"b": []int{}, "a": map[string]string{"k": "v"}},
+			output: `StartObject Key("a") StartObject Key("k") Scalar("v") EndObject Key("b") StartArray EndArray EndObject`,
+		},
+		{
+			name:   "struct",
+			value:  []point{{X: 1, Y: 2}, {Label: "origin"}},
+			output: `StartArray StartObject Key("X") Scalar(1) Key("Y") Scalar(2) EndObject StartObject Key("X") Scalar(0) Key("Y") Scalar(0) Key("label") Scalar("origin") EndObject EndArray`,
+		},
+		{
+			name:   "marshalers",
+			value:  map[string]any{"t": time.Unix(0, 0).UTC(), "c": celsius(20)},
+			output: `StartObject Key("c") StartObject Key("celsius") Scalar(20) EndObject Key("t") Scalar("1970-01-01T00:00:00Z") EndObject`,
+		},
+		{
+			name:  "error",
+			value: map[string]any{"f": func() {}},
+			err:   true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			toks, err := token.FromGo(tc.value)
+			if tc.err {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			var strs []string
+			for _, tok := range toks {
+				if s, ok := tok.(*token.Scalar); ok && s.IsKey() {
+					strs = append(strs, "Key("+string(s.Bytes)+")")
+				} else {
+					strs = append(strs, tok.String())
+				}
+			}
+			if got := strings.Join(strs, " "); got != tc.output {
+				t.Fatalf("Expected %s, got %s", tc.output, got)
+			}
+		})
+	}
+}
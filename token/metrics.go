@@ -0,0 +1,209 @@
+package token
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Metrics collects statistics about the stages of a pipeline, to help find out
+// which stages are slow or produce a lot of data.  Wrap the source, transformers
+// and sink of the pipeline with the Source, Transformer and Sink methods, then
+// call Stages or WriteSummary once the stream is consumed.
+//
+// Stages are identified by name, so wrapping several transformers with the
+// same name (e.g. when the same transforms are applied to several inputs) adds
+// up their statistics.  A Metrics value is safe for concurrent use.
+type Metrics struct {
+	mu     sync.Mutex
+	stages []*stageCounters
+}
+
+// StageMetrics contains the statistics collected about a stage of a pipeline.
+type StageMetrics struct {
+	Name string
+
+	// Number of tokens the stage output (or consumed for a sink), and total
+	// size of the scalars among them (this does not include the punctuation
+	// that encoders add).
+	Tokens int64
+	Bytes  int64
+
+	// Time is the wall time the stage ran for, and Wait the part of it the
+	// stage spent waiting for its input or for the next stage to take its
+	// output.  The difference is the time the stage was busy.  These are summed
+	// over goroutines, so they can exceed the duration of the pipeline when a
+	// transformer is applied to values in parallel.
+	Time time.Duration
+	Wait time.Duration
+}
+
+// Busy returns the time the stage spent doing work rather than waiting.
+func (m StageMetrics) Busy() time.Duration {
+	if m.Wait > m.Time {
+		return 0
+	}
+	return m.Time - m.Wait
+}
+
+type stageCounters struct {
+	name   string
+	tokens atomic.Int64
+	bytes  atomic.Int64
+	time   atomic.Int64
+	wait   atomic.Int64
+}
+
+// stage returns the counters of the stage with the given name, creating it if
+// needed.
+func (m *Metrics) stage(name string) *stageCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.stages {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &stageCounters{name: name}
+	m.stages = append(m.stages, s)
+	return s
+}
+
+// count records that tok was output by the stage.
+func (s *stageCounters) count(tok Token) {
+	s.tokens.Add(1)
+	if scalar, ok := tok.(*Scalar); ok {
+		s.bytes.Add(int64(len(scalar.Bytes)))
+	}
+}
+
+// Stages returns the statistics collected so far for each stage, in the order
+// the stages were first wrapped.
+func (m *Metrics) Stages() []StageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stages := make([]StageMetrics, len(m.stages))
+	for i, s := range m.stages {
+		stages[i] = StageMetrics{
+			Name:   s.name,
+			Tokens: s.tokens.Load(),
+			Bytes:  s.bytes.Load(),
+			Time:   time.Duration(s.time.Load()),
+			Wait:   time.Duration(s.wait.Load()),
+		}
+	}
+	return stages
+}
+
+// WriteSummary writes a table of the statistics of each stage to w.
+func (m *Metrics) WriteSummary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "tokens\tbytes\ttime\tbusy\t\tstage")
+	for _, s := range m.Stages() {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t\t%s\n", s.Tokens, s.Bytes, s.Time.Round(time.Microsecond), s.Busy().Round(time.Microsecond), s.Name)
+	}
+	return tw.Flush()
+}
+
+// Source returns a StreamSource which produces the same stream as source,
+// recording statistics in the stage with the given name.
+func (m *Metrics) Source(name string, source StreamSource) StreamSource {
+	return &metricsSource{stage: m.stage(name), source: source}
+}
+
+// Transformer returns a StreamTransformer which transforms streams like
+// transformer, recording statistics in the stage with the given name.
+func (m *Metrics) Transformer(name string, transformer StreamTransformer) StreamTransformer {
+	return &metricsTransformer{stage: m.stage(name), transformer: transformer}
+}
+
+// Sink returns a StreamSink which consumes streams like sink, recording
+// statistics in the stage with the given name.
+func (m *Metrics) Sink(name string, sink StreamSink) StreamSink {
+	return &metricsSink{stage: m.stage(name), sink: sink}
+}
+
+type metricsSource struct {
+	stage  *stageCounters
+	source StreamSource
+}
+
+func (s *metricsSource) Produce(out chan<- Token) error {
+	start := time.Now()
+	defer func() { s.stage.time.Add(int64(time.Since(start))) }()
+	produced := make(chan Token, streamBufferSize)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w := metricsWriteStream{stage: s.stage, out: ChannelWriteStream(out)}
+		for tok := range produced {
+			w.Put(tok)
+		}
+	}()
+	err := s.source.Produce(produced)
+	close(produced)
+	<-done
+	return err
+}
+
+type metricsTransformer struct {
+	stage       *stageCounters
+	transformer StreamTransformer
+}
+
+func (t *metricsTransformer) Transform(in <-chan Token, out WriteStream) {
+	start := time.Now()
+	defer func() { t.stage.time.Add(int64(time.Since(start))) }()
+	t.transformer.Transform(waitingInput(t.stage, in, false), metricsWriteStream{stage: t.stage, out: out})
+}
+
+type metricsSink struct {
+	stage *stageCounters
+	sink  StreamSink
+}
+
+func (s *metricsSink) Consume(in <-chan Token) error {
+	start := time.Now()
+	defer func() { s.stage.time.Add(int64(time.Since(start))) }()
+	return s.sink.Consume(waitingInput(s.stage, in, true))
+}
+
+// waitingInput returns a channel with the same tokens as in, adding the time
+// spent waiting for them to the wait time of stage.  If count is true, the
+// tokens are also counted in stage.
+func waitingInput(stage *stageCounters, in <-chan Token, count bool) <-chan Token {
+	out := make(chan Token, streamBufferSize)
+	go func() {
+		defer close(out)
+		for {
+			start := time.Now()
+			tok, ok := <-in
+			stage.wait.Add(int64(time.Since(start)))
+			if !ok {
+				return
+			}
+			if count {
+				stage.count(tok)
+			}
+			out <- tok
+		}
+	}()
+	return out
+}
+
+// metricsWriteStream counts the tokens put to out, adding the time spent
+// waiting for out to take them to the wait time of stage.
+type metricsWriteStream struct {
+	stage *stageCounters
+	out   WriteStream
+}
+
+func (w metricsWriteStream) Put(tok Token) {
+	w.stage.count(tok)
+	start := time.Now()
+	w.out.Put(tok)
+	w.stage.wait.Add(int64(time.Since(start)))
+}
@@ -0,0 +1,71 @@
+package token_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// scalarsOnly is a transformer which outputs the scalars of its input.
+type scalarsOnly struct{}
+
+func (scalarsOnly) Transform(in <-chan token.Token, out token.WriteStream) {
+	for tok := range in {
+		if s, ok := tok.(*token.Scalar); ok && !s.IsKey() {
+			out.Put(tok)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var metrics token.Metrics
+	var count int
+	run := func(input string) {
+		source := metrics.Source("input", jsonstream.NewJSONDecoder(strings.NewReader(input)))
+		stream := token.StartStream(source, nil)
+		stream = token.TransformStream(stream, metrics.Transformer("scalars", scalarsOnly{}))
+		if err := token.ConsumeStream(stream, metrics.Sink("output", countingSink{&count})); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	run(`[1, "ab"] {"cd": true}`)
+
+	// Stages with the same name add up
+	run(`123`)
+
+	if count != 4 {
+		t.Fatalf("Expected 4 tokens in output, got %d", count)
+	}
+	type stage struct {
+		name          string
+		tokens, bytes int64
+	}
+	expected := []stage{
+		{"input", 9, 16},
+		{"scalars", 4, 12},
+		{"output", 4, 12},
+	}
+	stages := metrics.Stages()
+	if len(stages) != len(expected) {
+		t.Fatalf("Expected %d stages, got %d", len(expected), len(stages))
+	}
+	for i, s := range stages {
+		if got := (stage{s.Name, s.Tokens, s.Bytes}); got != expected[i] {
+			t.Errorf("Expected stage %d to be %v, got %v", i, expected[i], got)
+		}
+		if s.Time <= 0 || s.Busy() > s.Time {
+			t.Errorf("Invalid times for stage %q: time %s, busy %s", s.Name, s.Time, s.Busy())
+		}
+	}
+
+	var b strings.Builder
+	if err := metrics.WriteSummary(&b); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[0], "stage") || !strings.HasSuffix(lines[2], "  scalars") {
+		t.Fatalf("Unexpected summary:\n%s", b.String())
+	}
+}
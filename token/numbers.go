@@ -0,0 +1,115 @@
+package token
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Number scalars keep the bytes of their literal, so numbers too large or too
+// precise for an int64 or a float64 go through a pipeline unchanged.  The
+// functions in this file make sure that comparing them does not lose precision
+// either.
+
+// ToBigNumber returns the value of a number scalar as a *big.Float, or false
+// if s is not a number or is NaN.  Integers are represented exactly, and other
+// numbers with at least 4 bits of precision per byte of their literal, which
+// is more than their decimal digits need.
+func (s *Scalar) ToBigNumber() (*big.Float, bool) {
+	if s.Type() != Number {
+		return nil, false
+	}
+	return parseBigNumber(s.Bytes, bigNumberPrec(len(s.Bytes)))
+}
+
+// CompareNumbers compares the number scalars a and b, returning -1 if a < b, 0
+// if a == b and +1 if a > b.  Numbers are compared exactly whatever their size
+// or precision: float64 values are used when it is safe to, and math/big
+// otherwise.  NaN is considered less than any other number and equal to
+// itself.
+func CompareNumbers(a, b *Scalar) int {
+	x, y := a.Bytes, b.Bytes
+	if isShortDecimal(x) && isShortDecimal(y) {
+		xf, _ := strconv.ParseFloat(string(x), 64)
+		yf, _ := strconv.ParseFloat(string(y), 64)
+		return compareFloats(xf, yf)
+	}
+	if xi, err := strconv.ParseInt(string(x), 10, 64); err == nil {
+		if yi, err := strconv.ParseInt(string(y), 10, 64); err == nil {
+			switch {
+			case xi < yi:
+				return -1
+			case xi > yi:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	n := len(x)
+	if len(y) > n {
+		n = len(y)
+	}
+	prec := bigNumberPrec(n)
+	xb, xok := parseBigNumber(x, prec)
+	yb, yok := parseBigNumber(y, prec)
+	if xok && yok {
+		return xb.Cmp(yb)
+	}
+	// At least one of them is NaN (or an invalid literal)
+	xf, _ := strconv.ParseFloat(string(x), 64)
+	yf, _ := strconv.ParseFloat(string(y), 64)
+	return compareFloats(xf, yf)
+}
+
+// isShortDecimal returns true if the number literal b has no exponent and at
+// most 15 digits, in which case converting it to a float64 keeps it distinct
+// from other such literals with a different value (a float64 has 15
+// significant decimal digits).  Non-finite literals also qualify.
+func isShortDecimal(b []byte) bool {
+	if len(b) > 15 {
+		return false
+	}
+	for _, c := range b {
+		if c == 'e' || c == 'E' {
+			return false
+		}
+	}
+	return true
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	case x == y:
+		return 0
+	case math.IsNaN(x):
+		if math.IsNaN(y) {
+			return 0
+		}
+		return -1
+	default:
+		return 1
+	}
+}
+
+// bigNumberPrec returns the precision to use to parse number literals of n
+// bytes.  It takes about 3.32 bits to represent a decimal digit, so 4 bits per
+// byte represents integers exactly.
+func bigNumberPrec(n int) uint {
+	return uint(4*n + 64)
+}
+
+func parseBigNumber(b []byte, prec uint) (*big.Float, bool) {
+	if x, ok := parseNonFinite(b); ok {
+		if math.IsNaN(x) {
+			return nil, false
+		}
+		return new(big.Float).SetInf(x < 0), true
+	}
+	x, _, err := big.ParseFloat(string(b), 10, prec, big.ToNearestEven)
+	return x, err == nil
+}
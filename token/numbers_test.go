@@ -0,0 +1,91 @@
+package token
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompareNumbers(t *testing.T) {
+	type testCase struct {
+		name string
+		a, b string
+		cmp  int
+	}
+	var testCases = []testCase{
+		{name: "small integers", a: "2", b: "10", cmp: -1},
+		{name: "same value", a: "1.50", b: "15e-1", cmp: 0},
+		{name: "negative zero", a: "-0", b: "0", cmp: 0},
+		{name: "int64 integers", a: "9007199254740993", b: "9007199254740992", cmp: 1},
+		{name: "big integers", a: "-123456789012345678901234567890", b: "-123456789012345678901234567891", cmp: 1},
+		{name: "big integer and float", a: "123456789012345678901234567890", b: "1.2345678901234567890123456789e29", cmp: 0},
+		{name: "precise decimals", a: "0.1", b: "0.10000000000000000000000000001", cmp: -1},
+		{name: "out of float64 range", a: "1e400", b: "1e401", cmp: -1},
+		{name: "infinity", a: "1e400", b: "Infinity", cmp: -1},
+		{name: "negative infinity", a: "-Infinity", b: "-1e400", cmp: -1},
+		{name: "NaN", a: "NaN", b: "-Infinity", cmp: -1},
+		{name: "NaNs", a: "NaN", b: "NaN", cmp: 0},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			a := NewScalar(Number, []byte(c.a))
+			b := NewScalar(Number, []byte(c.b))
+			if got := CompareNumbers(a, b); got != c.cmp {
+				t.Fatalf("Expected %d, got %d", c.cmp, got)
+			}
+			if got := CompareNumbers(b, a); got != -c.cmp {
+				t.Fatalf("Expected %d in reverse, got %d", -c.cmp, got)
+			}
+			if equal := a.Equal(b); equal != (c.cmp == 0) {
+				t.Fatalf("Expected Equal to return %t", c.cmp == 0)
+			}
+		})
+	}
+}
+
+func TestScalarToBigNumber(t *testing.T) {
+	type testCase struct {
+		name   string
+		scalar *Scalar
+		text   string
+		ok     bool
+	}
+	var testCases = []testCase{
+		{
+			name:   "big integer",
+			scalar: NewScalar(Number, []byte("123456789012345678901234567890")),
+			text:   "123456789012345678901234567890",
+			ok:     true,
+		},
+		{
+			name:   "decimal",
+			scalar: NewScalar(Number, []byte("-2.5e-3")),
+			text:   "-0.0025",
+			ok:     true,
+		},
+		{
+			name:   "infinity",
+			scalar: Float64Scalar(math.Inf(1)),
+			text:   "+Inf",
+			ok:     true,
+		},
+		{
+			name:   "NaN",
+			scalar: Float64Scalar(math.NaN()),
+		},
+		{
+			name:   "string",
+			scalar: StringScalar("12"),
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			x, ok := c.scalar.ToBigNumber()
+			if ok != c.ok {
+				t.Fatalf("Expected ok to be %t", c.ok)
+			}
+			if ok && x.Text('f', -1) != c.text {
+				t.Fatalf("Expected %s, got %s", c.text, x.Text('f', -1))
+			}
+		})
+	}
+}
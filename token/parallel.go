@@ -0,0 +1,150 @@
+package token
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelTransformStream is like TransformStreamWithErrorHandler but it
+// splits the incoming json stream into its top-level values and applies the
+// transformer to each value separately, using the given number of worker
+// goroutines.  This speeds up CPU bound transforms of streams with many
+// values (e.g. JSON Lines input).
+//
+// The transformer is called once for each value, from several goroutines
+// concurrently, so it must be safe for concurrent use and must not keep state
+// across values (e.g. JSONPath queries are fine but sorting is not).
+//
+// If preserveOrder is true, the output for each value is written in the order
+// of the input values.  Otherwise it is written as soon as it is available,
+// which uses less memory when the time taken to transform values varies a lot.
+// In both cases the output for a value is never interleaved with the output
+// for another one.
+//
+// If the transformer fails with a *TransformError on a value, the output for
+// that value is discarded, no more values are transformed, and handleError is
+// called with the error before the returned stream is closed (or the error is
+// returned by ConsumeStream if handleError is nil).  If preserveOrder
+// is true, the output for the values which come after the failed value is
+// discarded too, as if the values had been transformed sequentially.
+func ParallelTransformStream(in <-chan Token, transformer StreamTransformer, workers int, preserveOrder bool, handleError func(error)) <-chan Token {
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan Token, streamBufferSize)
+	w := ChannelWriteStream(out)
+	go func() {
+		defer close(out)
+		var (
+			wg      sync.WaitGroup
+			writeMx sync.Mutex
+			failed  atomic.Bool
+
+			// The first failed value and its error (values before it are
+			// still transformed)
+			errMx    sync.Mutex
+			errTag   uint64 = math.MaxUint64
+			firstErr error
+		)
+		skip := func(tag uint64) bool {
+			errMx.Lock()
+			defer errMx.Unlock()
+			return tag > errTag
+		}
+		sink := NewSerializingSink(w)
+		jobs := make(chan parallelJob, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for job := range jobs {
+					if skip(job.tag) {
+						continue
+					}
+					toks, err := transformValueTokens(transformer, job.toks)
+					if err != nil {
+						errMx.Lock()
+						if job.tag < errTag {
+							errTag, firstErr = job.tag, err
+						}
+						errMx.Unlock()
+						failed.Store(true)
+						continue
+					}
+					if preserveOrder {
+						sink.PutTokens(job.tag, toks)
+					} else {
+						writeMx.Lock()
+						for _, tok := range toks {
+							w.Put(tok)
+						}
+						writeMx.Unlock()
+					}
+				}
+			}()
+		}
+		var (
+			tag       uint64
+			depth     int
+			toks      []Token
+			streamErr *StreamError
+		)
+		for tok := range in {
+			if e, ok := tok.(*StreamError); ok {
+				streamErr = e
+				continue
+			}
+			if failed.Load() {
+				// Drain the input so the previous stages are not blocked.
+				continue
+			}
+			toks = append(toks, tok)
+			switch tok.(type) {
+			case *StartObject, *StartArray:
+				depth++
+			case *EndObject, *EndArray:
+				depth--
+			}
+			if depth == 0 {
+				jobs <- parallelJob{tag: tag, toks: toks}
+				tag++
+				toks = nil
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		// An error which cut the input short comes first, as it may be why
+		// the transformer failed.
+		if streamErr != nil {
+			out <- streamErr
+			if firstErr != nil && handleError != nil {
+				handleError(firstErr)
+			}
+		} else if firstErr != nil {
+			reportError(out, handleError, firstErr)
+		}
+	}()
+	return out
+}
+
+type parallelJob struct {
+	tag  uint64
+	toks []Token
+}
+
+// transformValueTokens applies transformer to the tokens of a value and
+// returns the tokens it outputs, or the error it fails with.
+func transformValueTokens(transformer StreamTransformer, toks []Token) (result []Token, err error) {
+	// The channel is buffered so that nothing blocks if the transformer fails
+	// before consuming all its input.
+	in := make(chan Token, len(toks))
+	for _, tok := range toks {
+		in <- tok
+	}
+	close(in)
+	out := NewAccumulatorStream()
+	defer CatchTransformError(&err)
+	transformer.Transform(in, out)
+	return out.GetTokens(), nil
+}
@@ -0,0 +1,86 @@
+package token_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// wrappingTransformer wraps each value in an array.  It fails on the string
+// "bad".
+type wrappingTransformer struct{}
+
+func (wrappingTransformer) Transform(in <-chan token.Token, out token.WriteStream) {
+	depth := 0
+	for tok := range in {
+		if depth == 0 {
+			if scalar, ok := tok.(*token.Scalar); ok && scalar.EqualsString("bad") {
+				panic(token.TransformErrorf("bad value"))
+			}
+			out.Put(&token.StartArray{})
+		}
+		out.Put(tok)
+		switch tok.(type) {
+		case *token.StartArray, *token.StartObject:
+			depth++
+		case *token.EndArray, *token.EndObject:
+			depth--
+		}
+		if depth == 0 {
+			out.Put(&token.EndArray{})
+		}
+	}
+}
+
+func encodeLines(t *testing.T, stream <-chan token.Token) []string {
+	var b strings.Builder
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	if err := token.ConsumeStream(stream, encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+func TestParallelTransformStream(t *testing.T) {
+	var input strings.Builder
+	var expected []string
+	for i := 0; i < 200; i++ {
+		input.WriteString(`{"a": [1, {"b": 2}]} 3 `)
+		expected = append(expected, `[{"a": [1,{"b": 2}]}]`, "[3]")
+	}
+	for _, preserveOrder := range []bool{true, false} {
+		var err error
+		stream := token.ParallelTransformStream(streamJSONString(input.String()), wrappingTransformer{}, 4, preserveOrder, func(e error) { err = e })
+		got := encodeLines(t, stream)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if !preserveOrder {
+			sort.Strings(got)
+			sort.Strings(expected)
+		}
+		if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+			t.Fatalf("preserveOrder=%t: unexpected output %q", preserveOrder, got)
+		}
+	}
+}
+
+func TestParallelTransformStreamError(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 100; i++ {
+		input.WriteString(`1 `)
+	}
+	input.WriteString(`"bad" 2 3`)
+	var err error
+	stream := token.ParallelTransformStream(streamJSONString(input.String()), wrappingTransformer{}, 4, true, func(e error) { err = e })
+	got := encodeLines(t, stream)
+	if err == nil || err.Error() != "bad value" {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(got) != 100 || got[99] != "[1]" {
+		t.Fatalf("Expected the 100 values before the error, got %d: %q", len(got), got)
+	}
+}
@@ -0,0 +1,55 @@
+package token
+
+import "fmt"
+
+// A ParseError is returned by decoders when their input is invalid.  It records
+// where the problem is so that it can be located in large inputs.
+type ParseError struct {
+	Format string // Name of the input format, or "" for JSON
+
+	// Line and Col give the position of the error (starting from 1), when the
+	// input format has lines.  Col is 0 if only the line is known.
+	Line, Col int
+
+	Offset int64 // Position of the error in bytes (starting from 0)
+
+	// Context is the input around the error on the same line (if available),
+	// and ContextPos is the index in Context where the error is.
+	Context    string
+	ContextPos int
+
+	Msg string // What the error is
+	Err error  // The underlying error, if any
+}
+
+func (e *ParseError) Error() string {
+	var prefix string
+	if e.Format != "" {
+		prefix = e.Format + ": "
+	}
+	switch {
+	case e.Col > 0:
+		return fmt.Sprintf("%ssyntax error at L%d,C%d: %s", prefix, e.Line, e.Col, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%ssyntax error at L%d: %s", prefix, e.Line, e.Msg)
+	default:
+		return prefix + e.Msg
+	}
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Location describes where the error is, e.g. "line 1042, col 17" or "byte
+// 1234" for formats without lines.
+func (e *ParseError) Location() string {
+	switch {
+	case e.Col > 0:
+		return fmt.Sprintf("line %d, col %d", e.Line, e.Col)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d", e.Line)
+	default:
+		return fmt.Sprintf("byte %d", e.Offset)
+	}
+}
@@ -1,24 +1,88 @@
 package token
 
+import (
+	"fmt"
+	"sync/atomic"
+)
+
 // A StreamTransformer can transform a json stream into another.
 // Use the TransformStream function to apply it.
+//
+// If its input ends with a StreamError, the transformer should end its output
+// with it (see StreamErrorReader).
+//
+// A transformer which cannot process its input should panic with a
+// *TransformError, which can be handled with TransformStreamWithErrorHandler.
 type StreamTransformer interface {
 	Transform(in <-chan Token, out WriteStream)
 }
 
+// A TransformError contains an error that prevented a StreamTransformer from
+// processing its input.
+type TransformError struct {
+	Err error
+}
+
+func (e *TransformError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// TransformErrorf returns a *TransformError with the formatted message,
+// ready to be passed to panic().
+func TransformErrorf(format string, args ...any) *TransformError {
+	return &TransformError{Err: fmt.Errorf(format, args...)}
+}
+
+// CatchTransformError can be used to capture panics caused by a transformer
+// failing to process its input.  Use as
+//
+//	defer CatchTransformError(&err)
+func CatchTransformError(err *error) {
+	if r := recover(); r != nil {
+		terr, ok := r.(*TransformError)
+		if ok {
+			*err = terr
+		} else {
+			panic(r)
+		}
+	}
+}
+
 type StreamSource interface {
 	Produce(chan<- Token) error
 }
 
+// A TokenSource produces a json stream one token at a time, on demand.  Unlike
+// a StreamSource it does not need to run in its own goroutine, so it can be
+// used from synchronous code or another event loop.  The JSON, CSV and JPV
+// decoders in the jsonstream package are both StreamSources and TokenSources,
+// but a decoder should only be used as one or the other.
+type TokenSource interface {
+	// NextToken returns the next token in the stream.  It returns io.EOF when
+	// the stream is finished, and keeps returning the same error after an
+	// error.
+	NextToken() (Token, error)
+}
+
 type StreamSink interface {
 	Consume(<-chan Token) error
 }
 
+// streamBufferSize is the capacity of the channels between the stages of a
+// pipeline.  Tokens are small and there are many of them, so letting a stage
+// write a batch of tokens before the next stage needs to run saves most of the
+// cost of passing them through channels.
+const streamBufferSize = 64
+
 // TransformStream applies the transformer to the incoming json stream,
 // returning a new json stream.  This is always fast because the
 // transformer is computed in a goroutine.
 func TransformStream(in <-chan Token, transformer StreamTransformer) <-chan Token {
-	out := make(chan Token)
+	out := make(chan Token, streamBufferSize)
 	w := ChannelWriteStream(out)
 	go func() {
 		defer close(out)
@@ -27,23 +91,108 @@ func TransformStream(in <-chan Token, transformer StreamTransformer) <-chan Toke
 	return out
 }
 
+// TransformStreamWithErrorHandler is like TransformStream but if the
+// transformer fails with a *TransformError, the returned stream is closed and
+// handleError is called with the error.  If handleError is nil, the error is
+// returned by ConsumeStream at the end of the pipeline instead.
+func TransformStreamWithErrorHandler(in <-chan Token, transformer StreamTransformer, handleError func(error)) <-chan Token {
+	out := make(chan Token, streamBufferSize)
+	w := ChannelWriteStream(out)
+	go func() {
+		defer close(out)
+		var err error
+		func() {
+			defer CatchTransformError(&err)
+			transformer.Transform(in, w)
+		}()
+		if err != nil {
+			reportError(out, handleError, err)
+		}
+	}()
+	return out
+}
+
 // StartStream uses the source to start producing items and returns a new json
 // stream where these items are produced.  This is always fast because the
 // source is computed in a goroutine.
 //
-// As a source can produce errors, a handleError function can be provided.
+// If the source fails, the stream is closed (possibly in the middle of a value,
+// which iterators treat as elided) and handleError is called with the error.
+// If handleError is nil, the error is returned by ConsumeStream at the end of
+// the pipeline instead.
 func StartStream(source StreamSource, handleError func(error)) <-chan Token {
-	out := make(chan Token)
+	out := make(chan Token, streamBufferSize)
 	go func() {
 		defer close(out)
-		err := source.Produce(out)
-		if err != nil && handleError != nil {
-			handleError(err)
+		if err := source.Produce(out); err != nil {
+			reportError(out, handleError, err)
 		}
 	}()
 	return out
 }
 
+// ConsumeStream consumes the stream with the sink and returns its error.  The
+// sink also returns the error which cut the stream short if it was not given
+// to an error handler (see StartStream, TransformStreamWithErrorHandler and
+// StreamError).  If the sink returns before the end of the stream, the rest of
+// it is drained in the background so that the stages producing it are not
+// blocked.
 func ConsumeStream(in <-chan Token, sink StreamSink) error {
-	return sink.Consume(in)
+	err := sink.Consume(in)
+	go drain(in)
+	return err
+}
+
+// MultiSink returns a StreamSink which duplicates the stream it consumes to
+// all the given sinks.  Each sink consumes its stream in its own goroutine.
+// Tokens are not copied as they are never mutated by sinks.
+//
+// If any of the sinks returns an error, the stream stops being forwarded (the
+// rest of it is drained in the background) and Consume returns the first such
+// error once all the sinks have returned.
+func MultiSink(sinks ...StreamSink) StreamSink {
+	return multiSink(sinks)
+}
+
+type multiSink []StreamSink
+
+func (s multiSink) Consume(in <-chan Token) error {
+	chans := make([]chan Token, len(s))
+	errs := make(chan error, len(s))
+	var failed atomic.Bool
+	for i, sink := range s {
+		ch := make(chan Token, streamBufferSize)
+		chans[i] = ch
+		go func(sink StreamSink) {
+			err := sink.Consume(ch)
+			if err != nil {
+				failed.Store(true)
+			}
+			errs <- err
+
+			// Drain the channel so that the other sinks are not blocked.
+			for range ch {
+			}
+		}(sink)
+	}
+	for tok := range in {
+		if failed.Load() {
+			break
+		}
+		for _, ch := range chans {
+			ch <- tok
+		}
+	}
+	for _, ch := range chans {
+		close(ch)
+	}
+	// Drain the input so that the stage feeding it is not blocked.
+	go drain(in)
+	var firstErr error
+	for range s {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -0,0 +1,250 @@
+package token_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func streamJSONString(s string) <-chan token.Token {
+	return token.StartStream(jsonstream.NewJSONDecoder(strings.NewReader(s)), nil)
+}
+
+func TestMultiSink(t *testing.T) {
+	var pretty, compact strings.Builder
+	sink := token.MultiSink(
+		&jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &pretty, IndentSize: 2}},
+		&jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &compact, IndentSize: -1}},
+	)
+	err := token.ConsumeStream(streamJSONString(`{"a": [1, 2]} 3`), sink)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectedPretty := "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n3\n"
+	if pretty.String() != expectedPretty {
+		t.Errorf("Expected %q, got %q", expectedPretty, pretty.String())
+	}
+	expectedCompact := "{\"a\": [1,2]}\n3\n"
+	if compact.String() != expectedCompact {
+		t.Errorf("Expected %q, got %q", expectedCompact, compact.String())
+	}
+}
+
+type failingSink struct {
+	err error
+}
+
+func (s failingSink) Consume(in <-chan token.Token) error {
+	<-in
+	return s.err
+}
+
+type countingSink struct {
+	count *int
+}
+
+func (s countingSink) Consume(in <-chan token.Token) error {
+	for range in {
+		*s.count++
+	}
+	return nil
+}
+
+func TestMultiSinkError(t *testing.T) {
+	sinkErr := errors.New("sink error")
+	var count int
+	sink := token.MultiSink(countingSink{&count}, failingSink{sinkErr})
+	input := strings.Repeat("[1, 2, 3] ", 100)
+	err := token.ConsumeStream(streamJSONString(input), sink)
+	if !errors.Is(err, sinkErr) {
+		t.Fatalf("Expected sink error, got %v", err)
+	}
+	if count >= 500 {
+		t.Fatalf("Expected stream to be aborted, but %d tokens were forwarded", count)
+	}
+}
+
+// The input of a MultiSink is still read to the end after a sink fails, so
+// that the goroutine producing it is not blocked.
+func TestMultiSinkErrorDrainsInput(t *testing.T) {
+	in := make(chan token.Token)
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			in <- token.Int64Scalar(int64(i))
+		}
+	}()
+	sink := token.MultiSink(failingSink{errors.New("sink error")})
+	if err := sink.Consume(in); err == nil {
+		t.Fatal("Expected an error")
+	}
+	select {
+	case <-fed:
+	case <-time.After(time.Second):
+		t.Fatal("The input is not drained")
+	}
+}
+
+func TestSerializingSink(t *testing.T) {
+	acc := token.NewAccumulatorStream()
+	sink := token.NewSerializingSink(acc)
+	value := func(n int64) []token.Token {
+		return []token.Token{&token.StartArray{}, token.Int64Scalar(n), &token.EndArray{}}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	// One goroutine emits the even tags in order, the other emits the odd
+	// tags in reverse order so that they arrive out of order at the sink.
+	go func() {
+		defer wg.Done()
+		for tag := uint64(0); tag < 10; tag += 2 {
+			sink.PutTokens(tag, value(int64(tag)))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for tag := uint64(9); tag < 10; tag -= 2 {
+			if tag == 5 {
+				// A tag can produce no values
+				sink.PutTokens(tag, nil)
+			} else {
+				sink.PutTokens(tag, value(int64(tag)))
+			}
+		}
+	}()
+	wg.Wait()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	var b strings.Builder
+	encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+	if err := token.ConsumeStream(sliceStream(acc.GetTokens()), encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "[0]\n[1]\n[2]\n[3]\n[4]\n[6]\n[7]\n[8]\n[9]\n"
+	if b.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestSerializingSinkMissingTag(t *testing.T) {
+	sink := token.NewSerializingSink(token.NewAccumulatorStream())
+	sink.PutTokens(0, []token.Token{token.TrueScalar})
+	sink.PutTokens(2, []token.Token{token.FalseScalar})
+	if err := sink.Close(); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func sliceStream(toks []token.Token) <-chan token.Token {
+	ch := make(chan token.Token)
+	go func() {
+		defer close(ch)
+		for _, tok := range toks {
+			ch <- tok
+		}
+	}()
+	return ch
+}
+
+// failingTransformer passes on its input until it sees the scalar 0.
+type failingTransformer struct{}
+
+func (failingTransformer) Transform(in <-chan token.Token, out token.WriteStream) {
+	for tok := range in {
+		if s, ok := tok.(*token.Scalar); ok && string(s.Bytes) == "0" {
+			panic(token.TransformErrorf("found 0"))
+		}
+		out.Put(tok)
+	}
+}
+
+func TestConsumeStreamErrors(t *testing.T) {
+	type testCase struct {
+		name     string
+		input    string
+		pipeline func(stream <-chan token.Token) <-chan token.Token
+		handled  bool
+		output   string
+		err      string
+	}
+	handle := func(error) {}
+	var testCases = []testCase{
+		{
+			name:   "source error",
+			input:  `[1] [2,`,
+			output: "[1]\n[2...]\n",
+			err:    "syntax error at L1,C8: unexpected: <EOF>",
+		},
+		{
+			name:    "handled source error",
+			input:   `[1] [2,`,
+			handled: true,
+			output:  "[1]\n[2...]\n",
+		},
+		{
+			name:  "source error after transforms",
+			input: `{"a": 1} {"a": 2`,
+			pipeline: func(stream <-chan token.Token) <-chan token.Token {
+				stream = token.TransformStream(stream, passThrough{})
+				stream = token.TransformStreamWithErrorHandler(stream, passThrough{}, handle)
+				return token.ParallelTransformStream(stream, passThrough{}, 2, true, handle)
+			},
+			output: "{\"a\": 1}\n",
+			err:    "syntax error at L1,C17: expected '}' or ',' got: <EOF>",
+		},
+		{
+			name:  "transform error",
+			input: `[1, [0, 2]] 3`,
+			pipeline: func(stream <-chan token.Token) <-chan token.Token {
+				return token.TransformStreamWithErrorHandler(stream, failingTransformer{}, nil)
+			},
+			output: "[1,[...]...]\n",
+			err:    "found 0",
+		},
+		{
+			name:  "parallel transform error",
+			input: `[1] [0] [2]`,
+			pipeline: func(stream <-chan token.Token) <-chan token.Token {
+				return token.ParallelTransformStream(stream, failingTransformer{}, 2, true, nil)
+			},
+			output: "[1]\n",
+			err:    "found 0",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var handledErr error
+			var handleError func(error)
+			if c.handled {
+				handleError = func(err error) { handledErr = err }
+			}
+			stream := token.StartStream(jsonstream.NewJSONDecoder(strings.NewReader(c.input)), handleError)
+			if c.pipeline != nil {
+				stream = c.pipeline(stream)
+			}
+			var b strings.Builder
+			encoder := &jsonstream.JSONEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1}}
+			err := token.ConsumeStream(stream, encoder)
+			if c.err == "" && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if c.err != "" && (err == nil || err.Error() != c.err) {
+				t.Fatalf("Expected error %q, got %v", c.err, err)
+			}
+			if c.handled && handledErr == nil {
+				t.Fatalf("Expected the error to be handled")
+			}
+			if b.String() != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, b.String())
+			}
+		})
+	}
+}
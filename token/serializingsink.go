@@ -0,0 +1,73 @@
+package token
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A SerializingSink funnels tokens from several producer goroutines into a
+// single WriteStream, restoring the order of values.
+//
+// Each producer calls PutTokens with a tag and the tokens making up a value
+// (or any number of values, including none).  Tags start at 0 and each tag
+// must be used exactly once.  The tokens are written to the underlying stream
+// in increasing tag order: tokens with a tag that is not next in line are
+// kept in memory until all tokens with previous tags have been written.
+//
+// It is safe to call PutTokens from several goroutines concurrently.  Writing
+// to the underlying stream happens in the goroutine which supplies the next
+// tag in line, so a slow consumer applies backpressure to all producers.
+type SerializingSink struct {
+	mx      sync.Mutex
+	out     WriteStream
+	next    uint64
+	pending map[uint64][]Token
+}
+
+// NewSerializingSink returns a new SerializingSink writing to out.
+func NewSerializingSink(out WriteStream) *SerializingSink {
+	return &SerializingSink{
+		out:     out,
+		pending: make(map[uint64][]Token),
+	}
+}
+
+// PutTokens records the tokens for the given tag, writing them and any pending
+// tokens which follow them to the underlying stream if tag is next in line.
+func (s *SerializingSink) PutTokens(tag uint64, toks []Token) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if _, ok := s.pending[tag]; ok || tag < s.next {
+		panic(fmt.Sprintf("tag %d used more than once", tag))
+	}
+	if tag != s.next {
+		if toks == nil {
+			toks = []Token{}
+		}
+		s.pending[tag] = toks
+		return
+	}
+	for {
+		for _, tok := range toks {
+			s.out.Put(tok)
+		}
+		s.next++
+		var ok bool
+		toks, ok = s.pending[s.next]
+		if !ok {
+			return
+		}
+		delete(s.pending, s.next)
+	}
+}
+
+// Close checks that all tokens have been written to the underlying stream,
+// i.e. that no tag is missing.  It returns an error otherwise.
+func (s *SerializingSink) Close() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if len(s.pending) > 0 {
+		return fmt.Errorf("tag %d is missing, %d values not written", s.next, len(s.pending))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package token
+
+import "fmt"
+
+// A StreamError is the last token of a stream which was cut short because its
+// source or a transformer failed and no function was given to handle the
+// error.  This way the error travels with the stream until ConsumeStream,
+// which returns it.
+//
+// Transformers and sinks see the StreamError at the end of their input.  A
+// transformer should put it at the end of its output and a sink should return
+// its error, so that it is not lost.  Reading the input with a
+// StreamErrorReader does most of the work: the iterator based transformers
+// and the encoders of the jsonstream package all do so.
+type StreamError struct {
+	Err error
+}
+
+func (e *StreamError) String() string {
+	return fmt.Sprintf("StreamError(%s)", e.Err)
+}
+
+// reportError passes err to handleError if it is not nil, and otherwise writes
+// it at the end of out as a StreamError.
+func reportError(out chan<- Token, handleError func(error), err error) {
+	if handleError != nil {
+		handleError(err)
+	} else {
+		out <- &StreamError{Err: err}
+	}
+}
+
+// A StreamErrorReader is a ReadStream reading tokens from a channel like
+// ChannelReadStream, except that a StreamError ends the stream (Next returns
+// nil) and its error is kept.
+type StreamErrorReader struct {
+	in  <-chan Token
+	err error
+}
+
+var _ ReadStream = &StreamErrorReader{}
+
+func NewStreamErrorReader(in <-chan Token) *StreamErrorReader {
+	return &StreamErrorReader{in: in}
+}
+
+func (r *StreamErrorReader) Next() Token {
+	tok := <-r.in
+	if streamErr, ok := tok.(*StreamError); ok {
+		r.err = streamErr.Err
+		return nil
+	}
+	return tok
+}
+
+// Err returns the error of the StreamError which ended the stream, if any.
+func (r *StreamErrorReader) Err() error {
+	return r.err
+}
+
+// ForwardError puts the StreamError which ended the stream, if any, into out.
+// Transformers call it after writing the rest of their output.
+func (r *StreamErrorReader) ForwardError(out WriteStream) {
+	if r.err != nil {
+		out.Put(&StreamError{Err: r.err})
+	}
+}
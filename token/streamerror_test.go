@@ -0,0 +1,70 @@
+package token
+
+import (
+	"errors"
+	"testing"
+)
+
+type failingSource struct{}
+
+func (failingSource) Produce(out chan<- Token) error {
+	out <- &StartArray{}
+	return errors.New("source error")
+}
+
+// recordingTransformer passes on its input, recording the tokens it sees.
+type recordingTransformer struct {
+	seen *[]Token
+}
+
+func (t recordingTransformer) Transform(in <-chan Token, out WriteStream) {
+	for tok := range in {
+		*t.seen = append(*t.seen, tok)
+		out.Put(tok)
+	}
+}
+
+// The error travels with the stream as its last token, which transformers
+// pass on.
+func TestStreamErrorToken(t *testing.T) {
+	var seen1, seen2 []Token
+	stream := StartStream(failingSource{}, nil)
+	stream = TransformStream(stream, recordingTransformer{seen: &seen1})
+	stream = TransformStreamWithErrorHandler(stream, recordingTransformer{seen: &seen2}, nil)
+	var toks []Token
+	for tok := range stream {
+		toks = append(toks, tok)
+	}
+	if len(toks) != 2 {
+		t.Fatalf("Expected 2 tokens, got %v", toks)
+	}
+	if _, ok := toks[0].(*StartArray); !ok {
+		t.Errorf("Expected StartArray, got %s", toks[0])
+	}
+	if streamErr, ok := toks[1].(*StreamError); !ok || streamErr.Err.Error() != "source error" {
+		t.Errorf("Expected StreamError(source error), got %s", toks[1])
+	}
+	for _, seen := range [][]Token{seen1, seen2} {
+		if len(seen) != 2 {
+			t.Errorf("Expected the transformer to see 2 tokens, got %v", seen)
+		}
+	}
+}
+
+func TestStreamErrorReader(t *testing.T) {
+	stream := NewStreamErrorReader(StartStream(failingSource{}, nil))
+	if _, ok := stream.Next().(*StartArray); !ok {
+		t.Fatalf("Expected StartArray")
+	}
+	if tok := stream.Next(); tok != nil {
+		t.Fatalf("Expected the end of the stream, got %s", tok)
+	}
+	if err := stream.Err(); err == nil || err.Error() != "source error" {
+		t.Fatalf("Expected source error, got %v", err)
+	}
+	out := NewAccumulatorStream()
+	stream.ForwardError(out)
+	if toks := out.GetTokens(); len(toks) != 1 {
+		t.Fatalf("Expected a StreamError, got %v", toks)
+	}
+}
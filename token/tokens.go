@@ -75,8 +75,11 @@ var _ Token = &EndArray{}
 
 // Elision is not part of the JSON syntax but is used to remove contents
 // from an array or an object but signal to the user that the content has
-// been 'elided'.
-type Elision struct{}
+// been 'elided'.  Count is the number of items (values of an array or members
+// of an object) which have been elided, or 0 if it is not known.
+type Elision struct {
+	Count int
+}
 
 func (e *Elision) String() string {
 	return "Elision"
@@ -115,6 +118,19 @@ func (s *Scalar) EqualsString(str string) bool {
 	return s.ToString() == str
 }
 
+// EqualsBytes is like EqualsString but compares to a string passed as bytes.
+// It does not allocate when the scalar contains no escape sequences, so it is
+// suitable for hot paths such as matching object keys.
+func (s *Scalar) EqualsBytes(b []byte) bool {
+	if s.Type() != String {
+		return false
+	}
+	if s.IsUnescaped() {
+		return bytes.Equal(s.Bytes[1:len(s.Bytes)-1], b)
+	}
+	return s.ToString() == string(b)
+}
+
 func NewScalar(tp ScalarType, bytes []byte) *Scalar {
 	return &Scalar{
 		Bytes:        bytes,
@@ -169,9 +185,7 @@ func (s *Scalar) Equal(t *Scalar) bool {
 			return false
 		}
 	case Number:
-		if bytes.Equal(s.Bytes, t.Bytes) {
-			return true
-		}
+		return bytes.Equal(s.Bytes, t.Bytes) || CompareNumbers(s, t) == 0
 	default:
 		panic("invalid scalar type")
 	}
@@ -195,6 +209,9 @@ func (s *Scalar) ToGo() any {
 }
 
 func parseJsonLiteralBytes(b []byte) json.Token {
+	if x, ok := parseNonFinite(b); ok {
+		return x
+	}
 	dec := json.NewDecoder(bytes.NewReader(b))
 	tok, err := dec.Token()
 	if err != nil {
@@ -203,6 +220,31 @@ func parseJsonLiteralBytes(b []byte) json.Token {
 	return tok
 }
 
+// IsNonFinite returns true if s is a number which is NaN or an infinity.  These
+// are not valid JSON but Float64Scalar produces them and the JSON decoder can
+// be configured to accept them.
+func (s *Scalar) IsNonFinite() bool {
+	if s.Type() != Number {
+		return false
+	}
+	_, ok := parseNonFinite(s.Bytes)
+	return ok
+}
+
+// parseNonFinite returns the value of b if it is a non-finite number literal
+// such as "NaN", "+Inf" or "-Infinity".
+func parseNonFinite(b []byte) (float64, bool) {
+	s := b
+	if len(s) > 0 && (s[0] == '-' || s[0] == '+') {
+		s = s[1:]
+	}
+	if len(s) == 0 || s[0] != 'N' && s[0] != 'I' {
+		return 0, false
+	}
+	x, err := strconv.ParseFloat(string(b), 64)
+	return x, err == nil
+}
+
 // ScalarType encodes the four possible JSON scalar types.
 type ScalarType uint8
 
@@ -265,6 +307,8 @@ func ToScalar(value any) (*Scalar, error) {
 	switch x := value.(type) {
 	case string:
 		return StringScalar(x), nil
+	case json.Number:
+		return NewScalar(Number, []byte(x)), nil
 	case float64:
 		return Float64Scalar(x), nil
 	case int64:
@@ -0,0 +1,71 @@
+package token
+
+import "testing"
+
+func TestScalarEqualsBytes(t *testing.T) {
+	type testCase struct {
+		name   string
+		scalar *Scalar
+		str    string
+		equals bool
+	}
+	unescaped := func(s string) *Scalar {
+		return &Scalar{Bytes: []byte(`"` + s + `"`), TypeAndFlags: uint8(String) | UnescapedMask}
+	}
+	var testCases = []testCase{
+		{
+			name:   "unescaped equal",
+			scalar: unescaped("foo"),
+			str:    "foo",
+			equals: true,
+		},
+		{
+			name:   "unescaped different",
+			scalar: unescaped("foo"),
+			str:    "fob",
+		},
+		{
+			name:   "unescaped prefix",
+			scalar: unescaped("foo"),
+			str:    "fo",
+		},
+		{
+			name:   "empty",
+			scalar: unescaped(""),
+			str:    "",
+			equals: true,
+		},
+		{
+			name:   "escaped equal",
+			scalar: NewScalar(String, []byte(`"a\"bé"`)),
+			str:    `a"bé`,
+			equals: true,
+		},
+		{
+			name:   "escaped different",
+			scalar: NewScalar(String, []byte(`"a\"b"`)),
+			str:    `a\"b`,
+		},
+		{
+			name:   "key",
+			scalar: NewKey(String, []byte(`"id"`)),
+			str:    "id",
+			equals: true,
+		},
+		{
+			name:   "number",
+			scalar: NewScalar(Number, []byte(`12`)),
+			str:    "12",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.scalar.EqualsBytes([]byte(c.str)); got != c.equals {
+				t.Fatalf("EqualsBytes: expected %t, got %t", c.equals, got)
+			}
+			if got := c.scalar.EqualsString(c.str); got != c.equals {
+				t.Fatalf("EqualsString: expected %t, got %t", c.equals, got)
+			}
+		})
+	}
+}
@@ -12,6 +12,10 @@ type ReadStream interface {
 	Next() Token
 }
 
+// A WriteStream receives tokens.  Implementations are not required to be safe
+// for concurrent use, and even if they are, tokens from concurrent calls to Put
+// may be interleaved so that values are mixed up.  Use a SerializingSink to
+// write values from several goroutines to the same stream.
 type WriteStream interface {
 	Put(Token)
 }
@@ -42,6 +46,8 @@ func (r *SliceReadStream) Next() (tok Token) {
 	return
 }
 
+// ChannelWriteStream is a WriteStream that sends tokens to a channel.  It is
+// safe for concurrent use, but see WriteStream about interleaving of tokens.
 type ChannelWriteStream chan<- Token
 
 var _ WriteStream = make(ChannelWriteStream)
@@ -50,6 +56,8 @@ func (w ChannelWriteStream) Put(tok Token) {
 	w <- tok
 }
 
+// AccumulatorStream is a WriteStream that stores tokens in memory.  It is not
+// safe for concurrent use.
 type AccumulatorStream struct {
 	toks []Token
 }
@@ -1,6 +1,7 @@
 package token
 
 import (
+	"sync/atomic"
 	"testing"
 )
 
@@ -38,3 +39,84 @@ func TestCursorPool(t *testing.T) {
 		assertNext(t, c3, intToken(i))
 	}
 }
+
+// A cursor which lags behind the leading one by a fixed number of tokens, as a
+// slow consumer of cloned values would, keeps the window bounded by that lag.
+// The producer is blocked by the leading cursor: it is never more than one
+// token ahead of it.
+func TestCursorPoolSlowConsumer(t *testing.T) {
+	const count = 10000
+	const lag = 50
+	ch := make(chan Token)
+	var produced atomic.Int64
+	go func() {
+		for i := 0; i < count; i++ {
+			ch <- intToken(i)
+			produced.Store(int64(i + 1))
+		}
+		close(ch)
+	}()
+	lead, slow := CloneReadStream(ChannelReadStream(ch))
+	pool := lead.pool
+	maxWindowSize := 0
+	for i := 0; i < count; i++ {
+		assertNext(t, lead, intToken(i))
+		if n := int(produced.Load()); n > i+1 {
+			t.Fatalf("Producer ahead by %d tokens", n-i)
+		}
+		if i >= lag {
+			assertNext(t, slow, intToken(i-lag))
+		}
+		if pool.WindowSize() > maxWindowSize {
+			maxWindowSize = pool.WindowSize()
+		}
+	}
+	if maxWindowSize > lag+101 {
+		t.Fatalf("Window grew to %d tokens", maxWindowSize)
+	}
+	slow.Detach()
+	if pool.WindowSize() > lag+101 {
+		t.Fatalf("Window has %d tokens after detaching", pool.WindowSize())
+	}
+	assertNext(t, lead, nil)
+}
+
+func TestCursorPoolMaxWindowSize(t *testing.T) {
+	toks := make([]Token, 100)
+	for i := range toks {
+		toks[i] = intToken(i)
+	}
+	pool := NewCursorPool(NewSliceReadStream(toks))
+	pool.MaxWindowSize = 10
+	lead := pool.NewCursor()
+	slow := lead.Clone()
+
+	// The slow cursor catches up often enough that the window can be compacted.
+	for i := 0; i < 50; i++ {
+		assertNext(t, lead, intToken(i))
+		if i%5 == 4 {
+			for j := i - 4; j <= i; j++ {
+				assertNext(t, slow, intToken(j))
+			}
+		}
+		if pool.WindowSize() > 10 {
+			t.Fatalf("Window grew to %d tokens", pool.WindowSize())
+		}
+	}
+
+	// Now the slow cursor stalls, so the leading one cannot proceed.
+	defer func() {
+		err, ok := recover().(*TransformError)
+		if !ok {
+			t.Fatalf("Expected a *TransformError, got %v", err)
+		}
+		expected := "cursors diverge by more than the max window size of 10 tokens"
+		if err.Error() != expected {
+			t.Fatalf("Expected %q, got %q", expected, err)
+		}
+	}()
+	for i := 50; i < 100; i++ {
+		assertNext(t, lead, intToken(i))
+	}
+	t.Fatal("Expected panic")
+}
@@ -0,0 +1,137 @@
+package jsonstream
+
+import (
+	"unicode/utf8"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// Truncate is a StreamTransformer which shortens values so that they can be
+// previewed safely even if they contain huge nested values, arrays or strings.
+//
+// If MaxDepth is not negative, arrays and objects are truncated below that
+// depth like MaxDepthFilter does.  If MaxArrayItems is positive, only the first
+// MaxArrayItems items of arrays are kept.  In both cases the removed items are
+// replaced with an elision which records how many there were.
+//
+// If MaxStringLength is positive, strings longer than that many characters are
+// cut and end with "…" (object keys are left unchanged).
+//
+// E.g. with MaxDepth=2, MaxArrayItems=2 and MaxStringLength=3
+//
+//	{"a": [1, 2, 3, 4], "b": {"c": {"d": 1}}, "e": "abcdef"} -> {"a": [1, 2 ...], "b": {"c": {...}}, "e": "abc…"}
+type Truncate struct {
+	MaxDepth        int
+	MaxArrayItems   int
+	MaxStringLength int
+}
+
+// Transform implements the Truncate transform.
+func (f *Truncate) Transform(in <-chan token.Token, out token.WriteStream) {
+	var (
+		stack []truncateFrame
+		skip  int // Depth inside an item being removed, 0 if there is none
+	)
+	for tok := range in {
+		if _, ok := tok.(*token.StreamError); ok {
+			out.Put(tok)
+			continue
+		}
+		if skip > 0 {
+			switch tok.(type) {
+			case *token.StartArray, *token.StartObject:
+				skip++
+			case *token.EndArray, *token.EndObject:
+				skip--
+			}
+			continue
+		}
+		var top *truncateFrame
+		if n := len(stack); n > 0 {
+			top = &stack[n-1]
+		}
+		switch t := tok.(type) {
+		case *token.EndArray, *token.EndObject:
+			if top.elision != nil {
+				out.Put(top.elision)
+			}
+			stack = stack[:len(stack)-1]
+			out.Put(tok)
+			continue
+		case *token.Elision:
+			if top.elision == nil {
+				top.elision = &token.Elision{}
+			}
+			top.elision.Count += t.Count
+			continue
+		}
+		if top != nil {
+			// Object values are removed with their key, so only keys and array
+			// items are counted.
+			if top.isObject {
+				if s, ok := tok.(*token.Scalar); ok && s.IsKey() {
+					top.items++
+				}
+			} else {
+				top.items++
+				if f.MaxArrayItems > 0 && top.items > f.MaxArrayItems && top.elision == nil {
+					top.elision = &token.Elision{}
+				}
+			}
+			if top.elision != nil {
+				if s, ok := tok.(*token.Scalar); !top.isObject || ok && s.IsKey() {
+					top.elision.Count++
+				}
+				switch tok.(type) {
+				case *token.StartArray, *token.StartObject:
+					skip = 1
+				}
+				continue
+			}
+		}
+		switch t := tok.(type) {
+		case *token.StartArray, *token.StartObject:
+			_, isObject := tok.(*token.StartObject)
+			frame := truncateFrame{isObject: isObject}
+			if f.MaxDepth >= 0 && len(stack) >= f.MaxDepth {
+				frame.elision = &token.Elision{}
+			}
+			stack = append(stack, frame)
+		case *token.Scalar:
+			if f.MaxStringLength > 0 && !t.IsKey() && t.Type() == token.String {
+				tok = f.truncateString(t)
+			}
+		}
+		out.Put(tok)
+	}
+}
+
+// truncateString returns s shortened to MaxStringLength characters if it is
+// longer.
+func (f *Truncate) truncateString(s *token.Scalar) *token.Scalar {
+	// Each character takes at least one byte, so short enough literals do not
+	// need to be unescaped.
+	if len(s.Bytes)-2 <= f.MaxStringLength {
+		return s
+	}
+	str := s.ToString()
+	if utf8.RuneCountInString(str) <= f.MaxStringLength {
+		return s
+	}
+	n := 0
+	for i := range str {
+		if n == f.MaxStringLength {
+			return stringScalar(str[:i] + "…")
+		}
+		n++
+	}
+	return s
+}
+
+// A truncateFrame records the state of an array or object which Truncate is
+// inside.
+type truncateFrame struct {
+	isObject bool
+	items    int            // Number of items (or keys) seen so far
+	elision  *token.Elision // Not nil once items are being removed
+}
@@ -0,0 +1,79 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestTruncate(t *testing.T) {
+	type testCase struct {
+		name     string
+		truncate jsonstream.Truncate
+		before   token.StreamTransformer // Applied before truncating, if not nil
+		input    string
+		output   string
+	}
+	var testCases = []testCase{
+		{
+			name:     "no limits",
+			truncate: jsonstream.Truncate{MaxDepth: -1},
+			input:    `{"a": [1, [2, 3]], "b": "long string"}`,
+			output:   `{"a": [1,[2,3]],"b": "long string"}`,
+		},
+		{
+			name:     "max depth",
+			truncate: jsonstream.Truncate{MaxDepth: 1},
+			input:    `[1, {"a": [2], "b": {}}, [3, [4], 5], []] {}`,
+			output:   `[1,{<2>},[<3>],[...]] {}`,
+		},
+		{
+			name:     "max array items",
+			truncate: jsonstream.Truncate{MaxDepth: -1, MaxArrayItems: 2},
+			input:    `[1, 2, 3, [4, 5, 6], {"a": 1}] [1, 2] {"a": [[1], [2], [3], [4]]}`,
+			output:   `[1,2<3>] [1,2] {"a": [[1],[2]<2>]}`,
+		},
+		{
+			name:     "max string length",
+			truncate: jsonstream.Truncate{MaxDepth: -1, MaxStringLength: 3},
+			input:    `["abc", "abcd", "étés", "a\nbcd", 12345] {"long key": "x"}`,
+			output:   `["abc","abc…","été…","a\nb…",12345] {"long key": "x"}`,
+		},
+		{
+			name:     "all limits",
+			truncate: jsonstream.Truncate{MaxDepth: 2, MaxArrayItems: 2, MaxStringLength: 3},
+			input:    `{"a": [1, 2, 3, 4], "b": {"c": {"d": 1}}, "e": "abcdef"}`,
+			output:   `{"a": [1,2<2>],"b": {"c": {<1>}},"e": "abc…"}`,
+		},
+		{
+			name:     "existing elisions",
+			truncate: jsonstream.Truncate{MaxDepth: -1, MaxArrayItems: 1},
+			before:   &jsonstream.MaxDepthFilter{MaxDepth: 1},
+			input:    `[[1, 2, 3], [4, 5]]`,
+			output:   `[[<3>]<1>]`,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			stream := streamJSONString(c.input)
+			if c.before != nil {
+				stream = token.TransformStream(stream, c.before)
+			}
+			stream = token.TransformStream(stream, &c.truncate)
+			var b strings.Builder
+			encoder := &jsonstream.JSONEncoder{
+				Printer:       &jsonstream.DefaultPrinter{Writer: &b, IndentSize: -1},
+				ElisionMarker: "<%d>",
+			}
+			if err := token.ConsumeStream(stream, encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			got := strings.ReplaceAll(strings.TrimSpace(b.String()), "\n", " ")
+			if got != c.output {
+				t.Fatalf("Expected %s, got %s", c.output, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package jsonstream
+
+import (
+	"io"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A valueDecoder is a decoder which reads its input one top-level value at a
+// time.  Such a decoder implements Produce with produceValues.  When the values
+// it decodes are small (e.g. CSV records or JPV lines), it also implements
+// NextToken with a valueQueue.
+type valueDecoder interface {
+	// decodeValue streams the next top-level value in the input to out.  It
+	// returns false if there is no more input.  It may stream nothing even if
+	// there is more input (e.g. if it only reads a header).
+	decodeValue(out token.WriteStream) (bool, error)
+}
+
+// produceValues streams all the values d decodes to out.
+func produceValues(d valueDecoder, out chan<- token.Token) error {
+	w := token.ChannelWriteStream(out)
+	for {
+		more, err := d.decodeValue(w)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// A valueQueue holds the tokens of the value a valueDecoder has decoded which
+// have not been returned by NextToken yet.  This means that a whole value is
+// buffered in memory, so it is not used by decoders whose values can be large
+// (e.g. XML documents).
+type valueQueue struct {
+	toks []token.Token
+	next int
+	err  error
+}
+
+var _ token.WriteStream = &valueQueue{}
+
+// Put adds a token to the queue.
+func (q *valueQueue) Put(tok token.Token) {
+	q.toks = append(q.toks, tok)
+}
+
+// nextToken returns the next token in the queue, decoding the next value with
+// d when the queue is empty.  Tokens decoded before an error are returned before
+// the error.  Once d has no more input, it returns io.EOF.
+func (q *valueQueue) nextToken(d valueDecoder) (token.Token, error) {
+	for q.next == len(q.toks) {
+		if q.err != nil {
+			return nil, q.err
+		}
+		q.toks, q.next = q.toks[:0], 0
+		more, err := d.decodeValue(q)
+		if err != nil {
+			q.err = err
+		} else if !more {
+			q.err = io.EOF
+		}
+	}
+	tok := q.toks[q.next]
+	q.toks[q.next] = nil
+	q.next++
+	return tok, nil
+}
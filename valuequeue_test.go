@@ -0,0 +1,127 @@
+package jsonstream_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+type decoder interface {
+	token.StreamSource
+	token.TokenSource
+}
+
+// TestNextToken checks that decoders return the same tokens with NextToken as
+// with Produce, including when the input is invalid.
+func TestNextToken(t *testing.T) {
+	type testCase struct {
+		name       string
+		newDecoder func(io.Reader) decoder
+		input      string
+		err        bool
+	}
+	newJSON := func(r io.Reader) decoder { return jsonstream.NewJSONDecoder(r) }
+	newCSV := func(r io.Reader) decoder {
+		d := jsonstream.NewCSVDecoder(r)
+		d.HasHeader = true
+		d.RecordsProduceObjects = true
+		return d
+	}
+	var testCases = []testCase{
+		{
+			name:       "json values",
+			newDecoder: newJSON,
+			input:      `1 "two" [3, [], {}] {"a": {"b": [null, true, false]}, "c": 4}`,
+		},
+		{
+			name:       "json empty input",
+			newDecoder: newJSON,
+			input:      "  ",
+		},
+		{
+			name:       "json syntax error",
+			newDecoder: newJSON,
+			input:      `[1, {"a": 2, "b" 3}]`,
+			err:        true,
+		},
+		{
+			name:       "json truncated input",
+			newDecoder: newJSON,
+			input:      `{"a": [1, 2`,
+			err:        true,
+		},
+		{
+			name: "json relaxed syntax",
+			newDecoder: func(r io.Reader) decoder {
+				d := jsonstream.NewJSONDecoder(r)
+				d.AllowComments = true
+				d.AllowTrailingCommas = true
+				d.AllowUnquotedKeys = true
+				return d
+			},
+			input: "{a: [1, 2,], // comment\n b: {},}",
+		},
+		{
+			name:       "csv",
+			newDecoder: newCSV,
+			input:      "id,name\n1,foo\n2,bar\n",
+		},
+		{
+			name:       "csv error",
+			newDecoder: newCSV,
+			input:      "id,name\n1,\"foo\n",
+			err:        true,
+		},
+		{
+			name:       "jpv",
+			newDecoder: func(r io.Reader) decoder { return jsonstream.NewJPVDecoder(r) },
+			input:      "$.a[0] = 1\n$.a[1] = 2\n$.b = {}\n",
+		},
+		{
+			name:       "jpv error",
+			newDecoder: func(r io.Reader) decoder { return jsonstream.NewJPVDecoder(r) },
+			input:      "$.a[0] = 1\n$.a[1] 2\n",
+			err:        true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var expected []string
+			var expectedErr error
+			produced := token.StartStream(tc.newDecoder(strings.NewReader(tc.input)), func(err error) { expectedErr = err })
+			for tok := range produced {
+				expected = append(expected, tok.String())
+			}
+			if (expectedErr != nil) != tc.err {
+				t.Fatalf("Produce: unexpected error %v", expectedErr)
+			}
+			d := tc.newDecoder(strings.NewReader(tc.input))
+			var got []string
+			var err error
+			for {
+				var tok token.Token
+				tok, err = d.NextToken()
+				if err != nil {
+					break
+				}
+				got = append(got, tok.String())
+			}
+			if strings.Join(got, " ") != strings.Join(expected, " ") {
+				t.Fatalf("Expected tokens %q, got %q", expected, got)
+			}
+			if tc.err {
+				if err == io.EOF || err.Error() != expectedErr.Error() {
+					t.Fatalf("Expected error %q, got %v", expectedErr, err)
+				}
+			} else if err != io.EOF {
+				t.Fatalf("Expected io.EOF, got %v", err)
+			}
+			if _, err2 := d.NextToken(); err2 != err {
+				t.Fatalf("Expected the same error again, got %v", err2)
+			}
+		})
+	}
+}
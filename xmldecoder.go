@@ -0,0 +1,198 @@
+package jsonstream
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+
+	"github.com/arnodel/jsonstream/token"
+)
+
+// An XMLDecoder reads XML input and streams it into a JSON stream, without
+// loading the whole document in memory.
+//
+// Each root element is streamed as an object with a single key, the name of the
+// element.  Elements are mapped to JSON values as follows.
+//   - An element with no attributes and no child elements is a string made of
+//     its text, or null if it is empty.
+//   - Other elements are objects.  Attributes are keys made of their name
+//     prefixed with AttributePrefix and child elements are keys made of their
+//     name.  Text which is not just whitespace is streamed with the key
+//     TextKey.
+//
+// In order to stream the output, repeated child elements are not grouped into
+// arrays but streamed as repeated keys, e.g.
+//
+//	<list><item>a</item><item>b</item></list>
+//
+// is streamed as
+//
+//	{"list": {"item": "a", "item": "b"}}
+//
+// Note that JSONPath name selectors only select the first of repeated keys, so
+// $.list.item selects "a" only, whereas $.list.* selects both items.
+//
+// Names are streamed without their namespace and namespace declarations are
+// dropped.  Comments, processing instructions and directives are ignored.
+type XMLDecoder struct {
+	AttributePrefix string // Prefix of keys for attributes ("@" by default)
+	TextKey         string // Key for text in objects ("#text" by default)
+
+	decoder *xml.Decoder
+}
+
+var _ token.StreamSource = &XMLDecoder{}
+
+// NewXMLDecoder sets up a new XMLDecoder instance to read from the given input.
+func NewXMLDecoder(in io.Reader) *XMLDecoder {
+	return &XMLDecoder{
+		AttributePrefix: "@",
+		TextKey:         "#text",
+		decoder:         xml.NewDecoder(in),
+	}
+}
+
+// Produce reads XML root elements and streams them, until it runs out of input
+// or encounters invalid XML, in which case it will return an error.
+func (d *XMLDecoder) Produce(out chan<- token.Token) error {
+	return produceValues(d, out)
+}
+
+func (d *XMLDecoder) decodeValue(out token.WriteStream) (bool, error) {
+	for {
+		tok, err := d.decoder.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, d.parseError(err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			out.Put(&token.StartObject{})
+			out.Put(keyScalar(start.Name.Local))
+			if err := d.parseElement(start, out); err != nil {
+				return false, d.parseError(err)
+			}
+			out.Put(&token.EndObject{})
+			return true, nil
+		}
+	}
+}
+
+// parseElement streams the value of an element whose start has already been
+// read.
+func (d *XMLDecoder) parseElement(start xml.StartElement, out token.WriteStream) error {
+	attrs := start.Attr[:0]
+	for _, attr := range start.Attr {
+		if attr.Name.Space != "xmlns" && !(attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			attrs = append(attrs, attr)
+		}
+	}
+	if len(attrs) > 0 {
+		out.Put(&token.StartObject{})
+		for _, attr := range attrs {
+			out.Put(keyScalar(d.AttributePrefix + attr.Name.Local))
+			out.Put(stringScalar(attr.Value))
+		}
+		return d.parseChildren(nil, nil, out)
+	}
+	// Read on until we know whether the element has child elements.
+	var text []byte
+	for {
+		tok, err := d.nextToken()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.StartElement:
+			out.Put(&token.StartObject{})
+			return d.parseChildren(text, &t, out)
+		case xml.EndElement:
+			if len(text) == 0 {
+				out.Put(nullInstance)
+			} else {
+				out.Put(stringScalar(string(text)))
+			}
+			return nil
+		}
+	}
+}
+
+// parseChildren streams the text and child elements of an element as the items
+// of an object (which has already been started), then ends the object.  The
+// text read so far and the first child element may be given if they have
+// already been read.
+func (d *XMLDecoder) parseChildren(text []byte, child *xml.StartElement, out token.WriteStream) error {
+	for {
+		if child != nil {
+			d.putText(text, out)
+			text = nil
+			out.Put(keyScalar(child.Name.Local))
+			if err := d.parseElement(*child, out); err != nil {
+				return err
+			}
+			child = nil
+		}
+		tok, err := d.nextToken()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.StartElement:
+			child = &t
+		case xml.EndElement:
+			d.putText(text, out)
+			out.Put(&token.EndObject{})
+			return nil
+		}
+	}
+}
+
+// putText streams text with the TextKey key, unless it is only whitespace.
+func (d *XMLDecoder) putText(text []byte, out token.WriteStream) {
+	if len(bytes.TrimSpace(text)) > 0 {
+		out.Put(keyScalar(d.TextKey))
+		out.Put(stringScalar(string(text)))
+	}
+}
+
+// nextToken returns the next XML token, failing at the end of the input as it
+// is only called inside an element.
+func (d *XMLDecoder) nextToken() (xml.Token, error) {
+	tok, err := d.decoder.Token()
+	if err == io.EOF {
+		return nil, errXMLUnexpectedEnd
+	}
+	return tok, err
+}
+
+var errXMLUnexpectedEnd = errors.New("xml: unexpected end of input")
+
+// parseError returns a *token.ParseError for err, at the current position of
+// the decoder, if err is due to invalid input.
+func (d *XMLDecoder) parseError(err error) error {
+	var msg string
+	var syntaxErr *xml.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		msg = syntaxErr.Msg
+	case err == errXMLUnexpectedEnd:
+		msg = "unexpected end of input"
+	default:
+		return err
+	}
+	line, col := d.decoder.InputPos()
+	return &token.ParseError{
+		Format: "xml",
+		Line:   line,
+		Col:    col,
+		Offset: d.decoder.InputOffset(),
+		Msg:    msg,
+		Err:    err,
+	}
+}
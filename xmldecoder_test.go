@@ -0,0 +1,95 @@
+package jsonstream_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+)
+
+func TestXMLDecoder(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  string
+		output string
+		err    bool
+	}
+	var testCases = []testCase{
+		{
+			name:   "text element",
+			input:  `<?xml version="1.0"?><a> hello </a>`,
+			output: `{"a": " hello "}`,
+		},
+		{
+			name:   "empty element",
+			input:  `<a/>`,
+			output: `{"a": null}`,
+		},
+		{
+			name:   "attributes",
+			input:  `<a id="1" x:lang="en" xmlns:x="urn:x" xmlns="urn:y">hi</a>`,
+			output: `{"a": {"@id": "1","@lang": "en","#text": "hi"}}`,
+		},
+		{
+			name:   "repeated children",
+			input:  "<list>\n  <item>a</item>\n  <item><b>c</b></item>\n</list>",
+			output: `{"list": {"item": "a","item": {"b": "c"}}}`,
+		},
+		{
+			name:   "mixed content",
+			input:  `<p>Hello <b>world</b>!<!-- comment --></p>`,
+			output: `{"p": {"#text": "Hello ","b": "world","#text": "!"}}`,
+		},
+		{
+			name:   "cdata",
+			input:  `<a><![CDATA[<x> & "y"]]></a>`,
+			output: `{"a": "<x> & \"y\""}`,
+		},
+		{
+			name:   "several roots",
+			input:  `<a>1</a> <b>2</b>`,
+			output: "{\"a\": \"1\"}\n{\"b\": \"2\"}",
+		},
+		{
+			name:  "unclosed element",
+			input: `<a><b>1</b>`,
+			err:   true,
+		},
+		{
+			name:  "mismatched element",
+			input: `<a></b>`,
+			err:   true,
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeToJSONString(t, jsonstream.NewXMLDecoder(strings.NewReader(c.input)))
+			if c.err {
+				if err == nil {
+					t.Fatalf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if got != c.output+"\n" {
+				t.Fatalf("Expected %q, got %q", c.output+"\n", got)
+			}
+		})
+	}
+}
+
+func TestXMLDecoderMapping(t *testing.T) {
+	decoder := jsonstream.NewXMLDecoder(strings.NewReader(`<a id="1">x<b/></a>`))
+	decoder.AttributePrefix = "attr_"
+	decoder.TextKey = "text"
+	got, err := decodeToJSONString(t, decoder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := `{"a": {"attr_id": "1","text": "x","b": null}}` + "\n"
+	if got != expected {
+		t.Fatalf("Expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,230 @@
+package jsonstream
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/arnodel/jsonstream/iterator"
+	"github.com/arnodel/jsonstream/token"
+)
+
+// A YAMLEncoder can output a stream encoding a (stream of) JSON values as YAML
+// using the given Printer instance.  Objects and arrays are output in block
+// style, with IndentSize spaces for each nesting level (2 if IndentSize is 0
+// or less).  The encoder manages indentation itself and starts new lines with
+// the Printer's Reset() method, so the Printer's own indentation settings are
+// ignored.
+//
+// Values are streamed with constant memory (apart from the nesting depth).
+// Successive values in the stream are output as separate YAML documents,
+// separated by "---" lines.  Strings are output as plain scalars when that is
+// unambiguous, otherwise they are double-quoted (a JSON string literal is a
+// valid YAML double-quoted scalar).  Elided content is shown as a "# ..."
+// comment.
+type YAMLEncoder struct {
+	Printer
+	*Colorizer
+	IndentSize int
+}
+
+var _ token.StreamSink = &YAMLEncoder{}
+
+// Consume formats the JSON stream encoded in the given channel using the
+// instance's Printer.  It assumes that the stream is well-formed, i.e.
+// is a valid encoding for a stream of JSON values and may panic if that is
+// not the case.
+//
+// And error can be returned if the Printer could not perform some writing
+// operation.  A typical example is if it attempt to write to a closed pipe.
+func (e *YAMLEncoder) Consume(stream <-chan token.Token) (err error) {
+	defer CatchPrinterError(&err)
+	reader := token.NewStreamErrorReader(stream)
+	iterator := iterator.New(reader)
+	first := true
+	for iterator.Advance() {
+		if !first {
+			e.PrintBytes(yamlDocumentSeparatorBytes)
+			e.Reset()
+		}
+		first = false
+		e.writeValue(iterator.CurrentValue(), 0)
+		e.Reset()
+	}
+	return reader.Err()
+}
+
+func (e *YAMLEncoder) indentSize() int {
+	if e.IndentSize <= 0 {
+		return 2
+	}
+	return e.IndentSize
+}
+
+// writeValue outputs the value at the current position.  If it spans several
+// lines, the lines after the first one are indented by col spaces.  It does
+// not end the last line.
+func (e *YAMLEncoder) writeValue(value iterator.Value, col int) {
+	switch v := value.(type) {
+	case *iterator.Scalar:
+		e.writeScalar(v.Scalar())
+	case *iterator.Object:
+		if v.Advance() {
+			e.writeObjectItems(v, col)
+		} else {
+			e.writeEmpty(emptyObjectBytes, v.Elided())
+		}
+	case *iterator.Array:
+		if v.Advance() {
+			e.writeArrayItems(v, col)
+		} else {
+			e.writeEmpty(emptyArrayBytes, v.Elided())
+		}
+	default:
+		panic(fmt.Sprintf("invalid stream item: %#v", value))
+	}
+}
+
+// writeNestedValue outputs a value following a key.  Non-empty collections
+// start on a new line, indented one level deeper than col.
+func (e *YAMLEncoder) writeNestedValue(value iterator.Value, col int) {
+	e.PrintBytes(yamlColonBytes)
+	col += e.indentSize()
+	switch v := value.(type) {
+	case *iterator.Object:
+		if v.Advance() {
+			e.newLine(col)
+			e.writeObjectItems(v, col)
+			return
+		}
+		e.PrintBytes(spaceBytes)
+		e.writeEmpty(emptyObjectBytes, v.Elided())
+	case *iterator.Array:
+		if v.Advance() {
+			e.newLine(col)
+			e.writeArrayItems(v, col)
+			return
+		}
+		e.PrintBytes(spaceBytes)
+		e.writeEmpty(emptyArrayBytes, v.Elided())
+	default:
+		e.PrintBytes(spaceBytes)
+		e.writeValue(value, col)
+	}
+}
+
+// writeObjectItems outputs the items of an object as a block mapping at
+// column col.  The object must have been advanced to its first item.
+func (e *YAMLEncoder) writeObjectItems(obj *iterator.Object, col int) {
+	for first := true; first || obj.Advance(); first = false {
+		if !first {
+			e.newLine(col)
+		}
+		key, value := obj.CurrentKeyVal()
+		e.writeString(key)
+		e.writeNestedValue(value, col)
+	}
+	if obj.Elided() {
+		e.newLine(col)
+		e.PrintBytes(yamlElisionBytes)
+	}
+}
+
+// writeArrayItems outputs the items of an array as a block sequence at column
+// col.  The array must have been advanced to its first item.
+func (e *YAMLEncoder) writeArrayItems(arr *iterator.Array, col int) {
+	for first := true; first || arr.Advance(); first = false {
+		if !first {
+			e.newLine(col)
+		}
+		e.PrintBytes(yamlDashBytes)
+		// The contents of the item are aligned after the dash.
+		e.writeValue(arr.CurrentValue(), col+len(yamlDashBytes))
+	}
+	if arr.Elided() {
+		e.newLine(col)
+		e.PrintBytes(yamlElisionBytes)
+	}
+}
+
+func (e *YAMLEncoder) writeEmpty(b []byte, elided bool) {
+	e.PrintBytes(b)
+	if elided {
+		e.PrintBytes(spaceBytes)
+		e.PrintBytes(yamlElisionBytes)
+	}
+}
+
+func (e *YAMLEncoder) writeScalar(scalar *token.Scalar) {
+	if scalar.Type() == token.String {
+		e.writeString(scalar)
+	} else {
+		e.PrintScalar(e.Printer, scalar)
+	}
+}
+
+// writeString outputs a string (or key) scalar, without quotes if it can be
+// read back unambiguously as a plain YAML scalar.
+func (e *YAMLEncoder) writeString(scalar *token.Scalar) {
+	b := scalar.Bytes
+	if isPlainYAMLString(scalar) {
+		b = b[1 : len(b)-1]
+	}
+	if e.Colorizer != nil {
+		e.PrintBytes(e.ScalarColorCode(scalar))
+	}
+	e.PrintBytes(b)
+	if e.Colorizer != nil {
+		e.PrintBytes(e.ResetCode)
+	}
+}
+
+func (e *YAMLEncoder) newLine(col int) {
+	e.Reset()
+	for i := 0; i < col; i++ {
+		e.PrintBytes(spaceBytes)
+	}
+}
+
+// isPlainYAMLString returns true if the string can be output as a plain YAML
+// scalar.  This is conservative: strings which could be read as another type
+// (e.g. numbers, booleans, null) or which contain indicator characters are
+// quoted.
+func isPlainYAMLString(scalar *token.Scalar) bool {
+	if !scalar.IsUnescaped() {
+		return false
+	}
+	s := scalar.Bytes[1 : len(scalar.Bytes)-1]
+	if !plainYAMLStringPattern.Match(s) {
+		return false
+	}
+	for _, reserved := range yamlReservedWords {
+		if bytes.EqualFold(s, reserved) {
+			return false
+		}
+	}
+	return true
+}
+
+var plainYAMLStringPattern = regexp.MustCompile(`^[A-Za-z_]([A-Za-z0-9_ ./-]*[A-Za-z0-9_./-])?$`)
+
+// Words which YAML parsers may read as booleans or null (YAML 1.1 accepts more
+// spellings than YAML 1.2).
+var yamlReservedWords = [][]byte{
+	[]byte("null"),
+	[]byte("true"),
+	[]byte("false"),
+	[]byte("yes"),
+	[]byte("no"),
+	[]byte("on"),
+	[]byte("off"),
+	[]byte("y"),
+	[]byte("n"),
+}
+
+var (
+	yamlDocumentSeparatorBytes = []byte("---")
+	yamlColonBytes             = []byte(":")
+	yamlDashBytes              = []byte("- ")
+	yamlElisionBytes           = []byte("# ...")
+)
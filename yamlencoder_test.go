@@ -0,0 +1,82 @@
+package jsonstream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/arnodel/jsonstream"
+	"github.com/arnodel/jsonstream/token"
+)
+
+func TestYAMLEncoder(t *testing.T) {
+	type testCase struct {
+		name       string
+		input      string
+		indentSize int
+		output     string
+	}
+	var testCases = []testCase{
+		{
+			name:   "scalars",
+			input:  `1 true null "x"`,
+			output: "1\n---\ntrue\n---\nnull\n---\nx\n",
+		},
+		{
+			name:   "nested",
+			input:  `{"a": [1, {"b": "c", "d": [2, [3, 4]]}], "e": {"f": {}}, "g": []}`,
+			output: "a:\n  - 1\n  - b: c\n    d:\n      - 2\n      - - 3\n        - 4\ne:\n  f: {}\ng: []\n",
+		},
+		{
+			name:       "indent size",
+			input:      `{"a": {"b": [{"c": 1}]}}`,
+			indentSize: 4,
+			output:     "a:\n    b:\n        - c: 1\n",
+		},
+		{
+			name:   "quoted strings",
+			input:  `["yes", "No", "null", "1.5", "-x", "a: b", "a #b", "", " x", "x ", "é", "a\nb", "plain text"]`,
+			output: "- \"yes\"\n- \"No\"\n- \"null\"\n- \"1.5\"\n- \"-x\"\n- \"a: b\"\n- \"a #b\"\n- \"\"\n- \" x\"\n- \"x \"\n- \"é\"\n- \"a\\nb\"\n- plain text\n",
+		},
+		{
+			name:   "quoted keys",
+			input:  `{"on": 1, "a b": 2, "1": 3, "a.b/c-d_e": 4}`,
+			output: "\"on\": 1\na b: 2\n\"1\": 3\na.b/c-d_e: 4\n",
+		},
+		{
+			name:   "empty collections",
+			input:  `{} [] [{}, []]`,
+			output: "{}\n---\n[]\n---\n- {}\n- []\n",
+		},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			encoder := &jsonstream.YAMLEncoder{
+				Printer:    &jsonstream.DefaultPrinter{Writer: &buf},
+				IndentSize: c.indentSize,
+			}
+			if err := token.ConsumeStream(streamJSONString(c.input), encoder); err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if buf.String() != c.output {
+				t.Fatalf("Expected %q, got %q", c.output, buf.String())
+			}
+		})
+	}
+}
+
+func TestYAMLEncoderElision(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := &jsonstream.YAMLEncoder{Printer: &jsonstream.DefaultPrinter{Writer: &buf}}
+	stream := token.TransformStream(
+		streamJSONString(`{"a": {"b": 1}, "c": 2}`),
+		&jsonstream.MaxDepthFilter{MaxDepth: 1},
+	)
+	if err := token.ConsumeStream(stream, encoder); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "a: {} # ...\nc: 2\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, buf.String())
+	}
+}